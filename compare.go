@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+const compareUsage = `Usage: db_bench compare base new [new...]
+
+Compares each driver and scenario in base with the same pair in each new
+file. Files may be JSON or CSV written by db_bench run or import, or go test
+-bench output.
+
+The delta is the change of the mean ns/op relative to base, followed by its
+95% confidence interval. A ~ marks deltas whose interval includes zero. The
+interval needs at least two samples on each side, e.g. run --count 5 or go
+test -count 5.
+`
+
+// compareCommand implements db_bench compare.
+func compareCommand(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, compareUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("compare needs at least two result files")
+	}
+
+	reports := make([]*runReport, fs.NArg())
+	for i, path := range fs.Args() {
+		var err error
+		reports[i], err = readResultsFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 1; i < len(reports); i++ {
+		if i > 1 {
+			fmt.Println()
+		}
+		fmt.Printf("base: %s\nnew:  %s\n\n", fs.Arg(0), fs.Arg(i))
+		if err := writeComparison(os.Stdout, reports[0], reports[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type resultKey struct {
+	driver, scenario, transport string
+	payloadSize                 int64
+	concurrency                 int
+}
+
+func (r *benchResult) key() resultKey {
+	return resultKey{r.Driver, r.Scenario, r.Transport, r.PayloadSize, r.concurrency()}
+}
+
+// scenarioLabel returns the scenario of r followed by its transport, if any,
+// such as connect@tls.
+func (r *benchResult) scenarioLabel() string {
+	if r.Transport == "" {
+		return r.Scenario
+	}
+	return r.Scenario + "@" + r.Transport
+}
+
+// concurrency returns r.Concurrency, treating the 0 of files written before
+// the field existed as 1.
+func (r *benchResult) concurrency() int {
+	if r.Concurrency == 0 {
+		return 1
+	}
+	return r.Concurrency
+}
+
+// writeComparison writes a table comparing every result in base with the
+// result for the same driver and scenario in next. Results only present in
+// one of the reports are listed as missing on the other side.
+func writeComparison(w io.Writer, base, next *runReport) error {
+	nextByKey := make(map[resultKey]*benchResult, len(next.Results))
+	for i := range next.Results {
+		nextByKey[next.Results[i].key()] = &next.Results[i]
+	}
+	baseKeys := make(map[resultKey]bool, len(base.Results))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "driver\tscenario\tgoroutines\tbase ns/op\tnew ns/op\tdelta\t95% CI\tp99 ns\tmax ns\tB/op\tallocs/op")
+
+	for i := range base.Results {
+		b := &base.Results[i]
+		baseKeys[b.key()] = true
+		n, ok := nextByKey[b.key()]
+		if !ok {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\tmissing\t\t\t%d\t%d\t%d\t%d\n", b.Driver, b.scenarioLabel(), b.concurrency(), formatNsPerOp(b), b.P99Ns, b.MaxNs, b.BytesPerOp, b.AllocsPerOp)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			b.Driver, b.scenarioLabel(), b.concurrency(), formatNsPerOp(b), formatNsPerOp(n),
+			formatDelta(b, n), formatConfidenceInterval(b, n),
+			formatChange(b.P99Ns, n.P99Ns), formatChange(b.MaxNs, n.MaxNs),
+			formatChange(b.BytesPerOp, n.BytesPerOp), formatChange(b.AllocsPerOp, n.AllocsPerOp))
+	}
+
+	for i := range next.Results {
+		n := &next.Results[i]
+		if !baseKeys[n.key()] {
+			fmt.Fprintf(tw, "%s\t%s\t%d\tmissing\t%s\t\t\t%d\t%d\t%d\t%d\n", n.Driver, n.scenarioLabel(), n.concurrency(), formatNsPerOp(n), n.P99Ns, n.MaxNs, n.BytesPerOp, n.AllocsPerOp)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func formatNsPerOp(r *benchResult) string {
+	if r.Samples < 2 || r.NsPerOp == 0 {
+		return fmt.Sprintf("%.0f (n=%d)", r.NsPerOp, r.Samples)
+	}
+	return fmt.Sprintf("%.0f ±%.1f%% (n=%d)", r.NsPerOp, 100*r.NsPerOpStddev/r.NsPerOp, r.Samples)
+}
+
+func formatDelta(base, next *benchResult) string {
+	if base.NsPerOp == 0 {
+		return "n/a"
+	}
+	delta := fmt.Sprintf("%+.2f%%", 100*(next.NsPerOp-base.NsPerOp)/base.NsPerOp)
+	if low, high, ok := diffConfidenceInterval(base, next); ok && low <= 0 && high >= 0 {
+		delta = "~ " + delta
+	}
+	return delta
+}
+
+func formatConfidenceInterval(base, next *benchResult) string {
+	low, high, ok := diffConfidenceInterval(base, next)
+	if !ok || base.NsPerOp == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("[%+.2f%%, %+.2f%%]", 100*low/base.NsPerOp, 100*high/base.NsPerOp)
+}
+
+func formatChange(base, next int64) string {
+	if base == next {
+		return fmt.Sprint(base)
+	}
+	return fmt.Sprintf("%d -> %d", base, next)
+}
+
+// diffConfidenceInterval returns the 95% confidence interval of the
+// difference of the mean ns/op of next and base using Welch's t-test, which
+// does not assume the two runs have the same variance. ok is false unless
+// both results have at least two samples.
+func diffConfidenceInterval(base, next *benchResult) (low, high float64, ok bool) {
+	if base.Samples < 2 || next.Samples < 2 {
+		return 0, 0, false
+	}
+
+	vb := base.NsPerOpStddev * base.NsPerOpStddev / float64(base.Samples)
+	vn := next.NsPerOpStddev * next.NsPerOpStddev / float64(next.Samples)
+	diff := next.NsPerOp - base.NsPerOp
+	if vb+vn == 0 {
+		return diff, diff, true
+	}
+
+	df := (vb + vn) * (vb + vn) / (vb*vb/float64(base.Samples-1) + vn*vn/float64(next.Samples-1))
+	margin := studentT975(df) * math.Sqrt(vb+vn)
+	return diff - margin, diff + margin, true
+}
+
+// tTable975 holds the 97.5th percentile of Student's t-distribution for 1 to
+// 30 degrees of freedom.
+var tTable975 = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// studentT975 returns the 97.5th percentile of Student's t-distribution with
+// df degrees of freedom, which is the multiplier of the standard error for a
+// two-sided 95% confidence interval. Fractional df are rounded down, which
+// widens the interval slightly.
+func studentT975(df float64) float64 {
+	if df < 1 {
+		df = 1
+	}
+	if df <= 30 {
+		return tTable975[int(df)-1]
+	}
+
+	// Interpolate between the usual table rows above 30 degrees of freedom.
+	points := []struct{ df, t float64 }{{30, 2.042}, {40, 2.021}, {60, 2.000}, {120, 1.980}}
+	for i := 1; i < len(points); i++ {
+		if df <= points[i].df {
+			p, q := points[i-1], points[i]
+			return p.t + (q.t-p.t)*(df-p.df)/(q.df-p.df)
+		}
+	}
+	return 1.960
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gopg "github.com/go-pg/pg"
+	"github.com/hixichen/go_db_bench/raw"
+	"github.com/jackc/pgx"
+)
+
+// dbConfig is the database connection configuration every driver is opened
+// with. It is built by extractConfig from a connection string, a service
+// file, the environment and a password file, as libpq would, and converted
+// to each driver's own configuration so every driver connects the same way.
+//
+// ConnectTimeout bounds the whole startup and authentication handshake for raw
+// and pq, as connect_timeout does in libpq, but only dialing for pgx and
+// go-pg, which set no deadline for the rest. The connect scenario therefore
+// times out at different points depending on the driver.
+type dbConfig struct {
+	Host            string // host name or unix domain socket directory
+	Port            uint16
+	User            string
+	Password        string
+	Database        string
+	SSLMode         string // disable, prefer, require, verify-ca or verify-full
+	SSLRootCert     string // PEM file of the roots to verify the server with
+	ConnectTimeout  time.Duration
+	ApplicationName string
+	MaxConnections  int
+
+	// tlsResumption gives the TLS configuration a session cache, so new
+	// connections resume the TLS session of an earlier one.
+	tlsResumption bool
+
+	tlsConfig *tls.Config // set by resolveSSLMode
+}
+
+// connKeywords are the libpq keywords accepted in connection strings and
+// service files.
+var connKeywords = map[string]bool{
+	"host":             true,
+	"port":             true,
+	"user":             true,
+	"password":         true,
+	"dbname":           true,
+	"sslmode":          true,
+	"sslrootcert":      true,
+	"connect_timeout":  true,
+	"application_name": true,
+	"service":          true,
+}
+
+// connEnv maps the libpq environment variables to the keywords they set.
+// Variables later in the list only set keywords the earlier ones left unset.
+var connEnv = []struct{ name, keyword string }{
+	{"PGHOST", "host"},
+	{"POSTGRES_SERVICE_HOST", "host"},
+	{"PGPORT", "port"},
+	{"POSTGRES_SERVICE_PORT", "port"},
+	{"PGUSER", "user"},
+	{"PGPASSWORD", "password"},
+	{"PGDATABASE", "dbname"},
+	{"PGSSLMODE", "sslmode"},
+	{"PGSSLROOTCERT", "sslrootcert"},
+	{"PGCONNECT_TIMEOUT", "connect_timeout"},
+	{"PGAPPNAME", "application_name"},
+}
+
+// extractConfig builds the configuration from connString, a postgres:// URI
+// or a keyword/value DSN such as "host=localhost sslmode=require", which may
+// be empty. Settings not in connString are taken from the service named by
+// its service keyword or PGSERVICE, then from the PG* environment variables.
+// A missing password is looked up in the password file. sslmode defaults to
+// disable rather than libpq's prefer so results stay comparable with runs
+// that never used TLS.
+func extractConfig(connString string) (dbConfig, error) {
+	settings, err := parseConnString(connString)
+	if err != nil {
+		return dbConfig{}, err
+	}
+
+	service := settings["service"]
+	if service == "" {
+		service = os.Getenv("PGSERVICE")
+	}
+	if service != "" {
+		serviceSettings, err := readServiceFile(serviceFilePath(), service)
+		if err != nil {
+			return dbConfig{}, err
+		}
+		for k, v := range serviceSettings {
+			if _, ok := settings[k]; !ok {
+				settings[k] = v
+			}
+		}
+	}
+
+	for _, e := range connEnv {
+		if _, ok := settings[e.keyword]; ok {
+			continue
+		}
+		if v := os.Getenv(e.name); v != "" {
+			settings[e.keyword] = v
+		}
+	}
+
+	config := dbConfig{
+		Host:            "localhost",
+		Port:            5432,
+		User:            "postgres",
+		Database:        "postgres",
+		SSLMode:         "disable",
+		SSLRootCert:     settings["sslrootcert"],
+		ApplicationName: settings["application_name"],
+		MaxConnections:  10,
+	}
+	if v := settings["host"]; v != "" {
+		config.Host = v
+	}
+	if v := settings["port"]; v != "" {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return config, fmt.Errorf("invalid port %q", v)
+		}
+		config.Port = uint16(port)
+	}
+	if v := settings["user"]; v != "" {
+		config.User = v
+	}
+	if v := settings["dbname"]; v != "" {
+		config.Database = v
+	}
+	if v := settings["sslmode"]; v != "" {
+		switch v {
+		case "disable", "prefer", "require", "verify-ca", "verify-full":
+			config.SSLMode = v
+		default:
+			return config, fmt.Errorf("unsupported sslmode %q", v)
+		}
+	}
+	if v := settings["connect_timeout"]; v != "" {
+		seconds, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return config, fmt.Errorf("invalid connect_timeout %q", v)
+		}
+		config.ConnectTimeout = time.Duration(seconds) * time.Second
+	}
+	if config.SSLRootCert == "" && (config.SSLMode == "verify-ca" || config.SSLMode == "verify-full") {
+		if home, err := os.UserHomeDir(); err == nil {
+			if path := filepath.Join(home, ".postgresql", "root.crt"); fileExists(path) {
+				config.SSLRootCert = path
+			}
+		}
+	}
+
+	if password, ok := settings["password"]; ok {
+		config.Password = password
+	} else if password, ok, err := lookupPassFile(passFilePath(), config.passFileHost(), strconv.Itoa(int(config.Port)), config.Database, config.User); err != nil {
+		return config, err
+	} else if ok {
+		config.Password = password
+	} else {
+		config.Password = "password"
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("GO_DB_BENCH_MAX_CONNS")); err == nil && n > 0 {
+		config.MaxConnections = n
+	}
+
+	return config, nil
+}
+
+// String returns the configuration without the password.
+func (c *dbConfig) String() string {
+	return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s connect_timeout=%v application_name=%q max_conns=%d",
+		c.Host, c.Port, c.User, c.Database, c.SSLMode, c.ConnectTimeout, c.ApplicationName, c.MaxConnections)
+}
+
+// isSocket reports whether Host is a unix domain socket directory.
+func (c *dbConfig) isSocket() bool {
+	return strings.HasPrefix(c.Host, "/")
+}
+
+// passFileHost returns the host name the password file matches, which is
+// localhost for unix domain sockets as in libpq.
+func (c *dbConfig) passFileHost() string {
+	if c.isSocket() {
+		return "localhost"
+	}
+	return c.Host
+}
+
+// resolveSSLMode builds the TLS configuration once the server is known. TLS
+// is never used over unix domain sockets. pq and go-pg have no equivalent of
+// prefer, so it is resolved to require or disable by asking the server
+// whether it accepts TLS, and every driver then connects the same way.
+func (c *dbConfig) resolveSSLMode() error {
+	if c.isSocket() {
+		c.SSLMode = "disable"
+	}
+	if c.SSLMode == "prefer" {
+		ok, err := c.serverAcceptsTLS()
+		if err != nil {
+			return err
+		}
+		c.SSLMode = "disable"
+		if ok {
+			c.SSLMode = "require"
+		}
+	}
+
+	var err error
+	c.tlsConfig, err = raw.NewTLSConfig(c.SSLMode, c.Host, c.SSLRootCert)
+	if c.tlsConfig != nil && c.tlsResumption {
+		c.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return err
+}
+
+// serverAcceptsTLS sends an SSLRequest and reports whether the server
+// accepted it.
+func (c *dbConfig) serverAcceptsTLS() (bool, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.Host, strconv.Itoa(int(c.Port))), c.ConnectTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return requestTLS(conn)
+}
+
+// requestTLS sends an SSLRequest on conn and reports whether the server
+// accepted it.
+func requestTLS(conn net.Conn) (bool, error) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request, 8)
+	binary.BigEndian.PutUint32(request[4:], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		return false, err
+	}
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return false, err
+	}
+	return response[0] == 'S', nil
+}
+
+// tlsDialer dials connections that have negotiated TLS with config, for a
+// driver that is then told not to use TLS itself. It implements pq.Dialer.
+type tlsDialer struct {
+	config *tls.Config
+}
+
+func (d tlsDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, 0)
+}
+
+func (d tlsDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := requestTLS(conn)
+	if err == nil && !ok {
+		err = errors.New("server refused TLS")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, d.config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (c *dbConfig) runtimeParams() map[string]string {
+	if c.ApplicationName == "" {
+		return nil
+	}
+	return map[string]string{"application_name": c.ApplicationName}
+}
+
+// pgxConnConfig returns the configuration of pgx. ConnectTimeout only bounds
+// dialing; pgx sets no deadline for the handshake that follows.
+func (c *dbConfig) pgxConnConfig() pgx.ConnConfig {
+	config := pgx.ConnConfig{
+		Host:          c.Host,
+		Port:          c.Port,
+		User:          c.User,
+		Password:      c.Password,
+		Database:      c.Database,
+		TLSConfig:     c.tlsConfig,
+		RuntimeParams: c.runtimeParams(),
+	}
+	if c.ConnectTimeout > 0 {
+		d := &net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 5 * time.Minute}
+		config.Dial = d.Dial
+	}
+	return config
+}
+
+func (c *dbConfig) pgxPoolConfig() pgx.ConnPoolConfig {
+	return pgx.ConnPoolConfig{ConnConfig: c.pgxConnConfig(), MaxConnections: c.MaxConnections}
+}
+
+// pqDSN returns the keyword/value connection string for pq.
+func (c *dbConfig) pqDSN() string {
+	options := []string{
+		"host=" + quoteConnValue(c.Host),
+		"port=" + strconv.Itoa(int(c.Port)),
+		"user=" + quoteConnValue(c.User),
+		"dbname=" + quoteConnValue(c.Database),
+		"sslmode=" + c.SSLMode,
+	}
+	if c.Password != "" {
+		options = append(options, "password="+quoteConnValue(c.Password))
+	}
+	// pq verifies the chain with sslmode=require if sslrootcert is set.
+	if c.SSLRootCert != "" && (c.SSLMode == "verify-ca" || c.SSLMode == "verify-full") {
+		options = append(options, "sslrootcert="+quoteConnValue(c.SSLRootCert))
+	}
+	if c.ConnectTimeout > 0 {
+		options = append(options, "connect_timeout="+strconv.Itoa(int(c.ConnectTimeout/time.Second)))
+	}
+	if c.ApplicationName != "" {
+		options = append(options, "application_name="+quoteConnValue(c.ApplicationName))
+	}
+	return strings.Join(options, " ")
+}
+
+func (c *dbConfig) pgOptions() *gopg.Options {
+	options := &gopg.Options{
+		Addr:            net.JoinHostPort(c.Host, strconv.Itoa(int(c.Port))),
+		User:            c.User,
+		Database:        c.Database,
+		Password:        c.Password,
+		TLSConfig:       c.tlsConfig,
+		DialTimeout:     c.ConnectTimeout,
+		ApplicationName: c.ApplicationName,
+		PoolSize:        c.MaxConnections,
+	}
+	if c.isSocket() {
+		options.Network = "unix"
+		options.Addr = filepath.Join(c.Host, ".s.PGSQL."+strconv.Itoa(int(c.Port)))
+	}
+	return options
+}
+
+func (c *dbConfig) rawConnConfig() raw.ConnConfig {
+	return raw.ConnConfig{
+		Host:           c.Host,
+		Port:           c.Port,
+		User:           c.User,
+		Password:       c.Password,
+		Database:       c.Database,
+		TLSConfig:      c.tlsConfig,
+		ConnectTimeout: c.ConnectTimeout,
+		RuntimeParams:  c.runtimeParams(),
+	}
+}
+
+// parseConnString parses a postgres:// or postgresql:// URI or a libpq
+// keyword/value DSN into its keywords.
+func parseConnString(s string) (map[string]string, error) {
+	var settings map[string]string
+	var err error
+	if strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://") {
+		settings, err = parseConnURI(s)
+	} else {
+		settings, err = parseConnDSN(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k := range settings {
+		if !connKeywords[k] {
+			return nil, fmt.Errorf("unsupported connection keyword %q", k)
+		}
+	}
+	return settings, nil
+}
+
+func parseConnURI(s string) (map[string]string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	if u.User != nil {
+		settings["user"] = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			settings["password"] = password
+		}
+	}
+	if host := u.Hostname(); host != "" {
+		settings["host"] = host
+	}
+	if port := u.Port(); port != "" {
+		settings["port"] = port
+	}
+	if dbname := strings.TrimPrefix(u.Path, "/"); dbname != "" {
+		settings["dbname"] = dbname
+	}
+	for k, v := range u.Query() {
+		settings[k] = v[len(v)-1]
+	}
+	return settings, nil
+}
+
+// parseConnDSN parses keyword = value pairs separated by white space. Values
+// can be single quoted and use backslash escapes.
+func parseConnDSN(s string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t\n\r")
+		if s == "" {
+			return settings, nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("missing \"=\" after %q in connection string", s)
+		}
+		key := strings.TrimRight(s[:eq], " \t\n\r")
+		if key == "" || strings.ContainsAny(key, " \t\n\r") {
+			return nil, fmt.Errorf("invalid keyword %q in connection string", key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t\n\r")
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+		i := 0
+		for ; i < len(s); i++ {
+			ch := s[i]
+			if ch == '\\' && i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+				continue
+			}
+			if quoted && ch == '\'' || !quoted && strings.IndexByte(" \t\n\r", ch) >= 0 {
+				break
+			}
+			value.WriteByte(ch)
+		}
+		if quoted {
+			if i == len(s) {
+				return nil, errors.New("unterminated quoted string in connection string")
+			}
+			i++
+		}
+		settings[key] = value.String()
+		s = s[i:]
+	}
+}
+
+// quoteConnValue quotes v for a keyword/value DSN.
+func quoteConnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n\r'\\") {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// serviceFilePath returns PGSERVICEFILE or ~/.pg_service.conf.
+func serviceFilePath() string {
+	if path := os.Getenv("PGSERVICEFILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pg_service.conf")
+}
+
+// readServiceFile returns the keywords of service in the INI style service
+// file at path.
+func readServiceFile(path, service string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("service %q: %v", service, err)
+	}
+	defer f.Close()
+
+	var settings map[string]string
+	var section string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || text[0] == '#':
+		case text[0] == '[':
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("%s:%d: invalid section %q", path, line, text)
+			}
+			section = text[1 : len(text)-1]
+			if section == service {
+				settings = make(map[string]string)
+			}
+		default:
+			eq := strings.IndexByte(text, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("%s:%d: missing \"=\"", path, line)
+			}
+			if section != service {
+				continue
+			}
+			key := strings.TrimSpace(text[:eq])
+			if !connKeywords[key] || key == "service" {
+				return nil, fmt.Errorf("%s:%d: unsupported keyword %q", path, line, key)
+			}
+			settings[key] = strings.TrimSpace(text[eq+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("service %q not found in %s", service, path)
+	}
+	return settings, nil
+}
+
+// passFilePath returns PGPASSFILE or ~/.pgpass.
+func passFilePath() string {
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPassFile returns the password of the first line of the password file
+// at path matching host:port:database:user. Fields of a line can be * to
+// match anything, and \ escapes : and \. A missing file has no passwords.
+func lookupPassFile(path, host, port, database, user string) (string, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) || path == "" {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	want := []string{host, port, database, user}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := splitPassFileLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		match := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return fields[4], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+func splitPassFileLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case ch == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case ch == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(ch)
+		}
+	}
+	return append(fields, field.String())
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// connKeywordNames returns the accepted connection keywords for usage text.
+func connKeywordNames() string {
+	names := make([]string, 0, len(connKeywords))
+	for k := range connKeywords {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hixichen/go_db_bench/fakepg"
+)
+
+// mysqlDriver is go-sql-driver/mysql through database/sql. It has no
+// PostgreSQL to talk to, so Open starts an in-process fakepg MySQL server
+// loaded with the person dataset, reached the way env's server is: over a unix
+// socket when Host is a directory, or over TCP on the loopback interface, with
+// TLS if env uses TLS. Everything else comes from env.config: the credentials,
+// database name, connect timeout and pool size.
+type mysqlDriver struct{}
+
+func (mysqlDriver) Name() string     { return "mysql" }
+func (mysqlDriver) Concurrent() bool { return true }
+
+func (mysqlDriver) Open(env *runEnv) (Session, error) {
+	connector, err := startMySQL(env)
+	if err != nil {
+		return nil, err
+	}
+	db := openSQL(connector, env.config.MaxConnections)
+	env.closers = append(env.closers, db)
+
+	if err := loadMySQLTestData(db); err != nil {
+		return nil, err
+	}
+
+	prepare := func(sql string) (*sql.Stmt, error) {
+		stmt, err := db.Prepare(sql)
+		if err != nil {
+			return nil, err
+		}
+		env.closers = append(env.closers, stmt)
+		return stmt, nil
+	}
+
+	s := &mysqlSession{env: env, db: db, connector: connector}
+	if s.nameStmt, err = prepare(selectPersonNameSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.personStmt, err = prepare(selectPersonSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.multiStmt, err = prepare(selectMultiplePeopleSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.largeTextStmt, err = prepare(selectLargeTextSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.insertStmt, err = prepare(insertPersonSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.updateStmt, err = prepare(updatePersonSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.deleteStmt, err = prepare(deletePersonSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// startMySQL starts the MySQL server of a mysql session, adding what stops it
+// to env.closers, and returns a connector of the driver to it. The
+// server's certificate is generated at startup and verified as with
+// sslmode=verify-full. When env resumes TLS sessions, so does the driver, with
+// a session cache of its own.
+func startMySQL(env *runEnv) (driver.Connector, error) {
+	config := mysql.NewConfig()
+	config.User = env.config.User
+	config.Passwd = env.config.Password
+	config.DBName = env.config.Database
+	config.Timeout = env.config.ConnectTimeout
+	config.ParseTime = true
+	config.MultiStatements = true
+
+	serverConfig := fakepg.MySQLConfig{Password: env.config.Password}
+	if env.config.tlsConfig != nil {
+		cert, roots, err := fakepg.SelfSignedCert("127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		serverConfig.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		clientTLS := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		if env.config.tlsConfig.ClientSessionCache != nil {
+			clientTLS.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+		// The driver only takes TLS configs by name. mysql.NewConnector looks
+		// the name up once, so it is deregistered on return.
+		if err := mysql.RegisterTLSConfig("go_db_bench", clientTLS); err != nil {
+			return nil, err
+		}
+		defer mysql.DeregisterTLSConfig("go_db_bench")
+		config.TLSConfig = "go_db_bench"
+	}
+
+	var server *fakepg.MySQLServer
+	if env.config.isSocket() {
+		dir, err := ioutil.TempDir("", "go_db_bench")
+		if err != nil {
+			return nil, err
+		}
+		env.closers = append(env.closers, closerFunc(func() error { return os.RemoveAll(dir) }))
+		config.Net, config.Addr = "unix", filepath.Join(dir, "mysql.sock")
+		server, err = fakepg.ListenMySQL(config.Net, config.Addr, serverConfig)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		server, err = fakepg.ListenMySQL("tcp", "127.0.0.1:0", serverConfig)
+		if err != nil {
+			return nil, err
+		}
+		config.Net, config.Addr = "tcp", server.Addr().String()
+	}
+	env.closers = append(env.closers, server)
+
+	return mysql.NewConnector(config)
+}
+
+// loadMySQLTestData creates person and person_scratch in MySQL and loads
+// them with the rows loadTestData inserts into PostgreSQL.
+func loadMySQLTestData(db *sql.DB) error {
+	for _, sql := range []string{
+		mysqlPersonCreateSQL,
+		PersonInsertSQL,
+		mysqlPersonScratchCreateSQL,
+		personScratchInsertSQL,
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mysqlSession implements the scenarios of the mysql driver with prepared
+// statements. The person ids of env select the same rows as they do in
+// PostgreSQL, but the rows deleted by DeleteRow are counted separately since
+// the database is not env's.
+type mysqlSession struct {
+	env                                            *runEnv
+	db                                             *sql.DB
+	connector                                      driver.Connector // for the connect scenario
+	nameStmt, personStmt, multiStmt, largeTextStmt *sql.Stmt
+	insertStmt, updateStmt, deleteStmt             *sql.Stmt
+	deletable                                      deletablePeople
+}
+
+func (s *mysqlSession) Scenario(scenario Scenario) scenarioFunc {
+	env, size := s.env, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectValueBytes:
+		return func(i int) error {
+			var firstName []byte
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			err := s.personStmt.QueryRow(env.personID(i)).Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRows:
+		return func(i int) error {
+			// Each ? is a separate parameter, so the id is passed twice.
+			id := env.personID(i)
+			rows, err := s.multiStmt.Query(id, id)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p person
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPerson(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectRowsBytes:
+		return func(i int) error {
+			id := env.personID(i)
+			rows, err := s.multiStmt.Query(id, id)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p personBytes
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPersonBytes(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectNoBatch:
+		resultsPool := newResultsPool(size)
+		return func(i int) error {
+			p := resultsPool.Get().(*[]string)
+			defer resultsPool.Put(p)
+			results := *p
+			for j := range results {
+				if err := s.largeTextStmt.QueryRow(j).Scan(&results[j]); err != nil {
+					return err
+				}
+				if err := checkLargeText(len(results[j]), j); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var text string
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case SelectLargeTextBytes:
+		return func(i int) error {
+			var text []byte
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case InsertRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkSQLResult(s.insertStmt.Exec(p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime))
+		}
+
+	case InsertReturning:
+		// MySQL has no returning clause. The id of the new row comes back in
+		// the OK packet of the insert instead.
+		return func(i int) error {
+			p := newPerson(i)
+			result, err := s.insertStmt.Exec(p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if id == 0 {
+				return errors.New("LastInsertId was 0")
+			}
+			return nil
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkSQLResult(s.updateStmt.Exec(p.Weight, p.UpdateTime, env.personID(i)))
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := s.db.Exec(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := s.deletable.next(exec)
+			if err != nil {
+				return err
+			}
+			return checkSQLResult(s.deleteStmt.Exec(id))
+		}
+
+	case Connect:
+		return func(i int) error {
+			db := sql.OpenDB(s.connector)
+			defer db.Close()
+			var n int64
+			err := db.QueryRow("select 1").Scan(&n)
+			return checkSelectOne(n, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+
+	gopg "github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// pgDriver is go-pg with prepared statements.
+type pgDriver struct{}
+
+func (pgDriver) Name() string     { return "pg" }
+func (pgDriver) Concurrent() bool { return true }
+
+func (pgDriver) Open(env *runEnv) (Session, error) {
+	db, err := openPg(env.config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, db)
+
+	prepare := func(sql string) (*gopg.Stmt, error) {
+		stmt, err := db.Prepare(sql)
+		if err != nil {
+			return nil, err
+		}
+		env.closers = append(env.closers, stmt)
+		return stmt, nil
+	}
+
+	s := &pgSession{env: env}
+	if s.nameStmt, err = prepare(selectPersonNameSQL); err != nil {
+		return nil, err
+	}
+	if s.personStmt, err = prepare(selectPersonSQL); err != nil {
+		return nil, err
+	}
+	if s.multiStmt, err = prepare(selectMultiplePeopleSQL); err != nil {
+		return nil, err
+	}
+	if s.largeTextStmt, err = prepare(selectLargeTextSQL); err != nil {
+		return nil, err
+	}
+	if s.insertStmt, err = prepare(insertPersonSQL); err != nil {
+		return nil, err
+	}
+	if s.insertReturningStmt, err = prepare(insertPersonReturningSQL); err != nil {
+		return nil, err
+	}
+	if s.updateStmt, err = prepare(updatePersonSQL); err != nil {
+		return nil, err
+	}
+	if s.deleteStmt, err = prepare(deletePersonSQL); err != nil {
+		return nil, err
+	}
+	s.db = db
+	return s, nil
+}
+
+type pgSession struct {
+	env                                            *runEnv
+	db                                             *gopg.DB
+	nameStmt, personStmt, multiStmt, largeTextStmt *gopg.Stmt
+	insertStmt, insertReturningStmt                *gopg.Stmt
+	updateStmt, deleteStmt                         *gopg.Stmt
+}
+
+func (s *pgSession) Scenario(scenario Scenario) scenarioFunc {
+	env, size := s.env, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if _, err := s.nameStmt.QueryOne(gopg.Scan(&firstName), env.personID(i)); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			if _, err := s.personStmt.QueryOne(&p, env.personID(i)); err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	// Unlike SelectRows, which processes and discards each row, this
+	// collects all rows, so it is not apples-to-apples with multi-row.
+	case SelectRowsCollect:
+		return func(i int) error {
+			var people People
+			if _, err := s.multiStmt.Query(&people, env.personID(i)); err != nil {
+				return err
+			}
+			for i := range people.C {
+				if err := checkPerson(people.C[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case SelectRowsDiscard:
+		return func(i int) error {
+			_, err := s.multiStmt.Query(gopg.Discard, env.personID(i))
+			return err
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var text string
+			if _, err := s.largeTextStmt.QueryOne(gopg.Scan(&text), size); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case InsertRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkPgResult(s.insertStmt.Exec(p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime))
+		}
+
+	case InsertReturning:
+		return func(i int) error {
+			p := newPerson(i)
+			_, err := s.insertReturningStmt.QueryOne(gopg.Scan(&p.Id), p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime)
+			return err
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkPgResult(s.updateStmt.Exec(env.personID(i), p.Weight, p.UpdateTime))
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := s.db.Exec(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := env.deleteID(exec)
+			if err != nil {
+				return err
+			}
+			return checkPgResult(s.deleteStmt.Exec(id))
+		}
+
+	case CopyToBinary, CopyToText:
+		sql := copyPeopleToTextSQL
+		if scenario.Kind == CopyToBinary {
+			sql = copyPeopleToBinarySQL
+		}
+		return func(i int) error {
+			var w countingWriter
+			if _, err := s.db.CopyTo(&w, sql); err != nil {
+				return err
+			}
+			return checkCopyTo(w)
+		}
+
+	case Connect:
+		options := env.config.pgOptions()
+		options.PoolSize = 1
+		return func(i int) error {
+			o := *options
+			db := gopg.Connect(&o)
+			defer db.Close()
+			var n int
+			_, err := db.QueryOne(gopg.Scan(&n), "select 1")
+			return checkSelectOne(int64(n), err)
+		}
+	}
+
+	return nil
+}
+
+// pgModelsDriver is go-pg through its ORM, which builds the query for each
+// call.
+type pgModelsDriver struct{}
+
+func (pgModelsDriver) Name() string     { return "pg-models" }
+func (pgModelsDriver) Concurrent() bool { return true }
+
+func (pgModelsDriver) Open(env *runEnv) (Session, error) {
+	db, err := openPg(env.config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, db)
+	return &pgModelsSession{env: env, db: db}, nil
+}
+
+type pgModelsSession struct {
+	env *runEnv
+	db  *gopg.DB
+}
+
+func (s *pgModelsSession) Scenario(scenario Scenario) scenarioFunc {
+	env, db := s.env, s.db
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			p := &person{}
+			if err := db.Model(p).Column("first_name").Where("id = ?", env.personID(i)).Select(); err != nil {
+				return err
+			}
+			if len(p.FirstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			p := person{}
+			if err := db.Model(&p).Where("id = ?", env.personID(i)).Select(); err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRowsCollect:
+		return func(i int) error {
+			id := env.personID(i)
+			var persons []person
+			if err := db.Model(&persons).Where("id >= ?", id).Where("id <= ?", id+24).Select(); err != nil {
+				return err
+			}
+			for i := range persons {
+				if err := checkPerson(persons[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	// The ORM always inserts with RETURNING to fill in the primary key, so
+	// there is no plain insert.
+	case InsertReturning:
+		return func(i int) error {
+			p := personScratch(newPerson(i))
+			if err := db.Insert(&p); err != nil {
+				return err
+			}
+			if p.Id == 0 {
+				return errors.New("id was 0")
+			}
+			return nil
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := personScratch(newPerson(i))
+			p.Id = env.personID(i)
+			return checkPgResult(db.Model(&p).Column("weight", "update_time").WherePK().Update())
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := db.Exec(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := env.deleteID(exec)
+			if err != nil {
+				return err
+			}
+			return checkPgResult(db.Model(&personScratch{Id: id}).WherePK().Delete())
+		}
+	}
+
+	return nil
+}
+
+// checkPgResult returns the error of a write or, if there was none, checks
+// that it affected exactly one row.
+func checkPgResult(result orm.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(int64(result.RowsAffected()))
+}
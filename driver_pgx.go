@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// pgxNativeDriver is pgx through its native interface.
+type pgxNativeDriver struct{}
+
+func (pgxNativeDriver) Name() string     { return "pgx-native" }
+func (pgxNativeDriver) Concurrent() bool { return true }
+
+func (pgxNativeDriver) Open(env *runEnv) (Session, error) {
+	config := env.config.pgxPoolConfig()
+	config.AfterConnect = func(conn *pgx.Conn) error {
+		for name, sql := range map[string]string{
+			"selectPersonName":      selectPersonNameSQL,
+			"selectPerson":          selectPersonSQL,
+			"selectMultiplePeople":  selectMultiplePeopleSQL,
+			"selectLargeText":       selectLargeTextSQL,
+			"insertPerson":          insertPersonSQL,
+			"insertPersonReturning": insertPersonReturningSQL,
+			"updatePerson":          updatePersonSQL,
+			"deletePerson":          deletePersonSQL,
+		} {
+			if _, err := conn.Prepare(name, sql); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pool, err := openPgxNative(config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, closerFunc(func() error { pool.Close(); return nil }))
+
+	return &pgxNativeSession{env: env, pool: pool}, nil
+}
+
+type pgxNativeSession struct {
+	env  *runEnv
+	pool *pgx.ConnPool
+}
+
+func (s *pgxNativeSession) Scenario(scenario Scenario) scenarioFunc {
+	env, pool, size := s.env, s.pool, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if err := pool.QueryRow("selectPersonName", env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectValueBytes:
+		return func(i int) error {
+			var firstName []byte
+			if err := pool.QueryRow("selectPersonName", env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			err := pool.QueryRow("selectPerson", env.personID(i)).Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRows:
+		return func(i int) error {
+			rows, _ := pool.Query("selectMultiplePeople", env.personID(i))
+			var p person
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					rows.Close()
+					return err
+				}
+				if err := checkPerson(p); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectRowsBytes:
+		return func(i int) error {
+			rows, _ := pool.Query("selectMultiplePeople", env.personID(i))
+			var p personBytes
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					rows.Close()
+					return err
+				}
+				if err := checkPersonBytes(p); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectRowsGenericBinary:
+		type personRaw struct {
+			Id         pgtype.GenericBinary
+			FirstName  pgtype.GenericBinary
+			LastName   pgtype.GenericBinary
+			Sex        pgtype.GenericBinary
+			BirthDate  pgtype.GenericBinary
+			Weight     pgtype.GenericBinary
+			Height     pgtype.GenericBinary
+			UpdateTime pgtype.GenericBinary
+		}
+		return func(i int) error {
+			rows, _ := pool.Query("selectMultiplePeople", env.personID(i))
+			var p personRaw
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectBatch:
+		resultsPool := newResultsPool(size)
+		return func(i int) error {
+			p := resultsPool.Get().(*[]string)
+			defer resultsPool.Put(p)
+			results := *p
+			batch := pool.BeginBatch()
+			for j := range results {
+				batch.Queue("selectLargeText", []interface{}{j}, nil, []int16{pgx.BinaryFormatCode})
+			}
+			if err := batch.Send(context.Background(), nil); err != nil {
+				batch.Close()
+				return err
+			}
+			for j := range results {
+				if err := batch.QueryRowResults().Scan(&results[j]); err != nil {
+					batch.Close()
+					return err
+				}
+				if err := checkLargeText(len(results[j]), j); err != nil {
+					batch.Close()
+					return err
+				}
+			}
+			return batch.Close()
+		}
+
+	case SelectNoBatch:
+		resultsPool := newResultsPool(size)
+		return func(i int) error {
+			p := resultsPool.Get().(*[]string)
+			defer resultsPool.Put(p)
+			results := *p
+			for j := range results {
+				if err := pool.QueryRow("selectLargeText", j).Scan(&results[j]); err != nil {
+					return err
+				}
+				if err := checkLargeText(len(results[j]), j); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var s string
+			if err := pool.QueryRow("selectLargeText", size).Scan(&s); err != nil {
+				return err
+			}
+			return checkLargeText(len(s), size)
+		}
+
+	case SelectLargeTextBytes:
+		return func(i int) error {
+			var s []byte
+			if err := pool.QueryRow("selectLargeText", size).Scan(&s); err != nil {
+				return err
+			}
+			return checkLargeText(len(s), size)
+		}
+
+	case InsertRow:
+		return func(i int) error {
+			p := newPerson(i)
+			ct, err := pool.Exec("insertPerson", p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case InsertReturning:
+		return func(i int) error {
+			p := newPerson(i)
+			return pool.QueryRow("insertPersonReturning", p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime).Scan(&p.Id)
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := newPerson(i)
+			ct, err := pool.Exec("updatePerson", env.personID(i), p.Weight, p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := pool.Exec(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := env.deleteID(exec)
+			if err != nil {
+				return err
+			}
+			ct, err := pool.Exec("deletePerson", id)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case CopyFromBinary:
+		return func(i int) error {
+			if _, err := pool.Exec(truncatePersonCopySQL); err != nil {
+				return err
+			}
+			n, err := pool.CopyFrom(pgx.Identifier{"person_copy"}, personCopyColumns, pgx.CopyFromRows(env.people))
+			if err != nil {
+				return err
+			}
+			return env.checkCopyFrom(int64(n))
+		}
+
+	case CopyToBinary, CopyToText:
+		sql := copyPeopleToTextSQL
+		if scenario.Kind == CopyToBinary {
+			sql = copyPeopleToBinarySQL
+		}
+		return func(i int) error {
+			var w countingWriter
+			if err := pool.CopyToWriter(&w, sql); err != nil {
+				return err
+			}
+			return checkCopyTo(w)
+		}
+
+	case Connect:
+		config := env.config.pgxConnConfig()
+		return func(i int) error {
+			conn, err := pgx.Connect(config)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			var n int32
+			err = conn.QueryRow("select 1").Scan(&n)
+			return checkSelectOne(int64(n), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,744 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+// rawDriver writes prebuilt query messages and reads the response without
+// parsing it. It measures the theoretical maximum performance of a driver.
+// Writes go through raw.Conn.Execute, which parses the CommandTag. Each
+// iteration acquires a connection from a raw.ConnPool, so it is concurrent.
+type rawDriver struct{}
+
+func (rawDriver) Name() string     { return "raw" }
+func (rawDriver) Concurrent() bool { return true }
+
+func (rawDriver) Open(env *runEnv) (Session, error) {
+	pool, err := openRaw(env.config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, closerFunc(func() error { pool.Close(); return nil }))
+
+	s := &rawSession{env: env, pool: pool, queries: make(map[ScenarioKind][]rawQuery)}
+	s.scratch.New = func() interface{} {
+		return &rawScratch{rxBuf: make([]byte, 16384)}
+	}
+
+	for name, sql := range map[string]string{
+		"insertPerson":          insertPersonSQL,
+		"insertPersonReturning": insertPersonReturningSQL,
+		"updatePerson":          updatePersonSQL,
+		"deletePerson":          deletePersonSQL,
+		"selectLargeText":       selectLargeTextSQL,
+	} {
+		if _, err := pool.Prepare(name, sql); err != nil {
+			return nil, err
+		}
+	}
+
+	// The IDs are all of person, so the rows of a multi-row query are the
+	// IDs in its range.
+	ids := append([]int32(nil), env.randPersonIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	idsBetween := func(lo, hi int32) int {
+		return sort.Search(len(ids), func(i int) bool { return ids[i] > hi }) - sort.Search(len(ids), func(i int) bool { return ids[i] >= lo })
+	}
+
+	for _, q := range []struct {
+		kind          ScenarioKind
+		stmtName, sql string
+		rows          func(personID int32) int
+	}{
+		{SelectValue, "selectPersonName", selectPersonNameSQL, func(int32) int { return 1 }},
+		{SelectRow, "selectPerson", selectPersonSQL, func(int32) int { return 1 }},
+		{SelectRows, "selectMultiplePeople", selectMultiplePeopleSQL, func(id int32) int { return idsBetween(id, id+24) }},
+	} {
+		stmt, err := pool.Prepare(q.stmtName, q.sql)
+		if err != nil {
+			return nil, err
+		}
+		// The query messages only name the statement, which the pool
+		// prepares on every connection, so they can be sent on any of them.
+		conn, err := pool.Acquire()
+		if err != nil {
+			return nil, err
+		}
+		// BuildPreparedQueryBuf returns a slice of the connection's write
+		// buffer so each query must be copied out before building the next.
+		queries := make([]rawQuery, len(env.randPersonIDs))
+		for i, personID := range env.randPersonIDs {
+			buf, err := conn.BuildPreparedQueryBuf(stmt, personID)
+			if err != nil {
+				pool.Release(conn)
+				return nil, err
+			}
+			queries[i] = rawQuery{txBuf: append([]byte(nil), buf...), rows: q.rows(personID)}
+		}
+		pool.Release(conn)
+		s.queries[q.kind] = queries
+	}
+
+	return s, nil
+}
+
+type rawSession struct {
+	env     *runEnv
+	pool    *raw.ConnPool
+	scratch sync.Pool                   // *rawScratch, so concurrent iterations share no buffers
+	queries map[ScenarioKind][]rawQuery // one query per random person ID
+}
+
+// rawQuery is a prebuilt query message and the number of rows it returns.
+type rawQuery struct {
+	txBuf []byte
+	rows  int
+}
+
+// rawScratch is the state of one iteration of a raw scenario.
+type rawScratch struct {
+	rxBuf  []byte
+	walker rawFrameWalker
+	lt     rawLargeText
+}
+
+func (s *rawSession) Scenario(scenario Scenario) scenarioFunc {
+	env, pool := s.env, s.pool
+
+	switch scenario.Kind {
+	// The batch scenarios check the size of each text without decoding it.
+	case SelectBatch:
+		return func(i int) error {
+			conn, err := pool.Acquire()
+			if err != nil {
+				return err
+			}
+			defer pool.Release(conn)
+			scratch := s.scratch.Get().(*rawScratch)
+			defer s.scratch.Put(scratch)
+			lt := &scratch.lt
+
+			batch := conn.BeginBatch()
+			for j := 0; j < scenario.Size; j++ {
+				if err := batch.Queue("selectLargeText", j); err != nil {
+					return err
+				}
+			}
+			if err := batch.Send(); err != nil {
+				batch.Close()
+				return err
+			}
+			for j := 0; j < scenario.Size; j++ {
+				lt.reset(j)
+				_, err := batch.ResultsFunc(lt.onDataRow)
+				if err == nil {
+					err = lt.check()
+				}
+				if err != nil {
+					batch.Close()
+					return err
+				}
+			}
+			return batch.Close()
+		}
+
+	case SelectNoBatch:
+		return func(i int) error {
+			conn, err := pool.Acquire()
+			if err != nil {
+				return err
+			}
+			defer pool.Release(conn)
+			scratch := s.scratch.Get().(*rawScratch)
+			defer s.scratch.Put(scratch)
+			lt := &scratch.lt
+
+			for j := 0; j < scenario.Size; j++ {
+				lt.reset(j)
+				if err := conn.SelectFunc("selectLargeText", lt.onDataRow, j); err != nil {
+					return err
+				}
+				if err := lt.check(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case InsertRow:
+		return func(i int) error {
+			p := newPerson(i)
+			ct, err := pool.Execute("insertPerson", p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case InsertReturning:
+		return func(i int) error {
+			p := newPerson(i)
+			_, err := pool.SelectValue("insertPersonReturning", p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime)
+			return err
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := newPerson(i)
+			ct, err := pool.Execute("updatePerson", env.personID(i), p.Weight, p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := pool.Execute(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := env.deleteID(exec)
+			if err != nil {
+				return err
+			}
+			ct, err := pool.Execute("deletePerson", id)
+			if err != nil {
+				return err
+			}
+			return checkRowsAffected(ct.RowsAffected())
+		}
+
+	case CopyFromBinary, CopyFromText:
+		return func(i int) error {
+			conn, err := pool.Acquire()
+			if err != nil {
+				return err
+			}
+			defer pool.Release(conn)
+
+			copyFrom := conn.CopyFrom
+			if scenario.Kind == CopyFromText {
+				copyFrom = conn.CopyFromText
+			}
+			if _, err := conn.Execute(truncatePersonCopySQL); err != nil {
+				return err
+			}
+			n, err := copyFrom("person_copy", personCopyColumns, raw.CopyFromRows(env.people))
+			if err != nil {
+				return err
+			}
+			return env.checkCopyFrom(int64(n))
+		}
+
+	case CopyToBinary, CopyToText:
+		sql := copyPeopleToTextSQL
+		if scenario.Kind == CopyToBinary {
+			sql = copyPeopleToBinarySQL
+		}
+		return func(i int) error {
+			conn, err := pool.Acquire()
+			if err != nil {
+				return err
+			}
+			defer pool.Release(conn)
+
+			var w countingWriter
+			if _, err := conn.CopyTo(&w, sql); err != nil {
+				return err
+			}
+			return checkCopyTo(w)
+		}
+
+	case Connect:
+		config := env.config.rawConnConfig()
+		return func(i int) error {
+			conn, err := raw.Connect(config)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			v, err := conn.SelectValue("select 1")
+			n, _ := v.(int32)
+			return checkSelectOne(int64(n), err)
+		}
+	}
+
+	queries, ok := s.queries[scenario.Kind]
+	if !ok {
+		return nil
+	}
+	return func(i int) error {
+		conn, err := pool.Acquire()
+		if err != nil {
+			return err
+		}
+		defer pool.Release(conn)
+		scratch := s.scratch.Get().(*rawScratch)
+		defer s.scratch.Put(scratch)
+
+		return rawRoundTrip(conn, queries[i%len(queries)], scratch)
+	}
+}
+
+// rawRoundTrip writes the query to conn and reads the response up to
+// ReadyForQuery, walking its messages without parsing them. It fails if the
+// response has an ErrorResponse or does not have the rows q returns. Unless
+// the failure is an ErrorResponse followed by ReadyForQuery, conn is closed so
+// the pool does not hand out a connection with part of a response unread.
+func rawRoundTrip(conn *raw.Conn, q rawQuery, scratch *rawScratch) error {
+	fail := func(err error) error {
+		conn.Close()
+		return err
+	}
+
+	if _, err := conn.Conn().Write(q.txBuf); err != nil {
+		return fail(err)
+	}
+	w := &scratch.walker
+	w.reset()
+	for !w.done {
+		n, err := conn.Conn().Read(scratch.rxBuf)
+		if err != nil {
+			return fail(err)
+		}
+		if err := w.walk(scratch.rxBuf[:n]); err != nil {
+			return fail(err)
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.dataRows != q.rows {
+		return fail(fmt.Errorf("expected %d rows, got %d", q.rows, w.dataRows))
+	}
+	return nil
+}
+
+// rawFrameWalker follows the messages of a response as it is read, in
+// whatever pieces Read returns them. It only looks at the 5 byte header of
+// each message, except for the body of an ErrorResponse, so it allocates
+// nothing unless the query fails.
+type rawFrameWalker struct {
+	header    [5]byte // message type and length
+	headerLen int     // bytes of header read
+	remaining int     // bytes of the current message body not yet read
+	errBody   []byte  // the body of an ErrorResponse, as it is read
+	dataRows  int
+	err       error // the first ErrorResponse
+	done      bool  // ReadyForQuery was read
+}
+
+func (w *rawFrameWalker) reset() {
+	*w = rawFrameWalker{errBody: w.errBody[:0]}
+}
+
+// walk reads the next bytes of the response.
+func (w *rawFrameWalker) walk(buf []byte) error {
+	for len(buf) > 0 {
+		if w.done {
+			return fmt.Errorf("received %d bytes after ReadyForQuery", len(buf))
+		}
+
+		if w.headerLen < len(w.header) {
+			n := copy(w.header[w.headerLen:], buf)
+			w.headerLen += n
+			buf = buf[n:]
+			if w.headerLen < len(w.header) {
+				return nil
+			}
+			w.remaining = int(binary.BigEndian.Uint32(w.header[1:])) - 4
+			if w.remaining < 0 {
+				return fmt.Errorf("received message %q with invalid length %d", w.header[0], w.remaining+4)
+			}
+		}
+
+		n := w.remaining
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if w.header[0] == 'E' {
+			w.errBody = append(w.errBody, buf[:n]...)
+		}
+		w.remaining -= n
+		buf = buf[n:]
+		if w.remaining == 0 {
+			w.endMessage()
+		}
+	}
+	return nil
+}
+
+// endMessage is called once the whole of the current message has been read.
+func (w *rawFrameWalker) endMessage() {
+	switch w.header[0] {
+	case 'D':
+		w.dataRows++
+	case 'E':
+		if w.err == nil {
+			w.err = parseRawErrorResponse(w.errBody)
+		}
+		w.errBody = w.errBody[:0]
+	case 'Z':
+		w.done = true
+	}
+	w.headerLen = 0
+}
+
+// parseRawErrorResponse returns the error of the body of an ErrorResponse.
+func parseRawErrorResponse(body []byte) error {
+	var err raw.PgError
+	for len(body) > 0 && body[0] != 0 {
+		end := bytes.IndexByte(body, 0)
+		if end < 0 {
+			break
+		}
+		value := string(body[1:end])
+		switch body[0] {
+		case 'S':
+			err.Severity = value
+		case 'C':
+			err.Code = value
+		case 'M':
+			err.Message = value
+		}
+		body = body[end+1:]
+	}
+	return err
+}
+
+// rawLargeText checks the result of a large text query without decoding the
+// text.
+type rawLargeText struct {
+	size, rows int
+}
+
+func (lt *rawLargeText) reset(size int) {
+	lt.size, lt.rows = size, 0
+}
+
+func (lt *rawLargeText) onDataRow(r *raw.DataRowReader) error {
+	lt.rows++
+	return checkLargeText(int(r.MessageReader().ReadInt32()), lt.size)
+}
+
+// check checks that the result was one row.
+func (lt *rawLargeText) check() error {
+	if lt.rows != 1 {
+		return fmt.Errorf("expected 1 row, got %d", lt.rows)
+	}
+	return nil
+}
+
+// rawScanDriver reads the select scenarios with raw.Rows, scanning into the
+// same destinations as pgx-native, so comparing it with pgx-native measures
+// pgx's Rows.Scan against raw's. Its multi-row-collect maps the rows into
+// structs with raw.Conn.SelectStructs, to compare with pg-models.
+type rawScanDriver struct{}
+
+func (rawScanDriver) Name() string     { return "raw-scan" }
+func (rawScanDriver) Concurrent() bool { return true }
+
+func (rawScanDriver) Open(env *runEnv) (Session, error) {
+	pool, err := openRaw(env.config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, closerFunc(func() error { pool.Close(); return nil }))
+
+	for name, sql := range map[string]string{
+		"selectPersonName":     selectPersonNameSQL,
+		"selectPerson":         selectPersonSQL,
+		"selectMultiplePeople": selectMultiplePeopleSQL,
+		"selectLargeText":      selectLargeTextSQL,
+	} {
+		if _, err := pool.Prepare(name, sql); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rawScanSession{env: env, pool: pool}, nil
+}
+
+type rawScanSession struct {
+	env  *runEnv
+	pool *raw.ConnPool
+}
+
+// queryRow scans the single row of sql into dest.
+func (s *rawScanSession) queryRow(sql string, arg interface{}, dest ...interface{}) error {
+	conn, err := s.pool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer s.pool.Release(conn)
+
+	rows, err := conn.Query(sql, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return errors.New("no rows in result set")
+	}
+	return rows.Scan(dest...)
+}
+
+// queryRows scans each row of sql into dest and calls check after each.
+func (s *rawScanSession) queryRows(sql string, arg interface{}, check func() error, dest ...interface{}) error {
+	conn, err := s.pool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer s.pool.Release(conn)
+
+	rows, err := conn.Query(sql, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *rawScanSession) Scenario(scenario Scenario) scenarioFunc {
+	env, size := s.env, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if err := s.queryRow("selectPersonName", env.personID(i), &firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectValueBytes:
+		return func(i int) error {
+			var firstName []byte
+			if err := s.queryRow("selectPersonName", env.personID(i), &firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			err := s.queryRow("selectPerson", env.personID(i), &p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRows:
+		return func(i int) error {
+			var p person
+			return s.queryRows("selectMultiplePeople", env.personID(i), func() error { return checkPerson(p) },
+				&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+		}
+
+	case SelectRowsBytes:
+		return func(i int) error {
+			var p personBytes
+			return s.queryRows("selectMultiplePeople", env.personID(i), func() error { return checkPersonBytes(p) },
+				&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+		}
+
+	// The people are mapped to person by their sql tags, as pg-models maps
+	// them.
+	case SelectRowsCollect:
+		return func(i int) error {
+			var people []person
+			if err := s.pool.SelectStructs(&people, "selectMultiplePeople", env.personID(i)); err != nil {
+				return err
+			}
+			for i := range people {
+				if err := checkPerson(people[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var text string
+			if err := s.queryRow("selectLargeText", size, &text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case SelectLargeTextBytes:
+		return func(i int) error {
+			var b []byte
+			if err := s.queryRow("selectLargeText", size, &b); err != nil {
+				return err
+			}
+			return checkLargeText(len(b), size)
+		}
+	}
+	return nil
+}
+
+// rawDecodedDriver reads the select scenarios with raw.Conn.SelectFunc and
+// decodes each value with the typed DataRowReader accessors, which do not
+// box values in an interface{}. Unlike raw, which stops at finding the end
+// of the response, it parses and decodes every message, so it measures the
+// floor for a driver that returns decoded values. The bytes scenarios read
+// the text columns with ReadBytesUnsafe, without copying them.
+type rawDecodedDriver struct{}
+
+func (rawDecodedDriver) Name() string     { return "raw-decoded" }
+func (rawDecodedDriver) Concurrent() bool { return true }
+
+func (rawDecodedDriver) Open(env *runEnv) (Session, error) {
+	pool, err := openRaw(env.config)
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, closerFunc(func() error { pool.Close(); return nil }))
+
+	for name, sql := range map[string]string{
+		"selectPersonName":     selectPersonNameSQL,
+		"selectPerson":         selectPersonSQL,
+		"selectMultiplePeople": selectMultiplePeopleSQL,
+	} {
+		if _, err := pool.Prepare(name, sql); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &rawDecodedSession{env: env, pool: pool}
+	s.scratch.New = func() interface{} {
+		d := &rawDecoded{}
+		d.onName, d.onNameBytes = d.readName, d.readNameBytes
+		d.onPerson, d.onPersonBytes = d.readPerson, d.readPersonBytes
+		return d
+	}
+	return s, nil
+}
+
+type rawDecodedSession struct {
+	env     *runEnv
+	pool    *raw.ConnPool
+	scratch sync.Pool // *rawDecoded
+}
+
+// rawDecoded is the state of one iteration of a raw-decoded scenario. Its
+// callbacks are bound once, so passing them to SelectFunc does not allocate.
+type rawDecoded struct {
+	rows                                         int
+	onName, onNameBytes, onPerson, onPersonBytes func(*raw.DataRowReader) error
+}
+
+func (d *rawDecoded) readName(r *raw.DataRowReader) error {
+	d.rows++
+	if len(string(r.ReadBytesUnsafe())) == 0 {
+		return errors.New("FirstName was empty")
+	}
+	return nil
+}
+
+func (d *rawDecoded) readNameBytes(r *raw.DataRowReader) error {
+	d.rows++
+	if len(r.ReadBytesUnsafe()) == 0 {
+		return errors.New("FirstName was empty")
+	}
+	return nil
+}
+
+func (d *rawDecoded) readPerson(r *raw.DataRowReader) error {
+	d.rows++
+	var p person
+	p.Id = r.ReadInt32()
+	p.FirstName = string(r.ReadBytesUnsafe())
+	p.LastName = string(r.ReadBytesUnsafe())
+	p.Sex = string(r.ReadBytesUnsafe())
+	p.BirthDate = r.ReadTime()
+	p.Weight = r.ReadInt32()
+	p.Height = r.ReadInt32()
+	p.UpdateTime = r.ReadTime()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return checkPerson(p)
+}
+
+func (d *rawDecoded) readPersonBytes(r *raw.DataRowReader) error {
+	d.rows++
+	var p personBytes
+	p.Id = r.ReadInt32()
+	p.FirstName = r.ReadBytesUnsafe()
+	p.LastName = r.ReadBytesUnsafe()
+	p.Sex = r.ReadBytesUnsafe()
+	p.BirthDate = r.ReadTime()
+	p.Weight = r.ReadInt32()
+	p.Height = r.ReadInt32()
+	p.UpdateTime = r.ReadTime()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return checkPersonBytes(p)
+}
+
+func (s *rawDecodedSession) Scenario(scenario Scenario) scenarioFunc {
+	var sql string
+	var onDataRow func(*rawDecoded) func(*raw.DataRowReader) error
+	single := true
+	switch scenario.Kind {
+	case SelectValue:
+		sql, onDataRow = "selectPersonName", func(d *rawDecoded) func(*raw.DataRowReader) error { return d.onName }
+	case SelectValueBytes:
+		sql, onDataRow = "selectPersonName", func(d *rawDecoded) func(*raw.DataRowReader) error { return d.onNameBytes }
+	case SelectRow:
+		sql, onDataRow = "selectPerson", func(d *rawDecoded) func(*raw.DataRowReader) error { return d.onPerson }
+	case SelectRows:
+		sql, onDataRow, single = "selectMultiplePeople", func(d *rawDecoded) func(*raw.DataRowReader) error { return d.onPerson }, false
+	case SelectRowsBytes:
+		sql, onDataRow, single = "selectMultiplePeople", func(d *rawDecoded) func(*raw.DataRowReader) error { return d.onPersonBytes }, false
+	default:
+		return nil
+	}
+
+	env, pool := s.env, s.pool
+	return func(i int) error {
+		d := s.scratch.Get().(*rawDecoded)
+		defer s.scratch.Put(d)
+
+		d.rows = 0
+		if err := pool.SelectFunc(sql, onDataRow(d), env.personID(i)); err != nil {
+			return err
+		}
+		if single && d.rows != 1 {
+			return fmt.Errorf("expected 1 row, got %d", d.rows)
+		}
+		return nil
+	}
+}
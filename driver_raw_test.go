@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestRawFrameWalker(t *testing.T) {
+	message := func(typ byte, body string) string {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(4+len(body)))
+		return string(typ) + string(length[:]) + body
+	}
+	rows := message('2', "") + message('D', "\x00\x01\x00\x00\x00\x01a") + message('D', "\x00\x01\xff\xff\xff\xff") + message('C', "SELECT 2\x00")
+	ready := message('Z', "I")
+	errorResponse := message('E', "SERROR\x00C42P01\x00Mrelation \"missing\" does not exist\x00\x00")
+
+	tests := []struct {
+		response string
+		rows     int
+		err      string
+	}{
+		{rows + ready, 2, ""},
+		{errorResponse + ready, 0, "42P01"},
+		{rows + errorResponse + ready, 2, "42P01"},
+		{rows + ready + ready, 2, "after ReadyForQuery"},
+	}
+	for i, tt := range tests {
+		// Walk the response in pieces of every size, as Read could return it.
+		for size := 1; size <= len(tt.response); size++ {
+			var w rawFrameWalker
+			var err error
+			for s := tt.response; len(s) > 0 && err == nil; {
+				n := size
+				if n > len(s) {
+					n = len(s)
+				}
+				err = w.walk([]byte(s[:n]))
+				s = s[n:]
+			}
+			if err == nil {
+				err = w.err
+			}
+			if !w.done {
+				t.Errorf("%d: size %d: ReadyForQuery not found", i, size)
+			}
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("%d: size %d: got error %v, want %q", i, size, err, tt.err)
+			}
+			if w.dataRows != tt.rows {
+				t.Errorf("%d: size %d: got %d rows, want %d", i, size, w.dataRows, tt.rows)
+			}
+			if pgErr, ok := err.(raw.PgError); ok && pgErr.Message != `relation "missing" does not exist` {
+				t.Errorf("%d: size %d: got message %q", i, size, pgErr.Message)
+			}
+		}
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.Addr().(*net.TCPAddr)
+	config := raw.ConnConfig{Host: addr.IP.String(), Port: uint16(addr.Port), User: "postgres", Database: "postgres"}
+
+	simpleQuery := func(sql string) []byte {
+		buf := make([]byte, 5, 5+len(sql)+1)
+		buf[0] = 'Q'
+		binary.BigEndian.PutUint32(buf[1:], uint32(4+len(sql)+1))
+		return append(append(buf, sql...), 0)
+	}
+	scratch := &rawScratch{rxBuf: make([]byte, 16384)}
+
+	tests := []struct {
+		query rawQuery
+		err   string
+		alive bool
+	}{
+		{rawQuery{txBuf: simpleQuery("select 1"), rows: 1}, "", true},
+		// The response was read to ReadyForQuery, so the connection can be
+		// used again.
+		{rawQuery{txBuf: simpleQuery("select * from missing"), rows: 1}, "42P01", true},
+		// The connection is closed, as after an error in the middle of a
+		// response.
+		{rawQuery{txBuf: simpleQuery("select 1"), rows: 2}, "expected 2 rows, got 1", false},
+	}
+	for i, tt := range tests {
+		conn, err := raw.Connect(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rawRoundTrip(conn, tt.query, scratch)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%d: got error %v, want %q", i, err, tt.err)
+		}
+		if conn.IsAlive() != tt.alive {
+			t.Errorf("%d: IsAlive is %v, want %v", i, conn.IsAlive(), tt.alive)
+		}
+		if tt.alive {
+			if _, err := conn.SelectValue("select 1"); err != nil {
+				t.Errorf("%d: connection not usable after the round trip: %v", i, err)
+			}
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/hixichen/go_db_bench/raw"
+	"github.com/lib/pq"
+)
+
+// pgxStdlibDriver is pgx through database/sql.
+type pgxStdlibDriver struct{}
+
+func (pgxStdlibDriver) Name() string     { return "pgx-stdlib" }
+func (pgxStdlibDriver) Concurrent() bool { return true }
+
+func (pgxStdlibDriver) Open(env *runEnv) (Session, error) {
+	connector, err := pgxStdlibConnector(env.config.pgxConnConfig())
+	if err != nil {
+		return nil, err
+	}
+	return openSQLSession(env, connector)
+}
+
+// pqDriver is pq through database/sql.
+type pqDriver struct{}
+
+func (pqDriver) Name() string     { return "pq" }
+func (pqDriver) Concurrent() bool { return true }
+
+func (pqDriver) Open(env *runEnv) (Session, error) {
+	connector, err := pqConnector(env.config)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openSQLSession(env, connector)
+	if err != nil {
+		return nil, err
+	}
+	s.copyIn = true
+	return s, nil
+}
+
+// rawStdlibDriver is raw through database/sql. Compared with the raw driver
+// it shows the overhead of database/sql itself.
+type rawStdlibDriver struct{}
+
+func (rawStdlibDriver) Name() string     { return "raw-stdlib" }
+func (rawStdlibDriver) Concurrent() bool { return true }
+
+func (rawStdlibDriver) Open(env *runEnv) (Session, error) {
+	return openSQLSession(env, raw.NewConnector(env.config.rawConnConfig()))
+}
+
+// sqlSession implements the scenarios of any database/sql driver with
+// prepared statements.
+type sqlSession struct {
+	env                                            *runEnv
+	db                                             *sql.DB
+	connector                                      driver.Connector // for the connect scenario
+	nameStmt, personStmt, multiStmt, largeTextStmt *sql.Stmt
+	insertStmt, insertReturningStmt                *sql.Stmt
+	updateStmt, deleteStmt                         *sql.Stmt
+
+	copyIn bool // the driver supports pq.CopyIn
+}
+
+// openSQLSession opens a pool of connections from connector and prepares the
+// statements of the scenarios.
+func openSQLSession(env *runEnv, connector driver.Connector) (*sqlSession, error) {
+	db := openSQL(connector, env.config.MaxConnections)
+	env.closers = append(env.closers, db)
+
+	prepare := func(sql string) (*sql.Stmt, error) {
+		stmt, err := db.Prepare(sql)
+		if err != nil {
+			return nil, err
+		}
+		env.closers = append(env.closers, stmt)
+		return stmt, nil
+	}
+
+	s := &sqlSession{env: env, db: db, connector: connector}
+	var err error
+	if s.nameStmt, err = prepare(selectPersonNameSQL); err != nil {
+		return nil, err
+	}
+	if s.personStmt, err = prepare(selectPersonSQL); err != nil {
+		return nil, err
+	}
+	if s.multiStmt, err = prepare(selectMultiplePeopleSQL); err != nil {
+		return nil, err
+	}
+	if s.largeTextStmt, err = prepare(selectLargeTextSQL); err != nil {
+		return nil, err
+	}
+	if s.insertStmt, err = prepare(insertPersonSQL); err != nil {
+		return nil, err
+	}
+	if s.insertReturningStmt, err = prepare(insertPersonReturningSQL); err != nil {
+		return nil, err
+	}
+	if s.updateStmt, err = prepare(updatePersonSQL); err != nil {
+		return nil, err
+	}
+	if s.deleteStmt, err = prepare(deletePersonSQL); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqlSession) Scenario(scenario Scenario) scenarioFunc {
+	env, size := s.env, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectValueBytes:
+		return func(i int) error {
+			var firstName []byte
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			err := s.personStmt.QueryRow(env.personID(i)).Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRows:
+		return func(i int) error {
+			rows, err := s.multiStmt.Query(env.personID(i))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p person
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPerson(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectRowsBytes:
+		return func(i int) error {
+			rows, err := s.multiStmt.Query(env.personID(i))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p personBytes
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPersonBytes(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectNoBatch:
+		resultsPool := newResultsPool(size)
+		return func(i int) error {
+			p := resultsPool.Get().(*[]string)
+			defer resultsPool.Put(p)
+			results := *p
+			for j := range results {
+				if err := s.largeTextStmt.QueryRow(j).Scan(&results[j]); err != nil {
+					return err
+				}
+				if err := checkLargeText(len(results[j]), j); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var text string
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case SelectLargeTextBytes:
+		return func(i int) error {
+			var text []byte
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case InsertRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkSQLResult(s.insertStmt.Exec(p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime))
+		}
+
+	case InsertReturning:
+		return func(i int) error {
+			p := newPerson(i)
+			return s.insertReturningStmt.QueryRow(p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime).Scan(&p.Id)
+		}
+
+	case UpdateRow:
+		return func(i int) error {
+			p := newPerson(i)
+			return checkSQLResult(s.updateStmt.Exec(env.personID(i), p.Weight, p.UpdateTime))
+		}
+
+	case DeleteRow:
+		exec := func(sql string) error {
+			_, err := s.db.Exec(sql)
+			return err
+		}
+		return func(i int) error {
+			id, err := env.deleteID(exec)
+			if err != nil {
+				return err
+			}
+			return checkSQLResult(s.deleteStmt.Exec(id))
+		}
+
+	case CopyFromText:
+		if !s.copyIn {
+			return nil
+		}
+		return func(i int) error {
+			if _, err := s.db.Exec(truncatePersonCopySQL); err != nil {
+				return err
+			}
+			return copyInPeople(s.db, env.people)
+		}
+
+	case Connect:
+		return func(i int) error {
+			db := sql.OpenDB(s.connector)
+			defer db.Close()
+			var n int64
+			err := db.QueryRow("select 1").Scan(&n)
+			return checkSelectOne(n, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSQLResult returns the error of a write or, if there was none, checks
+// that it affected exactly one row.
+func checkSQLResult(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(n)
+}
+
+// copyInPeople copies people into person_copy with pq.CopyIn, which must be
+// used in a transaction.
+func copyInPeople(db *sql.DB, people [][]interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("person_copy", personCopyColumns...))
+	if err != nil {
+		return err
+	}
+	for _, values := range people {
+		if _, err := stmt.Exec(values...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	sqlite "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+)
+
+// sqliteDriver is SQLite, embedded in the benchmark process, through
+// database/sql. It is ncruces/go-sqlite3, which runs SQLite compiled to
+// WebAssembly and so builds without cgo. Open creates a database file in a
+// temporary directory loaded with the person dataset; nothing is taken from
+// env.config but the pool size. It runs the read scenarios only, as a measure
+// of how far a network driver is from an embedded engine. It does not use
+// env's transport, so it is not run over each transport.
+type sqliteDriver struct{}
+
+func (sqliteDriver) Name() string        { return "sqlite" }
+func (sqliteDriver) Concurrent() bool    { return true }
+func (sqliteDriver) UsesTransport() bool { return false }
+
+func (sqliteDriver) Open(env *runEnv) (Session, error) {
+	dir, err := ioutil.TempDir("", "go_db_bench")
+	if err != nil {
+		return nil, err
+	}
+	env.closers = append(env.closers, closerFunc(func() error { return os.RemoveAll(dir) }))
+
+	// _timefmt=sqlite decodes the text of date and timestamp columns into
+	// time.Time, as PostgreSQL drivers return them.
+	name := (&url.URL{
+		Scheme:   "file",
+		Path:     filepath.Join(dir, "person.db"),
+		RawQuery: "_timefmt=sqlite",
+	}).String()
+	connector, err := (&sqlite.SQLite{}).OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	db := openSQL(connector, env.config.MaxConnections)
+	env.closers = append(env.closers, db)
+
+	for _, sql := range []string{sqlitePersonCreateSQL, PersonInsertSQL} {
+		if _, err := db.Exec(sql); err != nil {
+			return nil, err
+		}
+	}
+
+	prepare := func(sql string) (*sql.Stmt, error) {
+		stmt, err := db.Prepare(sql)
+		if err != nil {
+			return nil, err
+		}
+		env.closers = append(env.closers, stmt)
+		return stmt, nil
+	}
+
+	s := &sqliteSession{env: env}
+	if s.nameStmt, err = prepare(selectPersonNameSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.personStmt, err = prepare(selectPersonSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.multiStmt, err = prepare(selectMultiplePeopleSQLQuestionMark); err != nil {
+		return nil, err
+	}
+	if s.largeTextStmt, err = prepare(sqliteSelectLargeTextSQL); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqliteSession implements the read scenarios of the sqlite driver with
+// prepared statements.
+type sqliteSession struct {
+	env                                            *runEnv
+	nameStmt, personStmt, multiStmt, largeTextStmt *sql.Stmt
+}
+
+func (s *sqliteSession) Scenario(scenario Scenario) scenarioFunc {
+	env, size := s.env, scenario.Size
+
+	switch scenario.Kind {
+	case SelectValue:
+		return func(i int) error {
+			var firstName string
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectValueBytes:
+		return func(i int) error {
+			var firstName []byte
+			if err := s.nameStmt.QueryRow(env.personID(i)).Scan(&firstName); err != nil {
+				return err
+			}
+			if len(firstName) == 0 {
+				return errors.New("FirstName was empty")
+			}
+			return nil
+		}
+
+	case SelectRow:
+		return func(i int) error {
+			var p person
+			err := s.personStmt.QueryRow(env.personID(i)).Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime)
+			if err != nil {
+				return err
+			}
+			return checkPerson(p)
+		}
+
+	case SelectRows:
+		return func(i int) error {
+			// Each ? is a separate parameter, so the id is passed twice.
+			id := env.personID(i)
+			rows, err := s.multiStmt.Query(id, id)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p person
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPerson(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectRowsBytes:
+		return func(i int) error {
+			id := env.personID(i)
+			rows, err := s.multiStmt.Query(id, id)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var p personBytes
+			for rows.Next() {
+				if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+					return err
+				}
+				if err := checkPersonBytes(p); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}
+
+	case SelectLargeText:
+		return func(i int) error {
+			var text string
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+
+	case SelectLargeTextBytes:
+		return func(i int) error {
+			var text []byte
+			if err := s.largeTextStmt.QueryRow(size).Scan(&text); err != nil {
+				return err
+			}
+			return checkLargeText(len(text), size)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLitePersonCreateSQL(t *testing.T) {
+	for _, pg := range []string{"serial", "timestamptz"} {
+		if strings.Contains(sqlitePersonCreateSQL, pg) {
+			t.Errorf("sqlitePersonCreateSQL contains %s", pg)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "go_db_bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, "person.db")+"?_timefmt=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, sql := range []string{sqlitePersonCreateSQL, PersonInsertSQL} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ids are assigned from 1 as a serial would assign them.
+	var count, minID, maxID int
+	if err := db.QueryRow("select count(*), min(id), max(id) from person").Scan(&count, &minID, &maxID); err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 || minID != 1 || maxID != count {
+		t.Errorf("got %d rows with ids from %d to %d", count, minID, maxID)
+	}
+
+	var birthDate, updateTime time.Time
+	if err := db.QueryRow("select birth_date, update_time from person where id = 1").Scan(&birthDate, &updateTime); err != nil {
+		t.Fatal(err)
+	}
+	if birthDate.IsZero() || updateTime.IsZero() {
+		t.Errorf("got birth_date %v, update_time %v", birthDate, updateTime)
+	}
+}
+
+func TestSQLiteScenarios(t *testing.T) {
+	env := &runEnv{config: dbConfig{MaxConnections: 2}, randPersonIDs: []int32{1, 2, 3}}
+	session, err := sqliteDriver{}.Open(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	ran := make(map[ScenarioKind]bool)
+	for _, s := range registeredScenarios {
+		fn := session.Scenario(s)
+		if fn == nil {
+			continue
+		}
+		ran[s.Kind] = true
+		for i := 0; i < 3; i++ {
+			if err := fn(i); err != nil {
+				t.Errorf("%s: %v", s.Name, err)
+			}
+		}
+	}
+
+	want := map[ScenarioKind]bool{
+		SelectValue:          true,
+		SelectValueBytes:     true,
+		SelectRow:            true,
+		SelectRows:           true,
+		SelectRowsBytes:      true,
+		SelectLargeText:      true,
+		SelectLargeTextBytes: true,
+	}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran scenarios of kinds %v, want %v", ran, want)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+// Driver is a database driver under benchmark.
+type Driver interface {
+	// Name identifies the driver in benchmark names and results.
+	Name() string
+
+	// Concurrent reports whether the scenarios of a Session may be run from
+	// several goroutines at once.
+	Concurrent() bool
+
+	// Open connects to the database of env and prepares the driver's
+	// statements. Everything it opens is closed by env.Close.
+	Open(env *runEnv) (Session, error)
+}
+
+// Session is a connected Driver.
+type Session interface {
+	// Scenario returns a function that runs one iteration of s, or nil if the
+	// driver does not implement the kind of s.
+	Scenario(s Scenario) scenarioFunc
+}
+
+// usesTransport reports whether d connects to the server of a runEnv over its
+// transport, so that its results depend on the transport. Drivers that do not,
+// such as an embedded database, implement UsesTransport to return false.
+func usesTransport(d Driver) bool {
+	t, ok := d.(interface{ UsesTransport() bool })
+	return !ok || t.UsesTransport()
+}
+
+// registeredDrivers lists every driver in the order they are run. Adding a
+// driver only takes an entry here.
+var registeredDrivers = []Driver{
+	pgxNativeDriver{},
+	pgxStdlibDriver{},
+	pqDriver{},
+	pgDriver{},
+	pgModelsDriver{},
+	rawDriver{},
+	rawStdlibDriver{},
+	rawScanDriver{},
+	rawDecodedDriver{},
+	mysqlDriver{},
+	sqliteDriver{},
+}
+
+// driverNames returns the names of registeredDrivers.
+func driverNames() []string {
+	names := make([]string, len(registeredDrivers))
+	for i, d := range registeredDrivers {
+		names[i] = d.Name()
+	}
+	return names
+}
+
+// lookupDriver returns the registered driver called name.
+func lookupDriver(name string) (Driver, bool) {
+	for _, d := range registeredDrivers {
+		if d.Name() == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,431 @@
+package fakepg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Array types. A value of an array type is an arrayValue.
+const (
+	BoolArrayOid        Oid = 1000
+	ByteaArrayOid       Oid = 1001
+	Int2ArrayOid        Oid = 1005
+	Int4ArrayOid        Oid = 1007
+	TextArrayOid        Oid = 1009
+	VarcharArrayOid     Oid = 1015
+	Int8ArrayOid        Oid = 1016
+	Float4ArrayOid      Oid = 1021
+	Float8ArrayOid      Oid = 1022
+	TimestampArrayOid   Oid = 1115
+	DateArrayOid        Oid = 1182
+	TimestamptzArrayOid Oid = 1185
+	JSONArrayOid        Oid = 199
+	CidrArrayOid        Oid = 651
+	MacaddrArrayOid     Oid = 1040
+	InetArrayOid        Oid = 1041
+	TimeArrayOid        Oid = 1183
+	IntervalArrayOid    Oid = 1187
+	NumericArrayOid     Oid = 1231
+	UUIDArrayOid        Oid = 2951
+	JSONBArrayOid       Oid = 3807
+)
+
+// arrayElems maps each array type to its element type.
+var arrayElems = map[Oid]Oid{
+	BoolArrayOid:        BoolOid,
+	ByteaArrayOid:       ByteaOid,
+	Int2ArrayOid:        Int2Oid,
+	Int4ArrayOid:        Int4Oid,
+	TextArrayOid:        TextOid,
+	VarcharArrayOid:     VarcharOid,
+	Int8ArrayOid:        Int8Oid,
+	Float4ArrayOid:      Float4Oid,
+	Float8ArrayOid:      Float8Oid,
+	TimestampArrayOid:   TimestampOid,
+	DateArrayOid:        DateOid,
+	TimestamptzArrayOid: TimestamptzOid,
+	JSONArrayOid:        JSONOid,
+	CidrArrayOid:        CidrOid,
+	MacaddrArrayOid:     MacaddrOid,
+	InetArrayOid:        InetOid,
+	TimeArrayOid:        TimeOid,
+	IntervalArrayOid:    IntervalOid,
+	NumericArrayOid:     NumericOid,
+	UUIDArrayOid:        UUIDOid,
+	JSONBArrayOid:       JSONBOid,
+}
+
+// The array types are named after their element types, as in PostgreSQL:
+// int4[] in SQL and _int4 in pg_type.
+func init() {
+	arrayOf := make(map[Oid]Oid, len(arrayElems))
+	for array, elem := range arrayElems {
+		typeNames[array] = "_" + typeNames[elem]
+		arrayOf[elem] = array
+	}
+	names := make([]string, 0, len(sqlTypes))
+	for name := range sqlTypes {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if array, ok := arrayOf[sqlTypes[name]]; ok {
+			sqlTypes[name+"[]"] = array
+		}
+	}
+}
+
+// arrayValue is an array of any number of dimensions. An array with no
+// elements has no dimensions.
+type arrayValue struct {
+	dims  []arrayDim
+	elems []interface{} // in row-major order, nil for NULL
+}
+
+type arrayDim struct {
+	length, lower int32
+}
+
+// appendArrayText appends the text representation of a, such as
+// {{1,2},{3,NULL}}, to buf.
+func appendArrayText(buf []byte, elem Oid, a arrayValue) ([]byte, error) {
+	if len(a.dims) == 0 {
+		return append(buf, "{}"...), nil
+	}
+	for _, d := range a.dims {
+		if d.lower != 1 {
+			for _, d := range a.dims {
+				buf = append(buf, '[')
+				buf = strconv.AppendInt(buf, int64(d.lower), 10)
+				buf = append(buf, ':')
+				buf = strconv.AppendInt(buf, int64(d.lower+d.length-1), 10)
+				buf = append(buf, ']')
+			}
+			buf = append(buf, '=')
+			break
+		}
+	}
+
+	i := 0
+	var appendDim func(buf []byte, dim int) ([]byte, error)
+	appendDim = func(buf []byte, dim int) ([]byte, error) {
+		buf = append(buf, '{')
+		for j := int32(0); j < a.dims[dim].length; j++ {
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			if dim < len(a.dims)-1 {
+				var err error
+				if buf, err = appendDim(buf, dim+1); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			v := a.elems[i]
+			i++
+			if v == nil {
+				buf = append(buf, "NULL"...)
+				continue
+			}
+			s, err := appendText(nil, elem, v)
+			if err != nil {
+				return nil, err
+			}
+			buf = appendArrayElementText(buf, string(s))
+		}
+		return append(buf, '}'), nil
+	}
+	return appendDim(buf, 0)
+}
+
+// appendArrayElementText appends s, quoted if it would otherwise be read as
+// something else.
+func appendArrayElementText(buf []byte, s string) []byte {
+	if s != "" && !strings.EqualFold(s, "NULL") && !strings.ContainsAny(s, "{},\"\\ \t\n\r") {
+		return append(buf, s...)
+	}
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, s[i])
+	}
+	return append(buf, '"')
+}
+
+// appendArrayBinary appends the binary representation of a.
+func appendArrayBinary(buf []byte, elem Oid, a arrayValue) ([]byte, error) {
+	hasNull := uint32(0)
+	for _, v := range a.elems {
+		if v == nil {
+			hasNull = 1
+			break
+		}
+	}
+
+	buf = appendUint32(buf, uint32(len(a.dims)))
+	buf = appendUint32(buf, hasNull)
+	buf = appendUint32(buf, uint32(elem))
+	for _, d := range a.dims {
+		buf = appendUint32(buf, uint32(d.length))
+		buf = appendUint32(buf, uint32(d.lower))
+	}
+	for _, v := range a.elems {
+		if v == nil {
+			buf = appendUint32(buf, 0xffffffff)
+			continue
+		}
+		sizeIdx := len(buf)
+		buf = append(buf, 0, 0, 0, 0)
+		var err error
+		if buf, err = appendBinary(buf, elem, v); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(buf[sizeIdx:], uint32(len(buf)-sizeIdx-4))
+	}
+	return buf, nil
+}
+
+// decodeArrayBinary decodes an array received in binary format.
+func decodeArrayBinary(elem Oid, src []byte) (interface{}, error) {
+	r := &msgReader{buf: src}
+	ndim := r.int32()
+	r.int32() // has NULL elements
+	if oid := Oid(r.int32()); r.err == nil && oid != elem {
+		return nil, fmt.Errorf("wrong element type in array: %s, expected %s", typeNames[oid], typeNames[elem])
+	}
+	if ndim < 0 || ndim > 6 {
+		return nil, fmt.Errorf("invalid number of array dimensions: %d", ndim)
+	}
+
+	a := arrayValue{dims: make([]arrayDim, ndim)}
+	n := 1
+	if ndim == 0 {
+		n = 0
+	}
+	for i := range a.dims {
+		a.dims[i] = arrayDim{length: r.int32(), lower: r.int32()}
+		if a.dims[i].length < 0 {
+			return nil, fmt.Errorf("invalid array dimension length: %d", a.dims[i].length)
+		}
+		n *= int(a.dims[i].length)
+	}
+	if r.err != nil || n > len(r.buf) {
+		return nil, fmt.Errorf("invalid binary array")
+	}
+
+	a.elems = make([]interface{}, n)
+	for i := range a.elems {
+		size := r.int32()
+		if size < 0 {
+			continue
+		}
+		b := r.bytes(int(size))
+		if r.err != nil {
+			break
+		}
+		v, err := decodeValue(elem, binaryFormat, b)
+		if err != nil {
+			return nil, err
+		}
+		a.elems[i] = v
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid binary array")
+	}
+	return a, nil
+}
+
+// parseArrayText parses the text representation of an array, optionally
+// prefixed with its bounds as in [0:1]={1,2}.
+func parseArrayText(elem Oid, s string) (interface{}, error) {
+	p := &arrayTextParser{s: strings.TrimSpace(s), elem: elem}
+	var bounds []arrayDim
+	if strings.HasPrefix(p.s, "[") {
+		i := strings.Index(p.s, "=")
+		if i < 0 {
+			return nil, p.errorf()
+		}
+		for _, b := range strings.Split(strings.Trim(strings.TrimSpace(p.s[:i]), "[]"), "][") {
+			parts := strings.Split(b, ":")
+			if len(parts) != 2 {
+				return nil, p.errorf()
+			}
+			lower, err1 := strconv.Atoi(parts[0])
+			upper, err2 := strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil || upper < lower-1 {
+				return nil, p.errorf()
+			}
+			bounds = append(bounds, arrayDim{length: int32(upper - lower + 1), lower: int32(lower)})
+		}
+		p.pos = i + 1
+	}
+
+	if err := p.parseDim(0); err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n\r", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	if p.pos != len(p.s) {
+		return nil, p.errorf()
+	}
+
+	a := arrayValue{elems: p.elems}
+	if len(p.elems) == 0 {
+		return a, nil
+	}
+	a.dims = make([]arrayDim, len(p.lengths))
+	for i, length := range p.lengths {
+		a.dims[i] = arrayDim{length: int32(length), lower: 1}
+	}
+	if bounds != nil {
+		if len(bounds) != len(a.dims) {
+			return nil, p.errorf()
+		}
+		for i := range bounds {
+			if bounds[i].length != a.dims[i].length {
+				return nil, p.errorf()
+			}
+		}
+		a.dims = bounds
+	}
+	return a, nil
+}
+
+type arrayTextParser struct {
+	s       string
+	pos     int
+	elem    Oid
+	elems   []interface{}
+	lengths []int // of each dimension, set by its first sub-array
+}
+
+func (p *arrayTextParser) errorf() error {
+	return fmt.Errorf("malformed array literal: %q", p.s)
+}
+
+func (p *arrayTextParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n\r", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// parseDim parses a brace-enclosed sub-array of dimension dim.
+func (p *arrayTextParser) parseDim(dim int) error {
+	p.skipSpace()
+	if p.pos >= len(p.s) || p.s[p.pos] != '{' {
+		return p.errorf()
+	}
+	p.pos++
+	p.skipSpace()
+
+	count := 0
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		if dim > 0 {
+			return p.errorf()
+		}
+		return nil
+	}
+	if dim == len(p.lengths) {
+		if len(p.elems) > 0 {
+			// Elements were found at a shallower depth before.
+			return p.errorf()
+		}
+		// The length is set when the first sub-array of this dimension ends.
+		p.lengths = append(p.lengths, -1)
+	}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == '{' {
+			if err := p.parseDim(dim + 1); err != nil {
+				return err
+			}
+		} else {
+			if dim+1 < len(p.lengths) {
+				return p.errorf()
+			}
+			v, err := p.parseElement()
+			if err != nil {
+				return err
+			}
+			p.elems = append(p.elems, v)
+		}
+		count++
+
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return p.errorf()
+		}
+		c := p.s[p.pos]
+		p.pos++
+		if c == '}' {
+			break
+		}
+		if c != ',' {
+			return p.errorf()
+		}
+	}
+
+	switch {
+	case p.lengths[dim] == -1:
+		p.lengths[dim] = count
+	case p.lengths[dim] != count:
+		// Multidimensional arrays must be rectangular.
+		return p.errorf()
+	}
+	return nil
+}
+
+func (p *arrayTextParser) parseElement() (interface{}, error) {
+	var b strings.Builder
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		p.pos++
+		for {
+			if p.pos >= len(p.s) {
+				return nil, p.errorf()
+			}
+			c := p.s[p.pos]
+			p.pos++
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				if p.pos >= len(p.s) {
+					return nil, p.errorf()
+				}
+				c = p.s[p.pos]
+				p.pos++
+			}
+			b.WriteByte(c)
+		}
+		return parseText(p.elem, b.String())
+	}
+
+	for p.pos < len(p.s) && p.s[p.pos] != ',' && p.s[p.pos] != '}' {
+		c := p.s[p.pos]
+		p.pos++
+		if c == '{' || c == '"' {
+			return nil, p.errorf()
+		}
+		if c == '\\' {
+			if p.pos >= len(p.s) {
+				return nil, p.errorf()
+			}
+			c = p.s[p.pos]
+			p.pos++
+		}
+		b.WriteByte(c)
+	}
+	s := strings.TrimSpace(b.String())
+	if s == "" {
+		return nil, p.errorf()
+	}
+	if strings.EqualFold(s, "NULL") {
+		return nil, nil
+	}
+	return parseText(p.elem, s)
+}
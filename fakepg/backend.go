@@ -0,0 +1,875 @@
+package fakepg
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	protocolVersionNumber = 196608 // 3.0
+	sslRequestNumber      = 80877103
+	cancelRequestNumber   = 80877102
+	gssEncRequestNumber   = 80877104
+)
+
+// flushThreshold bounds how much output is buffered before it is written to
+// the connection in the middle of a response.
+const flushThreshold = 64 * 1024
+
+// backend serves a single client connection.
+type backend struct {
+	server   *Server
+	conn     net.Conn
+	reader   *bufio.Reader
+	wbuf     []byte
+	msgStart int // offset in wbuf of the message being written
+	rbuf     []byte
+
+	pid       int32
+	secretKey int32
+	user      string
+	txStatus  byte
+
+	statements map[string]*query
+	portals    map[string]*portal
+
+	// ignoreTillSync is set after an error in the extended protocol. Messages
+	// are discarded until the next Sync.
+	ignoreTillSync bool
+
+	// queryMu guards the interrupt state, which a CancelRequest changes from
+	// the goroutine of another connection.
+	queryMu     sync.Mutex
+	interrupt   chan struct{} // closed to cancel the running query
+	interrupted bool          // interrupt has been closed
+	running     bool          // a query is executing
+}
+
+// portal is a bound statement ready for execution.
+type portal struct {
+	query         *query
+	params        []interface{}
+	resultFormats []int16
+
+	// result and pos hold the remaining rows of a portal suspended by an
+	// Execute with a row limit.
+	result *result
+	pos    int
+}
+
+func newBackend(s *Server, conn net.Conn, pid int32) *backend {
+	var key [4]byte
+	rand.Read(key[:])
+
+	return &backend{
+		server:     s,
+		conn:       conn,
+		reader:     bufio.NewReaderSize(conn, 8192),
+		pid:        pid,
+		secretKey:  int32(binary.BigEndian.Uint32(key[:])),
+		txStatus:   'I',
+		statements: make(map[string]*query),
+		portals:    make(map[string]*portal),
+	}
+}
+
+func (b *backend) run() {
+	defer b.conn.Close()
+
+	if err := b.startup(); err != nil {
+		return
+	}
+
+	for {
+		t, body, err := b.rxMsg()
+		if err != nil {
+			return
+		}
+		if t == 'X' {
+			return
+		}
+		if err := b.handleMsg(t, body); err != nil {
+			return
+		}
+		if len(b.wbuf) >= flushThreshold {
+			if err := b.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *backend) startup() error {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(b.reader, header[:]); err != nil {
+			return err
+		}
+		size := int(binary.BigEndian.Uint32(header[0:4]))
+		code := binary.BigEndian.Uint32(header[4:8])
+		if size < 8 || size > 10000 {
+			return fmt.Errorf("invalid startup packet length %d", size)
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(b.reader, body); err != nil {
+			return err
+		}
+
+		switch code {
+		case sslRequestNumber:
+			tlsConfig := b.server.config.TLSConfig
+			if _, isTLS := b.conn.(*tls.Conn); isTLS || tlsConfig == nil {
+				if _, err := b.conn.Write([]byte{'N'}); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := b.conn.Write([]byte{'S'}); err != nil {
+				return err
+			}
+			b.conn = tls.Server(b.conn, tlsConfig)
+			b.reader = bufio.NewReaderSize(b.conn, 8192)
+			continue
+		case gssEncRequestNumber:
+			if _, err := b.conn.Write([]byte{'N'}); err != nil {
+				return err
+			}
+			continue
+		case cancelRequestNumber:
+			r := &msgReader{buf: body}
+			pid, secretKey := r.int32(), r.int32()
+			if r.err == nil {
+				b.server.cancel(pid, secretKey)
+			}
+			return errors.New("cancel request")
+		case protocolVersionNumber:
+		default:
+			b.sendError(&pgError{severity: "FATAL", code: "0A000", message: fmt.Sprintf("unsupported frontend protocol %d", code)})
+			b.flush()
+			return errors.New("unsupported protocol")
+		}
+
+		r := &msgReader{buf: body}
+		for {
+			key := r.cstring()
+			if key == "" || r.err != nil {
+				break
+			}
+			value := r.cstring()
+			if key == "user" {
+				b.user = value
+			}
+		}
+		break
+	}
+
+	if err := b.authenticate(); err != nil {
+		return err
+	}
+
+	for _, p := range [][2]string{
+		{"server_version", "10.0"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		b.startMsg('S')
+		b.writeCString(p[0])
+		b.writeCString(p[1])
+		b.finishMsg()
+	}
+
+	b.startMsg('K')
+	b.writeInt32(b.pid)
+	b.writeInt32(b.secretKey)
+	b.finishMsg()
+
+	b.sendReadyForQuery()
+	return b.flush()
+}
+
+func (b *backend) authenticate() error {
+	var expected string
+	switch b.server.config.Auth {
+	case AuthTrust:
+		b.startMsg('R')
+		b.writeInt32(0)
+		b.finishMsg()
+		return nil
+	case AuthCleartext:
+		b.startMsg('R')
+		b.writeInt32(3)
+		b.finishMsg()
+		expected = b.server.config.Password
+	case AuthMD5:
+		var salt [4]byte
+		rand.Read(salt[:])
+		b.startMsg('R')
+		b.writeInt32(5)
+		b.wbuf = append(b.wbuf, salt[:]...)
+		b.finishMsg()
+		expected = "md5" + hexMD5(hexMD5(b.server.config.Password+b.user)+string(salt[:]))
+	case AuthSCRAMSHA256:
+		if err := b.authenticateSCRAM(); err != nil {
+			if err == errAuthFailed {
+				return b.authFailed()
+			}
+			return err
+		}
+		b.startMsg('R')
+		b.writeInt32(0)
+		b.finishMsg()
+		return nil
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	t, body, err := b.rxMsg()
+	if err != nil {
+		return err
+	}
+	r := &msgReader{buf: body}
+	if t != 'p' || r.cstring() != expected {
+		return b.authFailed()
+	}
+
+	b.startMsg('R')
+	b.writeInt32(0)
+	b.finishMsg()
+	return nil
+}
+
+// authFailed tells the client that authentication failed and returns the
+// error that ends the connection.
+func (b *backend) authFailed() error {
+	b.sendError(&pgError{severity: "FATAL", code: "28P01", message: fmt.Sprintf("password authentication failed for user %q", b.user)})
+	b.flush()
+	return errAuthFailed
+}
+
+func hexMD5(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *backend) handleMsg(t byte, body []byte) error {
+	r := &msgReader{buf: body}
+
+	if t == 'Q' {
+		b.ignoreTillSync = false
+		b.simpleQuery(r.cstring())
+		b.sendReadyForQuery()
+		return b.flush()
+	}
+
+	if t == 'S' {
+		b.ignoreTillSync = false
+		b.sendReadyForQuery()
+		return b.flush()
+	}
+
+	if b.ignoreTillSync {
+		return nil
+	}
+
+	var err error
+	switch t {
+	case 'P':
+		err = b.parse(r)
+	case 'B':
+		err = b.bind(r)
+	case 'D':
+		err = b.describe(r)
+	case 'E':
+		err = b.execute(r)
+	case 'C':
+		kind := r.byte()
+		name := r.cstring()
+		if kind == 'S' {
+			delete(b.statements, name)
+		} else {
+			delete(b.portals, name)
+		}
+		b.startMsg('3')
+		b.finishMsg()
+	case 'H':
+		return b.flush()
+	default:
+		err = &pgError{code: "08P01", message: fmt.Sprintf("invalid frontend message type %q", t)}
+	}
+	if err == nil {
+		err = r.err
+	}
+
+	if err != nil {
+		b.sendError(toPgError(err))
+		b.ignoreTillSync = true
+	}
+	return nil
+}
+
+func (b *backend) simpleQuery(sql string) {
+	statements := splitStatements(sql)
+	if len(statements) == 0 {
+		b.startMsg('I')
+		b.finishMsg()
+		return
+	}
+
+	// A statement that mentions pg_type is answered as a whole, see
+	// catalogQuery.
+	if _, ok := catalogQuery(sql); ok {
+		statements = []string{sql}
+	}
+
+	for _, s := range statements {
+		q, err := b.server.db.prepare(s, nil)
+		if err == nil && len(q.paramOids) > 0 {
+			err = &pgError{code: "42P02", message: "there is no parameter $1"}
+		}
+		if err != nil {
+			b.sendError(toPgError(err))
+			return
+		}
+		if stmt, ok := q.stmt.(*copyStmt); ok {
+			if stmt.from {
+				err = b.copyIn(q, stmt)
+			} else {
+				err = b.copyOut(q, stmt)
+			}
+			if err != nil {
+				b.sendError(toPgError(err))
+				return
+			}
+			continue
+		}
+		res, err := b.server.db.execute(q, nil, b.startQuery())
+		b.endQuery()
+		if err != nil {
+			b.sendError(toPgError(err))
+			return
+		}
+		b.sendNotices(res.notices)
+		if res.fields != nil {
+			b.sendRowDescription(res.fields, nil)
+		}
+		if err := b.sendRows(res.fields, res.rows, nil); err != nil {
+			b.sendError(toPgError(err))
+			return
+		}
+		b.sendCommandComplete(res.tag)
+	}
+}
+
+// copyIn receives the data of a COPY FROM STDIN until CopyDone or CopyFail
+// and inserts it.
+func (b *backend) copyIn(q *query, stmt *copyStmt) error {
+	b.sendCopyResponse('G', len(stmt.columns), stmt.binary)
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	var data []byte
+	for {
+		t, body, err := b.rxMsg()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'd':
+			data = append(data, body...)
+		case 'c':
+			res, err := b.server.db.copyIn(q, data)
+			if err != nil {
+				return err
+			}
+			b.sendCommandComplete(res.tag)
+			return nil
+		case 'f':
+			r := &msgReader{buf: body}
+			return &pgError{code: "57014", message: "COPY from stdin failed: " + r.cstring()}
+		case 'H', 'S':
+		default:
+			return &pgError{code: "08P01", message: fmt.Sprintf("unexpected message type %q during COPY from stdin", t)}
+		}
+	}
+}
+
+// copyOut sends the rows of a COPY TO STDOUT, one row per CopyData message.
+func (b *backend) copyOut(q *query, stmt *copyStmt) error {
+	res, err := b.server.db.copyOut(q)
+	if err != nil {
+		return err
+	}
+
+	b.sendCopyResponse('H', len(res.fields), stmt.binary)
+	if stmt.binary {
+		b.startMsg('d')
+		b.wbuf = appendCopyHeader(b.wbuf, true)
+		b.finishMsg()
+	}
+	for _, row := range res.rows {
+		b.startMsg('d')
+		if b.wbuf, err = appendCopyRow(b.wbuf, res.fields, row, stmt.binary); err != nil {
+			b.wbuf = b.wbuf[:b.msgStart]
+			return err
+		}
+		b.finishMsg()
+		if len(b.wbuf) >= flushThreshold {
+			if err := b.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if stmt.binary {
+		b.startMsg('d')
+		b.wbuf = appendCopyTrailer(b.wbuf, true)
+		b.finishMsg()
+	}
+	b.startMsg('c')
+	b.finishMsg()
+	b.sendCommandComplete(res.tag)
+	return nil
+}
+
+// sendCopyResponse sends a CopyInResponse or CopyOutResponse of type t.
+func (b *backend) sendCopyResponse(t byte, columns int, binaryCopy bool) {
+	format := int16(textFormat)
+	if binaryCopy {
+		format = binaryFormat
+	}
+	b.startMsg(t)
+	b.wbuf = append(b.wbuf, byte(format))
+	b.writeInt16(int16(columns))
+	for i := 0; i < columns; i++ {
+		b.writeInt16(format)
+	}
+	b.finishMsg()
+}
+
+func (b *backend) parse(r *msgReader) error {
+	name := r.cstring()
+	sql := r.cstring()
+	n := int(r.int16())
+	declared := make([]Oid, n)
+	for i := range declared {
+		declared[i] = Oid(r.int32())
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	q, err := b.server.db.prepare(sql, declared)
+	if err != nil {
+		return err
+	}
+	b.statements[name] = q
+
+	b.startMsg('1')
+	b.finishMsg()
+	return nil
+}
+
+func (b *backend) bind(r *msgReader) error {
+	portalName := r.cstring()
+	stmtName := r.cstring()
+
+	q, ok := b.statements[stmtName]
+	if !ok {
+		return &pgError{code: "26000", message: fmt.Sprintf("prepared statement %q does not exist", stmtName)}
+	}
+
+	paramFormats := make([]int16, r.int16())
+	for i := range paramFormats {
+		paramFormats[i] = r.int16()
+	}
+
+	params := make([]interface{}, r.int16())
+	if len(params) != len(q.paramOids) {
+		return &pgError{code: "08P01", message: fmt.Sprintf("bind message supplies %d parameters, but prepared statement %q requires %d", len(params), stmtName, len(q.paramOids))}
+	}
+	for i := range params {
+		size := r.int32()
+		var src []byte
+		if size >= 0 {
+			src = r.bytes(int(size))
+		}
+		if r.err != nil {
+			return r.err
+		}
+		var err error
+		params[i], err = decodeValue(q.paramOids[i], formatFor(paramFormats, i), src)
+		if err != nil {
+			return &pgError{code: "22P02", message: err.Error()}
+		}
+	}
+
+	resultFormats := make([]int16, r.int16())
+	for i := range resultFormats {
+		resultFormats[i] = r.int16()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	b.portals[portalName] = &portal{query: q, params: params, resultFormats: resultFormats}
+
+	b.startMsg('2')
+	b.finishMsg()
+	return nil
+}
+
+func (b *backend) describe(r *msgReader) error {
+	kind := r.byte()
+	name := r.cstring()
+
+	var q *query
+	var formats []int16
+	if kind == 'S' {
+		var ok bool
+		if q, ok = b.statements[name]; !ok {
+			return &pgError{code: "26000", message: fmt.Sprintf("prepared statement %q does not exist", name)}
+		}
+		b.startMsg('t')
+		b.writeInt16(int16(len(q.paramOids)))
+		for _, oid := range q.paramOids {
+			b.writeInt32(int32(oid))
+		}
+		b.finishMsg()
+	} else {
+		p, ok := b.portals[name]
+		if !ok {
+			return &pgError{code: "34000", message: fmt.Sprintf("portal %q does not exist", name)}
+		}
+		q = p.query
+		formats = p.resultFormats
+	}
+
+	if q.fields == nil {
+		b.startMsg('n')
+		b.finishMsg()
+	} else {
+		b.sendRowDescription(q.fields, formats)
+	}
+	return nil
+}
+
+func (b *backend) execute(r *msgReader) error {
+	name := r.cstring()
+	maxRows := int(r.int32())
+
+	p, ok := b.portals[name]
+	if !ok {
+		return &pgError{code: "34000", message: fmt.Sprintf("portal %q does not exist", name)}
+	}
+
+	if p.result == nil {
+		if p.query.stmt == nil {
+			b.startMsg('I')
+			b.finishMsg()
+			return nil
+		}
+		res, err := b.server.db.execute(p.query, p.params, b.startQuery())
+		b.endQuery()
+		if err != nil {
+			return err
+		}
+		b.sendNotices(res.notices)
+		p.result = res
+	}
+
+	rows := p.result.rows[p.pos:]
+	if maxRows > 0 && maxRows < len(rows) {
+		rows = rows[:maxRows]
+	}
+	if err := b.sendRows(p.result.fields, rows, p.resultFormats); err != nil {
+		return err
+	}
+	p.pos += len(rows)
+
+	if p.pos < len(p.result.rows) {
+		b.startMsg('s')
+		b.finishMsg()
+		return nil
+	}
+
+	b.sendCommandComplete(p.result.tag)
+	return nil
+}
+
+// startQuery marks a query as running and returns the channel that
+// cancelQuery closes. The channel is reused until a query is cancelled.
+func (b *backend) startQuery() <-chan struct{} {
+	b.queryMu.Lock()
+	defer b.queryMu.Unlock()
+	if b.interrupt == nil || b.interrupted {
+		b.interrupt = make(chan struct{})
+		b.interrupted = false
+	}
+	b.running = true
+	return b.interrupt
+}
+
+func (b *backend) endQuery() {
+	b.queryMu.Lock()
+	b.running = false
+	b.queryMu.Unlock()
+}
+
+// cancelQuery cancels the running query. Like PostgreSQL, it does nothing if
+// the connection is idle.
+func (b *backend) cancelQuery() {
+	b.queryMu.Lock()
+	defer b.queryMu.Unlock()
+	if b.running && !b.interrupted {
+		close(b.interrupt)
+		b.interrupted = true
+	}
+}
+
+func (b *backend) sendCommandComplete(tag string) {
+	switch tag {
+	case "BEGIN", "START TRANSACTION":
+		b.txStatus = 'T'
+	case "COMMIT", "ROLLBACK":
+		b.txStatus = 'I'
+	}
+	b.startMsg('C')
+	b.writeCString(tag)
+	b.finishMsg()
+}
+
+func (b *backend) sendRowDescription(fields []field, formats []int16) {
+	b.startMsg('T')
+	b.writeInt16(int16(len(fields)))
+	for i, f := range fields {
+		b.writeCString(f.name)
+		b.writeInt32(0) // table oid
+		b.writeInt16(0) // attribute number
+		b.writeInt32(int32(f.oid))
+		b.writeInt16(typeSize(f.oid))
+		b.writeInt32(-1) // type modifier
+		b.writeInt16(formatFor(formats, i))
+	}
+	b.finishMsg()
+}
+
+func (b *backend) sendRows(fields []field, rows [][]interface{}, formats []int16) error {
+	for _, row := range rows {
+		b.startMsg('D')
+		b.writeInt16(int16(len(row)))
+		for i, v := range row {
+			if v == nil {
+				b.writeInt32(-1)
+				continue
+			}
+			sizeIdx := len(b.wbuf)
+			b.writeInt32(0)
+			var err error
+			b.wbuf, err = appendValue(b.wbuf, fields[i].oid, formatFor(formats, i), v)
+			if err != nil {
+				b.wbuf = b.wbuf[:b.msgStart]
+				return err
+			}
+			binary.BigEndian.PutUint32(b.wbuf[sizeIdx:], uint32(len(b.wbuf)-sizeIdx-4))
+		}
+		b.finishMsg()
+	}
+	return nil
+}
+
+func (b *backend) sendError(e *pgError) {
+	if b.txStatus == 'T' {
+		b.txStatus = 'E'
+	}
+	b.sendErrorFields('E', e, "ERROR")
+}
+
+// sendNotices sends notices as NoticeResponse messages.
+func (b *backend) sendNotices(notices []*pgError) {
+	for _, n := range notices {
+		b.sendErrorFields('N', n, "NOTICE")
+	}
+}
+
+// sendErrorFields sends e as an ErrorResponse or NoticeResponse t.
+func (b *backend) sendErrorFields(t byte, e *pgError, defaultSeverity string) {
+	severity := e.severity
+	if severity == "" {
+		severity = defaultSeverity
+	}
+	b.startMsg(t)
+	for _, f := range []struct {
+		code  byte
+		value string
+	}{
+		{'S', severity},
+		{'V', severity},
+		{'C', e.code},
+		{'M', e.message},
+		{'D', e.detail},
+		{'s', e.schema},
+		{'t', e.table},
+		{'c', e.column},
+		{'n', e.constraint},
+	} {
+		if f.value != "" {
+			b.wbuf = append(b.wbuf, f.code)
+			b.writeCString(f.value)
+		}
+	}
+	b.wbuf = append(b.wbuf, 0)
+	b.finishMsg()
+}
+
+func (b *backend) sendReadyForQuery() {
+	b.startMsg('Z')
+	b.wbuf = append(b.wbuf, b.txStatus)
+	b.finishMsg()
+}
+
+// formatFor returns the format code for column or parameter i following the
+// protocol rules: no codes means text, one code applies to all.
+func formatFor(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return textFormat
+	case 1:
+		return formats[0]
+	default:
+		if i < len(formats) {
+			return formats[i]
+		}
+		return textFormat
+	}
+}
+
+func (b *backend) rxMsg() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(b.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[1:])) - 4
+	if size < 0 {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+	if cap(b.rbuf) < size {
+		b.rbuf = make([]byte, size)
+	}
+	body := b.rbuf[:size]
+	if _, err := io.ReadFull(b.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+func (b *backend) startMsg(t byte) {
+	b.msgStart = len(b.wbuf)
+	b.wbuf = append(b.wbuf, t, 0, 0, 0, 0)
+}
+
+// finishMsg fills in the length of the message started by the last startMsg.
+func (b *backend) finishMsg() {
+	binary.BigEndian.PutUint32(b.wbuf[b.msgStart+1:], uint32(len(b.wbuf)-b.msgStart-1))
+}
+
+func (b *backend) writeInt16(n int16) {
+	b.wbuf = appendUint16(b.wbuf, uint16(n))
+}
+
+func (b *backend) writeInt32(n int32) {
+	b.wbuf = appendUint32(b.wbuf, uint32(n))
+}
+
+func (b *backend) writeCString(s string) {
+	b.wbuf = append(b.wbuf, s...)
+	b.wbuf = append(b.wbuf, 0)
+}
+
+func (b *backend) flush() error {
+	if len(b.wbuf) == 0 {
+		return nil
+	}
+	_, err := b.conn.Write(b.wbuf)
+	b.wbuf = b.wbuf[:0]
+	return err
+}
+
+// msgReader decodes the body of a frontend message. The first error is kept
+// in err and later reads return zero values.
+type msgReader struct {
+	buf []byte
+	err error
+}
+
+func (r *msgReader) fail() {
+	if r.err == nil {
+		r.err = &pgError{code: "08P01", message: "invalid message format"}
+	}
+	r.buf = nil
+}
+
+func (r *msgReader) byte() byte {
+	if len(r.buf) < 1 {
+		r.fail()
+		return 0
+	}
+	c := r.buf[0]
+	r.buf = r.buf[1:]
+	return c
+}
+
+func (r *msgReader) int16() int16 {
+	if len(r.buf) < 2 {
+		r.fail()
+		return 0
+	}
+	n := int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return n
+}
+
+func (r *msgReader) int32() int32 {
+	if len(r.buf) < 4 {
+		r.fail()
+		return 0
+	}
+	n := int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return n
+}
+
+func (r *msgReader) bytes(n int) []byte {
+	if n < 0 || len(r.buf) < n {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *msgReader) cstring() string {
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.fail()
+	return ""
+}
@@ -0,0 +1,246 @@
+package fakepg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// copyBinarySignature starts the data of a binary COPY. It is followed by a
+// 32 bit flags field and a 32 bit header extension length.
+var copyBinarySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// copyOut runs the query of a COPY TO.
+func (db *database) copyOut(q *query) (*result, error) {
+	stmt := q.stmt.(*copyStmt)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	res, err := db.executeSelect(q, stmt.query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.tag = fmt.Sprintf("COPY %d", len(res.rows))
+	return res, nil
+}
+
+// appendCopyHeader appends what precedes the rows of a text or binary COPY.
+func appendCopyHeader(buf []byte, binaryCopy bool) []byte {
+	if !binaryCopy {
+		return buf
+	}
+	buf = append(buf, copyBinarySignature...)
+	buf = appendUint32(buf, 0) // flags
+	return appendUint32(buf, 0)
+}
+
+// appendCopyTrailer appends what follows the rows of a text or binary COPY.
+func appendCopyTrailer(buf []byte, binaryCopy bool) []byte {
+	if !binaryCopy {
+		return buf
+	}
+	return appendUint16(buf, 0xffff)
+}
+
+// appendCopyRow appends row in the text or binary COPY format.
+func appendCopyRow(buf []byte, fields []field, row []interface{}, binaryCopy bool) ([]byte, error) {
+	var err error
+	if binaryCopy {
+		buf = appendUint16(buf, uint16(len(row)))
+		for i, v := range row {
+			if v == nil {
+				buf = appendUint32(buf, 0xffffffff)
+				continue
+			}
+			sizeIdx := len(buf)
+			buf = appendUint32(buf, 0)
+			if buf, err = appendValue(buf, fields[i].oid, binaryFormat, v); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(buf[sizeIdx:], uint32(len(buf)-sizeIdx-4))
+		}
+		return buf, nil
+	}
+
+	for i, v := range row {
+		if i > 0 {
+			buf = append(buf, '\t')
+		}
+		if v == nil {
+			buf = append(buf, `\N`...)
+			continue
+		}
+		start := len(buf)
+		if buf, err = appendText(buf, fields[i].oid, v); err != nil {
+			return nil, err
+		}
+		if bytes.ContainsAny(buf[start:], "\\\t\n\r") {
+			escaped := copyTextEscaper.Replace(string(buf[start:]))
+			buf = append(buf[:start], escaped...)
+		}
+	}
+	return append(buf, '\n'), nil
+}
+
+var copyTextEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyIn inserts the rows in data, the concatenated CopyData messages of a
+// COPY FROM.
+func (db *database) copyIn(q *query, data []byte) (*result, error) {
+	stmt := q.stmt.(*copyStmt)
+	t := q.table
+
+	indexes := make([]int, len(stmt.columns))
+	oids := make([]Oid, len(stmt.columns))
+	listed := make([]bool, len(t.columns))
+	for i, name := range stmt.columns {
+		indexes[i] = t.columnIndex(name)
+		oids[i] = t.columns[indexes[i]].oid
+		listed[indexes[i]] = true
+	}
+
+	var rows [][]interface{}
+	var err error
+	if stmt.binary {
+		rows, err = parseCopyBinary(data, oids)
+	} else {
+		rows, err = parseCopyText(data, oids)
+	}
+	if err != nil {
+		return nil, &pgError{code: "22P04", message: err.Error()}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.tables[t.name] != t {
+		return nil, &pgError{code: "0A000", message: "cached plan must not change result type"}
+	}
+	for _, values := range rows {
+		row := make([]interface{}, len(t.columns))
+		for i, v := range values {
+			row[indexes[i]] = v
+		}
+		for i, col := range t.columns {
+			if !listed[i] && col.serial {
+				row[i] = t.nextID
+			}
+		}
+		if err := t.insertRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return &result{tag: fmt.Sprintf("COPY %d", len(rows))}, nil
+}
+
+// parseCopyText parses rows in the text COPY format.
+func parseCopyText(data []byte, oids []Oid) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		if string(line) == `\.` {
+			break
+		}
+
+		fields := bytes.Split(line, []byte{'\t'})
+		if len(fields) != len(oids) {
+			return nil, fmt.Errorf("COPY row has %d columns, expected %d", len(fields), len(oids))
+		}
+		row := make([]interface{}, len(fields))
+		for i, f := range fields {
+			if string(f) == `\N` {
+				continue
+			}
+			s, err := unescapeCopyText(f)
+			if err != nil {
+				return nil, err
+			}
+			if row[i], err = parseText(oids[i], s); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func unescapeCopyText(f []byte) (string, error) {
+	if bytes.IndexByte(f, '\\') < 0 {
+		return string(f), nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(f) {
+			return "", errors.New("COPY data ends with a backslash")
+		}
+		switch f[i] {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		default:
+			sb.WriteByte(f[i])
+		}
+	}
+	return sb.String(), nil
+}
+
+// parseCopyBinary parses rows in the binary COPY format.
+func parseCopyBinary(data []byte, oids []Oid) ([][]interface{}, error) {
+	r := &msgReader{buf: data}
+	if !bytes.Equal(r.bytes(len(copyBinarySignature)), copyBinarySignature) {
+		return nil, errors.New("COPY file signature not recognized")
+	}
+	r.int32() // flags
+	r.bytes(int(r.int32()))
+
+	var rows [][]interface{}
+	for {
+		n := r.int16()
+		if r.err != nil {
+			return nil, errors.New("unexpected end of binary COPY data")
+		}
+		if n == -1 {
+			return rows, nil
+		}
+		if int(n) != len(oids) {
+			return nil, fmt.Errorf("COPY row has %d columns, expected %d", n, len(oids))
+		}
+		row := make([]interface{}, n)
+		for i := range row {
+			size := r.int32()
+			if size < 0 {
+				continue
+			}
+			src := r.bytes(int(size))
+			if r.err != nil {
+				return nil, errors.New("unexpected end of binary COPY data")
+			}
+			var err error
+			if row[i], err = decodeValue(oids[i], binaryFormat, src); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+}
@@ -0,0 +1,153 @@
+package fakepg
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// database holds the tables shared by every connection to a Server.
+type database struct {
+	mu     sync.RWMutex
+	tables map[string]*table
+}
+
+func newDatabase() *database {
+	return &database{tables: make(map[string]*table)}
+}
+
+// table stores its rows ordered by primary key when it has an integer primary
+// key. Rows are never modified in place; updates replace them with a copy.
+type table struct {
+	name    string
+	columns []columnDef
+	rows    [][]interface{}
+	pk      int // index of the integer primary key column, -1 if none
+	nextID  int64
+}
+
+func (db *database) table(name string) (*table, error) {
+	t, ok := db.tables[name]
+	if !ok {
+		return nil, &pgError{code: "42P01", message: fmt.Sprintf("relation %q does not exist", name)}
+	}
+	return t, nil
+}
+
+func (t *table) columnIndex(name string) int {
+	for i := range t.columns {
+		if t.columns[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// pkRange returns the rows whose primary key is within [low, high].
+func (t *table) pkRange(low, high int64) [][]interface{} {
+	start := sort.Search(len(t.rows), func(i int) bool {
+		return t.rows[i][t.pk].(int64) >= low
+	})
+	end := sort.Search(len(t.rows), func(i int) bool {
+		return t.rows[i][t.pk].(int64) > high
+	})
+	if start >= end {
+		return nil
+	}
+	return t.rows[start:end]
+}
+
+// insertRow adds row keeping the rows ordered by primary key.
+func (t *table) insertRow(row []interface{}) error {
+	for i, col := range t.columns {
+		if col.notNull && row[i] == nil {
+			return &pgError{code: "23502", message: fmt.Sprintf("null value in column %q violates not-null constraint", col.name)}
+		}
+	}
+
+	if t.pk < 0 {
+		t.rows = append(t.rows, row)
+		return nil
+	}
+
+	id := row[t.pk].(int64)
+	if id >= t.nextID {
+		t.nextID = id + 1
+	}
+	n := len(t.rows)
+	if n == 0 || t.rows[n-1][t.pk].(int64) < id {
+		t.rows = append(t.rows, row)
+		return nil
+	}
+
+	i := sort.Search(n, func(i int) bool { return t.rows[i][t.pk].(int64) >= id })
+	if t.rows[i][t.pk].(int64) == id {
+		return &pgError{
+			code:       "23505",
+			message:    fmt.Sprintf("duplicate key value violates unique constraint \"%s_pkey\"", t.name),
+			detail:     fmt.Sprintf("Key (%s)=(%d) already exists.", t.columns[t.pk].name, id),
+			schema:     "public",
+			table:      t.name,
+			constraint: t.name + "_pkey",
+		}
+	}
+	t.rows = append(t.rows, nil)
+	copy(t.rows[i+1:], t.rows[i:])
+	t.rows[i] = row
+	return nil
+}
+
+// rowIndex returns the index of row in t.rows, or -1 if row is not in t.
+func (t *table) rowIndex(row []interface{}) int {
+	if t.pk >= 0 {
+		id := row[t.pk].(int64)
+		i := sort.Search(len(t.rows), func(i int) bool { return t.rows[i][t.pk].(int64) >= id })
+		if i < len(t.rows) && t.rows[i][t.pk].(int64) == id {
+			return i
+		}
+		return -1
+	}
+	for i := range t.rows {
+		if &t.rows[i][0] == &row[0] {
+			return i
+		}
+	}
+	return -1
+}
+
+// deleteRow removes row, which must have been read from t.rows.
+func (t *table) deleteRow(row []interface{}) {
+	if i := t.rowIndex(row); i >= 0 {
+		t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	}
+}
+
+// replaceRow replaces old, which must have been read from t.rows, with row.
+func (t *table) replaceRow(old, row []interface{}) error {
+	if t.pk >= 0 && row[t.pk] != old[t.pk] {
+		if row[t.pk] == nil {
+			return &pgError{code: "23502", message: fmt.Sprintf("null value in column %q violates not-null constraint", t.columns[t.pk].name)}
+		}
+		t.deleteRow(old)
+		return t.insertRow(row)
+	}
+	for i, col := range t.columns {
+		if col.notNull && row[i] == nil {
+			return &pgError{code: "23502", message: fmt.Sprintf("null value in column %q violates not-null constraint", col.name)}
+		}
+	}
+	if i := t.rowIndex(old); i >= 0 {
+		t.rows[i] = row
+	}
+	return nil
+}
+
+func newTable(stmt *createTableStmt) *table {
+	t := &table{name: stmt.name, columns: stmt.columns, pk: -1, nextID: 1}
+	for i, col := range t.columns {
+		if col.primaryKey && isIntType(col.oid) {
+			t.pk = i
+		}
+	}
+	return t
+}
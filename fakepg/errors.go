@@ -0,0 +1,28 @@
+package fakepg
+
+// pgError is sent to the client as an ErrorResponse, or as a NoticeResponse
+// when it is a notice in result.notices.
+type pgError struct {
+	severity string
+	code     string
+	message  string
+	detail   string
+
+	// The object the error is about, sent when set.
+	schema, table, column, constraint string
+}
+
+func (e *pgError) Error() string {
+	return e.message + " (SQLSTATE " + e.code + ")"
+}
+
+func syntaxError(message string) error {
+	return &pgError{code: "42601", message: message}
+}
+
+func toPgError(err error) *pgError {
+	if e, ok := err.(*pgError); ok {
+		return e
+	}
+	return &pgError{code: "XX000", message: err.Error()}
+}
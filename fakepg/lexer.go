@@ -0,0 +1,156 @@
+package fakepg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokParam
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex splits sql into tokens. Unquoted identifiers are folded to lower case
+// the same way PostgreSQL folds them. MySQL's ? placeholders are numbered in
+// order of appearance as if they were written $1, $2 and so on.
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	placeholders := 0
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentChar(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: strings.ToLower(sql[start:i])})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: sql[start:i]})
+		case c == '$':
+			start := i + 1
+			i++
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("syntax error at or near \"$\"")
+			}
+			tokens = append(tokens, token{kind: tokParam, text: sql[start:i]})
+		case c == '?':
+			placeholders++
+			tokens = append(tokens, token{kind: tokParam, text: strconv.Itoa(placeholders)})
+			i++
+		case c == '\'':
+			s, n, err := lexQuoted(sql[i:], '\'')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: s})
+			i += n
+		case c == '"':
+			s, n, err := lexQuoted(sql[i:], '"')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokQuotedIdent, text: s})
+			i += n
+		default:
+			op := string(c)
+			if i+1 < len(sql) {
+				switch two := sql[i : i+2]; two {
+				case "<=", ">=", "<>", "!=", "::", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("=<>!:|+-*/(),;.[]", op[:1]) {
+				return nil, fmt.Errorf("syntax error at or near %q", op)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// lexQuoted reads a quoted string or identifier where the quote character is
+// escaped by doubling it. It returns the unquoted text and the number of bytes
+// consumed.
+func lexQuoted(s string, quote byte) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] == quote {
+			if i+1 < len(s) && s[i+1] == quote {
+				b.WriteByte(quote)
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		}
+		b.WriteByte(s[i])
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// splitStatements splits a simple query string on semicolons that are not
+// inside quotes. Empty statements are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			statements = append(statements, sql[start:i])
+			start = i + 1
+		}
+	}
+	statements = append(statements, sql[start:])
+
+	nonEmpty := statements[:0]
+	for _, s := range statements {
+		if strings.TrimSpace(s) != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return nonEmpty
+}
@@ -0,0 +1,920 @@
+package fakepg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Capability flags of the MySQL protocol.
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientFoundRows        = 0x00000002
+	mysqlClientLongFlag         = 0x00000004
+	mysqlClientConnectWithDB    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConn       = 0x00008000
+	mysqlClientMultiStatements  = 0x00010000
+	mysqlClientMultiResults     = 0x00020000
+	mysqlClientPSMultiResults   = 0x00040000
+	mysqlClientPluginAuth       = 0x00080000
+	mysqlClientPluginAuthLenEnc = 0x00200000
+)
+
+const mysqlServerCapabilities = mysqlClientLongPassword | mysqlClientFoundRows | mysqlClientLongFlag |
+	mysqlClientConnectWithDB | mysqlClientProtocol41 | mysqlClientTransactions | mysqlClientSecureConn |
+	mysqlClientMultiStatements | mysqlClientMultiResults | mysqlClientPSMultiResults | mysqlClientPluginAuth |
+	mysqlClientPluginAuthLenEnc
+
+// Server status flags sent in OK and EOF packets.
+const (
+	mysqlStatusAutocommit       = 0x0002
+	mysqlStatusMoreResultsExist = 0x0008
+)
+
+const (
+	mysqlProtocolVersion  = 10
+	mysqlNativePassword   = "mysql_native_password"
+	mysqlMaxPacketSize    = 1<<24 - 1
+	mysqlSSLRequestLength = 32
+	mysqlCharsetUTF8MB4   = 45 // utf8mb4_general_ci
+	mysqlCharsetBinary    = 63
+)
+
+// Commands sent by MySQL clients.
+const (
+	mysqlComQuit             = 0x01
+	mysqlComInitDB           = 0x02
+	mysqlComQuery            = 0x03
+	mysqlComPing             = 0x0e
+	mysqlComStmtPrepare      = 0x16
+	mysqlComStmtExecute      = 0x17
+	mysqlComStmtSendLongData = 0x18
+	mysqlComStmtClose        = 0x19
+	mysqlComStmtReset        = 0x1a
+)
+
+// MySQLConfig contains the options used to start a MySQLServer.
+type MySQLConfig struct {
+	Password  string      // mysql_native_password of every user, empty for none
+	TLSConfig *tls.Config // accepts SSL requests when set -- nil refuses TLS
+}
+
+// MySQLServer is a fake MySQL server. It answers the same SQL as Server, with
+// ? placeholders, over the MySQL client/server protocol: the handshake with
+// mysql_native_password authentication and optional TLS, COM_QUERY with text
+// result sets and multiple statements, and prepared statements with binary
+// result sets. All connections share one database, separate from that of any
+// Server.
+type MySQLServer struct {
+	config   MySQLConfig
+	listener net.Listener
+	db       *database
+
+	mu         sync.Mutex
+	conns      map[*mysqlBackend]struct{}
+	nextConnID uint32
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+// ListenMySQL starts a MySQLServer listening on network and address, e.g.
+// "tcp" and "127.0.0.1:0". Connections are served in the background until
+// Close is called.
+func ListenMySQL(network, address string, config MySQLConfig) (*MySQLServer, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MySQLServer{
+		config:   config,
+		listener: listener,
+		db:       newDatabase(),
+		conns:    make(map[*mysqlBackend]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *MySQLServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes all open connections.
+func (s *MySQLServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for b := range s.conns {
+		b.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *MySQLServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.nextConnID++
+		b := &mysqlBackend{
+			server:     s,
+			conn:       conn,
+			reader:     bufio.NewReaderSize(conn, 8192),
+			connID:     s.nextConnID,
+			statements: make(map[uint32]*mysqlStmt),
+		}
+		s.conns[b] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			b.run()
+
+			s.mu.Lock()
+			delete(s.conns, b)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// mysqlError is sent to the client as an ERR packet.
+type mysqlError struct {
+	number   uint16
+	sqlState string
+	message  string
+}
+
+func (e *mysqlError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.number, e.message)
+}
+
+// mysqlErrorCodes maps the SQLSTATE of the errors the database returns to
+// the MySQL error number and SQLSTATE a MySQL server would send instead.
+var mysqlErrorCodes = map[string]mysqlError{
+	"42601": {1064, "42000", ""}, // ER_PARSE_ERROR
+	"42P01": {1146, "42S02", ""}, // ER_NO_SUCH_TABLE
+	"42P07": {1050, "42S01", ""}, // ER_TABLE_EXISTS_ERROR
+	"42703": {1054, "42S22", ""}, // ER_BAD_FIELD_ERROR
+	"23502": {1048, "23000", ""}, // ER_BAD_NULL_ERROR
+	"23505": {1062, "23000", ""}, // ER_DUP_ENTRY
+	"22P02": {1366, "HY000", ""}, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+}
+
+func toMySQLError(err error) *mysqlError {
+	if e, ok := err.(*mysqlError); ok {
+		return e
+	}
+	pgErr := toPgError(err)
+	e, ok := mysqlErrorCodes[pgErr.code]
+	if !ok {
+		e = mysqlError{1105, "HY000", ""} // ER_UNKNOWN_ERROR
+	}
+	e.message = pgErr.message
+	return &e
+}
+
+// mysqlStmt is a statement prepared with COM_STMT_PREPARE.
+type mysqlStmt struct {
+	query *query
+
+	// paramTypes are the types bound by the last COM_STMT_EXECUTE. Clients
+	// only send them again when they change.
+	paramTypes []byte
+
+	// longData holds the parameters sent with COM_STMT_SEND_LONG_DATA for the
+	// next execution.
+	longData map[int][]byte
+}
+
+// mysqlBackend serves a single MySQL client connection.
+type mysqlBackend struct {
+	server *MySQLServer
+	conn   net.Conn
+	reader *bufio.Reader
+	wbuf   []byte
+	rbuf   []byte
+
+	seq         byte // sequence id of the next packet
+	packetStart int  // offset in wbuf of the packet being written
+
+	connID       uint32
+	capabilities uint32 // flags of the client, limited to those of the server
+	user         string
+
+	statements map[uint32]*mysqlStmt
+	nextStmtID uint32
+}
+
+func (b *mysqlBackend) run() {
+	defer b.conn.Close()
+
+	if err := b.handshake(); err != nil {
+		return
+	}
+
+	for {
+		body, err := b.readPacket()
+		if err != nil || len(body) == 0 {
+			return
+		}
+		if body[0] == mysqlComQuit {
+			return
+		}
+		b.handleCommand(body[0], body[1:])
+		if err := b.flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (b *mysqlBackend) handshake() error {
+	var scramble [20]byte
+	rand.Read(scramble[:])
+	// Clients treat the scramble as NUL terminated.
+	for i := range scramble {
+		scramble[i] = scramble[i]&0x7f | 1
+	}
+
+	capabilities := uint32(mysqlServerCapabilities)
+	if b.server.config.TLSConfig != nil {
+		capabilities |= mysqlClientSSL
+	}
+
+	b.startPacket()
+	b.wbuf = append(b.wbuf, mysqlProtocolVersion)
+	b.writeCString("5.7.0-fakepg")
+	b.writeUint32(b.connID)
+	b.wbuf = append(b.wbuf, scramble[:8]...)
+	b.wbuf = append(b.wbuf, 0)
+	b.writeUint16(uint16(capabilities))
+	b.wbuf = append(b.wbuf, mysqlCharsetUTF8MB4)
+	b.writeUint16(mysqlStatusAutocommit)
+	b.writeUint16(uint16(capabilities >> 16))
+	b.wbuf = append(b.wbuf, byte(len(scramble)+1))
+	b.wbuf = append(b.wbuf, make([]byte, 10)...)
+	b.wbuf = append(b.wbuf, scramble[8:]...)
+	b.wbuf = append(b.wbuf, 0)
+	b.writeCString(mysqlNativePassword)
+	b.finishPacket()
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	body, err := b.readPacket()
+	if err != nil {
+		return err
+	}
+	r := &mysqlReader{buf: body}
+	b.capabilities = r.uint32() & capabilities
+	if b.capabilities&mysqlClientProtocol41 == 0 {
+		return b.fatal(&mysqlError{1251, "08004", "Client does not support the 4.1 protocol"})
+	}
+
+	if len(body) == mysqlSSLRequestLength && b.capabilities&mysqlClientSSL != 0 {
+		// Clients do not wait for a response before starting the TLS
+		// handshake, so part of it may already be buffered in b.reader.
+		tlsConn := tls.Server(&bufferedConn{Conn: b.conn, reader: b.reader}, b.server.config.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		b.conn = tlsConn
+		b.reader = bufio.NewReaderSize(b.conn, 8192)
+
+		if body, err = b.readPacket(); err != nil {
+			return err
+		}
+		r = &mysqlReader{buf: body}
+		r.uint32()
+	}
+
+	r.bytes(4 + 1 + 23) // max packet size, character set and filler
+	b.user = r.cstring()
+	var authResponse []byte
+	switch {
+	case b.capabilities&mysqlClientPluginAuthLenEnc != 0:
+		authResponse = r.lengthEncodedBytes()
+	case b.capabilities&mysqlClientSecureConn != 0:
+		authResponse = r.bytes(int(r.byte()))
+	default:
+		authResponse = []byte(r.cstring())
+	}
+	if b.capabilities&mysqlClientConnectWithDB != 0 {
+		r.cstring()
+	}
+	plugin := mysqlNativePassword
+	if b.capabilities&mysqlClientPluginAuth != 0 && len(r.buf) > 0 {
+		plugin = r.cstring()
+	}
+	if r.err != nil {
+		return b.fatal(&mysqlError{1043, "08S01", "Bad handshake"})
+	}
+
+	if plugin != mysqlNativePassword || !bytes.Equal(authResponse, nativePasswordAuth(scramble[:], b.server.config.Password)) {
+		return b.fatal(&mysqlError{1045, "28000", fmt.Sprintf("Access denied for user '%s' (using password: %s)", b.user, yesNo(len(authResponse) > 0))})
+	}
+
+	b.sendOK(0, 0, mysqlStatusAutocommit)
+	return b.flush()
+}
+
+// bufferedConn is a connection whose reads start with what was already
+// buffered by reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// fatal sends e and returns it as the error that ends the connection.
+func (b *mysqlBackend) fatal(e *mysqlError) error {
+	b.sendError(e)
+	b.flush()
+	return e
+}
+
+// nativePasswordAuth returns the mysql_native_password response to scramble:
+// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password))). An empty password
+// has an empty response.
+func nativePasswordAuth(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.New()
+	h.Write(scramble)
+	h.Write(stage2[:])
+	auth := h.Sum(nil)
+	for i := range auth {
+		auth[i] ^= stage1[i]
+	}
+	return auth
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func (b *mysqlBackend) handleCommand(command byte, body []byte) {
+	r := &mysqlReader{buf: body}
+
+	switch command {
+	case mysqlComInitDB, mysqlComPing:
+		b.sendOK(0, 0, mysqlStatusAutocommit)
+	case mysqlComQuery:
+		b.query(string(body))
+	case mysqlComStmtPrepare:
+		if err := b.prepare(string(body)); err != nil {
+			b.sendError(toMySQLError(err))
+		}
+	case mysqlComStmtExecute:
+		if err := b.execute(r); err != nil {
+			b.sendError(toMySQLError(err))
+		}
+	case mysqlComStmtSendLongData:
+		// There is no response, even to errors.
+		id, param := r.uint32(), int(r.uint16())
+		if stmt, ok := b.statements[id]; ok && r.err == nil && param < len(stmt.query.paramOids) {
+			if stmt.longData == nil {
+				stmt.longData = make(map[int][]byte)
+			}
+			stmt.longData[param] = append(stmt.longData[param], r.buf...)
+		}
+	case mysqlComStmtClose:
+		delete(b.statements, r.uint32())
+	case mysqlComStmtReset:
+		stmt, ok := b.statements[r.uint32()]
+		if !ok {
+			b.sendError(&mysqlError{1243, "HY000", "Unknown prepared statement handler given to mysqld_stmt_reset"})
+			break
+		}
+		stmt.longData = nil
+		b.sendOK(0, 0, mysqlStatusAutocommit)
+	default:
+		b.sendError(&mysqlError{1047, "08S01", "Unknown command"})
+	}
+}
+
+// query runs the statements of a COM_QUERY, sending a text result set or an
+// OK packet for each. Clients must enable multiple statements to send more
+// than one.
+func (b *mysqlBackend) query(sql string) {
+	statements := splitStatements(sql)
+	if len(statements) == 0 {
+		b.sendError(&mysqlError{1065, "42000", "Query was empty"})
+		return
+	}
+	if len(statements) > 1 && b.capabilities&mysqlClientMultiStatements == 0 {
+		b.sendError(&mysqlError{1064, "42000", "You have an error in your SQL syntax; multiple statements are not enabled"})
+		return
+	}
+
+	for i, s := range statements {
+		status := uint16(mysqlStatusAutocommit)
+		if i < len(statements)-1 {
+			status |= mysqlStatusMoreResultsExist
+		}
+
+		q, err := b.server.db.prepare(s, nil)
+		if err == nil && len(q.paramOids) > 0 {
+			err = syntaxError("COM_QUERY does not take parameters")
+		}
+		if err == nil {
+			if _, ok := q.stmt.(*copyStmt); ok {
+				err = syntaxError("COPY is not supported over the MySQL protocol")
+			}
+		}
+		if err != nil {
+			b.sendError(toMySQLError(err))
+			return
+		}
+
+		res, err := b.server.db.execute(q, nil, nil)
+		if err != nil {
+			b.sendError(toMySQLError(err))
+			return
+		}
+		if err := b.sendResult(res, false, status); err != nil {
+			b.sendError(toMySQLError(err))
+			return
+		}
+	}
+}
+
+// prepare handles COM_STMT_PREPARE.
+func (b *mysqlBackend) prepare(sql string) error {
+	q, err := b.server.db.prepare(sql, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := q.stmt.(*copyStmt); ok {
+		return syntaxError("COPY is not supported over the MySQL protocol")
+	}
+
+	b.nextStmtID++
+	b.statements[b.nextStmtID] = &mysqlStmt{query: q}
+
+	b.startPacket()
+	b.wbuf = append(b.wbuf, 0)
+	b.writeUint32(b.nextStmtID)
+	b.writeUint16(uint16(len(q.fields)))
+	b.writeUint16(uint16(len(q.paramOids)))
+	b.wbuf = append(b.wbuf, 0)
+	b.writeUint16(0) // warnings
+	b.finishPacket()
+
+	if len(q.paramOids) > 0 {
+		for _, oid := range q.paramOids {
+			b.sendColumnDefinition(field{name: "?", oid: oid})
+		}
+		b.sendEOF(mysqlStatusAutocommit)
+	}
+	if len(q.fields) > 0 {
+		for _, f := range q.fields {
+			b.sendColumnDefinition(f)
+		}
+		b.sendEOF(mysqlStatusAutocommit)
+	}
+	return nil
+}
+
+// execute handles COM_STMT_EXECUTE.
+func (b *mysqlBackend) execute(r *mysqlReader) error {
+	id := r.uint32()
+	stmt, ok := b.statements[id]
+	if !ok {
+		return &mysqlError{1243, "HY000", fmt.Sprintf("Unknown prepared statement handler (%d) given to mysqld_stmt_execute", id)}
+	}
+	r.byte()   // cursor flags
+	r.uint32() // iteration count
+
+	q := stmt.query
+	params := make([]interface{}, len(q.paramOids))
+	if len(params) > 0 {
+		nullMap := r.bytes((len(params) + 7) / 8)
+		if r.byte() == 1 {
+			stmt.paramTypes = append(stmt.paramTypes[:0], r.bytes(2*len(params))...)
+		}
+		if len(stmt.paramTypes) != 2*len(params) {
+			return &mysqlError{1210, "HY000", "Incorrect arguments to mysqld_stmt_execute"}
+		}
+		for i := range params {
+			if r.err != nil {
+				break
+			}
+			if nullMap[i/8]&(1<<(uint(i)%8)) != 0 {
+				continue
+			}
+			var v interface{}
+			var err error
+			if data, ok := stmt.longData[i]; ok {
+				v = string(data)
+			} else if v, err = r.binaryValue(stmt.paramTypes[2*i], stmt.paramTypes[2*i+1]&0x80 != 0); err != nil {
+				return err
+			}
+			if params[i], err = coerce(v, q.paramOids[i]); err != nil {
+				return &pgError{code: "22P02", message: err.Error()}
+			}
+		}
+	}
+	stmt.longData = nil
+	if r.err != nil {
+		return r.err
+	}
+
+	res, err := b.server.db.execute(q, params, nil)
+	if err != nil {
+		return err
+	}
+	return b.sendResult(res, true, mysqlStatusAutocommit)
+}
+
+// sendResult sends res as a result set, in the binary format of prepared
+// statements or the text format of COM_QUERY, or as an OK packet if it has no
+// columns.
+func (b *mysqlBackend) sendResult(res *result, binaryRows bool, status uint16) error {
+	if res.fields == nil {
+		b.sendOK(rowsAffected(res.tag), res.insertID, status)
+		return nil
+	}
+
+	start := len(b.wbuf)
+	startSeq := b.seq
+	b.startPacket()
+	b.writeLengthEncodedInt(uint64(len(res.fields)))
+	b.finishPacket()
+	for _, f := range res.fields {
+		b.sendColumnDefinition(f)
+	}
+	b.sendEOF(status &^ mysqlStatusMoreResultsExist)
+
+	for _, row := range res.rows {
+		var err error
+		if binaryRows {
+			err = b.sendBinaryRow(res.fields, row)
+		} else {
+			err = b.sendTextRow(res.fields, row)
+		}
+		if err != nil {
+			// Unless some rows have already been flushed, nothing of a
+			// result set that cannot be encoded is sent.
+			if start >= 0 {
+				b.wbuf, b.seq = b.wbuf[:start], startSeq
+			}
+			return err
+		}
+		if len(b.wbuf) >= flushThreshold {
+			if err := b.flush(); err != nil {
+				return err
+			}
+			start = -1
+		}
+	}
+	b.sendEOF(status)
+	return nil
+}
+
+// rowsAffected returns the row count at the end of a command tag such as
+// "INSERT 0 1" or "DELETE 3", or 0 if there is none.
+func rowsAffected(tag string) uint64 {
+	n, err := strconv.ParseUint(tag[strings.LastIndexByte(tag, ' ')+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (b *mysqlBackend) sendTextRow(fields []field, row []interface{}) error {
+	b.startPacket()
+	for i, v := range row {
+		if v == nil {
+			b.wbuf = append(b.wbuf, 0xfb)
+			continue
+		}
+		text, err := appendMySQLText(nil, fields[i].oid, v)
+		if err != nil {
+			return err
+		}
+		b.writeLengthEncodedBytes(text)
+	}
+	b.finishPacket()
+	return nil
+}
+
+func (b *mysqlBackend) sendBinaryRow(fields []field, row []interface{}) error {
+	b.startPacket()
+	b.wbuf = append(b.wbuf, 0)
+	// The NULL bitmap of binary rows starts at bit 2.
+	nullMap := len(b.wbuf)
+	b.wbuf = append(b.wbuf, make([]byte, (len(row)+7+2)/8)...)
+	for i, v := range row {
+		if v == nil {
+			b.wbuf[nullMap+(i+2)/8] |= 1 << (uint(i+2) % 8)
+			continue
+		}
+		var err error
+		if b.wbuf, err = appendMySQLBinary(b.wbuf, fields[i].oid, v); err != nil {
+			return err
+		}
+	}
+	b.finishPacket()
+	return nil
+}
+
+func (b *mysqlBackend) sendColumnDefinition(f field) {
+	t := mysqlTypeOf(f.oid)
+	b.startPacket()
+	b.writeLengthEncodedBytes([]byte("def"))
+	b.writeLengthEncodedBytes(nil) // schema
+	b.writeLengthEncodedBytes(nil) // table
+	b.writeLengthEncodedBytes(nil) // original table
+	b.writeLengthEncodedBytes([]byte(f.name))
+	b.writeLengthEncodedBytes([]byte(f.name))
+	b.wbuf = append(b.wbuf, 0x0c) // length of the fixed fields
+	b.writeUint16(uint16(t.charset))
+	b.writeUint32(t.length)
+	b.wbuf = append(b.wbuf, t.typ)
+	b.writeUint16(t.flags)
+	b.wbuf = append(b.wbuf, t.decimals)
+	b.writeUint16(0)
+	b.finishPacket()
+}
+
+func (b *mysqlBackend) sendOK(affectedRows uint64, insertID int64, status uint16) {
+	b.startPacket()
+	b.wbuf = append(b.wbuf, 0)
+	b.writeLengthEncodedInt(affectedRows)
+	b.writeLengthEncodedInt(uint64(insertID))
+	b.writeUint16(status)
+	b.writeUint16(0) // warnings
+	b.finishPacket()
+}
+
+func (b *mysqlBackend) sendEOF(status uint16) {
+	b.startPacket()
+	b.wbuf = append(b.wbuf, 0xfe)
+	b.writeUint16(0) // warnings
+	b.writeUint16(status)
+	b.finishPacket()
+}
+
+func (b *mysqlBackend) sendError(e *mysqlError) {
+	b.startPacket()
+	b.wbuf = append(b.wbuf, 0xff)
+	b.writeUint16(e.number)
+	b.wbuf = append(b.wbuf, '#')
+	b.wbuf = append(b.wbuf, e.sqlState...)
+	b.wbuf = append(b.wbuf, e.message...)
+	b.finishPacket()
+}
+
+// readPacket reads a packet, joining the parts of one that was split because
+// it is at least mysqlMaxPacketSize long. The sequence id of the response
+// follows that of the packet.
+func (b *mysqlBackend) readPacket() ([]byte, error) {
+	var body []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(b.reader, header[:]); err != nil {
+			return nil, err
+		}
+		size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		b.seq = header[3] + 1
+
+		start := len(body)
+		if cap(b.rbuf) < start+size {
+			rbuf := make([]byte, start+size)
+			copy(rbuf, body)
+			b.rbuf = rbuf
+		}
+		body = b.rbuf[:start+size]
+		if _, err := io.ReadFull(b.reader, body[start:]); err != nil {
+			return nil, err
+		}
+		if size < mysqlMaxPacketSize {
+			return body, nil
+		}
+	}
+}
+
+// startPacket starts a packet with the next sequence id.
+func (b *mysqlBackend) startPacket() {
+	b.packetStart = len(b.wbuf)
+	b.wbuf = append(b.wbuf, 0, 0, 0, b.seq)
+	b.seq++
+}
+
+// finishPacket fills in the length of the packet started by the last
+// startPacket, splitting it into several if it is too long for one.
+func (b *mysqlBackend) finishPacket() {
+	size := len(b.wbuf) - b.packetStart - 4
+	if size < mysqlMaxPacketSize {
+		b.wbuf[b.packetStart] = byte(size)
+		b.wbuf[b.packetStart+1] = byte(size >> 8)
+		b.wbuf[b.packetStart+2] = byte(size >> 16)
+		return
+	}
+
+	payload := append([]byte(nil), b.wbuf[b.packetStart+4:]...)
+	b.wbuf = b.wbuf[:b.packetStart]
+	b.seq--
+	for {
+		n := len(payload)
+		if n > mysqlMaxPacketSize {
+			n = mysqlMaxPacketSize
+		}
+		b.wbuf = append(b.wbuf, byte(n), byte(n>>8), byte(n>>16), b.seq)
+		b.seq++
+		b.wbuf = append(b.wbuf, payload[:n]...)
+		payload = payload[n:]
+		// A part shorter than the maximum ends the packet.
+		if n < mysqlMaxPacketSize {
+			return
+		}
+	}
+}
+
+func (b *mysqlBackend) writeUint16(n uint16) {
+	b.wbuf = append(b.wbuf, byte(n), byte(n>>8))
+}
+
+func (b *mysqlBackend) writeUint32(n uint32) {
+	b.wbuf = append(b.wbuf, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+}
+
+func (b *mysqlBackend) writeCString(s string) {
+	b.wbuf = append(b.wbuf, s...)
+	b.wbuf = append(b.wbuf, 0)
+}
+
+func (b *mysqlBackend) writeLengthEncodedInt(n uint64) {
+	b.wbuf = appendLengthEncodedInt(b.wbuf, n)
+}
+
+func (b *mysqlBackend) writeLengthEncodedBytes(s []byte) {
+	b.wbuf = appendLengthEncodedInt(b.wbuf, uint64(len(s)))
+	b.wbuf = append(b.wbuf, s...)
+}
+
+func (b *mysqlBackend) flush() error {
+	if len(b.wbuf) == 0 {
+		return nil
+	}
+	_, err := b.conn.Write(b.wbuf)
+	b.wbuf = b.wbuf[:0]
+	return err
+}
+
+func appendLengthEncodedInt(buf []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(buf, byte(n))
+	case n < 1<<16:
+		return append(buf, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(buf, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		buf = append(buf, 0xfe)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], n)
+		return append(buf, b[:]...)
+	}
+}
+
+// mysqlReader decodes a packet from a MySQL client. The first error is kept
+// in err and later reads return zero values.
+type mysqlReader struct {
+	buf []byte
+	err error
+}
+
+var errMalformedPacket = &mysqlError{1835, "HY000", "Malformed communication packet"}
+
+func (r *mysqlReader) fail() {
+	if r.err == nil {
+		r.err = errMalformedPacket
+	}
+	r.buf = nil
+}
+
+func (r *mysqlReader) bytes(n int) []byte {
+	if n < 0 || len(r.buf) < n {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *mysqlReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *mysqlReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *mysqlReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *mysqlReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *mysqlReader) cstring() string {
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.fail()
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *mysqlReader) lengthEncodedInt() uint64 {
+	switch c := r.byte(); c {
+	case 0xfc:
+		return uint64(r.uint16())
+	case 0xfd:
+		b := r.bytes(3)
+		if b == nil {
+			return 0
+		}
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+	case 0xfe:
+		return r.uint64()
+	case 0xfb, 0xff:
+		r.fail()
+		return 0
+	default:
+		return uint64(c)
+	}
+}
+
+func (r *mysqlReader) lengthEncodedBytes() []byte {
+	n := r.lengthEncodedInt()
+	if n > uint64(len(r.buf)) {
+		r.fail()
+		return nil
+	}
+	return r.bytes(int(n))
+}
@@ -0,0 +1,243 @@
+package fakepg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Column types of the MySQL protocol.
+const (
+	mysqlTypeDecimal    = 0x00
+	mysqlTypeTiny       = 0x01
+	mysqlTypeShort      = 0x02
+	mysqlTypeLong       = 0x03
+	mysqlTypeFloat      = 0x04
+	mysqlTypeDouble     = 0x05
+	mysqlTypeNull       = 0x06
+	mysqlTypeTimestamp  = 0x07
+	mysqlTypeLongLong   = 0x08
+	mysqlTypeInt24      = 0x09
+	mysqlTypeDate       = 0x0a
+	mysqlTypeDateTime   = 0x0c
+	mysqlTypeYear       = 0x0d
+	mysqlTypeVarChar    = 0x0f
+	mysqlTypeJSON       = 0xf5
+	mysqlTypeNewDecimal = 0xf6
+	mysqlTypeTinyBlob   = 0xf9
+	mysqlTypeMediumBlob = 0xfa
+	mysqlTypeLongBlob   = 0xfb
+	mysqlTypeBlob       = 0xfc
+	mysqlTypeVarString  = 0xfd
+	mysqlTypeString     = 0xfe
+)
+
+// Column definition flags.
+const (
+	mysqlFlagBinary = 0x0080
+)
+
+// mysqlColumnType describes how a column of a PostgreSQL type is reported to
+// MySQL clients.
+type mysqlColumnType struct {
+	typ      byte
+	charset  byte
+	length   uint32 // maximum display width
+	flags    uint16
+	decimals byte
+}
+
+// mysqlTypeOf returns the MySQL column type closest to oid. Types MySQL has
+// no equivalent for are sent as strings in their text format.
+func mysqlTypeOf(oid Oid) mysqlColumnType {
+	switch oid {
+	case BoolOid:
+		return mysqlColumnType{typ: mysqlTypeTiny, charset: mysqlCharsetBinary, length: 1, flags: mysqlFlagBinary}
+	case Int2Oid:
+		return mysqlColumnType{typ: mysqlTypeShort, charset: mysqlCharsetBinary, length: 6, flags: mysqlFlagBinary}
+	case Int4Oid:
+		return mysqlColumnType{typ: mysqlTypeLong, charset: mysqlCharsetBinary, length: 11, flags: mysqlFlagBinary}
+	case Int8Oid, OidOid:
+		return mysqlColumnType{typ: mysqlTypeLongLong, charset: mysqlCharsetBinary, length: 20, flags: mysqlFlagBinary}
+	case Float4Oid:
+		return mysqlColumnType{typ: mysqlTypeFloat, charset: mysqlCharsetBinary, length: 12, flags: mysqlFlagBinary, decimals: 0x1f}
+	case Float8Oid:
+		return mysqlColumnType{typ: mysqlTypeDouble, charset: mysqlCharsetBinary, length: 22, flags: mysqlFlagBinary, decimals: 0x1f}
+	case NumericOid:
+		return mysqlColumnType{typ: mysqlTypeNewDecimal, charset: mysqlCharsetBinary, length: 65, flags: mysqlFlagBinary, decimals: 0x1f}
+	case DateOid:
+		return mysqlColumnType{typ: mysqlTypeDate, charset: mysqlCharsetBinary, length: 10, flags: mysqlFlagBinary}
+	case TimestampOid, TimestamptzOid:
+		return mysqlColumnType{typ: mysqlTypeDateTime, charset: mysqlCharsetBinary, length: 26, flags: mysqlFlagBinary, decimals: 6}
+	case ByteaOid:
+		return mysqlColumnType{typ: mysqlTypeBlob, charset: mysqlCharsetBinary, length: math.MaxUint32, flags: mysqlFlagBinary}
+	default:
+		return mysqlColumnType{typ: mysqlTypeVarString, charset: mysqlCharsetUTF8MB4, length: math.MaxUint32}
+	}
+}
+
+const (
+	mysqlDateFormat     = "2006-01-02"
+	mysqlDateTimeFormat = "2006-01-02 15:04:05.999999"
+)
+
+// appendMySQLText appends v in the text format of the MySQL column type of
+// oid. Times are in UTC, the time zone of every connection.
+func appendMySQLText(buf []byte, oid Oid, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	case []byte:
+		return append(buf, v...), nil
+	case time.Time:
+		if oid == DateOid {
+			return v.AppendFormat(buf, mysqlDateFormat), nil
+		}
+		return v.UTC().AppendFormat(buf, mysqlDateTimeFormat), nil
+	default:
+		return appendText(buf, oid, v)
+	}
+}
+
+// appendMySQLBinary appends v in the binary row format of the MySQL column
+// type of oid.
+func appendMySQLBinary(buf []byte, oid Oid, v interface{}) ([]byte, error) {
+	switch mysqlTypeOf(oid).typ {
+	case mysqlTypeTiny:
+		switch v := v.(type) {
+		case bool:
+			if v {
+				return append(buf, 1), nil
+			}
+			return append(buf, 0), nil
+		case int64:
+			return append(buf, byte(v)), nil
+		}
+	case mysqlTypeShort:
+		if n, ok := v.(int64); ok {
+			return append(buf, byte(n), byte(n>>8)), nil
+		}
+	case mysqlTypeLong:
+		if n, ok := v.(int64); ok {
+			return append(buf, byte(n), byte(n>>8), byte(n>>16), byte(n>>24)), nil
+		}
+	case mysqlTypeLongLong:
+		if n, ok := v.(int64); ok {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(n))
+			return append(buf, b[:]...), nil
+		}
+	case mysqlTypeFloat:
+		if f, ok := v.(float64); ok {
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+			return append(buf, b[:]...), nil
+		}
+	case mysqlTypeDouble:
+		if f, ok := v.(float64); ok {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+			return append(buf, b[:]...), nil
+		}
+	case mysqlTypeDate, mysqlTypeDateTime:
+		if t, ok := v.(time.Time); ok {
+			return appendMySQLBinaryTime(buf, oid, t), nil
+		}
+	default:
+		text, err := appendMySQLText(nil, oid, v)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendLengthEncodedInt(buf, uint64(len(text)))
+		return append(buf, text...), nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as MySQL %s", v, typeNames[oid])
+}
+
+// appendMySQLBinaryTime appends t as a binary DATE or DATETIME, omitting the
+// time of day and the microseconds when they are zero.
+func appendMySQLBinaryTime(buf []byte, oid Oid, t time.Time) []byte {
+	if oid != DateOid {
+		t = t.UTC()
+	}
+	micro := t.Nanosecond() / 1000
+	n := 11
+	switch {
+	case oid == DateOid || t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && micro == 0:
+		n = 4
+	case micro == 0:
+		n = 7
+	}
+
+	buf = append(buf, byte(n), byte(t.Year()), byte(t.Year()>>8), byte(t.Month()), byte(t.Day()))
+	if n > 4 {
+		buf = append(buf, byte(t.Hour()), byte(t.Minute()), byte(t.Second()))
+	}
+	if n > 7 {
+		buf = append(buf, byte(micro), byte(micro>>8), byte(micro>>16), byte(micro>>24))
+	}
+	return buf
+}
+
+// binaryValue reads a parameter of COM_STMT_EXECUTE sent as MySQL type typ
+// and returns its internal representation. Strings are returned as is, to be
+// converted to the type of the parameter by coerce.
+func (r *mysqlReader) binaryValue(typ byte, unsigned bool) (interface{}, error) {
+	switch typ {
+	case mysqlTypeNull:
+		return nil, nil
+	case mysqlTypeTiny:
+		if unsigned {
+			return int64(r.byte()), nil
+		}
+		return int64(int8(r.byte())), nil
+	case mysqlTypeShort, mysqlTypeYear:
+		if unsigned {
+			return int64(r.uint16()), nil
+		}
+		return int64(int16(r.uint16())), nil
+	case mysqlTypeLong, mysqlTypeInt24:
+		if unsigned {
+			return int64(r.uint32()), nil
+		}
+		return int64(int32(r.uint32())), nil
+	case mysqlTypeLongLong:
+		n := r.uint64()
+		if unsigned && n > math.MaxInt64 {
+			return nil, &pgError{code: "22003", message: "bigint out of range: " + strconv.FormatUint(n, 10)}
+		}
+		return int64(n), nil
+	case mysqlTypeFloat:
+		return float64(math.Float32frombits(r.uint32())), nil
+	case mysqlTypeDouble:
+		return math.Float64frombits(r.uint64()), nil
+	case mysqlTypeDate, mysqlTypeDateTime, mysqlTypeTimestamp:
+		b := r.bytes(int(r.byte()))
+		var year, month, day, hour, min, sec, micro int
+		switch len(b) {
+		case 11:
+			micro = int(binary.LittleEndian.Uint32(b[7:]))
+			fallthrough
+		case 7:
+			hour, min, sec = int(b[4]), int(b[5]), int(b[6])
+			fallthrough
+		case 4:
+			year, month, day = int(binary.LittleEndian.Uint16(b)), int(b[2]), int(b[3])
+		case 0:
+		default:
+			r.fail()
+			return nil, r.err
+		}
+		return time.Date(year, time.Month(month), day, hour, min, sec, micro*1000, time.UTC), nil
+	case mysqlTypeDecimal, mysqlTypeNewDecimal, mysqlTypeVarChar, mysqlTypeJSON, mysqlTypeTinyBlob,
+		mysqlTypeMediumBlob, mysqlTypeLongBlob, mysqlTypeBlob, mysqlTypeVarString, mysqlTypeString:
+		return string(r.lengthEncodedBytes()), nil
+	default:
+		return nil, &mysqlError{1210, "HY000", fmt.Sprintf("Unsupported parameter type %#x", typ)}
+	}
+}
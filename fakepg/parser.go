@@ -0,0 +1,1119 @@
+package fakepg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The parser understands the small subset of SQL that the benchmarks send:
+// single table selects with simple predicates, multi-row inserts, single table
+// updates and deletes, COPY to and from the client, create, drop and truncate
+// table, and a handful of utility commands that are acknowledged without doing
+// anything.
+
+type statement interface{}
+
+type selectStmt struct {
+	targets []target
+	from    string // empty when there is no from clause
+	where   expr
+	orderBy []orderItem
+	limit   expr
+}
+
+type target struct {
+	expr expr
+	name string
+	star bool
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+type insertStmt struct {
+	table     string
+	columns   []string
+	rows      [][]expr
+	returning []target
+}
+
+type updateStmt struct {
+	table     string
+	set       []assignment
+	where     expr
+	returning []target
+}
+
+type assignment struct {
+	column string
+	value  expr
+}
+
+type deleteStmt struct {
+	table     string
+	where     expr
+	returning []target
+}
+
+// copyStmt is COPY between a table and the client. query selects the rows of
+// a COPY TO; a COPY of a table is parsed into a select of its columns.
+type copyStmt struct {
+	from    bool
+	table   string
+	columns []string // COPY FROM only; empty means all columns
+	query   *selectStmt
+	binary  bool
+}
+
+type createTableStmt struct {
+	name        string
+	columns     []columnDef
+	ifNotExists bool
+}
+
+type columnDef struct {
+	name       string
+	oid        Oid
+	serial     bool
+	primaryKey bool
+	notNull    bool
+}
+
+type dropTableStmt struct {
+	names    []string
+	ifExists bool
+}
+
+type truncateStmt struct {
+	names []string
+}
+
+// utilityStmt is a command that only needs a CommandComplete, e.g. begin or
+// analyze.
+type utilityStmt struct {
+	tag string
+}
+
+type expr interface{}
+
+type literal struct {
+	value interface{}
+	oid   Oid
+}
+
+type paramRef struct {
+	n int // 1 based
+}
+
+type columnRef struct {
+	table string
+	name  string
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+type notExpr struct {
+	e expr
+}
+
+type betweenExpr struct {
+	e, low, high expr
+}
+
+type isNullExpr struct {
+	e   expr
+	not bool
+}
+
+type funcCall struct {
+	name string
+	args []expr
+	star bool
+}
+
+type castExpr struct {
+	e   expr
+	oid Oid
+}
+
+// defaultExpr is DEFAULT in the VALUES list of an insert.
+type defaultExpr struct{}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses a single SQL statement.
+func parse(sql string) (statement, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, syntaxError(err.Error())
+	}
+	p := &parser{tokens: tokens}
+
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+
+	var stmt statement
+	switch p.peek().text {
+	case "select":
+		stmt, err = p.parseSelect()
+	case "insert":
+		stmt, err = p.parseInsert()
+	case "update":
+		stmt, err = p.parseUpdate()
+	case "delete":
+		stmt, err = p.parseDelete()
+	case "copy":
+		stmt, err = p.parseCopy()
+	case "create":
+		stmt, err = p.parseCreate()
+	case "drop":
+		stmt, err = p.parseDrop()
+	case "truncate":
+		stmt, err = p.parseTruncate()
+	default:
+		stmt, err = p.parseUtility()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptOp(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("syntax error at or near %q", p.peek().text)
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return syntaxError(fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && t.text == kw
+}
+
+func (p *parser) acceptKeyword(kws ...string) bool {
+	for i, kw := range kws {
+		if p.pos+i >= len(p.tokens) {
+			return false
+		}
+		t := p.tokens[p.pos+i]
+		if t.kind != tokIdent || t.text != kw {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf("syntax error at or near %q, expected %s", p.peek().text, kw)
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf("syntax error at or near %q, expected %q", p.peek().text, op)
+	}
+	return nil
+}
+
+func (p *parser) parseIdent() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent && t.kind != tokQuotedIdent {
+		return "", p.errorf("syntax error at or near %q, expected identifier", t.text)
+	}
+	return t.text, nil
+}
+
+// parseTableName parses a possibly schema qualified table name. The schema is
+// discarded.
+func (p *parser) parseTableName() (string, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	if p.acceptOp(".") {
+		return p.parseIdent()
+	}
+	return name, nil
+}
+
+var reservedWords = map[string]bool{
+	"from": true, "where": true, "order": true, "limit": true, "and": true,
+	"or": true, "not": true, "as": true, "by": true, "between": true, "is": true,
+	"null": true, "values": true, "returning": true, "set": true, "asc": true,
+	"desc": true, "on": true,
+}
+
+func (p *parser) parseSelect() (statement, error) {
+	p.next() // select
+	stmt := &selectStmt{}
+
+	var err error
+	if stmt.targets, err = p.parseTargets(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("from") {
+		if stmt.from, err = p.parseTableName(); err != nil {
+			return nil, err
+		}
+		if err := p.skipAlias(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("where") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.where = e
+	}
+
+	if p.acceptKeyword("order", "by") {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.acceptKeyword("desc") {
+				item.desc = true
+			} else {
+				p.acceptKeyword("asc")
+			}
+			stmt.orderBy = append(stmt.orderBy, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("limit") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.limit = e
+	}
+
+	return stmt, nil
+}
+
+// parseTargets parses the target list of a select or returning clause.
+func (p *parser) parseTargets() ([]target, error) {
+	var targets []target
+	for {
+		var t target
+		if p.acceptOp("*") {
+			t.star = true
+		} else {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			t.expr = e
+			t.name = defaultTargetName(e)
+			if p.acceptKeyword("as") {
+				if t.name, err = p.parseIdent(); err != nil {
+					return nil, err
+				}
+			} else if tok := p.peek(); (tok.kind == tokIdent && !reservedWords[tok.text]) || tok.kind == tokQuotedIdent {
+				t.name = p.next().text
+			}
+		}
+		targets = append(targets, t)
+		if !p.acceptOp(",") {
+			return targets, nil
+		}
+	}
+}
+
+// skipAlias skips an optional table alias. Aliases are accepted but
+// references are resolved by column name only.
+func (p *parser) skipAlias() error {
+	if p.acceptKeyword("as") {
+		_, err := p.parseIdent()
+		return err
+	}
+	if tok := p.peek(); (tok.kind == tokIdent && !reservedWords[tok.text]) || tok.kind == tokQuotedIdent {
+		p.next()
+	}
+	return nil
+}
+
+// parseReturning parses an optional returning clause.
+func (p *parser) parseReturning() ([]target, error) {
+	if !p.acceptKeyword("returning") {
+		return nil, nil
+	}
+	return p.parseTargets()
+}
+
+func defaultTargetName(e expr) string {
+	switch e := e.(type) {
+	case columnRef:
+		return e.name
+	case funcCall:
+		return e.name
+	case castExpr:
+		if name := defaultTargetName(e.e); name != "?column?" {
+			return name
+		}
+		return typeNames[e.oid]
+	default:
+		return "?column?"
+	}
+}
+
+func (p *parser) parseInsert() (statement, error) {
+	p.next() // insert
+	if err := p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+	stmt := &insertStmt{}
+	var err error
+	if stmt.table, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptOp("(") {
+		for {
+			name, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, name)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("values"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		var row []expr
+		for {
+			if p.acceptKeyword("default") {
+				row = append(row, defaultExpr{})
+			} else {
+				e, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, e)
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		stmt.rows = append(stmt.rows, row)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if stmt.returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseUpdate() (statement, error) {
+	p.next() // update
+	stmt := &updateStmt{}
+	var err error
+	if stmt.table, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	if err := p.skipAlias(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+	for {
+		var a assignment
+		if a.column, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("="); err != nil {
+			return nil, err
+		}
+		if a.value, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		stmt.set = append(stmt.set, a)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("where") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseDelete() (statement, error) {
+	p.next() // delete
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	stmt := &deleteStmt{}
+	var err error
+	if stmt.table, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	if err := p.skipAlias(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("where") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.returning, err = p.parseReturning(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCopy() (statement, error) {
+	p.next() // copy
+	stmt := &copyStmt{}
+	var err error
+	if p.acceptOp("(") {
+		if !p.isKeyword("select") {
+			return nil, p.errorf("syntax error at or near %q", p.peek().text)
+		}
+		sel, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		stmt.query = sel.(*selectStmt)
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		if stmt.table, err = p.parseTableName(); err != nil {
+			return nil, err
+		}
+		if p.acceptOp("(") {
+			for {
+				name, err := p.parseIdent()
+				if err != nil {
+					return nil, err
+				}
+				stmt.columns = append(stmt.columns, name)
+				if !p.acceptOp(",") {
+					break
+				}
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch {
+	case p.acceptKeyword("from"):
+		if stmt.query != nil {
+			return nil, p.errorf("COPY FROM is not supported for a query")
+		}
+		stmt.from = true
+		if err := p.expectKeyword("stdin"); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("to"):
+		if err := p.expectKeyword("stdout"); err != nil {
+			return nil, err
+		}
+		if stmt.query == nil {
+			stmt.query = &selectStmt{from: stmt.table}
+			for _, name := range stmt.columns {
+				stmt.query.targets = append(stmt.query.targets, target{expr: columnRef{name: name}, name: name})
+			}
+			if stmt.columns == nil {
+				stmt.query.targets = []target{{star: true}}
+			}
+			stmt.columns = nil
+		}
+	default:
+		return nil, p.errorf("syntax error at or near %q, expected FROM or TO", p.peek().text)
+	}
+
+	// Both the old "binary" option and the parenthesized option list are
+	// accepted. Only the format option is understood.
+	p.acceptKeyword("with")
+	if p.acceptKeyword("binary") {
+		stmt.binary = true
+	} else if p.acceptOp("(") {
+		for {
+			name, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			if name != "format" {
+				return nil, &pgError{code: "0A000", message: fmt.Sprintf("fakepg does not support COPY option %q", name)}
+			}
+			switch value {
+			case "binary":
+				stmt.binary = true
+			case "text":
+				stmt.binary = false
+			default:
+				return nil, &pgError{code: "0A000", message: fmt.Sprintf("fakepg does not support COPY format %q", value)}
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCreate() (statement, error) {
+	p.next() // create
+	if !p.acceptKeyword("table") {
+		// create index, create extension, etc. are accepted and ignored.
+		return p.parseUtilityFrom("CREATE")
+	}
+	stmt := &createTableStmt{}
+	stmt.ifNotExists = p.acceptKeyword("if", "not", "exists")
+	var err error
+	if stmt.name, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.isKeyword("primary") || p.isKeyword("unique") || p.isKeyword("constraint") {
+			p.skipToDelimiter()
+		} else {
+			col, err := p.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, col)
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseColumnDef() (columnDef, error) {
+	var col columnDef
+	var err error
+	if col.name, err = p.parseIdent(); err != nil {
+		return col, err
+	}
+
+	oid, typeName, err := p.parseTypeName()
+	if err != nil {
+		return col, err
+	}
+	col.oid = oid
+	col.serial = typeName == "serial" || typeName == "bigserial"
+
+	for !p.isOp(",") && !p.isOp(")") && p.peek().kind != tokEOF {
+		switch {
+		case p.acceptKeyword("primary", "key"):
+			col.primaryKey = true
+			col.notNull = true
+		case p.acceptKeyword("not", "null"):
+			col.notNull = true
+		case p.acceptKeyword("auto_increment"):
+			col.serial = true
+		default:
+			p.skipBalanced()
+		}
+	}
+	return col, nil
+}
+
+// parseTypeName parses a type name including multi-word names and an optional
+// length modifier.
+func (p *parser) parseTypeName() (Oid, string, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return 0, "", err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokIdent {
+			break
+		}
+		if !isTypeNamePrefix(name + " " + t.text) {
+			break
+		}
+		name += " " + p.next().text
+	}
+	if p.acceptOp("(") {
+		for !p.acceptOp(")") {
+			if p.next().kind == tokEOF {
+				return 0, "", p.errorf("unexpected end of statement")
+			}
+		}
+	}
+	if p.acceptOp("[") {
+		// int4[], int4[3] and int4[][] all name the array type.
+		for {
+			for !p.acceptOp("]") {
+				if p.next().kind == tokEOF {
+					return 0, "", p.errorf("unexpected end of statement")
+				}
+			}
+			if !p.acceptOp("[") {
+				break
+			}
+		}
+		name += "[]"
+	}
+	oid, ok := sqlTypes[name]
+	if !ok {
+		return 0, "", &pgError{code: "42704", message: fmt.Sprintf("type %q does not exist", name)}
+	}
+	return oid, name, nil
+}
+
+func isTypeNamePrefix(s string) bool {
+	for name := range sqlTypes {
+		if strings.HasPrefix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipBalanced skips one token, or a whole parenthesized group.
+func (p *parser) skipBalanced() {
+	t := p.next()
+	if t.kind != tokOp || t.text != "(" {
+		return
+	}
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return
+		case t.kind == tokOp && t.text == "(":
+			depth++
+		case t.kind == tokOp && t.text == ")":
+			depth--
+		}
+	}
+}
+
+// skipToDelimiter skips to the next top level comma or closing parenthesis.
+func (p *parser) skipToDelimiter() {
+	for !p.isOp(",") && !p.isOp(")") && p.peek().kind != tokEOF {
+		p.skipBalanced()
+	}
+}
+
+func (p *parser) parseDrop() (statement, error) {
+	p.next() // drop
+	if !p.acceptKeyword("table") {
+		return p.parseUtilityFrom("DROP")
+	}
+	stmt := &dropTableStmt{}
+	stmt.ifExists = p.acceptKeyword("if", "exists")
+	for {
+		name, err := p.parseTableName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.names = append(stmt.names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	p.acceptKeyword("cascade")
+	return stmt, nil
+}
+
+func (p *parser) parseTruncate() (statement, error) {
+	p.next() // truncate
+	p.acceptKeyword("table")
+	stmt := &truncateStmt{}
+	for {
+		name, err := p.parseTableName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.names = append(stmt.names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	p.acceptKeyword("restart", "identity")
+	p.acceptKeyword("cascade")
+	return stmt, nil
+}
+
+var utilityTags = map[string]string{
+	"begin":      "BEGIN",
+	"start":      "START TRANSACTION",
+	"commit":     "COMMIT",
+	"end":        "COMMIT",
+	"rollback":   "ROLLBACK",
+	"abort":      "ROLLBACK",
+	"analyze":    "ANALYZE",
+	"vacuum":     "VACUUM",
+	"set":        "SET",
+	"reset":      "RESET",
+	"discard":    "DISCARD ALL",
+	"deallocate": "DEALLOCATE",
+	"listen":     "LISTEN",
+	"unlisten":   "UNLISTEN",
+}
+
+func (p *parser) parseUtility() (statement, error) {
+	t := p.next()
+	tag, ok := utilityTags[t.text]
+	if t.kind != tokIdent || !ok {
+		return nil, &pgError{code: "0A000", message: fmt.Sprintf("fakepg does not support statement %q", t.text)}
+	}
+	return p.parseUtilityFrom(tag)
+}
+
+func (p *parser) parseUtilityFrom(tag string) (statement, error) {
+	for p.peek().kind != tokEOF && !p.isOp(";") {
+		p.next()
+	}
+	return &utilityStmt{tag: tag}, nil
+}
+
+// Expression grammar in increasing order of precedence:
+//   or, and, not, comparison / between / is null, || + -, * /, unary -, ::, primary
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("not") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e: e}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.acceptKeyword("between"):
+		// The and inside between binds tighter than boolean and.
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return betweenExpr{e: left, low: low, high: high}, nil
+	case p.acceptKeyword("is"):
+		not := p.acceptKeyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+		return isNullExpr{e: left, not: not}, nil
+	}
+
+	for _, op := range []string{"=", "<>", "!=", "<=", ">=", "<", ">"} {
+		if p.acceptOp(op) {
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			if op == "!=" {
+				op = "<>"
+			}
+			return binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptOp("+"):
+			op = "+"
+		case p.acceptOp("-"):
+			op = "-"
+		case p.acceptOp("||"):
+			op = "||"
+		default:
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptOp("*"):
+			op = "*"
+		case p.acceptOp("/"):
+			op = "/"
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.acceptOp("-") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := e.(literal); ok {
+			switch v := lit.value.(type) {
+			case int64:
+				return literal{value: -v, oid: lit.oid}, nil
+			case float64:
+				return literal{value: -v, oid: lit.oid}, nil
+			}
+		}
+		return binaryExpr{op: "-", left: literal{value: int64(0), oid: Int4Oid}, right: e}, nil
+	}
+	return p.parseCast()
+}
+
+func (p *parser) parseCast() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("::") {
+		oid, _, err := p.parseTypeName()
+		if err != nil {
+			return nil, err
+		}
+		e = castExpr{e: e, oid: oid}
+	}
+	return e, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if strings.Contains(t.text, ".") {
+			f, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, p.errorf("invalid number %q", t.text)
+			}
+			return literal{value: f, oid: Float8Oid}, nil
+		}
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.text)
+		}
+		if n > 1<<31-1 {
+			return literal{value: n, oid: Int8Oid}, nil
+		}
+		return literal{value: n, oid: Int4Oid}, nil
+	case tokString:
+		return literal{value: t.text, oid: UnknownOid}, nil
+	case tokParam:
+		n, err := strconv.Atoi(t.text)
+		if err != nil || n < 1 {
+			return nil, p.errorf("invalid parameter $%s", t.text)
+		}
+		return paramRef{n: n}, nil
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	case tokIdent, tokQuotedIdent:
+		if t.kind == tokIdent {
+			switch t.text {
+			case "null":
+				return literal{value: nil, oid: UnknownOid}, nil
+			case "true", "false":
+				return literal{value: t.text == "true", oid: BoolOid}, nil
+			}
+		}
+		if t.kind == tokIdent && p.isOp("(") {
+			return p.parseFuncCall(t.text)
+		}
+		if p.acceptOp(".") {
+			if p.acceptOp("*") {
+				return columnRef{table: t.text, name: "*"}, nil
+			}
+			name, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			return columnRef{table: t.text, name: name}, nil
+		}
+		return columnRef{name: t.text}, nil
+	}
+	return nil, p.errorf("syntax error at or near %q", t.text)
+}
+
+func (p *parser) parseFuncCall(name string) (expr, error) {
+	p.next() // (
+	call := funcCall{name: name}
+	if p.acceptOp("*") {
+		call.star = true
+	} else if !p.isOp(")") {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, e)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
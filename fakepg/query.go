@@ -0,0 +1,1291 @@
+package fakepg
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// field describes a result column.
+type field struct {
+	name string
+	oid  Oid
+}
+
+// query is a parsed and analyzed statement. It is what a prepared statement
+// refers to.
+type query struct {
+	sql       string
+	stmt      statement
+	table     *table // table referenced by stmt, if any
+	paramOids []Oid
+	fields    []field // nil when the statement returns no rows
+}
+
+// result is the outcome of executing a query.
+type result struct {
+	fields   []field
+	rows     [][]interface{}
+	tag      string
+	notices  []*pgError // sent before the rows
+	insertID int64      // last serial value assigned by an insert
+}
+
+// Bound expressions produced by analyze in place of column references.
+type boundColumn struct {
+	idx int
+	oid Oid
+}
+
+type wholeRow struct{}
+
+// catalogStmt answers the pg_type introspection queries drivers run after
+// connecting.
+type catalogStmt struct {
+	fields []field
+	rows   [][]interface{}
+}
+
+// prepare parses and analyzes sql. declared holds parameter types sent by
+// the client in a Parse message; zero entries are inferred.
+func (db *database) prepare(sql string, declared []Oid) (*query, error) {
+	q := &query{sql: sql}
+
+	if stmt, ok := catalogQuery(sql); ok {
+		q.stmt = stmt
+		q.fields = stmt.fields
+		return q, nil
+	}
+
+	stmt, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	q.stmt = stmt
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	a := &analyzer{params: append([]Oid(nil), declared...)}
+
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		if err := db.analyzeSelect(q, a, stmt); err != nil {
+			return nil, err
+		}
+	case *copyStmt:
+		if !stmt.from {
+			if err := db.analyzeSelect(q, a, stmt.query); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if q.table, err = db.table(stmt.table); err != nil {
+			return nil, err
+		}
+		if len(stmt.columns) == 0 {
+			for _, col := range q.table.columns {
+				stmt.columns = append(stmt.columns, col.name)
+			}
+		}
+		for _, name := range stmt.columns {
+			if q.table.columnIndex(name) < 0 {
+				return nil, &pgError{code: "42703", message: fmt.Sprintf("column %q of relation %q does not exist", name, q.table.name)}
+			}
+		}
+	case *insertStmt:
+		if q.table, err = db.table(stmt.table); err != nil {
+			return nil, err
+		}
+		a.table = q.table
+		if len(stmt.columns) == 0 {
+			for _, col := range q.table.columns {
+				stmt.columns = append(stmt.columns, col.name)
+			}
+		}
+		for _, name := range stmt.columns {
+			if q.table.columnIndex(name) < 0 {
+				return nil, &pgError{code: "42703", message: fmt.Sprintf("column %q of relation %q does not exist", name, q.table.name)}
+			}
+		}
+		for _, row := range stmt.rows {
+			if len(row) != len(stmt.columns) {
+				return nil, syntaxError("INSERT has more expressions than target columns")
+			}
+			for i := range row {
+				if row[i], err = a.bind(row[i]); err != nil {
+					return nil, err
+				}
+				a.expect(row[i], q.table.columns[q.table.columnIndex(stmt.columns[i])].oid)
+			}
+		}
+		if q.fields, err = a.bindTargets(stmt.returning); err != nil {
+			return nil, err
+		}
+	case *updateStmt:
+		if q.table, err = db.table(stmt.table); err != nil {
+			return nil, err
+		}
+		a.table = q.table
+		for i := range stmt.set {
+			idx := q.table.columnIndex(stmt.set[i].column)
+			if idx < 0 {
+				return nil, &pgError{code: "42703", message: fmt.Sprintf("column %q of relation %q does not exist", stmt.set[i].column, q.table.name)}
+			}
+			if stmt.set[i].value, err = a.bind(stmt.set[i].value); err != nil {
+				return nil, err
+			}
+			a.expect(stmt.set[i].value, q.table.columns[idx].oid)
+		}
+		if stmt.where, err = a.bindWhere(stmt.where); err != nil {
+			return nil, err
+		}
+		if q.fields, err = a.bindTargets(stmt.returning); err != nil {
+			return nil, err
+		}
+	case *deleteStmt:
+		if q.table, err = db.table(stmt.table); err != nil {
+			return nil, err
+		}
+		a.table = q.table
+		if stmt.where, err = a.bindWhere(stmt.where); err != nil {
+			return nil, err
+		}
+		if q.fields, err = a.bindTargets(stmt.returning); err != nil {
+			return nil, err
+		}
+	}
+
+	q.paramOids = a.params
+	for i, oid := range q.paramOids {
+		if oid == 0 || oid == UnknownOid {
+			q.paramOids[i] = TextOid
+		}
+	}
+	return q, nil
+}
+
+// analyzeSelect resolves the table of stmt into q and binds its expressions.
+func (db *database) analyzeSelect(q *query, a *analyzer, stmt *selectStmt) error {
+	var err error
+	if stmt.from != "" {
+		if q.table, err = db.table(stmt.from); err != nil {
+			return err
+		}
+	}
+	a.table = q.table
+	if q.fields, err = a.bindTargets(stmt.targets); err != nil {
+		return err
+	}
+	if stmt.where, err = a.bindWhere(stmt.where); err != nil {
+		return err
+	}
+	for i := range stmt.orderBy {
+		if stmt.orderBy[i].expr, err = a.bind(stmt.orderBy[i].expr); err != nil {
+			return err
+		}
+	}
+	if stmt.limit != nil {
+		if stmt.limit, err = a.bind(stmt.limit); err != nil {
+			return err
+		}
+		a.expect(stmt.limit, Int8Oid)
+	}
+	return nil
+}
+
+// analyzer resolves column references and infers parameter types.
+type analyzer struct {
+	table  *table
+	params []Oid
+}
+
+func (a *analyzer) bind(e expr) (expr, error) {
+	var err error
+	switch e := e.(type) {
+	case columnRef:
+		if a.table == nil {
+			return nil, &pgError{code: "42703", message: fmt.Sprintf("column %q does not exist", e.name)}
+		}
+		if e.name == "*" || (e.table == "" && e.name == a.table.name) {
+			return wholeRow{}, nil
+		}
+		idx := a.table.columnIndex(e.name)
+		if idx < 0 {
+			return nil, &pgError{code: "42703", message: fmt.Sprintf("column %q does not exist", e.name)}
+		}
+		return boundColumn{idx: idx, oid: a.table.columns[idx].oid}, nil
+	case paramRef:
+		for len(a.params) < e.n {
+			a.params = append(a.params, 0)
+		}
+		return e, nil
+	case binaryExpr:
+		if e.left, err = a.bind(e.left); err != nil {
+			return nil, err
+		}
+		if e.right, err = a.bind(e.right); err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "and", "or":
+			a.expect(e.left, BoolOid)
+			a.expect(e.right, BoolOid)
+		case "||":
+			a.expect(e.left, TextOid)
+			a.expect(e.right, TextOid)
+		default:
+			a.expect(e.left, a.typeOf(e.right))
+			a.expect(e.right, a.typeOf(e.left))
+		}
+		return e, nil
+	case notExpr:
+		if e.e, err = a.bind(e.e); err != nil {
+			return nil, err
+		}
+		a.expect(e.e, BoolOid)
+		return e, nil
+	case betweenExpr:
+		if e.e, err = a.bind(e.e); err != nil {
+			return nil, err
+		}
+		if e.low, err = a.bind(e.low); err != nil {
+			return nil, err
+		}
+		if e.high, err = a.bind(e.high); err != nil {
+			return nil, err
+		}
+		a.expect(e.low, a.typeOf(e.e))
+		a.expect(e.high, a.typeOf(e.e))
+		a.expect(e.e, a.typeOf(e.low))
+		return e, nil
+	case isNullExpr:
+		if e.e, err = a.bind(e.e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case castExpr:
+		if e.e, err = a.bind(e.e); err != nil {
+			return nil, err
+		}
+		a.expect(e.e, e.oid)
+		return e, nil
+	case funcCall:
+		fn, ok := functions[e.name]
+		if !ok {
+			return nil, &pgError{code: "42883", message: fmt.Sprintf("function %s does not exist", e.name)}
+		}
+		args := make([]expr, len(e.args))
+		for i := range e.args {
+			if args[i], err = a.bind(e.args[i]); err != nil {
+				return nil, err
+			}
+			if i < len(fn.argTypes) {
+				a.expect(args[i], fn.argTypes[i])
+			}
+		}
+		e.args = args
+		return e, nil
+	default:
+		return e, nil
+	}
+}
+
+// bindTargets binds the expressions of a select or returning target list and
+// returns the fields they produce.
+func (a *analyzer) bindTargets(targets []target) ([]field, error) {
+	var fields []field
+	for i := range targets {
+		t := &targets[i]
+		if t.star {
+			if a.table == nil {
+				return nil, syntaxError("SELECT * with no tables specified is not valid")
+			}
+			for _, col := range a.table.columns {
+				fields = append(fields, field{name: col.name, oid: col.oid})
+			}
+			continue
+		}
+		var err error
+		if t.expr, err = a.bind(t.expr); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field{name: t.name, oid: a.typeOf(t.expr)})
+	}
+	return fields, nil
+}
+
+// bindWhere binds a where clause, which may be nil.
+func (a *analyzer) bindWhere(where expr) (expr, error) {
+	if where == nil {
+		return nil, nil
+	}
+	where, err := a.bind(where)
+	if err != nil {
+		return nil, err
+	}
+	a.expect(where, BoolOid)
+	return where, nil
+}
+
+// expect records oid as the type of e when e is a parameter whose type is
+// not yet known.
+func (a *analyzer) expect(e expr, oid Oid) {
+	switch e := e.(type) {
+	case paramRef:
+		if oid != 0 && oid != UnknownOid && (a.params[e.n-1] == 0 || a.params[e.n-1] == UnknownOid) {
+			a.params[e.n-1] = oid
+		}
+	case binaryExpr:
+		switch e.op {
+		case "+", "-", "*", "/":
+			a.expect(e.left, oid)
+			a.expect(e.right, oid)
+		}
+	}
+}
+
+func (a *analyzer) typeOf(e expr) Oid {
+	switch e := e.(type) {
+	case literal:
+		return e.oid
+	case paramRef:
+		if oid := a.params[e.n-1]; oid != 0 {
+			return oid
+		}
+		return UnknownOid
+	case boundColumn:
+		return e.oid
+	case wholeRow:
+		return JSONOid
+	case binaryExpr:
+		switch e.op {
+		case "||":
+			return TextOid
+		case "+", "-", "*", "/":
+			l, r := a.typeOf(e.left), a.typeOf(e.right)
+			switch {
+			case l == Float8Oid || r == Float8Oid || l == Float4Oid || r == Float4Oid:
+				return Float8Oid
+			case l == Int8Oid || r == Int8Oid:
+				return Int8Oid
+			case l == UnknownOid:
+				return r
+			default:
+				return l
+			}
+		default:
+			return BoolOid
+		}
+	case notExpr, betweenExpr, isNullExpr:
+		return BoolOid
+	case castExpr:
+		return e.oid
+	case funcCall:
+		fn := functions[e.name]
+		if fn.resultType != 0 {
+			return fn.resultType
+		}
+		for _, arg := range e.args {
+			if oid := a.typeOf(arg); oid != UnknownOid {
+				return oid
+			}
+		}
+		return TextOid
+	}
+	return UnknownOid
+}
+
+// function describes a built in SQL function. A zero resultType means the
+// result has the type of the first argument with a known type.
+type function struct {
+	argTypes   []Oid
+	resultType Oid
+	aggregate  bool
+}
+
+var functions = map[string]function{
+	"repeat":      {argTypes: []Oid{TextOid, Int4Oid}, resultType: TextOid},
+	"coalesce":    {},
+	"row_to_json": {resultType: JSONOid},
+	"json_agg":    {resultType: JSONOid, aggregate: true},
+	"count":       {resultType: Int8Oid, aggregate: true},
+	"random":      {resultType: Float8Oid},
+	"now":         {resultType: TimestamptzOid},
+	"length":      {argTypes: []Oid{TextOid}, resultType: Int4Oid},
+	"lower":       {argTypes: []Oid{TextOid}, resultType: TextOid},
+	"upper":       {argTypes: []Oid{TextOid}, resultType: TextOid},
+	"pg_sleep":    {argTypes: []Oid{Float8Oid}, resultType: VoidOid},
+}
+
+// catalogQuery recognizes the pg_type queries that pgx runs when it connects.
+// They join system catalogs in ways the parser does not understand, so they
+// are answered wholesale. Only base types are reported; there are no enums or
+// domains.
+func catalogQuery(sql string) (*catalogStmt, bool) {
+	lower := strings.ToLower(sql)
+	if !strings.Contains(lower, "pg_type") {
+		return nil, false
+	}
+
+	stmt := &catalogStmt{fields: []field{{name: "oid", oid: OidOid}, {name: "typname", oid: TextOid}}}
+	switch {
+	case strings.Contains(lower, "typbasetype"):
+		stmt.fields = append(stmt.fields, field{name: "typbasetype", oid: OidOid})
+	case strings.Contains(lower, "typtype = 'e'"):
+	default:
+		oids := make([]int, 0, len(typeNames))
+		for oid := range typeNames {
+			oids = append(oids, int(oid))
+		}
+		sort.Ints(oids)
+		for _, oid := range oids {
+			stmt.rows = append(stmt.rows, []interface{}{int64(oid), typeNames[Oid(oid)]})
+		}
+	}
+	return stmt, true
+}
+
+// execContext carries the state needed to evaluate expressions.
+type execContext struct {
+	table  *table
+	params []interface{}
+	// interrupt is closed when the query is cancelled. Only pg_sleep waits
+	// long enough to notice.
+	interrupt <-chan struct{}
+	// aggRows is set while evaluating a target list that contains aggregates.
+	aggRows [][]interface{}
+}
+
+// execute runs q with params. The caller must have converted params to their
+// internal representation. Closing interrupt cancels the query.
+func (db *database) execute(q *query, params []interface{}, interrupt <-chan struct{}) (*result, error) {
+	if len(params) != len(q.paramOids) {
+		return nil, &pgError{code: "08P01", message: fmt.Sprintf("bind message supplies %d parameters, but prepared statement requires %d", len(params), len(q.paramOids))}
+	}
+
+	switch stmt := q.stmt.(type) {
+	case nil:
+		return &result{}, nil
+	case *catalogStmt:
+		return &result{fields: stmt.fields, rows: stmt.rows, tag: fmt.Sprintf("SELECT %d", len(stmt.rows))}, nil
+	case *selectStmt:
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.executeSelect(q, stmt, params, interrupt)
+	case *insertStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.executeInsert(q, stmt, params)
+	case *copyStmt:
+		return nil, &pgError{code: "0A000", message: "fakepg only supports COPY in the simple query protocol"}
+	case *updateStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.executeUpdate(q, stmt, params)
+	case *deleteStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.executeDelete(q, stmt, params)
+	case *createTableStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if _, ok := db.tables[stmt.name]; ok {
+			if stmt.ifNotExists {
+				return &result{tag: "CREATE TABLE"}, nil
+			}
+			return nil, &pgError{code: "42P07", message: fmt.Sprintf("relation %q already exists", stmt.name)}
+		}
+		db.tables[stmt.name] = newTable(stmt)
+		return &result{tag: "CREATE TABLE"}, nil
+	case *dropTableStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		res := &result{tag: "DROP TABLE"}
+		for _, name := range stmt.names {
+			if _, ok := db.tables[name]; !ok {
+				if !stmt.ifExists {
+					return nil, &pgError{code: "42P01", message: fmt.Sprintf("table %q does not exist", name)}
+				}
+				res.notices = append(res.notices, &pgError{severity: "NOTICE", code: "00000", message: fmt.Sprintf("table %q does not exist, skipping", name)})
+			}
+			delete(db.tables, name)
+		}
+		return res, nil
+	case *truncateStmt:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		for _, name := range stmt.names {
+			t, ok := db.tables[name]
+			if !ok {
+				return nil, &pgError{code: "42P01", message: fmt.Sprintf("table %q does not exist", name)}
+			}
+			// Rows already handed out in results share the old slice.
+			t.rows = nil
+		}
+		return &result{tag: "TRUNCATE TABLE"}, nil
+	case *utilityStmt:
+		return &result{tag: stmt.tag}, nil
+	default:
+		return nil, fmt.Errorf("unexpected statement %T", stmt)
+	}
+}
+
+func (db *database) executeSelect(q *query, stmt *selectStmt, params []interface{}, interrupt <-chan struct{}) (*result, error) {
+	ctx := &execContext{table: q.table, params: params, interrupt: interrupt}
+
+	// The table may have been dropped and recreated since q was prepared.
+	if q.table != nil && db.tables[q.table.name] != q.table {
+		return nil, &pgError{code: "0A000", message: "cached plan must not change result type"}
+	}
+
+	var rows [][]interface{}
+	if q.table == nil {
+		rows = [][]interface{}{nil}
+	} else {
+		var err error
+		if rows, err = ctx.matchingRows(stmt.where); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(stmt.orderBy) > 0 {
+		keys := make([][]interface{}, len(rows))
+		for i, row := range rows {
+			keys[i] = make([]interface{}, len(stmt.orderBy))
+			for j, item := range stmt.orderBy {
+				v, err := ctx.eval(item.expr, row)
+				if err != nil {
+					return nil, err
+				}
+				keys[i][j] = v
+			}
+		}
+		order := make([]int, len(rows))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			for j, item := range stmt.orderBy {
+				c := compareValues(keys[order[a]][j], keys[order[b]][j])
+				if c != 0 {
+					return (c < 0) != item.desc
+				}
+			}
+			return false
+		})
+		sorted := make([][]interface{}, len(rows))
+		for i, idx := range order {
+			sorted[i] = rows[idx]
+		}
+		rows = sorted
+	}
+
+	if hasAggregate(stmt.targets) {
+		ctx.aggRows = rows
+		rows = [][]interface{}{nil}
+	}
+
+	if stmt.limit != nil {
+		v, err := ctx.eval(stmt.limit, nil)
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := v.(int64); ok && n < int64(len(rows)) {
+			rows = rows[:n]
+		}
+	}
+
+	res := &result{fields: q.fields, rows: make([][]interface{}, 0, len(rows))}
+	for _, row := range rows {
+		out, err := ctx.project(stmt.targets, len(q.fields), row)
+		if err != nil {
+			return nil, err
+		}
+		res.rows = append(res.rows, out)
+	}
+	res.tag = fmt.Sprintf("SELECT %d", len(res.rows))
+	return res, nil
+}
+
+// matchingRows returns the rows of ctx.table for which where is true, or all
+// rows if where is nil. Where clauses that constrain the primary key are
+// answered without a full scan.
+func (ctx *execContext) matchingRows(where expr) ([][]interface{}, error) {
+	t := ctx.table
+	candidates := t.rows
+	if where == nil {
+		return candidates, nil
+	}
+	if t.pk >= 0 {
+		if low, high, ok, err := pkBounds(ctx, where, t.pk); err != nil {
+			return nil, err
+		} else if ok {
+			candidates = t.pkRange(low, high)
+		}
+	}
+
+	rows := make([][]interface{}, 0, len(candidates))
+	for _, row := range candidates {
+		v, err := ctx.eval(where, row)
+		if err != nil {
+			return nil, err
+		}
+		if b, _ := v.(bool); b {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// project evaluates a select or returning target list against row.
+func (ctx *execContext) project(targets []target, width int, row []interface{}) ([]interface{}, error) {
+	out := make([]interface{}, 0, width)
+	for _, t := range targets {
+		if t.star {
+			out = append(out, row...)
+			continue
+		}
+		v, err := ctx.eval(t.expr, row)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (db *database) executeInsert(q *query, stmt *insertStmt, params []interface{}) (*result, error) {
+	t := q.table
+	if db.tables[t.name] != t {
+		return nil, &pgError{code: "0A000", message: "cached plan must not change result type"}
+	}
+	ctx := &execContext{table: t, params: params}
+	res := &result{fields: q.fields}
+
+	for _, values := range stmt.rows {
+		row := make([]interface{}, len(t.columns))
+		assigned := make([]bool, len(t.columns))
+		for i, e := range values {
+			if _, ok := e.(defaultExpr); ok {
+				continue
+			}
+			idx := t.columnIndex(stmt.columns[i])
+			v, err := ctx.eval(e, nil)
+			if err != nil {
+				return nil, err
+			}
+			if row[idx], err = coerce(v, t.columns[idx].oid); err != nil {
+				return nil, &pgError{code: "22P02", message: err.Error()}
+			}
+			assigned[idx] = true
+		}
+		for i, col := range t.columns {
+			if !assigned[i] && col.serial {
+				row[i] = t.nextID
+				res.insertID = t.nextID
+			}
+		}
+		if err := t.insertRow(row); err != nil {
+			return nil, err
+		}
+		if stmt.returning != nil {
+			out, err := ctx.project(stmt.returning, len(q.fields), row)
+			if err != nil {
+				return nil, err
+			}
+			res.rows = append(res.rows, out)
+		}
+	}
+
+	res.tag = fmt.Sprintf("INSERT 0 %d", len(stmt.rows))
+	return res, nil
+}
+
+func (db *database) executeUpdate(q *query, stmt *updateStmt, params []interface{}) (*result, error) {
+	t := q.table
+	if db.tables[t.name] != t {
+		return nil, &pgError{code: "0A000", message: "cached plan must not change result type"}
+	}
+	ctx := &execContext{table: t, params: params}
+	res := &result{fields: q.fields}
+
+	matched, err := ctx.matchingRows(stmt.where)
+	if err != nil {
+		return nil, err
+	}
+	// matched may share its backing array with t.rows, which is changed
+	// below.
+	matched = append([][]interface{}(nil), matched...)
+
+	for _, old := range matched {
+		row := append([]interface{}(nil), old...)
+		for _, a := range stmt.set {
+			idx := t.columnIndex(a.column)
+			v, err := ctx.eval(a.value, old)
+			if err != nil {
+				return nil, err
+			}
+			if row[idx], err = coerce(v, t.columns[idx].oid); err != nil {
+				return nil, &pgError{code: "22P02", message: err.Error()}
+			}
+		}
+		if err := t.replaceRow(old, row); err != nil {
+			return nil, err
+		}
+		if stmt.returning != nil {
+			out, err := ctx.project(stmt.returning, len(q.fields), row)
+			if err != nil {
+				return nil, err
+			}
+			res.rows = append(res.rows, out)
+		}
+	}
+
+	res.tag = fmt.Sprintf("UPDATE %d", len(matched))
+	return res, nil
+}
+
+func (db *database) executeDelete(q *query, stmt *deleteStmt, params []interface{}) (*result, error) {
+	t := q.table
+	if db.tables[t.name] != t {
+		return nil, &pgError{code: "0A000", message: "cached plan must not change result type"}
+	}
+	ctx := &execContext{table: t, params: params}
+	res := &result{fields: q.fields}
+
+	matched, err := ctx.matchingRows(stmt.where)
+	if err != nil {
+		return nil, err
+	}
+	matched = append([][]interface{}(nil), matched...)
+
+	for _, row := range matched {
+		t.deleteRow(row)
+		if stmt.returning != nil {
+			out, err := ctx.project(stmt.returning, len(q.fields), row)
+			if err != nil {
+				return nil, err
+			}
+			res.rows = append(res.rows, out)
+		}
+	}
+
+	res.tag = fmt.Sprintf("DELETE %d", len(matched))
+	return res, nil
+}
+
+// pkBounds extracts an inclusive primary key range from the top level and
+// conjunction of where. ok is false when where does not constrain the key.
+func pkBounds(ctx *execContext, where expr, pk int) (low, high int64, ok bool, err error) {
+	low, high = -1<<63, 1<<63-1
+
+	var visit func(e expr) error
+	visit = func(e expr) error {
+		switch e := e.(type) {
+		case binaryExpr:
+			if e.op == "and" {
+				if err := visit(e.left); err != nil {
+					return err
+				}
+				return visit(e.right)
+			}
+			col, isCol := e.left.(boundColumn)
+			other := e.right
+			op := e.op
+			if !isCol || col.idx != pk {
+				if col, isCol = e.right.(boundColumn); !isCol || col.idx != pk {
+					return nil
+				}
+				other = e.left
+				op = map[string]string{"<": ">", ">": "<", "<=": ">=", ">=": "<=", "=": "=", "<>": "<>"}[op]
+			}
+			if !isConstant(other) {
+				return nil
+			}
+			v, err := ctx.eval(other, nil)
+			if err != nil {
+				return err
+			}
+			n, isInt := v.(int64)
+			if !isInt {
+				return nil
+			}
+			switch op {
+			case "=":
+				low, high, ok = max64(low, n), min64(high, n), true
+			case ">=":
+				low, ok = max64(low, n), true
+			case ">":
+				low, ok = max64(low, n+1), true
+			case "<=":
+				high, ok = min64(high, n), true
+			case "<":
+				high, ok = min64(high, n-1), true
+			}
+		case betweenExpr:
+			if col, isCol := e.e.(boundColumn); !isCol || col.idx != pk || !isConstant(e.low) || !isConstant(e.high) {
+				return nil
+			}
+			l, err := ctx.eval(e.low, nil)
+			if err != nil {
+				return err
+			}
+			h, err := ctx.eval(e.high, nil)
+			if err != nil {
+				return err
+			}
+			ln, lok := l.(int64)
+			hn, hok := h.(int64)
+			if lok && hok {
+				low, high, ok = max64(low, ln), min64(high, hn), true
+			}
+		}
+		return nil
+	}
+
+	err = visit(where)
+	return low, high, ok, err
+}
+
+// isConstant reports whether e can be evaluated without a row.
+func isConstant(e expr) bool {
+	switch e := e.(type) {
+	case literal, paramRef:
+		return true
+	case binaryExpr:
+		return isConstant(e.left) && isConstant(e.right)
+	case castExpr:
+		return isConstant(e.e)
+	default:
+		return false
+	}
+}
+
+func hasAggregate(targets []target) bool {
+	var found bool
+	var visit func(e expr)
+	visit = func(e expr) {
+		switch e := e.(type) {
+		case funcCall:
+			if functions[e.name].aggregate {
+				found = true
+				return
+			}
+			for _, arg := range e.args {
+				visit(arg)
+			}
+		case binaryExpr:
+			visit(e.left)
+			visit(e.right)
+		case castExpr:
+			visit(e.e)
+		}
+	}
+	for _, t := range targets {
+		visit(t.expr)
+	}
+	return found
+}
+
+func (ctx *execContext) eval(e expr, row []interface{}) (interface{}, error) {
+	switch e := e.(type) {
+	case literal:
+		return e.value, nil
+	case paramRef:
+		return ctx.params[e.n-1], nil
+	case boundColumn:
+		if row == nil {
+			return nil, &pgError{code: "42803", message: "column must appear in the GROUP BY clause or be used in an aggregate function"}
+		}
+		return row[e.idx], nil
+	case binaryExpr:
+		return ctx.evalBinary(e, row)
+	case notExpr:
+		v, err := ctx.eval(e.e, row)
+		if b, ok := v.(bool); ok {
+			return !b, err
+		}
+		return nil, err
+	case betweenExpr:
+		v, err := ctx.eval(e.e, row)
+		if err != nil {
+			return nil, err
+		}
+		low, err := ctx.eval(e.low, row)
+		if err != nil {
+			return nil, err
+		}
+		high, err := ctx.eval(e.high, row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil || low == nil || high == nil {
+			return nil, nil
+		}
+		return compareValues(v, low) >= 0 && compareValues(v, high) <= 0, nil
+	case isNullExpr:
+		v, err := ctx.eval(e.e, row)
+		return (v == nil) != e.not, err
+	case castExpr:
+		v, err := ctx.eval(e.e, row)
+		if err != nil {
+			return nil, err
+		}
+		v, err = coerce(v, e.oid)
+		if err != nil {
+			return nil, &pgError{code: "22P02", message: err.Error()}
+		}
+		return v, nil
+	case funcCall:
+		return ctx.evalFunc(e, row)
+	}
+	return nil, fmt.Errorf("cannot evaluate %T", e)
+}
+
+func (ctx *execContext) evalBinary(e binaryExpr, row []interface{}) (interface{}, error) {
+	left, err := ctx.eval(e.left, row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := ctx.eval(e.right, row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "and":
+		l, _ := left.(bool)
+		r, _ := right.(bool)
+		return l && r, nil
+	case "or":
+		l, _ := left.(bool)
+		r, _ := right.(bool)
+		return l || r, nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch e.op {
+	case "=":
+		return compareValues(left, right) == 0, nil
+	case "<>":
+		return compareValues(left, right) != 0, nil
+	case "<":
+		return compareValues(left, right) < 0, nil
+	case "<=":
+		return compareValues(left, right) <= 0, nil
+	case ">":
+		return compareValues(left, right) > 0, nil
+	case ">=":
+		return compareValues(left, right) >= 0, nil
+	case "||":
+		l, _ := appendText(nil, TextOid, left)
+		r, _ := appendText(nil, TextOid, right)
+		return string(l) + string(r), nil
+	}
+
+	left, right = unifyNumbers(left, right)
+	switch l := left.(type) {
+	case int64:
+		r := right.(int64)
+		switch e.op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return nil, &pgError{code: "22012", message: "division by zero"}
+			}
+			return l / r, nil
+		}
+	case float64:
+		r := right.(float64)
+		switch e.op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			return l / r, nil
+		}
+	}
+	return nil, &pgError{code: "42883", message: fmt.Sprintf("operator does not exist: %T %s %T", left, e.op, right)}
+}
+
+func (ctx *execContext) evalFunc(e funcCall, row []interface{}) (interface{}, error) {
+	if functions[e.name].aggregate {
+		return ctx.evalAggregate(e)
+	}
+
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		if _, ok := arg.(wholeRow); ok {
+			args[i] = row
+			continue
+		}
+		v, err := ctx.eval(arg, row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch e.name {
+	case "repeat":
+		if len(args) != 2 || args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		s, err := coerce(args[0], TextOid)
+		if err != nil {
+			return nil, err
+		}
+		n, err := coerce(args[1], Int4Oid)
+		if err != nil {
+			return nil, err
+		}
+		str, _ := s.(string)
+		count, _ := n.(int64)
+		if count < 0 {
+			count = 0
+		}
+		return strings.Repeat(str, int(count)), nil
+	case "coalesce":
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "row_to_json":
+		r, ok := args[0].([]interface{})
+		if len(args) != 1 || !ok {
+			return nil, &pgError{code: "42883", message: "row_to_json expects a row"}
+		}
+		return ctx.rowToJSON(r), nil
+	case "random":
+		return rand.Float64(), nil
+	case "pg_sleep":
+		if len(args) != 1 || args[0] == nil {
+			return "", nil
+		}
+		secs, err := coerce(args[0], Float8Oid)
+		if err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(time.Duration(secs.(float64) * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return "", nil
+		case <-ctx.interrupt:
+			return nil, &pgError{code: "57014", message: "canceling statement due to user request"}
+		}
+	case "now":
+		return time.Now().UTC(), nil
+	case "length":
+		if s, ok := args[0].(string); ok {
+			return int64(len([]rune(s))), nil
+		}
+		return nil, nil
+	case "lower", "upper":
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		if e.name == "lower" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	}
+	return nil, &pgError{code: "42883", message: fmt.Sprintf("function %s does not exist", e.name)}
+}
+
+func (ctx *execContext) evalAggregate(e funcCall) (interface{}, error) {
+	if ctx.aggRows == nil {
+		return nil, &pgError{code: "42803", message: fmt.Sprintf("aggregate function %s is not allowed here", e.name)}
+	}
+	inner := *ctx
+	inner.aggRows = nil
+
+	switch e.name {
+	case "count":
+		if e.star {
+			return int64(len(ctx.aggRows)), nil
+		}
+		var n int64
+		for _, row := range ctx.aggRows {
+			v, err := inner.eval(e.args[0], row)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				n++
+			}
+		}
+		return n, nil
+	case "json_agg":
+		if len(ctx.aggRows) == 0 {
+			return nil, nil
+		}
+		buf := []byte{'['}
+		for i, row := range ctx.aggRows {
+			if i > 0 {
+				buf = append(buf, ", "...)
+			}
+			var v interface{}
+			var oid Oid
+			if _, ok := e.args[0].(wholeRow); ok {
+				v, oid = inner.rowToJSON(row), JSONOid
+			} else {
+				var err error
+				if v, err = inner.eval(e.args[0], row); err != nil {
+					return nil, err
+				}
+				oid = (&analyzer{table: ctx.table, params: make([]Oid, len(ctx.params))}).typeOf(e.args[0])
+			}
+			buf = appendJSON(buf, oid, v)
+		}
+		return string(append(buf, ']')), nil
+	}
+	return nil, fmt.Errorf("unknown aggregate %s", e.name)
+}
+
+func (ctx *execContext) rowToJSON(row []interface{}) string {
+	buf := []byte{'{'}
+	for i, col := range ctx.table.columns {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSON(buf, TextOid, col.name)
+		buf = append(buf, ':')
+		buf = appendJSON(buf, col.oid, row[i])
+	}
+	return string(append(buf, '}'))
+}
+
+func unifyNumbers(a, b interface{}) (interface{}, interface{}) {
+	switch av := a.(type) {
+	case int64:
+		switch bv := b.(type) {
+		case float64:
+			return float64(av), bv
+		case string:
+			if n, err := parseText(Int8Oid, bv); err == nil {
+				return av, n
+			}
+		}
+	case float64:
+		switch bv := b.(type) {
+		case int64:
+			return av, float64(bv)
+		case string:
+			if f, err := parseText(Float8Oid, bv); err == nil {
+				return av, f
+			}
+		}
+	case string:
+		switch b.(type) {
+		case int64, float64:
+			y, x := unifyNumbers(b, a)
+			return x, y
+		}
+	}
+	return a, b
+}
+
+// compareValues orders two non-null values. Untyped string literals are
+// converted to the type of the other operand first.
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	a, b = unifyNumbers(a, b)
+	switch av := a.(type) {
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return cmpInt64(av, bv)
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0
+			case !av:
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		bt, ok := b.(time.Time)
+		if s, isString := b.(string); isString {
+			v, err := parseText(TimestamptzOid, s)
+			bt, ok = v.(time.Time), err == nil
+		}
+		if ok {
+			switch {
+			case av.Before(bt):
+				return -1
+			case av.After(bt):
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if _, ok := b.(time.Time); ok {
+			return -compareValues(b, a)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,230 @@
+package fakepg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	scramSHA256     = "SCRAM-SHA-256"
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+	scramIterations = 4096 // the PostgreSQL default
+)
+
+// errAuthFailed is returned by authenticateSCRAM when the client does not
+// prove that it knows the password.
+var errAuthFailed = errors.New("authentication failed")
+
+// scramSecret is what PostgreSQL stores for a SCRAM-SHA-256 password. Listen
+// computes it once so the server does not derive the keys on every
+// connection.
+type scramSecret struct {
+	salt                 []byte
+	storedKey, serverKey []byte
+}
+
+func newSCRAMSecret(password string) *scramSecret {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	saltedPassword := scramHi([]byte(password), salt, scramIterations)
+	storedKey := sha256.Sum256(scramHMAC(saltedPassword, []byte("Client Key")))
+	return &scramSecret{
+		salt:      salt,
+		storedKey: storedKey[:],
+		serverKey: scramHMAC(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// authenticateSCRAM runs a SCRAM-SHA-256 exchange up to and including the
+// AuthenticationSASLFinal, unless Config.SkipSASLFinal is set. On TLS
+// connections it also offers SCRAM-SHA-256-PLUS with tls-server-end-point
+// channel binding.
+func (b *backend) authenticateSCRAM() error {
+	secret := b.server.scramSecret
+	_, isTLS := b.conn.(*tls.Conn)
+	offerPlus := isTLS && b.server.channelBinding != nil
+
+	b.startMsg('R')
+	b.writeInt32(10) // AuthenticationSASL
+	if offerPlus {
+		b.writeCString(scramSHA256Plus)
+	}
+	b.writeCString(scramSHA256)
+	b.wbuf = append(b.wbuf, 0)
+	b.finishMsg()
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	// SASLInitialResponse
+	t, body, err := b.rxMsg()
+	if err != nil {
+		return err
+	}
+	r := &msgReader{buf: body}
+	mechanism := r.cstring()
+	clientFirst := string(r.bytes(int(r.int32())))
+	if t != 'p' || r.err != nil {
+		return errAuthFailed
+	}
+
+	// The client-first-message is the GS2 header, "flag,authzid,", followed
+	// by the bare message.
+	parts := strings.SplitN(clientFirst, ",", 3)
+	if len(parts) != 3 {
+		return errAuthFailed
+	}
+	flag, bare := parts[0], parts[2]
+	gs2Header := parts[0] + "," + parts[1] + ","
+	switch {
+	case mechanism == scramSHA256Plus && offerPlus:
+		if flag != "p=tls-server-end-point" {
+			return errAuthFailed
+		}
+	case mechanism == scramSHA256:
+		// "y" means the client would have used channel binding, so the
+		// exchange was tampered with if the server offered it.
+		if flag != "n" && !(flag == "y" && !offerPlus) {
+			return errAuthFailed
+		}
+	default:
+		return errAuthFailed
+	}
+	clientNonce := scramAttribute(bare, 'r')
+	if clientNonce == "" {
+		return errAuthFailed
+	}
+
+	serverNonce := make([]byte, 18)
+	rand.Read(serverNonce)
+	nonce := clientNonce + base64.StdEncoding.EncodeToString(serverNonce)
+	serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(secret.salt) + ",i=" + strconv.Itoa(scramIterations)
+
+	b.startMsg('R')
+	b.writeInt32(11) // AuthenticationSASLContinue
+	b.wbuf = append(b.wbuf, serverFirst...)
+	b.finishMsg()
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	// SASLResponse
+	t, body, err = b.rxMsg()
+	if err != nil {
+		return err
+	}
+	clientFinal := string(body)
+	i := strings.LastIndex(clientFinal, ",p=")
+	if t != 'p' || i < 0 {
+		return errAuthFailed
+	}
+	clientFinalWithoutProof := clientFinal[:i]
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return errAuthFailed
+	}
+
+	cbind := gs2Header
+	if flag == "p=tls-server-end-point" {
+		cbind += string(b.server.channelBinding)
+	}
+	if scramAttribute(clientFinalWithoutProof, 'c') != base64.StdEncoding.EncodeToString([]byte(cbind)) ||
+		scramAttribute(clientFinalWithoutProof, 'r') != nonce {
+		return errAuthFailed
+	}
+
+	authMessage := []byte(bare + "," + serverFirst + "," + clientFinalWithoutProof)
+	clientSignature := scramHMAC(secret.storedKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], secret.storedKey) != 1 {
+		return errAuthFailed
+	}
+
+	if b.server.config.SkipSASLFinal {
+		return nil
+	}
+	b.startMsg('R')
+	b.writeInt32(12) // AuthenticationSASLFinal
+	b.wbuf = append(b.wbuf, "v="...)
+	b.wbuf = append(b.wbuf, base64.StdEncoding.EncodeToString(scramHMAC(secret.serverKey, authMessage))...)
+	b.finishMsg()
+	return nil
+}
+
+// scramAttribute returns the value of attribute a in the SCRAM message msg,
+// or "" if there is none.
+func scramAttribute(msg string, a byte) string {
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[0] == a && attr[1] == '=' {
+			return attr[2:]
+		}
+	}
+	return ""
+}
+
+// scramHi is the Hi function of RFC 5802, PBKDF2 with HMAC-SHA-256 producing
+// one block.
+func scramHi(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func scramHMAC(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// tlsServerEndPoint returns the tls-server-end-point channel binding data of
+// the first certificate of config (RFC 5929).
+func tlsServerEndPoint(config *tls.Config) ([]byte, error) {
+	if len(config.Certificates) == 0 || len(config.Certificates[0].Certificate) == 0 {
+		return nil, errors.New("TLS config has no certificate")
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = sha512.New()
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1,
+		x509.SHA256WithRSA, x509.ECDSAWithSHA256, x509.SHA256WithRSAPSS, x509.DSAWithSHA256:
+		h = sha256.New()
+	default:
+		return nil, fmt.Errorf("no channel binding for signature algorithm %v", cert.SignatureAlgorithm)
+	}
+	h.Write(cert.Raw)
+	return h.Sum(nil), nil
+}
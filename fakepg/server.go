@@ -0,0 +1,157 @@
+// Package fakepg is an in-process server that speaks enough of the PostgreSQL
+// v3 wire protocol to run the go_db_bench suite without a real database.
+//
+// It supports startup, TLS, password and SCRAM authentication, the simple
+// query protocol and the extended protocol (Parse, Describe, Bind, Execute,
+// Close, Flush and Sync), and answers a small subset of SQL: create and drop
+// table, multi-row insert, and single table selects with simple predicates,
+// ordering and the few functions the benchmarks use such as repeat and
+// json_agg. pg_sleep can be cancelled with a CancelRequest.
+//
+// MySQLServer answers the same SQL over the MySQL client/server protocol so
+// MySQL drivers can be benchmarked on the same workload.
+package fakepg
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// AuthMethod selects how the server authenticates clients.
+type AuthMethod int
+
+// Supported authentication methods.
+const (
+	AuthTrust AuthMethod = iota
+	AuthCleartext
+	AuthMD5
+	AuthSCRAMSHA256 // SCRAM-SHA-256, and SCRAM-SHA-256-PLUS over TLS
+)
+
+// Config contains the options used to start a Server.
+type Config struct {
+	Auth      AuthMethod  // default: AuthTrust
+	Password  string      // password clients must present unless Auth is AuthTrust
+	TLSConfig *tls.Config // accepts SSLRequest when set -- nil refuses TLS
+
+	// SkipSASLFinal makes AuthSCRAMSHA256 send AuthenticationOk without the
+	// AuthenticationSASLFinal that proves the server knows the password, as a
+	// server impersonating PostgreSQL would. It is for testing clients.
+	SkipSASLFinal bool
+}
+
+// Server is a fake PostgreSQL server. All connections share one database.
+type Server struct {
+	config   Config
+	listener net.Listener
+	db       *database
+
+	scramSecret    *scramSecret // for AuthSCRAMSHA256
+	channelBinding []byte       // tls-server-end-point data of the TLS certificate
+
+	mu      sync.Mutex
+	conns   map[*backend]struct{}
+	nextPid int32
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// Listen starts a Server listening on network and address, e.g. "tcp" and
+// "127.0.0.1:0". Connections are served in the background until Close is
+// called.
+func Listen(network, address string, config Config) (*Server, error) {
+	s := &Server{
+		config: config,
+		db:     newDatabase(),
+		conns:  make(map[*backend]struct{}),
+	}
+	if config.Auth == AuthSCRAMSHA256 {
+		s.scramSecret = newSCRAMSecret(config.Password)
+	}
+	if config.TLSConfig != nil {
+		var err error
+		if s.channelBinding, err = tlsServerEndPoint(config.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes all open connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for b := range s.conns {
+		b.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.nextPid++
+		b := newBackend(s, conn, s.nextPid)
+		s.conns[b] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			b.run()
+
+			s.mu.Lock()
+			delete(s.conns, b)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// cancel handles a CancelRequest by cancelling the running query of the
+// connection with pid and secretKey, if there is one.
+func (s *Server) cancel(pid, secretKey int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for b := range s.conns {
+		if b.pid == pid && b.secretKey == secretKey {
+			b.cancelQuery()
+			return
+		}
+	}
+}
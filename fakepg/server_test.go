@@ -0,0 +1,484 @@
+package fakepg_test
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
+	"github.com/jackc/pgx"
+	_ "github.com/lib/pq"
+)
+
+func startServer(t *testing.T) (*fakepg.Server, pgx.ConnConfig) {
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{Auth: fakepg.AuthMD5, Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := server.Addr().(*net.TCPAddr)
+	config := pgx.ConnConfig{
+		Host:     addr.IP.String(),
+		Port:     uint16(addr.Port),
+		User:     "postgres",
+		Password: "secret",
+		Database: "postgres",
+	}
+	return server, config
+}
+
+func TestPgxExtendedProtocol(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec(`create table person(
+  id serial primary key,
+  first_name varchar(30) not null,
+  birth_date date not null
+);
+insert into person(first_name, birth_date) values ('Adam', '1980-01-02'), ('O''Brien', '1990-03-04'), ('Eve', '2000-05-06');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Prepare("selectPerson", "select id, first_name from person where id between $1 and $1 + 1 order by id"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.Query("selectPerson", int32(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		var id int32
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, fmt.Sprintf("%d:%s", id, name))
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+	if got, want := strings.Join(names, ","), "2:O'Brien,3:Eve"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var s string
+	if err := conn.QueryRow("select repeat('*', $1)", int32(5)).Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "*****" {
+		t.Errorf("repeat returned %q", s)
+	}
+
+	_, err = conn.Exec("select * from missing")
+	if pgErr, ok := err.(pgx.PgError); !ok || pgErr.Code != "42P01" {
+		t.Errorf("expected undefined_table error, got %v", err)
+	}
+
+	// The connection must still be usable after an error.
+	var n int64
+	if err := conn.QueryRow("select count(*) from person").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("count returned %d", n)
+	}
+}
+
+func TestPqTextProtocol(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		config.Host, config.Port, config.User, config.Password, config.Database)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table person(id serial primary key, email varchar(50))"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into person(email) values ($1), (null)", "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var email sql.NullString
+	if err := db.QueryRow("select email from person where id = $1", 2).Scan(&email); err != nil {
+		t.Fatal(err)
+	}
+	if email.Valid {
+		t.Errorf("expected null email, got %q", email.String)
+	}
+
+	var js string
+	if err := db.QueryRow("select coalesce(json_agg(row_to_json(person)), '[]'::json) from person where id between $1 and $1 + 25", 1).Scan(&js); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"id":1,"email":"a@example.com"}, {"id":2,"email":null}]`; js != want {
+		t.Errorf("got %s, want %s", js, want)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	config.Password = "wrong"
+	conn, err := pgx.Connect(config)
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected authentication to fail")
+	}
+	if pgErr, ok := err.(pgx.PgError); !ok || pgErr.Code != "28P01" {
+		t.Errorf("expected invalid_password error, got %v", err)
+	}
+}
+
+func TestSSLModes(t *testing.T) {
+	cert, _, err := fakepg.SelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCert, err := ioutil.TempFile("", "root.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCert.Name())
+	pem.Encode(rootCert, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	rootCert.Close()
+
+	tlsServer, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{
+		Auth:      fakepg.AuthMD5,
+		Password:  "secret",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsServer.Close()
+	plainServer, _ := startServer(t)
+	defer plainServer.Close()
+
+	for _, tt := range []struct {
+		server *fakepg.Server
+		host   string
+		query  string
+		ok     bool
+	}{
+		{plainServer, "127.0.0.1", "sslmode=disable", true},
+		{plainServer, "127.0.0.1", "sslmode=prefer", true},
+		{plainServer, "127.0.0.1", "sslmode=require", false},
+		{tlsServer, "127.0.0.1", "sslmode=prefer", true},
+		{tlsServer, "127.0.0.1", "sslmode=require&connect_timeout=5&application_name=bench", true},
+		{tlsServer, "localhost", "sslmode=verify-ca&sslrootcert=" + rootCert.Name(), true},
+		{tlsServer, "127.0.0.1", "sslmode=verify-full&sslrootcert=" + rootCert.Name(), true},
+		{tlsServer, "localhost", "sslmode=verify-full&sslrootcert=" + rootCert.Name(), false},
+		{tlsServer, "127.0.0.1", "sslmode=verify-ca", false},
+	} {
+		port := tt.server.Addr().(*net.TCPAddr).Port
+		uri := fmt.Sprintf("postgres://postgres:secret@%s:%d/postgres?%s", tt.host, port, tt.query)
+		config, err := raw.ParseURI(uri)
+		if err != nil {
+			t.Errorf("%s: %v", uri, err)
+			continue
+		}
+		conn, err := raw.Connect(config)
+		if !tt.ok {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: expected connect to fail", uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", uri, err)
+			continue
+		}
+		if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+			t.Errorf("%s: select returned %v, %v", uri, v, err)
+		}
+		conn.Close()
+	}
+
+	if _, err := raw.ParseURI("postgres://localhost/postgres?sslmode=allow"); err == nil {
+		t.Error("expected sslmode=allow to be rejected")
+	}
+}
+
+func TestWriteStatements(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("create table person(id serial primary key, first_name varchar not null, weight int not null)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var id int32
+	if err := conn.QueryRow("insert into person(id, first_name, weight) values (default, $1, $2) returning id", "Adam", int32(70)).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("insert returned id %d", id)
+	}
+	if _, err := conn.Exec("insert into person(id, first_name, weight) values (-1, 'Eve', 60), (-2, 'Cain', 80)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var weight int32
+	if err := conn.QueryRow("update person set weight = weight + $2 where id = $1 returning weight", int32(1), int32(5)).Scan(&weight); err != nil {
+		t.Fatal(err)
+	}
+	if weight != 75 {
+		t.Errorf("update returned weight %d", weight)
+	}
+
+	ct, err := conn.Exec("update person set first_name = 'Abel' where id < 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.RowsAffected() != 2 {
+		t.Errorf("update affected %d rows", ct.RowsAffected())
+	}
+
+	_, err = conn.Exec("update person set first_name = null where id = 1")
+	if pgErr, ok := err.(pgx.PgError); !ok || pgErr.Code != "23502" {
+		t.Errorf("expected not_null_violation error, got %v", err)
+	}
+
+	ct, err = conn.Exec("delete from person where id = $1", int32(-2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.RowsAffected() != 1 {
+		t.Errorf("delete affected %d rows", ct.RowsAffected())
+	}
+
+	rows, err := conn.Query("select id, first_name, weight from person order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var people []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&id, &name, &weight); err != nil {
+			t.Fatal(err)
+		}
+		people = append(people, fmt.Sprintf("%d:%s:%d", id, name, weight))
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+	if got, want := strings.Join(people, ","), "-1:Abel:60,1:Adam:75"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("create table person(id serial primary key, first_name varchar, weight int not null)"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]interface{}{{"Adam", int32(70)}, {nil, int32(60)}, {"tab\there", int32(80)}}
+	n, err := conn.CopyFrom(pgx.Identifier{"person"}, []string{"first_name", "weight"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(rows) {
+		t.Errorf("CopyFrom copied %d rows", n)
+	}
+
+	var text strings.Builder
+	if err := conn.CopyToWriter(&text, "copy person to stdout"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := text.String(), "1\tAdam\t70\n2\t\\N\t60\n3\ttab\\there\t80\n"; got != want {
+		t.Errorf("text COPY got %q, want %q", got, want)
+	}
+
+	var binary strings.Builder
+	if err := conn.CopyToWriter(&binary, "copy (select weight from person where id = 1) to stdout (format binary)"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := binary.String(), "PGCOPY\n\377\r\n\000"+"\000\000\000\000\000\000\000\000"+"\000\001\000\000\000\004\000\000\000F"+"\377\377"; got != want {
+		t.Errorf("binary COPY got %q, want %q", got, want)
+	}
+
+	if _, err := conn.Exec("truncate person"); err != nil {
+		t.Fatal(err)
+	}
+	text.Reset()
+	if err := conn.CopyToWriter(&text, "copy person to stdout"); err != nil {
+		t.Fatal(err)
+	}
+	if text.Len() != 0 {
+		t.Errorf("truncate left %q", text.String())
+	}
+}
+
+func TestMySQL(t *testing.T) {
+	cert, roots, err := fakepg.SelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fakepg.ListenMySQL("tcp", "127.0.0.1:0", fakepg.MySQLConfig{
+		Password:  "secret",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if err := mysql.RegisterTLSConfig("fakepg", &tls.Config{RootCAs: roots}); err != nil {
+		t.Fatal(err)
+	}
+	defer mysql.DeregisterTLSConfig("fakepg")
+
+	config := mysql.NewConfig()
+	config.User = "root"
+	config.Passwd = "secret"
+	config.Net = "tcp"
+	config.Addr = server.Addr().String()
+	config.DBName = "bench"
+	config.ParseTime = true
+	config.MultiStatements = true
+
+	for _, tlsConfig := range []string{"", "fakepg"} {
+		config.TLSConfig = tlsConfig
+		connector, err := mysql.NewConnector(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := sql.OpenDB(connector)
+
+		_, err = db.Exec(`drop table if exists person;
+create table person(
+  id int auto_increment primary key,
+  first_name varchar(30) not null,
+  birth_date date not null,
+  update_time datetime not null,
+  weight int
+);
+insert into person(first_name, birth_date, update_time, weight) values
+  ('Adam', '1980-01-02', '2001-02-03 04:05:06', 70),
+  ('O''Brien', '1990-03-04', '2002-03-04 05:06:07.5', null),
+  ('Eve', '2000-05-06', '2003-04-05 06:07:08', 60)`)
+		if err != nil {
+			t.Fatalf("tls=%q: %v", tlsConfig, err)
+		}
+
+		// Text protocol.
+		var name string
+		var birthDate time.Time
+		var weight sql.NullInt64
+		if err := db.QueryRow("select first_name, birth_date, weight from person where id = 2").Scan(&name, &birthDate, &weight); err != nil {
+			t.Fatal(err)
+		}
+		if name != "O'Brien" || !birthDate.Equal(time.Date(1990, 3, 4, 0, 0, 0, 0, time.UTC)) || weight.Valid {
+			t.Errorf("text protocol: got %q, %v, %v", name, birthDate, weight)
+		}
+
+		// Binary protocol.
+		stmt, err := db.Prepare("select id, first_name, update_time, weight from person where id between ? and ? + 1 order by id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := stmt.Query(int32(2), int32(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var id int32
+			var updateTime time.Time
+			if err := rows.Scan(&id, &name, &updateTime, &weight); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%d:%s:%s:%v", id, name, updateTime.Format(time.RFC3339Nano), weight.Valid))
+		}
+		if rows.Err() != nil {
+			t.Fatal(rows.Err())
+		}
+		want := []string{"2:O'Brien:2002-03-04T05:06:07.5Z:false", "3:Eve:2003-04-05T06:07:08Z:true"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("binary protocol: got %v, want %v", got, want)
+		}
+		stmt.Close()
+
+		result, err := db.Exec("insert into person(first_name, birth_date, update_time) values (?, ?, ?)", "Cain", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := result.LastInsertId(); err != nil || id != 4 {
+			t.Errorf("LastInsertId returned %v, %v", id, err)
+		}
+		result, err = db.Exec("update person set weight = ? where id <= ?", 80, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n != 2 {
+			t.Errorf("RowsAffected returned %v, %v", n, err)
+		}
+
+		var text string
+		if err := db.QueryRow("select repeat('*', ?)", 100000).Scan(&text); err != nil || len(text) != 100000 {
+			t.Errorf("repeat returned %d bytes, %v", len(text), err)
+		}
+
+		_, err = db.Exec("select * from missing")
+		if myErr, ok := err.(*mysql.MySQLError); !ok || myErr.Number != 1146 {
+			t.Errorf("expected ER_NO_SUCH_TABLE, got %v", err)
+		}
+
+		db.Close()
+	}
+
+	config.TLSConfig = ""
+	config.Passwd = "wrong"
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	err = db.Ping()
+	if myErr, ok := err.(*mysql.MySQLError); !ok || myErr.Number != 1045 {
+		t.Errorf("expected ER_ACCESS_DENIED_ERROR, got %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+package fakepg
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// isTextValueType reports whether values of oid are kept as their text
+// representation. They are converted from and to the binary format when they
+// are received or sent in it.
+func isTextValueType(oid Oid) bool {
+	switch oid {
+	case JSONBOid, CidrOid, MacaddrOid, InetOid, TimeOid, IntervalOid, NumericOid, UUIDOid:
+		return true
+	}
+	return false
+}
+
+// parseTextValue validates s and returns the text the server would output
+// for it.
+func parseTextValue(oid Oid, s string) (string, error) {
+	b, err := textValueToBinary(oid, s)
+	if err != nil {
+		return "", err
+	}
+	return textValueFromBinary(oid, b)
+}
+
+func textValueToBinary(oid Oid, s string) ([]byte, error) {
+	invalid := fmt.Errorf("invalid input syntax for type %s: %q", typeNames[oid], s)
+	switch oid {
+	case JSONBOid:
+		if !json.Valid([]byte(s)) {
+			return nil, invalid
+		}
+		return append([]byte{1}, s...), nil
+	case UUIDOid:
+		b, err := hex.DecodeString(strings.Replace(strings.Trim(s, "{}"), "-", "", -1))
+		if err != nil || len(b) != 16 {
+			return nil, invalid
+		}
+		return b, nil
+	case MacaddrOid:
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != 6 {
+			return nil, invalid
+		}
+		return mac, nil
+	case InetOid, CidrOid:
+		return inetToBinary(oid, s, invalid)
+	case TimeOid:
+		microsec, err := parseClock(s)
+		if err != nil {
+			return nil, invalid
+		}
+		return appendUint64(nil, uint64(microsec)), nil
+	case IntervalOid:
+		return intervalToBinary(s, invalid)
+	case NumericOid:
+		return numericToBinary(s, invalid)
+	}
+	return nil, fmt.Errorf("cannot encode %s as binary", typeNames[oid])
+}
+
+func textValueFromBinary(oid Oid, src []byte) (string, error) {
+	invalid := fmt.Errorf("invalid binary %s", typeNames[oid])
+	if size := typeSize(oid); size > 0 && len(src) != int(size) {
+		return "", invalid
+	}
+	switch oid {
+	case JSONBOid:
+		if len(src) == 0 || src[0] != 1 {
+			return "", invalid
+		}
+		return string(src[1:]), nil
+	case UUIDOid:
+		h := hex.EncodeToString(src)
+		return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+	case MacaddrOid:
+		return net.HardwareAddr(src).String(), nil
+	case InetOid, CidrOid:
+		return inetFromBinary(oid, src, invalid)
+	case TimeOid:
+		return formatClock(int64(binary.BigEndian.Uint64(src))), nil
+	case IntervalOid:
+		return formatInterval(int64(binary.BigEndian.Uint64(src)), int32(binary.BigEndian.Uint32(src[8:])), int32(binary.BigEndian.Uint32(src[12:]))), nil
+	case NumericOid:
+		return numericFromBinary(src, invalid)
+	}
+	return "", invalid
+}
+
+// parseClock parses a time of day, such as 15:04:05.999999, into
+// microseconds.
+func parseClock(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.ParseUint(parts[0], 10, 32)
+	m, err2 := strconv.ParseUint(parts[1], 10, 32)
+	var sec float64
+	var err3 error
+	if len(parts) == 3 {
+		sec, err3 = strconv.ParseFloat(parts[2], 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || m > 59 || sec >= 60 || sec < 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return int64(h)*3600000000 + int64(m)*60000000 + int64(math.Round(sec*1000000)), nil
+}
+
+// formatClock formats microseconds as PostgreSQL formats a time of day.
+func formatClock(microsec int64) string {
+	sec := microsec / 1000000
+	s := fmt.Sprintf("%02d:%02d:%02d", sec/3600, sec/60%60, sec%60)
+	if frac := microsec % 1000000; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return s
+}
+
+// intervalToBinary parses an interval in the postgres IntervalStyle, such as
+// 1 year 2 mons -3 days +04:05:06.5.
+func intervalToBinary(s string, invalid error) ([]byte, error) {
+	var microsec int64
+	var days, months int32
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			t, err := parseClock(strings.TrimLeft(f, "+-"))
+			if err != nil {
+				return nil, invalid
+			}
+			if strings.HasPrefix(f, "-") {
+				t = -t
+			}
+			microsec += t
+			continue
+		}
+
+		n, err := strconv.ParseInt(f, 10, 32)
+		if err != nil || i+1 == len(fields) {
+			return nil, invalid
+		}
+		i++
+		switch strings.TrimSuffix(fields[i], "s") {
+		case "year":
+			months += int32(n) * 12
+		case "mon", "month":
+			months += int32(n)
+		case "week":
+			days += int32(n) * 7
+		case "day":
+			days += int32(n)
+		case "hour":
+			microsec += n * 3600000000
+		case "min", "minute":
+			microsec += n * 60000000
+		case "sec", "second":
+			microsec += n * 1000000
+		default:
+			return nil, invalid
+		}
+	}
+
+	buf := appendUint64(nil, uint64(microsec))
+	buf = appendUint32(buf, uint32(days))
+	return appendUint32(buf, uint32(months)), nil
+}
+
+// formatInterval formats an interval in the postgres IntervalStyle.
+func formatInterval(microsec int64, days, months int32) string {
+	var parts []string
+	unit := func(n int64, name string) {
+		if n == 1 || n == -1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, name))
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, name))
+		}
+	}
+	unit(int64(months/12), "year")
+	unit(int64(months%12), "mon")
+	unit(int64(days), "day")
+	if microsec != 0 || len(parts) == 0 {
+		sign := ""
+		switch {
+		case microsec < 0:
+			sign = "-"
+			microsec = -microsec
+		case len(parts) > 0 && (months < 0 || days < 0):
+			sign = "+"
+		}
+		parts = append(parts, sign+formatClock(microsec))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Address families of the inet binary format.
+const (
+	pgAFInet  = 2
+	pgAFInet6 = 3
+)
+
+func inetToBinary(oid Oid, s string, invalid error) ([]byte, error) {
+	var ip net.IP
+	var bits int
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		ip = net.ParseIP(s[:i])
+		var err error
+		if bits, err = strconv.Atoi(s[i+1:]); err != nil {
+			return nil, invalid
+		}
+	} else {
+		ip = net.ParseIP(s)
+		bits = -1
+	}
+	if ip == nil {
+		return nil, invalid
+	}
+
+	family := byte(pgAFInet6)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		family = pgAFInet
+	}
+	if bits == -1 {
+		bits = len(ip) * 8
+	}
+	if bits < 0 || bits > len(ip)*8 {
+		return nil, invalid
+	}
+	isCidr := byte(0)
+	if oid == CidrOid {
+		isCidr = 1
+		if !ip.Mask(net.CIDRMask(bits, len(ip)*8)).Equal(ip) {
+			return nil, fmt.Errorf("invalid cidr value: %q", s)
+		}
+	}
+	return append([]byte{family, byte(bits), isCidr, byte(len(ip))}, ip...), nil
+}
+
+func inetFromBinary(oid Oid, src []byte, invalid error) (string, error) {
+	if len(src) < 4 || int(src[3]) != len(src)-4 || (src[3] != 4 && src[3] != 16) || int(src[1]) > len(src[4:])*8 {
+		return "", invalid
+	}
+	ip := net.IP(src[4:])
+	bits := int(src[1])
+	if oid == InetOid && bits == len(ip)*8 {
+		return ip.String(), nil
+	}
+	return ip.String() + "/" + strconv.Itoa(bits), nil
+}
+
+// Sign bits of the numeric binary format.
+const (
+	numericPos = 0x0000
+	numericNeg = 0x4000
+	numericNaN = 0xC000
+)
+
+// numericToBinary converts a decimal number, such as -12.50, to the binary
+// format: base 10000 digits, the weight of the first and the display scale.
+func numericToBinary(s string, invalid error) ([]byte, error) {
+	if strings.EqualFold(s, "NaN") {
+		return []byte{0, 0, 0, 0, numericNaN >> 8, 0, 0, 0}, nil
+	}
+
+	sign := uint16(numericPos)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = numericNeg
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if intPart == "" && frac == "" || strings.Trim(intPart+frac, "0123456789") != "" {
+		return nil, invalid
+	}
+	dscale := len(frac)
+
+	// Pad the digits so the decimal point is between groups of four.
+	intPart = strings.Repeat("0", (4-len(intPart)%4)%4) + intPart
+	digits := intPart + frac + strings.Repeat("0", (4-len(frac)%4)%4)
+	groups := make([]uint16, len(digits)/4)
+	for i := range groups {
+		n, _ := strconv.Atoi(digits[i*4 : i*4+4])
+		groups[i] = uint16(n)
+	}
+	weight := len(intPart)/4 - 1
+	for len(groups) > 0 && groups[0] == 0 {
+		groups = groups[1:]
+		weight--
+	}
+	for len(groups) > 0 && groups[len(groups)-1] == 0 {
+		groups = groups[:len(groups)-1]
+	}
+	if len(groups) == 0 {
+		weight = 0
+		sign = numericPos
+	}
+
+	buf := appendUint16(nil, uint16(len(groups)))
+	buf = appendUint16(buf, uint16(weight))
+	buf = appendUint16(buf, sign)
+	buf = appendUint16(buf, uint16(dscale))
+	for _, g := range groups {
+		buf = appendUint16(buf, g)
+	}
+	return buf, nil
+}
+
+func numericFromBinary(src []byte, invalid error) (string, error) {
+	if len(src) < 8 {
+		return "", invalid
+	}
+	ndigits := int(binary.BigEndian.Uint16(src))
+	weight := int(int16(binary.BigEndian.Uint16(src[2:])))
+	sign := binary.BigEndian.Uint16(src[4:])
+	dscale := int(binary.BigEndian.Uint16(src[6:]))
+	if len(src) != 8+2*ndigits || dscale > 0x3fff {
+		return "", invalid
+	}
+	switch sign {
+	case numericNaN:
+		return "NaN", nil
+	case numericPos, numericNeg:
+	default:
+		return "", invalid
+	}
+
+	// Write the groups from the one with weight max(weight, 0) down to the
+	// one holding the last digit of the scale.
+	var b strings.Builder
+	group := func(w int) uint16 {
+		if i := weight - w; i >= 0 && i < ndigits {
+			return binary.BigEndian.Uint16(src[8+2*i:])
+		}
+		return 0
+	}
+	if sign == numericNeg && ndigits > 0 {
+		b.WriteByte('-')
+	}
+	top := weight
+	if top < 0 {
+		top = 0
+	}
+	b.WriteString(strconv.Itoa(int(group(top))))
+	for w := top - 1; w >= 0; w-- {
+		fmt.Fprintf(&b, "%04d", group(w))
+	}
+	if dscale > 0 {
+		var frac strings.Builder
+		for w := -1; frac.Len() < dscale; w-- {
+			fmt.Fprintf(&frac, "%04d", group(w))
+		}
+		b.WriteByte('.')
+		b.WriteString(frac.String()[:dscale])
+	}
+	return b.String(), nil
+}
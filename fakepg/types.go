@@ -0,0 +1,487 @@
+package fakepg
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Oid is a PostgreSQL type object identifier.
+type Oid uint32
+
+// Type oids understood by the server.
+const (
+	BoolOid        Oid = 16
+	ByteaOid       Oid = 17
+	NameOid        Oid = 19
+	Int8Oid        Oid = 20
+	Int2Oid        Oid = 21
+	Int4Oid        Oid = 23
+	TextOid        Oid = 25
+	OidOid         Oid = 26
+	JSONOid        Oid = 114
+	CidrOid        Oid = 650
+	Float4Oid      Oid = 700
+	Float8Oid      Oid = 701
+	UnknownOid     Oid = 705
+	MacaddrOid     Oid = 829
+	InetOid        Oid = 869
+	VarcharOid     Oid = 1043
+	DateOid        Oid = 1082
+	TimeOid        Oid = 1083
+	TimestampOid   Oid = 1114
+	TimestamptzOid Oid = 1184
+	IntervalOid    Oid = 1186
+	NumericOid     Oid = 1700
+	VoidOid        Oid = 2278
+	UUIDOid        Oid = 2950
+	JSONBOid       Oid = 3802
+)
+
+// typeNames is what the server reports from pg_type. Drivers such as pgx use
+// it to decide which transcoder handles each oid.
+var typeNames = map[Oid]string{
+	BoolOid:        "bool",
+	ByteaOid:       "bytea",
+	NameOid:        "name",
+	Int8Oid:        "int8",
+	Int2Oid:        "int2",
+	Int4Oid:        "int4",
+	TextOid:        "text",
+	OidOid:         "oid",
+	JSONOid:        "json",
+	CidrOid:        "cidr",
+	Float4Oid:      "float4",
+	Float8Oid:      "float8",
+	UnknownOid:     "unknown",
+	MacaddrOid:     "macaddr",
+	InetOid:        "inet",
+	VarcharOid:     "varchar",
+	DateOid:        "date",
+	TimeOid:        "time",
+	TimestampOid:   "timestamp",
+	TimestamptzOid: "timestamptz",
+	IntervalOid:    "interval",
+	NumericOid:     "numeric",
+	VoidOid:        "void",
+	UUIDOid:        "uuid",
+	JSONBOid:       "jsonb",
+}
+
+// sqlTypes maps the type names accepted in DDL and casts to oids.
+var sqlTypes = map[string]Oid{
+	"bool":                        BoolOid,
+	"boolean":                     BoolOid,
+	"bytea":                       ByteaOid,
+	"name":                        NameOid,
+	"int8":                        Int8Oid,
+	"bigint":                      Int8Oid,
+	"bigserial":                   Int8Oid,
+	"int2":                        Int2Oid,
+	"smallint":                    Int2Oid,
+	"int4":                        Int4Oid,
+	"int":                         Int4Oid,
+	"integer":                     Int4Oid,
+	"serial":                      Int4Oid,
+	"text":                        TextOid,
+	"oid":                         OidOid,
+	"json":                        JSONOid,
+	"jsonb":                       JSONBOid,
+	"cidr":                        CidrOid,
+	"inet":                        InetOid,
+	"macaddr":                     MacaddrOid,
+	"float4":                      Float4Oid,
+	"real":                        Float4Oid,
+	"float8":                      Float8Oid,
+	"double precision":            Float8Oid,
+	"varchar":                     VarcharOid,
+	"character varying":           VarcharOid,
+	"date":                        DateOid,
+	"time":                        TimeOid,
+	"time without time zone":      TimeOid,
+	"interval":                    IntervalOid,
+	"numeric":                     NumericOid,
+	"decimal":                     NumericOid,
+	"uuid":                        UUIDOid,
+	"timestamp":                   TimestampOid,
+	"timestamp without time zone": TimestampOid,
+	"datetime":                    TimestampOid,
+	"timestamptz":                 TimestamptzOid,
+	"timestamp with time zone":    TimestamptzOid,
+}
+
+// typeSize returns the pg_type.typlen of oid.
+func typeSize(oid Oid) int16 {
+	switch oid {
+	case BoolOid:
+		return 1
+	case Int2Oid:
+		return 2
+	case Int4Oid, OidOid, Float4Oid, DateOid:
+		return 4
+	case MacaddrOid:
+		return 6
+	case Int8Oid, Float8Oid, TimeOid, TimestampOid, TimestamptzOid:
+		return 8
+	case IntervalOid, UUIDOid:
+		return 16
+	default:
+		return -1
+	}
+}
+
+func isTextType(oid Oid) bool {
+	switch oid {
+	case TextOid, VarcharOid, NameOid, JSONOid, UnknownOid:
+		return true
+	}
+	return false
+}
+
+func isIntType(oid Oid) bool {
+	switch oid {
+	case Int2Oid, Int4Oid, Int8Oid, OidOid:
+		return true
+	}
+	return false
+}
+
+const (
+	textFormat   = 0
+	binaryFormat = 1
+)
+
+var y2k = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const microsecFromUnixEpochToY2K = 946684800 * 1000000
+
+// appendValue appends the wire representation of v as type oid in format to
+// buf. The length prefix is not included.
+func appendValue(buf []byte, oid Oid, format int16, v interface{}) ([]byte, error) {
+	if format == binaryFormat {
+		return appendBinary(buf, oid, v)
+	}
+	return appendText(buf, oid, v)
+}
+
+func appendText(buf []byte, oid Oid, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return append(buf, 't'), nil
+		}
+		return append(buf, 'f'), nil
+	case string:
+		return append(buf, v...), nil
+	case []byte:
+		if oid == ByteaOid {
+			buf = append(buf, `\x`...)
+			return append(buf, hex.EncodeToString(v)...), nil
+		}
+		return append(buf, v...), nil
+	case arrayValue:
+		return appendArrayText(buf, arrayElems[oid], v)
+	case time.Time:
+		switch oid {
+		case DateOid:
+			return v.AppendFormat(buf, "2006-01-02"), nil
+		case TimestampOid:
+			return v.AppendFormat(buf, "2006-01-02 15:04:05.999999"), nil
+		default:
+			return v.UTC().AppendFormat(buf, "2006-01-02 15:04:05.999999-07"), nil
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %T as text", v)
+	}
+}
+
+func appendBinary(buf []byte, oid Oid, v interface{}) ([]byte, error) {
+	switch oid {
+	case BoolOid:
+		b, ok := v.(bool)
+		if !ok {
+			break
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case Int2Oid, Int4Oid, Int8Oid, OidOid:
+		n, ok := v.(int64)
+		if !ok {
+			break
+		}
+		switch typeSize(oid) {
+		case 2:
+			return appendUint16(buf, uint16(n)), nil
+		case 4:
+			return appendUint32(buf, uint32(n)), nil
+		default:
+			return appendUint64(buf, uint64(n)), nil
+		}
+	case Float4Oid:
+		f, ok := v.(float64)
+		if !ok {
+			break
+		}
+		return appendUint32(buf, math.Float32bits(float32(f))), nil
+	case Float8Oid:
+		f, ok := v.(float64)
+		if !ok {
+			break
+		}
+		return appendUint64(buf, math.Float64bits(f)), nil
+	case DateOid:
+		t, ok := v.(time.Time)
+		if !ok {
+			break
+		}
+		days := (time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() - y2k.Unix()) / 86400
+		return appendUint32(buf, uint32(int32(days))), nil
+	case TimestampOid, TimestamptzOid:
+		t, ok := v.(time.Time)
+		if !ok {
+			break
+		}
+		if oid == TimestampOid {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+		microsec := t.Unix()*1000000 + int64(t.Nanosecond())/1000 - microsecFromUnixEpochToY2K
+		return appendUint64(buf, uint64(microsec)), nil
+	case ByteaOid:
+		if b, ok := v.([]byte); ok {
+			return append(buf, b...), nil
+		}
+	case VoidOid:
+		return buf, nil
+	}
+	if elem, ok := arrayElems[oid]; ok {
+		if a, ok := v.(arrayValue); ok {
+			return appendArrayBinary(buf, elem, a)
+		}
+	}
+	if s, ok := v.(string); ok && isTextValueType(oid) {
+		b, err := textValueToBinary(oid, s)
+		return append(buf, b...), err
+	}
+
+	if isTextType(oid) || oid == ByteaOid {
+		switch v := v.(type) {
+		case string:
+			return append(buf, v...), nil
+		case []byte:
+			return append(buf, v...), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot encode %T as binary %s", v, typeNames[oid])
+}
+
+// decodeValue converts a parameter received from a client into its internal
+// representation.
+func decodeValue(oid Oid, format int16, src []byte) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if format == textFormat {
+		return parseText(oid, string(src))
+	}
+	if elem, ok := arrayElems[oid]; ok {
+		return decodeArrayBinary(elem, src)
+	}
+	if isTextValueType(oid) {
+		return textValueFromBinary(oid, src)
+	}
+
+	switch oid {
+	case BoolOid:
+		if len(src) != 1 {
+			return nil, fmt.Errorf("invalid binary bool length %d", len(src))
+		}
+		return src[0] != 0, nil
+	case Int2Oid, Int4Oid, Int8Oid, OidOid:
+		switch len(src) {
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(src))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(src))), nil
+		case 8:
+			return int64(binary.BigEndian.Uint64(src)), nil
+		}
+		return nil, fmt.Errorf("invalid binary integer length %d", len(src))
+	case Float4Oid:
+		if len(src) != 4 {
+			return nil, fmt.Errorf("invalid binary float4 length %d", len(src))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(src))), nil
+	case Float8Oid:
+		if len(src) != 8 {
+			return nil, fmt.Errorf("invalid binary float8 length %d", len(src))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(src)), nil
+	case DateOid:
+		if len(src) != 4 {
+			return nil, fmt.Errorf("invalid binary date length %d", len(src))
+		}
+		days := int32(binary.BigEndian.Uint32(src))
+		return y2k.AddDate(0, 0, int(days)), nil
+	case TimestampOid, TimestamptzOid:
+		if len(src) != 8 {
+			return nil, fmt.Errorf("invalid binary timestamp length %d", len(src))
+		}
+		microsec := int64(binary.BigEndian.Uint64(src)) + microsecFromUnixEpochToY2K
+		return time.Unix(microsec/1000000, (microsec%1000000)*1000).UTC(), nil
+	case ByteaOid:
+		return append([]byte(nil), src...), nil
+	default:
+		return string(src), nil
+	}
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseText converts the text representation of a value of type oid.
+func parseText(oid Oid, s string) (interface{}, error) {
+	if elem, ok := arrayElems[oid]; ok {
+		return parseArrayText(elem, s)
+	}
+	if isTextValueType(oid) {
+		return parseTextValue(oid, s)
+	}
+	switch oid {
+	case BoolOid:
+		switch strings.ToLower(s) {
+		case "t", "true", "y", "yes", "on", "1":
+			return true, nil
+		case "f", "false", "n", "no", "off", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid input syntax for type boolean: %q", s)
+	case Int2Oid, Int4Oid, Int8Oid, OidOid:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, int(typeSize(oid))*8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type %s: %q", typeNames[oid], s)
+		}
+		return n, nil
+	case Float4Oid, Float8Oid:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type %s: %q", typeNames[oid], s)
+		}
+		return f, nil
+	case DateOid:
+		// Drivers that send a time.Time as text send a full timestamp, which
+		// is truncated to its date.
+		for _, layout := range timestampLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+				return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid input syntax for type date: %q", s)
+	case TimestampOid, TimestamptzOid:
+		for _, layout := range timestampLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid input syntax for type %s: %q", typeNames[oid], s)
+	case ByteaOid:
+		if strings.HasPrefix(s, `\x`) {
+			return hex.DecodeString(s[2:])
+		}
+		return []byte(s), nil
+	default:
+		return s, nil
+	}
+}
+
+// coerce converts v to the internal representation of oid. It is used when a
+// literal or parameter meets a column of a known type.
+func coerce(v interface{}, oid Oid) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if isTextType(oid) {
+			return v, nil
+		}
+		return parseText(oid, v)
+	case int64:
+		switch {
+		case oid == Float4Oid || oid == Float8Oid:
+			return float64(v), nil
+		case isTextType(oid):
+			return strconv.FormatInt(v, 10), nil
+		}
+	case float64:
+		if isIntType(oid) {
+			return int64(math.Round(v)), nil
+		}
+	}
+	return v, nil
+}
+
+// appendJSON appends v to buf as it would appear in row_to_json output.
+func appendJSON(buf []byte, oid Oid, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		if oid == JSONOid || oid == JSONBOid || oid == NumericOid {
+			return append(buf, v...)
+		}
+		b, _ := json.Marshal(v)
+		return append(buf, b...)
+	case time.Time:
+		buf = append(buf, '"')
+		switch oid {
+		case DateOid:
+			buf = v.AppendFormat(buf, "2006-01-02")
+		case TimestampOid:
+			buf = v.AppendFormat(buf, "2006-01-02T15:04:05.999999")
+		default:
+			buf = v.UTC().AppendFormat(buf, "2006-01-02T15:04:05.999999-07:00")
+		}
+		return append(buf, '"')
+	case []byte:
+		b, _ := json.Marshal(`\x` + hex.EncodeToString(v))
+		return append(buf, b...)
+	default:
+		b, _ := appendText(nil, oid, v)
+		if _, ok := v.(bool); ok {
+			b, _ = json.Marshal(v)
+		}
+		return append(buf, b...)
+	}
+}
+
+func appendUint16(buf []byte, n uint16) []byte {
+	return append(buf, byte(n>>8), byte(n))
+}
+
+func appendUint32(buf []byte, n uint32) []byte {
+	return append(buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendUint64(buf []byte, n uint64) []byte {
+	return append(buf, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32), byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
@@ -1,21 +1,29 @@
 module github.com/hixichen/go_db_bench
 
-go 1.12
+go 1.22.0
 
 require (
 	github.com/go-pg/pg v8.0.3+incompatible
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/jackc/pgx v3.3.0+incompatible
+	github.com/lib/pq v1.0.0
+	github.com/ncruces/go-sqlite3 v0.23.3
+	gopkg.in/inconshreveable/log15.v2 v2.0.0-20180818164646-67afb5ed74ec
+)
+
+require (
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
-	github.com/jackc/go_db_bench v0.0.0-20181019145306-324d94daf5a7
-	github.com/jackc/pgx v3.3.0+incompatible
 	github.com/jinzhu/inflection v0.0.0-20180308033659-04140366298a // indirect
-	github.com/lib/pq v1.0.0
 	github.com/mattn/go-colorable v0.1.1 // indirect
 	github.com/mattn/go-isatty v0.0.7 // indirect
+	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 	gopkg.in/bsm/ratelimit.v1 v1.0.0-20160220154919-db14e161995a // indirect
 	gopkg.in/bufio.v1 v1.0.0-20140618132640-567b2bfa514e // indirect
-	gopkg.in/inconshreveable/log15.v2 v2.0.0-20180818164646-67afb5ed74ec
 	gopkg.in/pg.v3 v3.5.6 // indirect
 	gopkg.in/pg.v8 v8.0.3 // indirect
 	mellium.im/sasl v0.2.1 // indirect
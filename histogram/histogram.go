@@ -0,0 +1,303 @@
+// Package histogram records latencies in a high dynamic range histogram.
+//
+// Like HdrHistogram, values are counted in buckets whose width grows with the
+// magnitude of the value so that any recorded value can be reported with a
+// fixed number of significant decimal digits, while memory use only grows
+// with the logarithm of the largest value. Histograms with the same precision
+// can be merged exactly, which allows combining the histograms of several
+// goroutines or of separate runs.
+package histogram
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram counts non-negative int64 values. The zero value is not usable;
+// create histograms with New. A Histogram is not safe for concurrent use.
+type Histogram struct {
+	significantFigures int
+	subBucketBits      uint  // log2 of subBucketCount
+	subBucketCount     int64 // values below this are counted exactly
+	subBucketHalfCount int64
+
+	counts     []int64 // grown as larger values are recorded
+	totalCount int64
+	min, max   int64
+	sum        float64
+}
+
+// New returns a Histogram that reports values with significantFigures
+// significant decimal digits. significantFigures must be between 1 and 5; 3 is
+// a good default and keeps the error of any reported value below 0.1%.
+func New(significantFigures int) *Histogram {
+	if significantFigures < 1 || significantFigures > 5 {
+		panic(fmt.Sprintf("histogram: significant figures must be between 1 and 5, got %d", significantFigures))
+	}
+
+	// Values within one bucket must differ by less than one unit in the last
+	// significant digit, so each power of two needs 2*10^significantFigures
+	// sub-buckets, rounded up to a power of two.
+	largestExact := 2 * int64(math.Pow10(significantFigures))
+	subBucketBits := uint(bits.Len64(uint64(largestExact - 1)))
+
+	return &Histogram{
+		significantFigures: significantFigures,
+		subBucketBits:      subBucketBits,
+		subBucketCount:     1 << subBucketBits,
+		subBucketHalfCount: 1 << (subBucketBits - 1),
+		min:                math.MaxInt64,
+	}
+}
+
+// SignificantFigures returns the precision h was created with.
+func (h *Histogram) SignificantFigures() int {
+	return h.significantFigures
+}
+
+// Record counts one occurrence of v. Negative values are counted as 0.
+func (h *Histogram) Record(v int64) {
+	h.RecordN(v, 1)
+}
+
+// RecordN counts n occurrences of v. Negative values are counted as 0.
+func (h *Histogram) RecordN(v, n int64) {
+	if n <= 0 {
+		return
+	}
+	if v < 0 {
+		v = 0
+	}
+
+	i := h.index(v)
+	if i >= len(h.counts) {
+		h.grow(i)
+	}
+	h.counts[i] += n
+	h.totalCount += n
+	h.sum += float64(v) * float64(n)
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Preallocate makes room for values up to max so that recording them does not
+// allocate. It is useful before timing code that records values.
+func (h *Histogram) Preallocate(max int64) {
+	if i := h.index(max); i >= len(h.counts) {
+		h.grow(i)
+	}
+}
+
+func (h *Histogram) grow(i int) {
+	size := 2 * len(h.counts)
+	if size <= i {
+		size = i + 1
+	}
+	counts := make([]int64, size)
+	copy(counts, h.counts)
+	h.counts = counts
+}
+
+// index returns the bucket that counts v. The first subBucketCount buckets
+// count one value each. After that each power of two is split into
+// subBucketHalfCount buckets.
+func (h *Histogram) index(v int64) int {
+	if v < h.subBucketCount {
+		return int(v)
+	}
+	shift := uint(bits.Len64(uint64(v))) - h.subBucketBits
+	return int(h.subBucketCount + int64(shift-1)*h.subBucketHalfCount + (v >> shift) - h.subBucketHalfCount)
+}
+
+// bucketRange returns the lowest value counted by bucket i and the number of
+// values it counts.
+func (h *Histogram) bucketRange(i int) (lowest, width int64) {
+	if int64(i) < h.subBucketCount {
+		return int64(i), 1
+	}
+	rest := int64(i) - h.subBucketCount
+	shift := uint(rest/h.subBucketHalfCount) + 1
+	sub := rest%h.subBucketHalfCount + h.subBucketHalfCount
+	return sub << shift, 1 << shift
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() int64 {
+	return h.totalCount
+}
+
+// Min returns the smallest recorded value, or 0 if h is empty.
+func (h *Histogram) Min() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value, or 0 if h is empty.
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the mean of the recorded values, or 0 if h is empty.
+func (h *Histogram) Mean() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.sum / float64(h.totalCount)
+}
+
+// ValueAtPercentile returns the value that percentile percent of the recorded
+// values are less than or equal to, within the precision of h. percentile is
+// between 0 and 100. It returns 0 if h is empty.
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if percentile < 0 {
+		percentile = 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+
+	target := int64(math.Ceil(percentile / 100 * float64(h.totalCount)))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			lowest, width := h.bucketRange(i)
+			v := lowest + width - 1
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Merge adds the values recorded in other to h. Merging is exact when both
+// histograms have the same precision. Otherwise each bucket of other is
+// recorded at its midpoint.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.totalCount == 0 {
+		return
+	}
+
+	if other.significantFigures == h.significantFigures {
+		if len(other.counts) > len(h.counts) {
+			h.grow(len(other.counts) - 1)
+		}
+		for i, c := range other.counts {
+			h.counts[i] += c
+		}
+		h.totalCount += other.totalCount
+		h.sum += other.sum
+	} else {
+		sum := h.sum
+		for i, c := range other.counts {
+			if c != 0 {
+				lowest, width := other.bucketRange(i)
+				h.RecordN(lowest+width/2, c)
+			}
+		}
+		h.sum = sum + other.sum
+	}
+
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Reset removes all recorded values.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+	h.sum = 0
+	h.min = math.MaxInt64
+	h.max = 0
+}
+
+// jsonHistogram is the encoding of a Histogram. Only non-empty buckets are
+// written, as pairs of bucket index and count.
+type jsonHistogram struct {
+	SignificantFigures int        `json:"significant_figures"`
+	Count              int64      `json:"count"`
+	Min                int64      `json:"min"`
+	Max                int64      `json:"max"`
+	Sum                float64    `json:"sum"`
+	Buckets            [][2]int64 `json:"buckets"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	jh := jsonHistogram{
+		SignificantFigures: h.significantFigures,
+		Count:              h.totalCount,
+		Min:                h.Min(),
+		Max:                h.max,
+		Sum:                h.sum,
+		Buckets:            [][2]int64{},
+	}
+	for i, c := range h.counts {
+		if c != 0 {
+			jh.Buckets = append(jh.Buckets, [2]int64{int64(i), c})
+		}
+	}
+	return json.Marshal(jh)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *Histogram) UnmarshalJSON(buf []byte) error {
+	var jh jsonHistogram
+	if err := json.Unmarshal(buf, &jh); err != nil {
+		return err
+	}
+	if jh.SignificantFigures < 1 || jh.SignificantFigures > 5 {
+		return fmt.Errorf("histogram: invalid significant figures %d", jh.SignificantFigures)
+	}
+
+	*h = *New(jh.SignificantFigures)
+	// No value has a bucket past the one of math.MaxInt64, so a larger index
+	// comes from a corrupt file and would only allocate counts.
+	maxIndex := int64(h.index(math.MaxInt64))
+	for _, b := range jh.Buckets {
+		i, c := b[0], b[1]
+		if i < 0 || i > maxIndex || c < 0 {
+			return fmt.Errorf("histogram: invalid bucket %d with count %d", i, c)
+		}
+		if int(i) >= len(h.counts) {
+			h.grow(int(i))
+		}
+		h.counts[i] += c
+		h.totalCount += c
+	}
+	if h.totalCount != jh.Count {
+		return fmt.Errorf("histogram: bucket counts add up to %d, expected %d", h.totalCount, jh.Count)
+	}
+	if h.totalCount > 0 {
+		h.min = jh.Min
+		h.max = jh.Max
+	}
+	h.sum = jh.Sum
+	return nil
+}
@@ -0,0 +1,120 @@
+package histogram
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPercentilesWithinPrecision(t *testing.T) {
+	h := New(3)
+	rng := rand.New(rand.NewSource(1))
+	values := make([]int64, 100000)
+	for i := range values {
+		// Spread values over several orders of magnitude like latencies.
+		values[i] = int64(rng.ExpFloat64() * 50000)
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, p := range []float64{50, 90, 99, 99.9, 100} {
+		exact := values[int(math.Ceil(p/100*float64(len(values))))-1]
+		got := h.ValueAtPercentile(p)
+		if diff := float64(got - exact); diff < -0.001*float64(exact)-1 || diff > 0.001*float64(exact)+1 {
+			t.Errorf("p%v: got %d, exact %d", p, got, exact)
+		}
+	}
+
+	if h.Max() != values[len(values)-1] {
+		t.Errorf("max: got %d, want %d", h.Max(), values[len(values)-1])
+	}
+	if h.Min() != values[0] {
+		t.Errorf("min: got %d, want %d", h.Min(), values[0])
+	}
+	if h.Count() != int64(len(values)) {
+		t.Errorf("count: got %d, want %d", h.Count(), len(values))
+	}
+}
+
+func TestBucketRangeIsInverseOfIndex(t *testing.T) {
+	h := New(2)
+	for _, v := range []int64{0, 1, 255, 256, 257, 1000, 123456, 1 << 40, 1<<62 + 12345} {
+		lowest, width := h.bucketRange(h.index(v))
+		if v < lowest || v >= lowest+width {
+			t.Errorf("%d: bucket covers [%d, %d)", v, lowest, lowest+width)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, all := New(3), New(3), New(3)
+	for i := int64(0); i < 10000; i++ {
+		a.Record(i)
+		b.Record(i * 100)
+		all.Record(i)
+		all.Record(i * 100)
+	}
+
+	a.Merge(b)
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		if a.ValueAtPercentile(p) != all.ValueAtPercentile(p) {
+			t.Errorf("p%v: merged %d, recorded together %d", p, a.ValueAtPercentile(p), all.ValueAtPercentile(p))
+		}
+	}
+	if a.Count() != all.Count() || a.Max() != all.Max() || a.Mean() != all.Mean() {
+		t.Errorf("merged count %d max %d mean %v, recorded together count %d max %d mean %v",
+			a.Count(), a.Max(), a.Mean(), all.Count(), all.Max(), all.Mean())
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	h := New(3)
+	for i := int64(1); i < 5000; i++ {
+		h.RecordN(i*i, i%3)
+	}
+
+	buf, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Histogram
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Count() != h.Count() || decoded.Min() != h.Min() || decoded.Max() != h.Max() {
+		t.Errorf("decoded count %d min %d max %d, want %d %d %d", decoded.Count(), decoded.Min(), decoded.Max(), h.Count(), h.Min(), h.Max())
+	}
+	for _, p := range []float64{0, 50, 99, 99.99, 100} {
+		if decoded.ValueAtPercentile(p) != h.ValueAtPercentile(p) {
+			t.Errorf("p%v: decoded %d, want %d", p, decoded.ValueAtPercentile(p), h.ValueAtPercentile(p))
+		}
+	}
+}
+
+func TestUnmarshalJSONRejectsBucketsPastMaxInt64(t *testing.T) {
+	h := New(3)
+	h.Record(math.MaxInt64)
+	buf, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Histogram
+	if err := json.Unmarshal(buf, &decoded); err != nil || decoded.Max() != math.MaxInt64 {
+		t.Errorf("decoding the bucket of math.MaxInt64: max %d, %v", decoded.Max(), err)
+	}
+
+	maxIndex := h.index(math.MaxInt64)
+	buf = []byte(fmt.Sprintf(`{"significant_figures":3,"count":1,"min":1,"max":1,"sum":1,"buckets":[[%d,1]]}`, maxIndex+1))
+	if err := json.Unmarshal(buf, &decoded); err == nil || !strings.Contains(err.Error(), "invalid bucket") {
+		t.Errorf("expected an invalid bucket error, got %v", err)
+	}
+	buf = []byte(`{"significant_figures":3,"count":1,"min":1,"max":1,"sum":1,"buckets":[[2147483646,1]]}`)
+	if err := json.Unmarshal(buf, &decoded); err == nil || !strings.Contains(err.Error(), "invalid bucket") {
+		t.Errorf("expected an invalid bucket error, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const importUsage = `Usage: db_bench import [flags] [file]
+
+Converts go test -bench output, such as sample_result/log, to the db_bench
+result format. Reads standard input if no file is given.
+
+Flags:
+`
+
+// importCommand implements db_bench import.
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	output := fs.String("output", "-", "file to write results to, - for stdout")
+	format := fs.String("format", "json", "output format: json or csv")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, importUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("import takes at most one file")
+	}
+
+	in := os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	report, err := parseBenchOutput(in)
+	if err != nil {
+		return err
+	}
+
+	return writeResultsFile(*output, report, *format)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hixichen/go_db_bench/histogram"
+)
+
+const loadUsage = `Usage: db_bench load [flags]
+
+Sends requests to the /people/* endpoints of a running db_bench serve and
+writes the results in the same format as db_bench run.
+
+In closed-loop mode (the default) each of --concurrency clients sends its next
+request as soon as the previous response has been read, so the request rate
+follows the speed of the server.
+
+In open-loop mode requests are started at a fixed --rate regardless of how
+fast the server answers, by up to --workers requests in flight. Latencies are
+measured from the time a request was scheduled to be sent rather than the time
+it was sent, which corrects for coordinated omission: a stalled server delays
+the requests that should have been sent during the stall, and their latency
+includes the time they waited. Every request scheduled during --duration is
+sent, so a sample takes longer than --duration when the server falls behind.
+
+Flags:
+`
+
+// loadTargets are the endpoints served by db_bench serve.
+var loadTargets = []string{"pgx-native", "pgx-stdlib", "pq", "pg", "raw"}
+
+// loadCommand implements db_bench load.
+func loadCommand(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:8080", "base URL of db_bench serve")
+	drivers := fs.String("drivers", "all", "comma separated list of endpoints to load: "+strings.Join(loadTargets, ", "))
+	mode := fs.String("mode", "closed", "closed for a fixed number of clients, open for a fixed request rate")
+	concurrency := fs.String("concurrency", "1,16,64", "closed loop: comma separated list of client counts")
+	rates := fs.String("rate", "1000", "open loop: comma separated list of requests per second")
+	workers := fs.Int("workers", 256, "open loop: maximum number of requests in flight")
+	duration := fs.Duration("duration", 10*time.Second, "time to load each endpoint for each sample")
+	count := fs.Int("count", 1, "number of samples to take of each endpoint")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	output := fs.String("output", "-", "file to write results to, - for stdout")
+	format := fs.String("format", "json", "output format: json or csv")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, loadUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *duration <= 0 {
+		return fmt.Errorf("duration must be positive, got %v", *duration)
+	}
+	if *count < 1 {
+		return fmt.Errorf("count must be at least 1, got %d", *count)
+	}
+	if *workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", *workers)
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	targets, err := selectNames("driver", *drivers, loadTargets)
+	if err != nil {
+		return err
+	}
+
+	var levels []int
+	switch *mode {
+	case "closed":
+		levels, err = parsePositiveInts("client count", *concurrency)
+	case "open":
+		levels, err = parsePositiveInts("rate", *rates)
+	default:
+		return fmt.Errorf("unknown mode %q", *mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	report := newRunReport(*duration)
+	for _, target := range targets {
+		url := strings.TrimSuffix(*baseURL, "/") + "/people/" + target
+
+		for _, level := range levels {
+			clients := level
+			scenario := "http-people"
+			if *mode == "open" {
+				clients = *workers
+				scenario = fmt.Sprintf("http-people-%drps", level)
+			}
+			get := httpGetFunc(newLoadClient(clients, *timeout), url)
+
+			samples := make([]benchSample, *count)
+			for i := range samples {
+				var uncorrected *histogram.Histogram
+				if *mode == "open" {
+					samples[i], uncorrected, err = measureOpenLoop(get, *duration, level, clients)
+				} else {
+					samples[i], err = measure(get, *duration, clients)
+					// measure counts the allocations of this process, which
+					// are the load generator's and not the server's.
+					samples[i].BytesPerOp, samples[i].AllocsPerOp = 0, 0
+				}
+				if err != nil {
+					return fmt.Errorf("%s %s: %v", target, scenario, err)
+				}
+
+				p := percentilesOf(samples[i].Latency)
+				fmt.Fprintf(os.Stderr, "%-12s %-24s %4d %10d %12.0f ns/op %10.0f req/s %10d p50-ns %10d p99-ns %10d max-ns",
+					target, scenario, clients, samples[i].Iterations, samples[i].NsPerOp, 1e9/samples[i].NsPerOp, p.P50Ns, p.P99Ns, p.MaxNs)
+				if uncorrected != nil {
+					fmt.Fprintf(os.Stderr, " (uncorrected %d p99-ns)", uncorrected.ValueAtPercentile(99))
+				}
+				fmt.Fprintln(os.Stderr)
+			}
+
+			result := benchResult{Driver: target, Scenario: scenario, Concurrency: clients}
+			report.Results = append(report.Results, aggregateSamples(result, samples))
+		}
+	}
+
+	return writeResultsFile(*output, report, *format)
+}
+
+// newLoadClient returns an HTTP client that keeps a connection open for each
+// of up to clients concurrent requests so connection setup is not measured.
+func newLoadClient(clients int, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConns:        clients,
+			MaxIdleConnsPerHost: clients,
+			DisableCompression:  true,
+		},
+		Timeout: timeout,
+	}
+}
+
+// httpGetFunc returns a scenarioFunc that requests url and reads the whole
+// response. Responses with a status other than 200 OK are errors.
+func httpGetFunc(client *http.Client, url string) scenarioFunc {
+	return func(int) error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return err
+	}
+}
+
+// measureOpenLoop starts calls to fn at rate calls per second until duration
+// has elapsed, with at most workers calls running at once. Every call scheduled
+// before duration has elapsed is made, so it returns later than duration when
+// fn cannot keep up with rate. The latency of a call is measured from the time
+// it was scheduled to start, so calls delayed because all workers were busy
+// include that delay. The latencies measured from the time each call actually
+// started are returned as uncorrected for comparison. As with measure, fn is
+// called once before measuring starts and ns/op is the inverse of the achieved
+// throughput. Allocations are not measured.
+func measureOpenLoop(fn scenarioFunc, duration time.Duration, rate, workers int) (sample benchSample, uncorrected *histogram.Histogram, err error) {
+	if err := fn(0); err != nil {
+		return benchSample{}, nil, err
+	}
+
+	corrected := make([]*histogram.Histogram, workers)
+	uncorrectedByWorker := make([]*histogram.Histogram, workers)
+	for w := range corrected {
+		corrected[w] = newLatencyHistogram()
+		uncorrectedByWorker[w] = newLatencyHistogram()
+	}
+
+	interval := float64(time.Second) / float64(rate)
+	var next, completed int64
+	var failed int32
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(corrected, uncorrected *histogram.Histogram) {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := atomic.AddInt64(&next, 1) - 1
+				scheduled := start.Add(time.Duration(float64(i) * interval))
+				// Calls scheduled before the deadline are started even if the
+				// server has fallen behind and the deadline has passed.
+				// Dropping them would leave out the latencies of the calls
+				// that waited longest.
+				if !scheduled.Before(deadline) {
+					return
+				}
+				if wait := time.Until(scheduled); wait > 0 {
+					time.Sleep(wait)
+				}
+
+				sent := time.Now()
+				if err := fn(int(i) + 1); err != nil {
+					atomic.StoreInt32(&failed, 1)
+					errs <- err
+					return
+				}
+				done := time.Now()
+				corrected.Record(int64(done.Sub(scheduled)))
+				uncorrected.Record(int64(done.Sub(sent)))
+				atomic.AddInt64(&completed, 1)
+			}
+		}(corrected[w], uncorrectedByWorker[w])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	select {
+	case err := <-errs:
+		return benchSample{}, nil, err
+	default:
+	}
+
+	for w := 1; w < workers; w++ {
+		corrected[0].Merge(corrected[w])
+		uncorrectedByWorker[0].Merge(uncorrectedByWorker[w])
+	}
+
+	n := atomic.LoadInt64(&completed)
+	if n == 0 {
+		return benchSample{}, nil, fmt.Errorf("no requests completed in %v", duration)
+	}
+	if achieved := float64(n) / elapsed.Seconds(); achieved < 0.95*float64(rate) {
+		fmt.Fprintf(os.Stderr, "warning: achieved %.0f req/s of %d req/s; add --workers or lower --rate\n", achieved, rate)
+	}
+
+	return benchSample{
+		Iterations: n,
+		NsPerOp:    float64(elapsed.Nanoseconds()) / float64(n),
+		Latency:    corrected[0],
+	}, uncorrectedByWorker[0], nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLoadServer starts an HTTP server that answers each request after delay
+// and counts the requests in *requests.
+func newLoadServer(delay time.Duration, requests *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		time.Sleep(delay)
+		w.Write([]byte(`[{"id":1}]`))
+	}))
+}
+
+func TestMeasureClosedLoop(t *testing.T) {
+	var requests int64
+	server := newLoadServer(time.Millisecond, &requests)
+	defer server.Close()
+
+	get := httpGetFunc(newLoadClient(4, time.Second), server.URL)
+	sample, err := measure(get, 100*time.Millisecond, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The call made before measuring starts is not counted.
+	if n := atomic.LoadInt64(&requests); sample.Iterations != n-1 {
+		t.Errorf("measured %d requests, the server got %d", sample.Iterations, n)
+	}
+	if sample.Latency.Count() != sample.Iterations {
+		t.Errorf("recorded %d latencies of %d requests", sample.Latency.Count(), sample.Iterations)
+	}
+	if min := sample.Latency.Min(); min < int64(time.Millisecond) {
+		t.Errorf("latency %v is less than the server's delay", time.Duration(min))
+	}
+}
+
+func TestMeasureOpenLoop(t *testing.T) {
+	var requests int64
+	server := newLoadServer(20*time.Millisecond, &requests)
+	defer server.Close()
+
+	// 50 requests are scheduled over 250ms, but 2 workers can only send 100
+	// requests per second, so the server falls behind and the requests
+	// still waiting at the deadline are sent after it.
+	get := httpGetFunc(newLoadClient(2, time.Second), server.URL)
+	sample, uncorrected, err := measureOpenLoop(get, 250*time.Millisecond, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.Iterations != 50 {
+		t.Errorf("expected all 50 scheduled requests to be sent, got %d", sample.Iterations)
+	}
+	if n := atomic.LoadInt64(&requests); n != sample.Iterations+1 {
+		t.Errorf("measured %d requests, the server got %d", sample.Iterations, n)
+	}
+	if sample.Latency.Count() != sample.Iterations || uncorrected.Count() != sample.Iterations {
+		t.Errorf("recorded %d corrected and %d uncorrected latencies of %d requests", sample.Latency.Count(), uncorrected.Count(), sample.Iterations)
+	}
+	// The last requests waited for a worker for about as long as the run
+	// was scheduled to take, which only the corrected latencies include.
+	if max := sample.Latency.Max(); max < int64(200*time.Millisecond) {
+		t.Errorf("corrected max latency %v does not include the time waiting for a worker", time.Duration(max))
+	}
+	if max := uncorrected.Max(); max > int64(200*time.Millisecond) {
+		t.Errorf("uncorrected max latency %v includes the time waiting for a worker", time.Duration(max))
+	}
+}
+
+func TestHTTPGetFuncStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database is down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	get := httpGetFunc(newLoadClient(1, time.Second), server.URL)
+	if err := get(0); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected an error for status 500, got %v", err)
+	}
+}
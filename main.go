@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
+	"flag"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 
 	gopg "github.com/go-pg/pg"
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/stdlib"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var selectPeopleJSONSQL = `
@@ -22,19 +28,99 @@ from person
 where id between $1 and $1 + 25
 `
 
+const usage = `Usage: db_bench [command] [flags]
+
+Commands:
+  serve    start the HTTP benchmark server on localhost:8080 (default)
+  run      run the benchmark scenarios and write the results as JSON or CSV
+  import   convert go test -bench output to the db_bench result format
+  compare  compare two or more result files
+  merge    combine result files and their latency histograms
+  load     send HTTP requests to a running server and record the results
+
+Run db_bench <command> -h for the flags of a command.
+`
+
+// connFlagUsage describes the -conn flag of the commands that connect to the
+// database.
+var connFlagUsage = "postgres:// URI or keyword/value connection string, such as \"host=db sslmode=require\", overriding the PG* environment variables; keywords: " + connKeywordNames()
+
 func main() {
-	connPoolConfig, err := extractConfig()
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "run":
+		if err := runCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "run failed:", err)
+			os.Exit(1)
+		}
+	case "import":
+		if err := importCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "import failed:", err)
+			os.Exit(1)
+		}
+	case "compare":
+		if err := compareCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "compare failed:", err)
+			os.Exit(1)
+		}
+	case "merge":
+		if err := mergeCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "merge failed:", err)
+			os.Exit(1)
+		}
+	case "load":
+		if err := loadCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "load failed:", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	conn := fs.String("conn", "", connFlagUsage)
+	fs.Parse(args)
+
+	config, err := extractConfig(*conn)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "extractConfig failed:", err)
 		os.Exit(1)
 	}
 
-	err = loadTestData(connPoolConfig)
+	if useFakeServer() {
+		fakeServer, err := startFakeServer(&config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "startFakeServer failed:", err)
+			os.Exit(1)
+		}
+		defer fakeServer.Close()
+	}
+
+	if err := config.resolveSSLMode(); err != nil {
+		fmt.Fprintln(os.Stderr, "resolveSSLMode failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "database config: %v\n", &config)
+
+	err = loadTestData(config)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "loadTestData failed:", err)
 		os.Exit(1)
 	}
 
+	connPoolConfig := config.pgxPoolConfig()
 	connPoolConfig.AfterConnect = func(conn *pgx.Conn) error {
 		_, err := conn.Prepare("selectPeopleJSON", selectPeopleJSONSQL)
 		if err != nil {
@@ -49,7 +135,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	pgxStdlib, err := openPgxStdlib(connPoolConfig.ConnConfig)
+	pgxStdlib, err := openPgxStdlib(connPoolConfig.ConnConfig, connPoolConfig.MaxConnections)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "openPgxNative failed:", err)
 		os.Exit(1)
@@ -60,7 +146,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	pq, err := openPq(connPoolConfig)
+	pq, err := openPq(config)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "openPq failed:", err)
 		os.Exit(1)
@@ -71,7 +157,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	pg, err := openPg(connPoolConfig)
+	pg, err := openPg(config)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "openPg failed:", err)
 		os.Exit(1)
@@ -82,6 +168,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	rawPool, err := openRaw(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "openRaw failed:", err)
+		os.Exit(1)
+	}
+	if _, err := rawPool.Prepare("selectPeopleJSON", selectPeopleJSONSQL); err != nil {
+		fmt.Fprintln(os.Stderr, "rawPool.Prepare failed:", err)
+		os.Exit(1)
+	}
+
 	http.HandleFunc("/people/pgx-native", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -140,6 +236,18 @@ func main() {
 		io.WriteString(w, json)
 	})
 
+	http.HandleFunc("/people/raw", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// The JSON is copied from the DataRow to w without decoding it.
+		err := rawPool.SelectValueTo(w, "selectPeopleJSON", rand.Int31n(10000))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+
 	fmt.Println("Starting Go DB Bench on localhost:8080")
 	err = http.ListenAndServe("localhost:8080", nil)
 	if err != nil {
@@ -148,42 +256,46 @@ func main() {
 	}
 }
 
-func extractConfig() (config pgx.ConnPoolConfig, err error) {
-	config.ConnConfig, err = pgx.ParseEnvLibpq()
-	if err != nil {
-		return config, err
-	}
-
-	if config.Host == "" {
-		config.Host = os.Getenv("POSTGRES_SERVICE_HOST")
-	}
-
-	port, _ := strconv.ParseUint(os.Getenv("POSTGRES_SERVICE_PORT"), 10, 16)
-	config.Port = uint16(port)
-	if config.User == "" {
-		config.User = "postgres"
-	}
+// useFakeServer reports whether GO_DB_BENCH_FAKE_PG asks for the in-process
+// fake PostgreSQL server instead of a real database.
+func useFakeServer() bool {
+	v, _ := strconv.ParseBool(os.Getenv("GO_DB_BENCH_FAKE_PG"))
+	return v
+}
 
-	if config.Password == "" {
-		config.Password = "password"
+// startFakeServer starts a fakepg server on a loopback port and points config
+// at it. The server requires MD5 authentication with config's password so the
+// drivers exercise the same startup path as against a real server. It accepts
+// TLS with a self-signed certificate unless config's sslmode is disable, so
+// the certificate cannot be verified with verify-ca or verify-full.
+func startFakeServer(config *dbConfig) (*fakepg.Server, error) {
+	serverConfig := fakepg.Config{Auth: fakepg.AuthMD5, Password: config.Password}
+	switch config.SSLMode {
+	case "disable":
+	case "verify-ca", "verify-full":
+		return nil, fmt.Errorf("sslmode %s cannot verify the fake server's self-signed certificate", config.SSLMode)
+	default:
+		cert, _, err := fakepg.SelfSignedCert("127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		serverConfig.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
 
-	if config.Database == "" {
-		config.Database = "postgres"
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("database config: %+v", config)
+	addr := server.Addr().(*net.TCPAddr)
+	config.Host = addr.IP.String()
+	config.Port = uint16(addr.Port)
 
-	config.TLSConfig = nil
-	config.UseFallbackTLS = false
-
-	config.MaxConnections = 10
-
-	return config, nil
+	return server, nil
 }
 
-func loadTestData(config pgx.ConnPoolConfig) error {
-	conn, err := pgx.Connect(config.ConnConfig)
+func loadTestData(config dbConfig) error {
+	conn, err := pgx.Connect(config.pgxConnConfig())
 	if err != nil {
 		return err
 	}
@@ -204,6 +316,21 @@ func loadTestData(config pgx.ConnPoolConfig) error {
 		return err
 	}
 
+	_, err = conn.Exec(personScratchCreateSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(personScratchInsertSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(personCopyCreateSQL)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -211,32 +338,96 @@ func openPgxNative(config pgx.ConnPoolConfig) (*pgx.ConnPool, error) {
 	return pgx.NewConnPool(config)
 }
 
-func openPgxStdlib(config pgx.ConnConfig) (*sql.DB, error) {
+func openPgxStdlib(config pgx.ConnConfig, maxConnections int) (*sql.DB, error) {
+	connector, err := pgxStdlibConnector(config)
+	if err != nil {
+		return nil, err
+	}
+	return openSQL(connector, maxConnections), nil
+}
+
+// pgxStdlibConnector returns a connector for pgx through database/sql. pgx's
+// stdlib package has no driver.Connector, so this one opens config by the
+// name it is registered under.
+func pgxStdlibConnector(config pgx.ConnConfig) (driver.Connector, error) {
 	driverConfig := stdlib.DriverConfig{ConnConfig: config}
 	stdlib.RegisterDriverConfig(&driverConfig)
-	return sql.Open("pgx", driverConfig.ConnectionString(""))
-}
 
-func openPq(config pgx.ConnPoolConfig) (*sql.DB, error) {
-	var options []string
-	options = append(options, fmt.Sprintf("host=%s", config.Host))
-	options = append(options, fmt.Sprintf("port=%d", config.Port))
-	options = append(options, fmt.Sprintf("user=%s", config.User))
-	options = append(options, fmt.Sprintf("dbname=%s", config.Database))
-	options = append(options, "sslmode=disable")
-	if config.Password != "" {
-		options = append(options, fmt.Sprintf("password=%s", config.Password))
+	// Opening a *sql.DB does not connect; it only finds the registered driver.
+	db, err := sql.Open("pgx", "")
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
+	return dsnConnector{driver: db.Driver(), name: driverConfig.ConnectionString("")}, nil
+}
+
+// dsnConnector is a driver.Connector for a driver that only opens connection
+// strings.
+type dsnConnector struct {
+	driver driver.Driver
+	name   string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.name) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+// openSQL opens a database/sql pool of connections from connector.
+func openSQL(connector driver.Connector, maxConnections int) *sql.DB {
+	db := sql.OpenDB(connector)
+	limitSQLConns(db, maxConnections)
+	return db
+}
 
-	return sql.Open("postgres", strings.Join(options, " "))
+// limitSQLConns gives a database/sql pool the same limit as the pgx and go-pg
+// pools so concurrent benchmarks compare pools of equal size.
+func limitSQLConns(db *sql.DB, maxConnections int) {
+	db.SetMaxOpenConns(maxConnections)
+	db.SetMaxIdleConns(maxConnections)
 }
 
-func openPg(config pgx.ConnPoolConfig) (*gopg.DB, error) {
-	option := &gopg.Options{
-		Addr:     config.Host + ":" + strconv.Itoa(int(config.Port)),
-		User:     config.User,
-		Database: config.Database,
-		Password: config.Password,
+func openPq(config dbConfig) (*sql.DB, error) {
+	connector, err := pqConnector(config)
+	if err != nil {
+		return nil, err
 	}
-	return gopg.Connect(option), nil
+	return openSQL(connector, config.MaxConnections), nil
+}
+
+// pqConnector returns a connector for pq. pq builds its own tls.Config from
+// the connection string, which cannot carry a TLS session cache, so with a
+// cache TLS is negotiated by a dialer instead and pq is told to disable it.
+func pqConnector(config dbConfig) (driver.Connector, error) {
+	if config.tlsConfig == nil || config.tlsConfig.ClientSessionCache == nil {
+		return pq.NewConnector(config.pqDSN())
+	}
+	plain := config
+	plain.SSLMode = "disable"
+	return pqTLSConnector{dsn: plain.pqDSN(), dialer: tlsDialer{config: config.tlsConfig}}, nil
+}
+
+type pqTLSConnector struct {
+	dsn    string
+	dialer tlsDialer
+}
+
+func (c pqTLSConnector) Connect(context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+func (c pqTLSConnector) Driver() driver.Driver { return &pq.Driver{} }
+
+func openRaw(config dbConfig) (*raw.ConnPool, error) {
+	return raw.NewConnPool(raw.ConnPoolConfig{
+		ConnConfig:     config.rawConnConfig(),
+		MaxConnections: config.MaxConnections,
+	})
+}
+
+func openRawStdlib(config dbConfig) *sql.DB {
+	return openSQL(raw.NewConnector(config.rawConnConfig()), config.MaxConnections)
+}
+
+func openPg(config dbConfig) (*gopg.DB, error) {
+	return gopg.Connect(config.pgOptions()), nil
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/hixichen/go_db_bench/histogram"
+)
+
+const mergeUsage = `Usage: db_bench merge [flags] file [file...]
+
+Combines result files, for example from runs on several machines or at
+different times, into one. Results for the same driver, scenario, payload size
+and concurrency are combined: their samples are pooled and their latency
+histograms are merged. Files may be JSON or CSV written by db_bench run or
+import, or go test -bench output.
+
+Flags:
+`
+
+// mergeCommand implements db_bench merge.
+func mergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("output", "-", "file to write results to, - for stdout")
+	format := fs.String("format", "json", "output format: json or csv")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, mergeUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("merge needs at least one result file")
+	}
+
+	reports := make([]*runReport, fs.NArg())
+	for i, path := range fs.Args() {
+		var err error
+		reports[i], err = readResultsFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeResultsFile(*output, mergeReports(reports), *format)
+}
+
+// mergeReports combines the results of reports. Results are kept in the order
+// they first appear.
+func mergeReports(reports []*runReport) *runReport {
+	merged := &runReport{Version: resultSchemaVersion}
+	index := make(map[resultKey]int)
+	for _, report := range reports {
+		for _, r := range report.Results {
+			k := r.key()
+			if i, ok := index[k]; ok {
+				merged.Results[i] = mergeResults(merged.Results[i], r)
+				continue
+			}
+			index[k] = len(merged.Results)
+			merged.Results = append(merged.Results, r)
+		}
+	}
+	return merged
+}
+
+// mergeResults combines two results of the same driver and scenario as if all
+// their samples had been taken in one run. Means are weighted by sample count
+// and the standard deviation is pooled. Percentiles are computed from the
+// merged histogram when both results have one, otherwise they are the
+// weighted mean of the percentiles and the larger max.
+func mergeResults(a, b benchResult) benchResult {
+	na, nb := float64(a.Samples), float64(b.Samples)
+	n := na + nb
+	if n == 0 {
+		return a
+	}
+
+	m := a
+	m.Samples = a.Samples + b.Samples
+	m.Iterations = a.Iterations + b.Iterations
+	m.NsPerOp = (na*a.NsPerOp + nb*b.NsPerOp) / n
+	if n > 1 {
+		ss := (na-1)*a.NsPerOpStddev*a.NsPerOpStddev + (nb-1)*b.NsPerOpStddev*b.NsPerOpStddev
+		if na > 0 {
+			ss += na * (a.NsPerOp - m.NsPerOp) * (a.NsPerOp - m.NsPerOp)
+		}
+		if nb > 0 {
+			ss += nb * (b.NsPerOp - m.NsPerOp) * (b.NsPerOp - m.NsPerOp)
+		}
+		m.NsPerOpStddev = math.Sqrt(math.Max(ss, 0) / (n - 1))
+	}
+	weighted := func(x, y int64) int64 {
+		return int64(math.Round((na*float64(x) + nb*float64(y)) / n))
+	}
+	m.BytesPerOp = weighted(a.BytesPerOp, b.BytesPerOp)
+	m.AllocsPerOp = weighted(a.AllocsPerOp, b.AllocsPerOp)
+
+	if a.Latency != nil && b.Latency != nil {
+		m.Latency = histogram.New(latencySignificantFigures)
+		m.Latency.Merge(a.Latency)
+		m.Latency.Merge(b.Latency)
+		m.latencyPercentiles = percentilesOf(m.Latency)
+		return m
+	}
+
+	m.Latency = nil
+	m.P50Ns = weighted(a.P50Ns, b.P50Ns)
+	m.P90Ns = weighted(a.P90Ns, b.P90Ns)
+	m.P99Ns = weighted(a.P99Ns, b.P99Ns)
+	m.P999Ns = weighted(a.P999Ns, b.P999Ns)
+	if b.MaxNs > m.MaxNs {
+		m.MaxNs = b.MaxNs
+	}
+	return m
+}
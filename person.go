@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var selectPersonNameSQL = `select first_name from person where id=$1`
+var selectPersonNameSQLQuestionMark = `select first_name from person where id=?`
+
+var selectPersonSQL = `
+select id, first_name, last_name, sex, birth_date, weight, height, update_time
+from person
+where id=$1`
+var selectPersonSQLQuestionMark = `
+select id, first_name, last_name, sex, birth_date, weight, height, update_time
+from person
+where id=?`
+
+var selectMultiplePeopleSQL = `
+select id, first_name, last_name, sex, birth_date, weight, height, update_time
+from person
+where id between $1 and $1 + 24`
+var selectMultiplePeopleSQLQuestionMark = `
+select id, first_name, last_name, sex, birth_date, weight, height, update_time
+from person
+where id between ? and ? + 24`
+
+var selectLargeTextSQL = `select repeat('*', $1)`
+var selectLargeTextSQLQuestionMark = `select repeat('*', ?)`
+
+// person_scratch has the schema and rows of person. The write scenarios
+// modify it instead of person so they do not change what the read scenarios
+// select.
+var personScratchCreateSQL = `
+drop table if exists person_scratch;
+
+create table person_scratch(
+  id serial primary key,
+  first_name varchar not null,
+  last_name varchar not null,
+  sex varchar not null,
+  birth_date date not null,
+  weight int not null,
+  height int not null,
+  update_time timestamptz not null
+);
+`
+
+var personScratchInsertSQL = strings.Replace(PersonInsertSQL, "insert into person(", "insert into person_scratch(", 1)
+
+var insertPersonSQL = `
+insert into person_scratch(first_name, last_name, sex, birth_date, weight, height, update_time)
+values ($1, $2, $3, $4, $5, $6, $7)`
+
+var insertPersonReturningSQL = insertPersonSQL + `
+returning id`
+
+var updatePersonSQL = `update person_scratch set weight=$2, update_time=$3 where id=$1`
+
+var deletePersonSQL = `delete from person_scratch where id=$1`
+
+// The MySQL schema of person and person_scratch and the write statements with
+// ? placeholders, for the mysql driver. The data is loaded with PersonInsertSQL
+// and personScratchInsertSQL, which MySQL understands as they are.
+var mysqlPersonCreateSQL = `
+drop table if exists person;
+
+create table person(
+  id int auto_increment primary key,
+  first_name varchar(255) not null,
+  last_name varchar(255) not null,
+  sex varchar(255) not null,
+  birth_date date not null,
+  weight int not null,
+  height int not null,
+  update_time datetime(6) not null
+);
+`
+
+var mysqlPersonScratchCreateSQL = strings.Replace(mysqlPersonCreateSQL, "person", "person_scratch", 2)
+
+var insertPersonSQLQuestionMark = `
+insert into person_scratch(first_name, last_name, sex, birth_date, weight, height, update_time)
+values (?, ?, ?, ?, ?, ?, ?)`
+
+var updatePersonSQLQuestionMark = `update person_scratch set weight=?, update_time=? where id=?`
+
+var deletePersonSQLQuestionMark = `delete from person_scratch where id=?`
+
+// sqlitePersonCreateSQL is PersonCreateSQL translated to SQLite, for the
+// sqlite driver. An integer primary key is the rowid, which is assigned as a
+// serial is, and the declared type timestamp lets the driver decode
+// update_time. PersonInsertSQL needs no translation.
+var sqlitePersonCreateSQL = strings.NewReplacer(
+	"serial", "integer",
+	"timestamptz", "timestamp",
+).Replace(PersonCreateSQL)
+
+// SQLite has no repeat, but its printf repeats a %c to the given precision.
+var sqliteSelectLargeTextSQL = `select printf('%.*c', ?, '*')`
+
+// person_copy receives the person dataset in the copy-from scenarios. It has
+// no primary key so the ids of person can be copied into it.
+var personCopyCreateSQL = `
+drop table if exists person_copy;
+
+create table person_copy(
+  id int not null,
+  first_name varchar not null,
+  last_name varchar not null,
+  sex varchar not null,
+  birth_date date not null,
+  weight int not null,
+  height int not null,
+  update_time timestamptz not null
+);
+`
+
+var personCopyColumns = []string{"id", "first_name", "last_name", "sex", "birth_date", "weight", "height", "update_time"}
+
+var truncatePersonCopySQL = `truncate person_copy`
+
+var copyPeopleToTextSQL = `copy person to stdout`
+var copyPeopleToBinarySQL = `copy person to stdout (format binary)`
+
+type person struct {
+	TableName  struct{} `sql:"person"` // custom table name
+	Id         int32
+	FirstName  string    `sql:"first_name"`
+	LastName   string    `sql:"last_name"`
+	Sex        string    `sql:"sex"`
+	BirthDate  time.Time `sql:"birth_date"`
+	Weight     int32     `sql:"weight"`
+	Height     int32     `sql:"height"`
+	UpdateTime time.Time `sql:"update_time"`
+}
+
+// personScratch is person in person_scratch, for go-pg models.
+type personScratch struct {
+	TableName  struct{} `sql:"person_scratch"`
+	Id         int32
+	FirstName  string    `sql:"first_name"`
+	LastName   string    `sql:"last_name"`
+	Sex        string    `sql:"sex"`
+	BirthDate  time.Time `sql:"birth_date"`
+	Weight     int32     `sql:"weight"`
+	Height     int32     `sql:"height"`
+	UpdateTime time.Time `sql:"update_time"`
+}
+
+// newPerson returns the person inserted by iteration i of the write
+// scenarios.
+func newPerson(i int) person {
+	return person{
+		FirstName:  "Scratch",
+		LastName:   "Person",
+		Sex:        "female",
+		BirthDate:  time.Date(1990, 1, 1+i%365, 0, 0, 0, 0, time.UTC),
+		Weight:     int32(50 + i%100),
+		Height:     int32(150 + i%50),
+		UpdateTime: time.Date(2019, 1, 1, 0, 0, i%86400, 0, time.UTC),
+	}
+}
+
+// deletablePeopleSQL returns an insert of n people into person_scratch with
+// ids from first down to first-n+1.
+func deletablePeopleSQL(first int32, n int) string {
+	var sb strings.Builder
+	sb.WriteString("insert into person_scratch(id, first_name, last_name, sex, birth_date, weight, height, update_time) values ")
+	for j := 0; j < n; j++ {
+		if j > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "(%d, 'Scratch', 'Person', 'female', '1990-01-01', 50, 150, '2019-01-01 00:00:00')", first-int32(j))
+	}
+	return sb.String()
+}
+
+type personBytes struct {
+	Id         int32
+	FirstName  []byte
+	LastName   []byte
+	Sex        []byte
+	BirthDate  time.Time
+	Weight     int32
+	Height     int32
+	UpdateTime time.Time
+}
+
+type People struct {
+	tableName struct{} `pg:",discard_unknown_columns"`
+	C         []person
+}
+
+func (people *People) NewRecord() interface{} {
+	people.C = append(people.C, person{})
+	return &people.C[len(people.C)-1]
+}
+
+// checkPerson returns an error if any column of p was not filled in by the
+// driver.
+func checkPerson(p person) error {
+	if p.Id == 0 {
+		return errors.New("id was 0")
+	}
+	if len(p.FirstName) == 0 {
+		return errors.New("FirstName was empty")
+	}
+	if len(p.LastName) == 0 {
+		return errors.New("LastName was empty")
+	}
+	if len(p.Sex) == 0 {
+		return errors.New("Sex was empty")
+	}
+	var zeroTime time.Time
+	if p.BirthDate == zeroTime {
+		return errors.New("BirthDate was zero time")
+	}
+	if p.Weight == 0 {
+		return errors.New("Weight was 0")
+	}
+	if p.Height == 0 {
+		return errors.New("Height was 0")
+	}
+	if p.UpdateTime == zeroTime {
+		return errors.New("UpdateTime was zero time")
+	}
+	return nil
+}
+
+// checkPersonBytes is checkPerson for personBytes. It is kept separate so
+// checking does not allocate inside the timed loop.
+func checkPersonBytes(p personBytes) error {
+	if p.Id == 0 {
+		return errors.New("id was 0")
+	}
+	if len(p.FirstName) == 0 {
+		return errors.New("FirstName was empty")
+	}
+	if len(p.LastName) == 0 {
+		return errors.New("LastName was empty")
+	}
+	if len(p.Sex) == 0 {
+		return errors.New("Sex was empty")
+	}
+	var zeroTime time.Time
+	if p.BirthDate == zeroTime {
+		return errors.New("BirthDate was zero time")
+	}
+	if p.Weight == 0 {
+		return errors.New("Weight was 0")
+	}
+	if p.Height == 0 {
+		return errors.New("Height was 0")
+	}
+	if p.UpdateTime == zeroTime {
+		return errors.New("UpdateTime was zero time")
+	}
+	return nil
+}
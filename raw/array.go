@@ -0,0 +1,513 @@
+package raw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ArrayDimension is the length and lower bound of one dimension of an Array.
+type ArrayDimension struct {
+	Length     int32
+	LowerBound int32
+}
+
+// Array is a PostgreSQL array with any number of dimensions. Elements holds
+// the elements in row-major order, with nil for NULL. An empty array has no
+// dimensions.
+//
+// Arrays of a type registered with RegisterArrayType are decoded into a slice
+// of the element type, such as []int32 for int4[], when they have one
+// dimension starting at 1 and no NULL elements, and into an Array otherwise.
+type Array struct {
+	Dimensions []ArrayDimension
+	Elements   []interface{}
+}
+
+// RegisterArrayType adds a ValueTranscoder for arrayOid, an array of
+// elementOid, to ValueTranscoders. It is built on the transcoder of
+// elementOid, which must be registered first. Arrays are sent and received in
+// binary format when the element transcoder supports it, and in text format
+// otherwise.
+//
+// elementType is the Go type the element transcoder decodes to. It is used
+// to decode one-dimensional arrays into slices; if it is nil, every array is
+// decoded into an Array.
+//
+// Values encoded as arrayOid may be an Array or a slice of any element
+// value the element transcoder accepts, with nested slices for more
+// dimensions and nil for NULL elements.
+func RegisterArrayType(arrayOid, elementOid Oid, elementType reflect.Type) {
+	c := &arrayCodec{elementOid: elementOid, elementType: elementType}
+	c.elem = ValueTranscoders[elementOid]
+	if c.elem == nil {
+		c.elem = defaultTranscoder
+	}
+	if elementType != nil {
+		c.sliceType = reflect.SliceOf(elementType)
+	}
+
+	vt := &ValueTranscoder{DecodeText: c.decodeText, EncodeTo: c.encodeText}
+	if c.elem.DecodeBinary != nil {
+		vt.DecodeBinary = c.decodeBinary
+	}
+	// Text is encoded in text format, which is the same as its binary format.
+	if c.elem.EncodeFormat == 1 || c.elem == ValueTranscoders[Oid(25)] {
+		vt.EncodeTo = c.encodeBinary
+		vt.EncodeFormat = 1
+	}
+	ValueTranscoders[arrayOid] = vt
+	arrayCodecs[arrayOid] = c
+}
+
+// arrayCodecs holds the codecs of the registered array types by array oid.
+var arrayCodecs = make(map[Oid]*arrayCodec)
+
+type arrayCodec struct {
+	elementOid  Oid
+	elem        *ValueTranscoder
+	elementType reflect.Type
+	sliceType   reflect.Type
+}
+
+func (c *arrayCodec) decodeBinary(mr *MessageReader, size int32) interface{} {
+	buf := (*bytes.Buffer)(mr)
+	end := buf.Len() - int(size)
+	fail := func(format string, a ...interface{}) interface{} {
+		// Skip the rest of the array so the following values can be read.
+		if n := buf.Len() - end; n > 0 {
+			buf.Next(n)
+		}
+		return ProtocolError(fmt.Sprintf(format, a...))
+	}
+
+	if size < 12 {
+		return fail("Received an invalid size for an array: %d", size)
+	}
+	ndim := mr.ReadInt32()
+	mr.ReadInt32() // whether there are NULL elements
+	if oid := mr.ReadOid(); oid != c.elementOid {
+		return fail("Received an array of oid %d, expected oid %d", oid, c.elementOid)
+	}
+	if ndim < 0 || int(ndim)*8 > buf.Len()-end {
+		return fail("Received an invalid number of array dimensions: %d", ndim)
+	}
+
+	var a Array
+	n := 0
+	if ndim > 0 {
+		a.Dimensions = make([]ArrayDimension, ndim)
+		n = 1
+		for i := range a.Dimensions {
+			a.Dimensions[i] = ArrayDimension{Length: mr.ReadInt32(), LowerBound: mr.ReadInt32()}
+			n *= int(a.Dimensions[i].Length)
+		}
+	}
+	if n < 0 || n*4 > buf.Len()-end {
+		return fail("Received an invalid array length: %d", n)
+	}
+
+	a.Elements = make([]interface{}, n)
+	for i := range a.Elements {
+		if buf.Len()-end < 4 {
+			return fail("Received a truncated array")
+		}
+		elementSize := mr.ReadInt32()
+		if elementSize == -1 {
+			continue
+		}
+		if elementSize < 0 || int(elementSize) > buf.Len()-end-4*(n-i-1) {
+			return fail("Received an invalid array element size: %d", elementSize)
+		}
+		v := c.elem.DecodeBinary(mr, elementSize)
+		if err, ok := v.(ProtocolError); ok {
+			return fail("Can't decode array element: %v", err)
+		}
+		a.Elements[i] = v
+	}
+	return c.result(a)
+}
+
+func (c *arrayCodec) decodeText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	p := &arrayTextParser{s: s}
+	if err := p.parse(); err != nil {
+		return ProtocolError(fmt.Sprintf("Can't decode array: %v - %v", err, s))
+	}
+
+	a := Array{Dimensions: p.dims, Elements: make([]interface{}, len(p.elements))}
+	for i, e := range p.elements {
+		if p.nulls[i] {
+			continue
+		}
+		v := c.elem.DecodeText((*MessageReader)(bytes.NewBufferString(e)), int32(len(e)))
+		if err, ok := v.(ProtocolError); ok {
+			return ProtocolError(fmt.Sprintf("Can't decode array element: %v - %v", err, s))
+		}
+		a.Elements[i] = v
+	}
+	return c.result(a)
+}
+
+// result returns a as a slice of the element type if it can be one.
+func (c *arrayCodec) result(a Array) interface{} {
+	if c.sliceType == nil || len(a.Dimensions) > 1 || (len(a.Dimensions) == 1 && a.Dimensions[0].LowerBound != 1) {
+		return a
+	}
+	s := reflect.MakeSlice(c.sliceType, len(a.Elements), len(a.Elements))
+	for i, e := range a.Elements {
+		if e == nil || reflect.TypeOf(e) != c.elementType {
+			return a
+		}
+		s.Index(i).Set(reflect.ValueOf(e))
+	}
+	return s.Interface()
+}
+
+func (c *arrayCodec) encodeBinary(w *WriteBuf, value interface{}) error {
+	a, err := toArray(value)
+	if err != nil {
+		return err
+	}
+
+	sizeIdx := len(w.buf)
+	w.WriteInt32(0)
+	w.WriteInt32(int32(len(a.Dimensions)))
+	hasNull := int32(0)
+	for _, e := range a.Elements {
+		if e == nil {
+			hasNull = 1
+			break
+		}
+	}
+	w.WriteInt32(hasNull)
+	w.WriteInt32(int32(c.elementOid))
+	for _, d := range a.Dimensions {
+		w.WriteInt32(d.Length)
+		w.WriteInt32(d.LowerBound)
+	}
+	for _, e := range a.Elements {
+		if e == nil {
+			w.WriteInt32(-1)
+			continue
+		}
+		if err := c.elem.EncodeTo(w, e); err != nil {
+			return fmt.Errorf("Failed to encode array element: %v", err)
+		}
+	}
+	binary.BigEndian.PutUint32(w.buf[sizeIdx:], uint32(len(w.buf)-sizeIdx-4))
+	return nil
+}
+
+func (c *arrayCodec) encodeText(w *WriteBuf, value interface{}) error {
+	a, err := toArray(value)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+	for _, d := range a.Dimensions {
+		if d.LowerBound != 1 {
+			for _, d := range a.Dimensions {
+				b = append(b, '[')
+				b = strconv.AppendInt(b, int64(d.LowerBound), 10)
+				b = append(b, ':')
+				b = strconv.AppendInt(b, int64(d.LowerBound+d.Length-1), 10)
+				b = append(b, ']')
+			}
+			b = append(b, '=')
+			break
+		}
+	}
+	if len(a.Dimensions) == 0 {
+		b = append(b, "{}"...)
+		return encodeText(w, string(b))
+	}
+
+	elementBuf := &WriteBuf{}
+	i := 0
+	var appendDim func(dim int) error
+	appendDim = func(dim int) error {
+		b = append(b, '{')
+		for j := int32(0); j < a.Dimensions[dim].Length; j++ {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			if dim < len(a.Dimensions)-1 {
+				if err := appendDim(dim + 1); err != nil {
+					return err
+				}
+				continue
+			}
+			e := a.Elements[i]
+			i++
+			if e == nil {
+				b = append(b, "NULL"...)
+				continue
+			}
+			elementBuf.buf = elementBuf.buf[:0]
+			if err := c.elem.EncodeTo(elementBuf, e); err != nil {
+				return fmt.Errorf("Failed to encode array element: %v", err)
+			}
+			b = appendArrayElementText(b, elementBuf.buf[4:])
+		}
+		b = append(b, '}')
+		return nil
+	}
+	if err := appendDim(0); err != nil {
+		return err
+	}
+	return encodeText(w, string(b))
+}
+
+// appendArrayElementText appends s to b, quoted if it would otherwise be read
+// as something else.
+func appendArrayElementText(b, s []byte) []byte {
+	if len(s) > 0 && !bytes.EqualFold(s, []byte("NULL")) && !bytes.ContainsAny(s, "{},\"\\ \t\n\r") {
+		return append(b, s...)
+	}
+	b = append(b, '"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b = append(b, '\\')
+		}
+		b = append(b, c)
+	}
+	return append(b, '"')
+}
+
+// toArray converts an Array or a slice, possibly of slices, to an Array.
+func toArray(value interface{}) (Array, error) {
+	if a, ok := value.(Array); ok {
+		n := 0
+		if len(a.Dimensions) > 0 {
+			n = 1
+			for _, d := range a.Dimensions {
+				n *= int(d.Length)
+			}
+		}
+		if n != len(a.Elements) {
+			return Array{}, fmt.Errorf("Array has %d elements, expected %d for its dimensions", len(a.Elements), n)
+		}
+		return a, nil
+	}
+
+	v := reflect.ValueOf(value)
+	if !isArrayDimension(v) {
+		return Array{}, fmt.Errorf("Expected slice or Array, received %T", value)
+	}
+	var a Array
+	if err := flattenArray(v, 0, &a); err != nil {
+		return Array{}, fmt.Errorf("Failed to encode %T: %v", value, err)
+	}
+	if len(a.Elements) == 0 {
+		a.Dimensions = nil
+	}
+	return a, nil
+}
+
+// isArrayDimension reports whether v is a slice or array that makes up a
+// dimension. A []byte is an element.
+func isArrayDimension(v reflect.Value) bool {
+	k := v.Kind()
+	return (k == reflect.Slice || k == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// flattenArray appends the elements of v, dimension dim of a, to a.
+func flattenArray(v reflect.Value, dim int, a *Array) error {
+	n := int32(v.Len())
+	if dim == len(a.Dimensions) {
+		if len(a.Elements) > 0 {
+			return fmt.Errorf("mixed elements and sub-arrays")
+		}
+		a.Dimensions = append(a.Dimensions, ArrayDimension{Length: n, LowerBound: 1})
+	} else if a.Dimensions[dim].Length != n {
+		return fmt.Errorf("sub-arrays of different lengths")
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if e.Kind() == reflect.Interface {
+			if e.IsNil() {
+				if dim != len(a.Dimensions)-1 {
+					return fmt.Errorf("mixed elements and sub-arrays")
+				}
+				a.Elements = append(a.Elements, nil)
+				continue
+			}
+			e = e.Elem()
+		}
+		if isArrayDimension(e) {
+			if err := flattenArray(e, dim+1, a); err != nil {
+				return err
+			}
+			continue
+		}
+		if dim != len(a.Dimensions)-1 {
+			return fmt.Errorf("mixed elements and sub-arrays")
+		}
+		a.Elements = append(a.Elements, e.Interface())
+	}
+	return nil
+}
+
+// arrayTextParser splits the text format of an array, such as
+// [0:1]={{1,2},{3,NULL}}, into its dimensions and element texts.
+type arrayTextParser struct {
+	s        string
+	pos      int
+	dims     []ArrayDimension
+	elements []string
+	nulls    []bool
+}
+
+func (p *arrayTextParser) parse() error {
+	var bounds []ArrayDimension
+	if strings.HasPrefix(p.s, "[") {
+		i := strings.IndexByte(p.s, '=')
+		if i < 0 {
+			return fmt.Errorf("missing = after dimensions")
+		}
+		for _, r := range strings.Split(strings.Trim(p.s[:i], "[]"), "][") {
+			parts := strings.Split(r, ":")
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid dimensions")
+			}
+			lower, err1 := strconv.ParseInt(parts[0], 10, 32)
+			upper, err2 := strconv.ParseInt(parts[1], 10, 32)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("invalid dimensions")
+			}
+			bounds = append(bounds, ArrayDimension{Length: int32(upper - lower + 1), LowerBound: int32(lower)})
+		}
+		p.pos = i + 1
+	}
+
+	if err := p.parseDim(0); err != nil {
+		return err
+	}
+	if p.pos != len(p.s) {
+		return fmt.Errorf("unexpected text after array")
+	}
+	if len(p.elements) == 0 {
+		p.dims = nil
+		return nil
+	}
+	if bounds != nil {
+		if len(bounds) != len(p.dims) {
+			return fmt.Errorf("dimensions do not match array")
+		}
+		for i := range bounds {
+			if bounds[i].Length != p.dims[i].Length {
+				return fmt.Errorf("dimensions do not match array")
+			}
+		}
+		p.dims = bounds
+	}
+	return nil
+}
+
+func (p *arrayTextParser) parseDim(dim int) error {
+	if p.pos >= len(p.s) || p.s[p.pos] != '{' {
+		return fmt.Errorf("expected {")
+	}
+	p.pos++
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		if dim > 0 {
+			return fmt.Errorf("empty sub-array")
+		}
+		return nil
+	}
+
+	if dim == len(p.dims) {
+		if len(p.elements) > 0 {
+			return fmt.Errorf("mixed elements and sub-arrays")
+		}
+		// The length is set when the first sub-array of this dimension ends.
+		p.dims = append(p.dims, ArrayDimension{Length: -1, LowerBound: 1})
+	}
+
+	var n int32
+	for {
+		if p.pos < len(p.s) && p.s[p.pos] == '{' {
+			if err := p.parseDim(dim + 1); err != nil {
+				return err
+			}
+		} else {
+			if dim+1 < len(p.dims) {
+				return fmt.Errorf("mixed elements and sub-arrays")
+			}
+			if err := p.parseElement(); err != nil {
+				return err
+			}
+		}
+		n++
+
+		if p.pos >= len(p.s) {
+			return fmt.Errorf("unexpected end of array")
+		}
+		c := p.s[p.pos]
+		p.pos++
+		if c == '}' {
+			break
+		}
+		if c != ',' {
+			return fmt.Errorf("expected , or }")
+		}
+	}
+
+	switch {
+	case p.dims[dim].Length == -1:
+		p.dims[dim].Length = n
+	case p.dims[dim].Length != n:
+		return fmt.Errorf("sub-arrays of different lengths")
+	}
+	return nil
+}
+
+func (p *arrayTextParser) parseElement() error {
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		var b []byte
+		for p.pos++; ; p.pos++ {
+			if p.pos >= len(p.s) {
+				return fmt.Errorf("unterminated quoted element")
+			}
+			c := p.s[p.pos]
+			if c == '"' {
+				p.pos++
+				break
+			}
+			if c == '\\' {
+				p.pos++
+				if p.pos >= len(p.s) {
+					return fmt.Errorf("unterminated quoted element")
+				}
+				c = p.s[p.pos]
+			}
+			b = append(b, c)
+		}
+		p.elements = append(p.elements, string(b))
+		p.nulls = append(p.nulls, false)
+		return nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != ',' && p.s[p.pos] != '}' {
+		p.pos++
+	}
+	e := p.s[start:p.pos]
+	if e == "" {
+		return fmt.Errorf("empty element")
+	}
+	null := e == "NULL"
+	if null {
+		e = ""
+	}
+	p.elements = append(p.elements, e)
+	p.nulls = append(p.nulls, null)
+	return nil
+}
@@ -0,0 +1,104 @@
+package raw_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestArrays(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Execute("create table arrays(id int4 not null, ints int4[], texts text[], floats float8[], bools bool[], times timestamptz[], grid int4[][])"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("insertArrays", "insert into arrays(id, ints, texts, floats, bools, times, grid) values ($1, $2, $3, $4, $5, $6, $7)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("selectArrays", "select ints, texts, floats, bools, times, grid from arrays where id = $1"); err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Date(2020, 2, 29, 12, 30, 0, 123000, time.UTC)
+	want := []interface{}{
+		[]int32{1, 2, 3},
+		raw.Array{
+			Dimensions: []raw.ArrayDimension{{Length: 4, LowerBound: 1}},
+			Elements:   []interface{}{"a b", nil, `q"\`, ""},
+		},
+		[]float64{1.5, -2},
+		[]bool{true, false},
+		[]time.Time{when},
+		raw.Array{
+			Dimensions: []raw.ArrayDimension{{Length: 2, LowerBound: 1}, {Length: 2, LowerBound: 1}},
+			Elements:   []interface{}{int32(1), int32(2), nil, int32(4)},
+		},
+	}
+	if _, err := conn.Execute("insertArrays", int32(1), []int32{1, 2, 3}, []interface{}{"a b", nil, `q"\`, ""}, []float64{1.5, -2}, []bool{true, false}, []time.Time{when}, [][]interface{}{{1, 2}, {nil, 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, got []interface{}) {
+		if times, ok := got[4].([]time.Time); ok && len(times) == 1 && times[0].Equal(when) {
+			got[4] = []time.Time{when}
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Errorf("%s: column %d: got %#v, want %#v", name, i, got[i], want[i])
+			}
+		}
+	}
+	readRow := func(name string, sql string, arguments ...interface{}) {
+		var got []interface{}
+		err := conn.SelectFunc(sql, func(r *raw.DataRowReader) error {
+			for range r.FieldDescriptions {
+				got = append(got, r.ReadValue())
+			}
+			return nil
+		}, arguments...)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		check(name, got)
+	}
+	readRow("binary", "selectArrays", int32(1))
+	readRow("text", "select ints, texts, floats, bools, times, grid from arrays where id = 1")
+
+	bounded := raw.Array{
+		Dimensions: []raw.ArrayDimension{{Length: 2, LowerBound: 0}},
+		Elements:   []interface{}{int32(7), int32(8)},
+	}
+	if _, err := conn.Prepare("echoInts", "select $1::int4[]"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		arg, want interface{}
+	}{
+		{[]int32{}, []int32{}},
+		{bounded, bounded},
+		{[][][]int64{{{1}, {2}}, {{3}, {4}}}, raw.Array{
+			Dimensions: []raw.ArrayDimension{{Length: 2, LowerBound: 1}, {Length: 2, LowerBound: 1}, {Length: 1, LowerBound: 1}},
+			Elements:   []interface{}{int32(1), int32(2), int32(3), int32(4)},
+		}},
+	} {
+		if v, err := conn.SelectValue("echoInts", tt.arg); err != nil || !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("echo %v: got %#v, %v, want %#v", tt.arg, v, err, tt.want)
+		}
+	}
+
+	if v, err := conn.SelectValue("select '[0:1]={7,8}'::int4[]"); err != nil || !reflect.DeepEqual(v, bounded) {
+		t.Errorf("bounds in text: got %#v, %v", v, err)
+	}
+	if _, err := conn.SelectValue("echoInts", [][]int32{{1, 2}, {3}}); err == nil {
+		t.Error("expected error for sub-arrays of different lengths")
+	}
+}
@@ -0,0 +1,226 @@
+package raw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Batch pipelines executions of prepared statements. Queue adds a Bind and
+// Execute for each query, Send writes them to the server with a single Sync
+// and ResultsFunc reads the results in the order the queries were queued.
+//
+// If a query fails the server skips the rest of the batch, so the results of
+// the later queries return the same error.
+type Batch struct {
+	conn      *Conn
+	buf       []byte // kept across batches to avoid reallocating
+	wbuf      WriteBuf
+	stmts     []*PreparedStatement
+	sent      bool
+	startTime time.Time
+	resultIdx int   // index in stmts of the next result
+	done      bool  // ReadyForQuery has been received
+	err       error // first error of the batch
+
+	ctx    context.Context   // from SendContext, watched until Close
+	finish func(error) error // stops watching ctx
+}
+
+// BeginBatch returns an empty batch. The batch belongs to c and is reused by
+// the next BeginBatch, so the previous batch must be closed first.
+func (c *Conn) BeginBatch() *Batch {
+	b := &c.batch
+	*b = Batch{conn: c, buf: b.buf[:0], stmts: b.stmts[:0]}
+	return b
+}
+
+// Queue adds an execution of the prepared statement name with arguments to
+// the batch.
+func (b *Batch) Queue(name string, arguments ...interface{}) error {
+	if b.sent {
+		return errors.New("Cannot queue a query in a batch that has been sent")
+	}
+	ps, present := b.conn.preparedStatements[name]
+	if !present {
+		return fmt.Errorf("Batch can only queue prepared statements, \"%v\" is not prepared", name)
+	}
+	if len(ps.ParameterOids) != len(arguments) {
+		return fmt.Errorf("Prepared statement \"%v\" requires %d parameters, but %d were provided", ps.Name, len(ps.ParameterOids), len(arguments))
+	}
+
+	if len(b.stmts) == 0 {
+		b.wbuf = *newWriteBuf(b.buf, 'B')
+	} else {
+		b.wbuf.startMsg('B')
+	}
+	if err := writeBindExecute(&b.wbuf, ps, arguments); err != nil {
+		return err
+	}
+	b.stmts = append(b.stmts, ps)
+	return nil
+}
+
+// Send writes the queued queries followed by a Sync to the server.
+func (b *Batch) Send() error {
+	return b.SendContext(context.Background())
+}
+
+// SendContext is Send with a context that applies until Close. If ctx is
+// done first, the batch is cancelled with a CancelRequest and ResultsFunc and
+// Close return ctx.Err(); Close still reads the rest of the response so the
+// connection can be used again.
+func (b *Batch) SendContext(ctx context.Context) error {
+	if b.sent {
+		return errors.New("Batch has already been sent")
+	}
+	finish, err := b.conn.watchContext(ctx)
+	if err != nil {
+		return err
+	}
+	b.ctx, b.finish = ctx, finish
+
+	if len(b.stmts) == 0 {
+		b.wbuf = *newWriteBuf(b.buf, 'S')
+	} else {
+		b.wbuf.startMsg('S')
+	}
+	b.wbuf.closeMsg()
+	b.buf = b.wbuf.buf
+
+	b.sent = true
+	b.startTime = time.Now()
+	if _, err := b.conn.conn.Write(b.buf); err != nil {
+		b.conn.die(err)
+		b.done = true
+		b.err = err
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// ResultsFunc reads the result of the next query in the batch, calling
+// onDataRow for each row as SelectFunc does, and returns its CommandTag.
+func (b *Batch) ResultsFunc(onDataRow func(*DataRowReader) error) (CommandTag, error) {
+	commandTag, err := b.resultsFunc(onDataRow)
+	if b.ctx != nil {
+		err = contextError(b.ctx, err)
+	}
+	return commandTag, err
+}
+
+func (b *Batch) resultsFunc(onDataRow func(*DataRowReader) error) (CommandTag, error) {
+	if !b.sent {
+		return "", errors.New("Batch has not been sent")
+	}
+	if b.resultIdx == len(b.stmts) {
+		return "", errors.New("No more results in batch")
+	}
+	fields := b.stmts[b.resultIdx].FieldDescriptions
+	b.resultIdx++
+	if b.done {
+		if b.err == nil {
+			return "", ProtocolError("Batch ended before all results were received")
+		}
+		return "", b.err
+	}
+
+	c := b.conn
+	var softErr error
+	for {
+		t, r, err := c.rxMsg()
+		if err != nil {
+			b.done = true
+			b.err = err
+			return "", err
+		}
+
+		switch t {
+		case bindComplete:
+		case dataRow:
+			if softErr == nil {
+				c.drr.reset(r, fields)
+
+				fieldCount := int(r.ReadInt16())
+				if fieldCount != len(fields) {
+					softErr = ProtocolError(fmt.Sprintf("Row description field count (%v) and data row field count (%v) do not match", len(fields), fieldCount))
+				}
+				if softErr == nil {
+					softErr = onDataRow(&c.drr)
+					if softErr == nil {
+						softErr = c.drr.err
+					}
+				}
+			}
+		case commandComplete:
+			return CommandTag(c.rxCommandComplete(r)), softErr
+		case readyForQuery:
+			c.rxReadyForQuery(r)
+			b.done = true
+			if b.err == nil {
+				b.err = ProtocolError("Batch ended before all results were received")
+			}
+			return "", b.err
+		default:
+			if e := c.processContextFreeMsg(t, r); e != nil {
+				// The server skips the rest of the batch up to the Sync.
+				b.err = e
+				b.drain()
+				return "", e
+			}
+		}
+	}
+}
+
+// Close reads the results that have not been read and ends the batch. It
+// returns the first error of the batch. A sent batch must be closed before c
+// is used again.
+func (b *Batch) Close() (err error) {
+	if !b.sent {
+		return nil
+	}
+
+	defer func() {
+		if err == nil {
+			endTime := time.Now()
+			b.conn.logger.Info("Batch", "queries", len(b.stmts), "time", endTime.Sub(b.startTime))
+		} else {
+			b.conn.logger.Error("Batch", "queries", len(b.stmts), "error", err)
+		}
+	}()
+
+	b.drain()
+	b.resultIdx = len(b.stmts)
+	if b.finish != nil {
+		b.err = b.finish(b.err)
+		b.finish = nil
+	}
+	return b.err
+}
+
+// drain reads until ReadyForQuery, keeping the first error in b.err.
+func (b *Batch) drain() {
+	c := b.conn
+	for !b.done {
+		t, r, err := c.rxMsg()
+		if err != nil {
+			b.done = true
+			if b.err == nil {
+				b.err = err
+			}
+			return
+		}
+
+		switch t {
+		case bindComplete, dataRow, commandComplete:
+		case readyForQuery:
+			c.rxReadyForQuery(r)
+			b.done = true
+		default:
+			if e := c.processContextFreeMsg(t, r); e != nil && b.err == nil {
+				b.err = e
+			}
+		}
+	}
+}
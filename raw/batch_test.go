@@ -0,0 +1,124 @@
+package raw_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestBatch(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Execute("create table person(id int4 primary key, name varchar not null)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("insertPerson", "insert into person(id, name) values ($1, $2)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("selectName", "select name from person where id = $1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	readNames := func(r *raw.DataRowReader) error {
+		names = append(names, string(r.ReadBytesUnsafe()))
+		return nil
+	}
+	expectUsable := func(name string) {
+		if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+			t.Errorf("%s: connection not usable after the batch: %v, %v", name, v, err)
+		}
+	}
+
+	batch := conn.BeginBatch()
+	if err := batch.Queue("select 1"); err == nil {
+		t.Error("expected an error queueing a statement that is not prepared")
+	}
+	batch.Queue("insertPerson", int32(1), "Adam")
+	batch.Queue("selectName", int32(1))
+	batch.Queue("selectName", int32(2))
+	if err := batch.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Queue("selectName", int32(1)); err == nil {
+		t.Error("expected an error queueing in a sent batch")
+	}
+	if tag, err := batch.ResultsFunc(readNames); err != nil || tag.RowsAffected() != 1 {
+		t.Errorf("insert: got %q, %v", tag, err)
+	}
+	if _, err := batch.ResultsFunc(readNames); err != nil || !reflect.DeepEqual(names, []string{"Adam"}) {
+		t.Errorf("select: got %q, %v", names, err)
+	}
+	names = nil
+	if _, err := batch.ResultsFunc(readNames); err != nil || len(names) != 0 {
+		t.Errorf("select of a missing row: got %q, %v", names, err)
+	}
+	if _, err := batch.ResultsFunc(readNames); err == nil {
+		t.Error("expected an error reading past the last result")
+	}
+	if err := batch.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	expectUsable("happy path")
+
+	// The server skips the queries after the one that fails up to the Sync,
+	// so their results are the same error.
+	batch = conn.BeginBatch()
+	batch.Queue("insertPerson", int32(2), "Eve")
+	batch.Queue("insertPerson", int32(1), "Adam")
+	batch.Queue("selectName", int32(1))
+	if err := batch.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := batch.ResultsFunc(readNames); err != nil {
+		t.Errorf("first insert: %v", err)
+	}
+	if _, err := batch.ResultsFunc(readNames); !raw.IsUniqueViolation(err) {
+		t.Errorf("duplicate insert: expected unique_violation, got %v", err)
+	}
+	names = nil
+	if _, err := batch.ResultsFunc(readNames); !raw.IsUniqueViolation(err) || len(names) != 0 {
+		t.Errorf("select after the error: expected unique_violation, got %q, %v", names, err)
+	}
+	if err := batch.Close(); !raw.IsUniqueViolation(err) {
+		t.Errorf("Close: expected unique_violation, got %v", err)
+	}
+	expectUsable("error")
+
+	// Close reads the results that were not.
+	batch = conn.BeginBatch()
+	for i := 0; i < 3; i++ {
+		batch.Queue("selectName", int32(1))
+	}
+	if err := batch.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := batch.ResultsFunc(readNames); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := batch.ResultsFunc(readNames); err == nil {
+		t.Error("expected an error reading a result after Close")
+	}
+	expectUsable("close")
+
+	// An empty batch is only a Sync.
+	batch = conn.BeginBatch()
+	if err := batch.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Close(); err != nil {
+		t.Errorf("empty batch: %v", err)
+	}
+	expectUsable("empty")
+}
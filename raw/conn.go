@@ -41,6 +41,23 @@ type ConnConfig struct {
 	MsgBufSize int         // Size of work buffer used for transcoding messages. For optimal performance, it should be large enough to store a single row from any result set. Default: 1024
 	TLSConfig  *tls.Config // config for TLS connection -- nil disables TLS
 	Logger     log.Logger
+
+	// UseFallbackTLS connects without TLS if the server refuses it, as libpq
+	// does with sslmode=prefer. It has no effect without TLSConfig.
+	UseFallbackTLS bool
+
+	// ConnectTimeout limits the time to establish the connection, from
+	// dialing to the server's first ReadyForQuery. 0 means no limit.
+	ConnectTimeout time.Duration
+
+	// RuntimeParams are sent in the startup message as session defaults,
+	// such as application_name.
+	RuntimeParams map[string]string
+
+	// OnNotice is called with each NoticeResponse the server sends, such as
+	// a warning, while the Conn is reading a response. nil discards
+	// notices.
+	OnNotice func(*Conn, *Notice)
 }
 
 // Conn is a PostgreSQL connection handle. It is not safe for concurrent usage.
@@ -48,8 +65,10 @@ type ConnConfig struct {
 // goroutines.
 type Conn struct {
 	conn               net.Conn      // the underlying TCP or unix domain socket connection
+	network, address   string        // dialed to open conn, and to send a CancelRequest
 	reader             *bufio.Reader // buffered reader to improve read performance
 	wbuf               [1024]byte
+	rxHeader           [4]byte           // the length of the message being received
 	buf                *bytes.Buffer     // work buffer to avoid constant alloc and dealloc
 	bufSize            int               // desired size of buf
 	Pid                int32             // backend pid
@@ -63,12 +82,17 @@ type Conn struct {
 	causeOfDeath       error
 	logger             log.Logger
 	drr                DataRowReader
+	batch              Batch        // reused by BeginBatch
+	scram              *scramClient // SASL exchange in progress during Connect
+	poolPrepared       int          // statements registered with the ConnPool that are prepared
 }
 
 type PreparedStatement struct {
 	Name              string
 	FieldDescriptions []FieldDescription
 	ParameterOids     []Oid
+
+	structPlans structPlanCache // the plans of SelectStruct and SelectStructs
 }
 
 type Notification struct {
@@ -159,26 +183,33 @@ func Connect(config ConnConfig) (c *Conn, err error) {
 		}
 
 		c.logger.Info(fmt.Sprintf("Dialing PostgreSQL server at socket: %s", socket))
-		c.conn, err = net.Dial("unix", socket)
+		c.network, c.address = "unix", socket
+		c.conn, err = net.DialTimeout(c.network, c.address, c.config.ConnectTimeout)
 		if err != nil {
 			c.logger.Error(fmt.Sprintf("Connection failed: %v", err))
 			return nil, err
 		}
 	} else {
 		c.logger.Info(fmt.Sprintf("Dialing PostgreSQL server at host: %s:%d", c.config.Host, c.config.Port))
-		c.conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", c.config.Host, c.config.Port))
+		c.network, c.address = "tcp", net.JoinHostPort(c.config.Host, strconv.FormatUint(uint64(c.config.Port), 10))
+		c.conn, err = net.DialTimeout(c.network, c.address, c.config.ConnectTimeout)
 		if err != nil {
 			c.logger.Error(fmt.Sprintf("Connection failed: %v", err))
 			return nil, err
 		}
 	}
-	defer func() {
-		if c != nil && err != nil {
+	// c is passed in so the connection is closed even where nil is returned.
+	defer func(c *Conn) {
+		if err != nil {
 			c.conn.Close()
 			c.alive = false
 			c.logger.Error(err.Error())
 		}
-	}()
+	}(c)
+
+	if c.config.ConnectTimeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.config.ConnectTimeout))
+	}
 
 	c.bufSize = c.config.MsgBufSize
 	c.buf = bytes.NewBuffer(make([]byte, 0, c.bufSize))
@@ -190,19 +221,22 @@ func Connect(config ConnConfig) (c *Conn, err error) {
 		c.logger.Debug("Starting TLS handshake")
 		if err = c.startTLS(); err != nil {
 			c.logger.Error(fmt.Sprintf("TLS failed: %v", err))
-			return
+			return nil, err
 		}
 	}
 
 	c.reader = bufio.NewReader(c.conn)
 
 	msg := newStartupMessage()
+	for k, v := range c.config.RuntimeParams {
+		msg.options[k] = v
+	}
 	msg.options["user"] = c.config.User
 	if c.config.Database != "" {
 		msg.options["database"] = c.config.Database
 	}
 	if err = c.txStartupMessage(msg); err != nil {
-		return
+		return nil, err
 	}
 
 	for {
@@ -222,6 +256,9 @@ func Connect(config ConnConfig) (c *Conn, err error) {
 			}
 		case readyForQuery:
 			c.rxReadyForQuery(r)
+			if c.config.ConnectTimeout > 0 {
+				c.conn.SetDeadline(time.Time{})
+			}
 			c.logger = c.logger.New("pid", c.Pid)
 			c.logger.Info("Connection established")
 			return c, nil
@@ -250,7 +287,10 @@ func (c *Conn) Close() (err error) {
 	return err
 }
 
-// ParseURI parses a database URI into ConnConfig
+// ParseURI parses a database URI into ConnConfig. The query parameters
+// host, port, user, password and dbname override the rest of the URI;
+// sslmode, sslrootcert and connect_timeout are handled as by libpq, with
+// sslmode defaulting to disable; other parameters are sent as RuntimeParams.
 func ParseURI(uri string) (ConnConfig, error) {
 	var cp ConnConfig
 
@@ -275,6 +315,49 @@ func ParseURI(uri string) (ConnConfig, error) {
 	}
 	cp.Database = strings.TrimLeft(url.Path, "/")
 
+	var sslMode, sslRootCert string
+	for k, v := range url.Query() {
+		switch k {
+		case "host":
+			cp.Host = v[0]
+		case "port":
+			p, err := strconv.ParseUint(v[0], 10, 16)
+			if err != nil {
+				return cp, err
+			}
+			cp.Port = uint16(p)
+		case "user":
+			cp.User = v[0]
+		case "password":
+			cp.Password = v[0]
+		case "dbname":
+			cp.Database = v[0]
+		case "sslmode":
+			sslMode = v[0]
+		case "sslrootcert":
+			sslRootCert = v[0]
+		case "connect_timeout":
+			seconds, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil {
+				return cp, fmt.Errorf("Invalid connect_timeout: %v", v[0])
+			}
+			cp.ConnectTimeout = time.Duration(seconds) * time.Second
+		default:
+			if cp.RuntimeParams == nil {
+				cp.RuntimeParams = make(map[string]string)
+			}
+			cp.RuntimeParams[k] = v[0]
+		}
+	}
+
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	if cp.TLSConfig, err = NewTLSConfig(sslMode, cp.Host, sslRootCert); err != nil {
+		return cp, err
+	}
+	cp.UseFallbackTLS = sslMode == "prefer"
+
 	return cp, nil
 }
 
@@ -331,9 +414,7 @@ func (c *Conn) selectFunc(sql string, onDataRow func(*DataRowReader) error, argu
 			fields = c.rxRowDescription(r)
 		case dataRow:
 			if softErr == nil {
-				c.drr.mr = r
-				c.drr.FieldDescriptions = fields
-				c.drr.currentFieldIdx = 0
+				c.drr.reset(r, fields)
 
 				fieldCount := int(r.ReadInt16())
 				if fieldCount != len(fields) {
@@ -341,6 +422,9 @@ func (c *Conn) selectFunc(sql string, onDataRow func(*DataRowReader) error, argu
 				}
 				if softErr == nil {
 					softErr = onDataRow(&c.drr)
+					if softErr == nil {
+						softErr = c.drr.err
+					}
 				}
 			}
 		case commandComplete:
@@ -781,8 +865,22 @@ func (c *Conn) BuildPreparedQueryBuf(ps *PreparedStatement, arguments ...interfa
 		return nil, fmt.Errorf("Prepared statement \"%v\" requires %d parameters, but %d were provided", ps.Name, len(ps.ParameterOids), len(arguments))
 	}
 
-	// bind
 	wbuf := newWriteBuf(c.wbuf[0:0], 'B')
+	if err := writeBindExecute(wbuf, ps, arguments); err != nil {
+		return nil, err
+	}
+
+	// sync
+	wbuf.startMsg('S')
+	wbuf.closeMsg()
+
+	return wbuf.buf, nil
+}
+
+// writeBindExecute writes the body of the Bind message started in wbuf
+// followed by an Execute of the unnamed portal. ps must take len(arguments)
+// parameters.
+func writeBindExecute(wbuf *WriteBuf, ps *PreparedStatement, arguments []interface{}) error {
 	wbuf.WriteByte(0)
 	wbuf.WriteCString(ps.Name)
 
@@ -804,7 +902,7 @@ func (c *Conn) BuildPreparedQueryBuf(ps *PreparedStatement, arguments ...interfa
 			}
 			err := transcoder.EncodeTo(wbuf, arguments[i])
 			if err != nil {
-				return nil, err
+				return err
 			}
 		} else {
 			wbuf.WriteInt32(int32(-1))
@@ -825,12 +923,7 @@ func (c *Conn) BuildPreparedQueryBuf(ps *PreparedStatement, arguments ...interfa
 	wbuf.startMsg('E')
 	wbuf.WriteByte(0)
 	wbuf.WriteInt32(0)
-
-	// sync
-	wbuf.startMsg('S')
-	wbuf.closeMsg()
-
-	return wbuf.buf, nil
+	return nil
 }
 
 func (c *Conn) sendPreparedQuery(ps *PreparedStatement, arguments ...interface{}) error {
@@ -949,6 +1042,7 @@ func (c *Conn) processContextFreeMsg(t byte, r *MessageReader) (err error) {
 	case errorResponse:
 		return c.rxErrorResponse(r)
 	case noticeResponse:
+		c.rxNoticeResponse(r)
 		return nil
 	case notificationResponse:
 		return c.rxNotificationResponse(r)
@@ -984,7 +1078,7 @@ func (c *Conn) rxMsgHeader() (t byte, bodySize int32, err error) {
 		return 0, 0, err
 	}
 
-	b := make([]byte, 4)
+	b := c.rxHeader[:]
 	_, err = io.ReadFull(c.reader, b)
 	if err != nil {
 		c.die(err)
@@ -1003,6 +1097,19 @@ func (c *Conn) rxMsgBody(bodySize int32) (*bytes.Buffer, error) {
 	}
 
 	buf := c.getBuf()
+	if int(bodySize) <= c.reader.Size() {
+		// Copy the body out of the read buffer, which unlike io.CopyN does
+		// not allocate.
+		b, err := c.reader.Peek(int(bodySize))
+		if err != nil {
+			c.die(err)
+			return nil, err
+		}
+		buf.Write(b)
+		c.reader.Discard(len(b))
+		return buf, nil
+	}
+
 	_, err := io.CopyN(buf, c.reader, int64(bodySize))
 	if err != nil {
 		c.die(err)
@@ -1016,12 +1123,48 @@ func (c *Conn) rxAuthenticationX(r *MessageReader) (err error) {
 	code := r.ReadInt32()
 	switch code {
 	case 0: // AuthenticationOk
+		// A SCRAM exchange only authenticates the server, and with
+		// SCRAM-SHA-256-PLUS binds the TLS channel, once the signature in
+		// AuthenticationSASLFinal has been verified.
+		if c.scram != nil {
+			err = errors.New("Received AuthenticationOk before AuthenticationSASLFinal")
+		}
 	case 3: // AuthenticationCleartextPassword
 		err = c.txPasswordMessage(c.config.Password)
 	case 5: // AuthenticationMD5Password
 		salt := r.ReadString(4)
 		digestedPassword := "md5" + hexMD5(hexMD5(c.config.Password+c.config.User)+salt)
 		err = c.txPasswordMessage(digestedPassword)
+	case 10: // AuthenticationSASL
+		var mechanisms []string
+		for {
+			m := r.ReadCString()
+			if m == "" {
+				break
+			}
+			mechanisms = append(mechanisms, m)
+		}
+		if c.scram, err = newSCRAMClient(mechanisms, c.conn, c.config.Password); err != nil {
+			return err
+		}
+		err = c.txSASLInitialResponse(c.scram.mechanism, c.scram.clientFirstMessage())
+	case 11: // AuthenticationSASLContinue
+		if c.scram == nil {
+			return errors.New("Received AuthenticationSASLContinue before AuthenticationSASL")
+		}
+		var msg []byte
+		if msg, err = c.scram.clientFinalMessage((*bytes.Buffer)(r).Bytes()); err != nil {
+			return err
+		}
+		buf := c.getBuf()
+		buf.Write(msg)
+		err = c.txMsg('p', buf)
+	case 12: // AuthenticationSASLFinal
+		if c.scram == nil {
+			return errors.New("Received AuthenticationSASLFinal before AuthenticationSASL")
+		}
+		err = c.scram.verifyServerFinal((*bytes.Buffer)(r).Bytes())
+		c.scram = nil
 	default:
 		err = errors.New("Received unknown authentication message")
 	}
@@ -1043,28 +1186,71 @@ func (c *Conn) rxParameterStatus(r *MessageReader) {
 
 func (c *Conn) rxErrorResponse(r *MessageReader) (err PgError) {
 	for {
-		switch r.ReadByte() {
+		fieldType, _ := r.ReadByte()
+		switch fieldType {
 		case 'S':
 			err.Severity = r.ReadCString()
 		case 'C':
 			err.Code = r.ReadCString()
 		case 'M':
 			err.Message = r.ReadCString()
+		case 'D':
+			err.Detail = r.ReadCString()
+		case 'H':
+			err.Hint = r.ReadCString()
+		case 'P':
+			err.Position = parseErrorInt32(r.ReadCString())
+		case 'p':
+			err.InternalPosition = parseErrorInt32(r.ReadCString())
+		case 'q':
+			err.InternalQuery = r.ReadCString()
+		case 'W':
+			err.Where = r.ReadCString()
+		case 's':
+			err.SchemaName = r.ReadCString()
+		case 't':
+			err.TableName = r.ReadCString()
+		case 'c':
+			err.ColumnName = r.ReadCString()
+		case 'd':
+			err.DataTypeName = r.ReadCString()
+		case 'n':
+			err.ConstraintName = r.ReadCString()
+		case 'F':
+			err.File = r.ReadCString()
+		case 'L':
+			err.Line = parseErrorInt32(r.ReadCString())
+		case 'R':
+			err.Routine = r.ReadCString()
 		case 0: // End of error message
 			return
-		default: // Ignore other error fields
+		default: // Ignore fields added in later protocol versions, such as V
 			r.ReadCString()
 		}
 	}
 }
 
+func (c *Conn) rxNoticeResponse(r *MessageReader) {
+	notice := Notice(c.rxErrorResponse(r))
+	if c.config.OnNotice != nil {
+		c.config.OnNotice(c, &notice)
+	}
+}
+
+// parseErrorInt32 parses the numeric fields of an ErrorResponse, which are
+// sent as text. A malformed number is reported as 0.
+func parseErrorInt32(s string) int32 {
+	n, _ := strconv.ParseInt(s, 10, 32)
+	return int32(n)
+}
+
 func (c *Conn) rxBackendKeyData(r *MessageReader) {
 	c.Pid = r.ReadInt32()
 	c.SecretKey = r.ReadInt32()
 }
 
 func (c *Conn) rxReadyForQuery(r *MessageReader) {
-	c.TxStatus = r.ReadByte()
+	c.TxStatus, _ = r.ReadByte()
 }
 
 func (c *Conn) rxRowDescription(r *MessageReader) (fields []FieldDescription) {
@@ -1127,6 +1313,10 @@ func (c *Conn) startTLS() (err error) {
 	}
 
 	if response[0] != 'S' {
+		if c.config.UseFallbackTLS {
+			c.logger.Info("Server refused TLS, connecting without it")
+			return nil
+		}
 		err = errors.New("Could not use TLS")
 		return
 	}
@@ -1170,6 +1360,15 @@ func (c *Conn) txMsg(identifier byte, buf *bytes.Buffer) (err error) {
 	return
 }
 
+func (c *Conn) txSASLInitialResponse(mechanism string, data []byte) error {
+	buf := c.getBuf()
+	buf.WriteString(mechanism)
+	buf.WriteByte(0)
+	binary.Write(buf, binary.BigEndian, int32(len(data)))
+	buf.Write(data)
+	return c.txMsg('p', buf)
+}
+
 func (c *Conn) txPasswordMessage(password string) (err error) {
 	buf := c.getBuf()
 
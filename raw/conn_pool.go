@@ -0,0 +1,366 @@
+package raw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// ConnPoolConfig contains the options of a ConnPool. ConnConfig is used to
+// establish each connection.
+type ConnPoolConfig struct {
+	ConnConfig
+	MaxConnections int               // max simultaneous connections to use, default 5
+	AfterConnect   func(*Conn) error // function to call on every new connection
+	AcquireTimeout time.Duration     // max wait time when all connections are busy (0 means no timeout)
+}
+
+// ConnPool is a pool of connections that is safe for concurrent use. A
+// connection is acquired for each use and released afterwards, so its
+// messages are read and written with no more overhead than a lone Conn.
+//
+// Statements prepared with ConnPool.Prepare are registered with the pool and
+// prepared on each connection before it is first acquired, including
+// connections that replace dead ones.
+type ConnPool struct {
+	cond                 *sync.Cond
+	config               ConnConfig
+	maxConnections       int
+	afterConnect         func(*Conn) error
+	acquireTimeout       time.Duration
+	logger               log.Logger
+	allConnections       []*Conn
+	availableConnections []*Conn
+	inProgressConnects   int
+	closed               bool
+
+	// stmts lists the registered statements in the order they were
+	// registered. It is only appended to, so Conn.poolPrepared, the number
+	// of them prepared on a connection, tells which are missing.
+	stmts []poolStatement
+}
+
+type poolStatement struct {
+	name, sql string
+}
+
+// ConnPoolStat is a snapshot of the connections of a ConnPool.
+type ConnPoolStat struct {
+	MaxConnections       int // max simultaneous connections to use
+	CurrentConnections   int // current live connections
+	AvailableConnections int // unused live connections
+}
+
+// CheckedOutConnections returns the number of connections that are acquired.
+func (stat *ConnPoolStat) CheckedOutConnections() int {
+	return stat.CurrentConnections - stat.AvailableConnections
+}
+
+// ErrAcquireTimeout is returned by Acquire when no connection became
+// available within AcquireTimeout.
+var ErrAcquireTimeout = errors.New("Timeout acquiring connection from pool")
+
+// ErrClosedPool is returned by Acquire after the pool is closed.
+var ErrClosedPool = errors.New("Cannot acquire from closed pool")
+
+// NewConnPool creates a ConnPool and establishes its first connection, so an
+// invalid config is reported here rather than by the first Acquire.
+func NewConnPool(config ConnPoolConfig) (p *ConnPool, err error) {
+	p = &ConnPool{
+		config:         config.ConnConfig,
+		maxConnections: config.MaxConnections,
+		afterConnect:   config.AfterConnect,
+		acquireTimeout: config.AcquireTimeout,
+	}
+	p.cond = sync.NewCond(new(sync.Mutex))
+
+	if p.maxConnections == 0 {
+		p.maxConnections = 5
+	}
+	if p.maxConnections < 1 {
+		return nil, errors.New("MaxConnections must be at least 1")
+	}
+	if p.acquireTimeout < 0 {
+		return nil, errors.New("AcquireTimeout must not be negative")
+	}
+
+	if config.Logger != nil {
+		p.logger = config.Logger
+	} else {
+		p.logger = log.New()
+		p.logger.SetHandler(log.DiscardHandler())
+	}
+
+	c, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	p.Release(c)
+	return p, nil
+}
+
+// Acquire takes a connection from the pool, establishing a new one if none
+// is available and the pool has fewer than MaxConnections. Otherwise it waits
+// up to AcquireTimeout for one to be released. The connection must be
+// returned with Release.
+func (p *ConnPool) Acquire() (*Conn, error) {
+	p.cond.L.Lock()
+
+	var deadline time.Time
+	if p.acquireTimeout > 0 {
+		deadline = time.Now().Add(p.acquireTimeout)
+		// Wake the waiters so this one sees that its deadline has passed.
+		timer := time.AfterFunc(p.acquireTimeout, p.cond.Broadcast)
+		defer timer.Stop()
+	}
+
+	for {
+		if p.closed {
+			p.cond.L.Unlock()
+			return nil, ErrClosedPool
+		}
+
+		if n := len(p.availableConnections); n > 0 {
+			c := p.availableConnections[n-1]
+			p.availableConnections = p.availableConnections[:n-1]
+			if !c.IsAlive() {
+				// The connection died while it was idle, such as when
+				// the server closed it.
+				p.removeConnection(c)
+				p.logger.Warn("Discarding dead connection", "pid", c.Pid, "causeOfDeath", c.CauseOfDeath())
+				continue
+			}
+			return p.prepare(c, p.stmts)
+		}
+
+		if len(p.allConnections)+p.inProgressConnects < p.maxConnections {
+			p.inProgressConnects++
+			p.cond.L.Unlock()
+			c, err := p.createConnection()
+			p.cond.L.Lock()
+			p.inProgressConnects--
+			if err != nil {
+				// Another goroutine may be waiting for the slot.
+				p.cond.L.Unlock()
+				p.cond.Signal()
+				return nil, err
+			}
+			if p.closed {
+				p.cond.L.Unlock()
+				c.Close()
+				return nil, ErrClosedPool
+			}
+			p.allConnections = append(p.allConnections, c)
+			return p.prepare(c, p.stmts)
+		}
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			p.cond.L.Unlock()
+			return nil, ErrAcquireTimeout
+		}
+		p.cond.Wait()
+	}
+}
+
+// prepare prepares the registered statements, stmts, that are missing on c,
+// which has just been acquired. It is called with p.cond.L locked and
+// unlocks it.
+func (p *ConnPool) prepare(c *Conn, stmts []poolStatement) (*Conn, error) {
+	p.cond.L.Unlock()
+
+	for ; c.poolPrepared < len(stmts); c.poolPrepared++ {
+		stmt := stmts[c.poolPrepared]
+		if _, present := c.preparedStatements[stmt.name]; present {
+			// c is the connection ConnPool.Prepare prepared it on.
+			continue
+		}
+		if _, err := c.Prepare(stmt.name, stmt.sql); err != nil {
+			p.Release(c)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *ConnPool) createConnection() (*Conn, error) {
+	c, err := Connect(p.config)
+	if err != nil {
+		return nil, err
+	}
+	if p.afterConnect != nil {
+		if err := p.afterConnect(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Release returns c to the pool. A transaction left open on c is rolled
+// back. If c is dead, as reported by IsAlive, it is discarded and replaced by
+// a new connection when one is next needed.
+func (p *ConnPool) Release(c *Conn) {
+	if c.IsAlive() && c.TxStatus != 'I' {
+		c.Execute("rollback")
+	}
+
+	p.cond.L.Lock()
+	if !c.IsAlive() || p.closed {
+		p.removeConnection(c)
+		p.cond.L.Unlock()
+		if c.IsAlive() {
+			c.Close()
+		} else {
+			p.logger.Warn("Discarding dead connection", "pid", c.Pid, "causeOfDeath", c.CauseOfDeath())
+		}
+		// A waiting Acquire may now establish a new connection.
+		p.cond.Signal()
+		return
+	}
+	p.availableConnections = append(p.availableConnections, c)
+	p.cond.L.Unlock()
+	p.cond.Signal()
+}
+
+// removeConnection removes c from p.allConnections. It is called with
+// p.cond.L locked.
+func (p *ConnPool) removeConnection(c *Conn) {
+	for i, conn := range p.allConnections {
+		if conn == c {
+			p.allConnections = append(p.allConnections[:i], p.allConnections[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close closes the available connections and stops further acquires.
+// Acquired connections are closed when they are released.
+func (p *ConnPool) Close() {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+
+	p.closed = true
+	for _, c := range p.availableConnections {
+		p.removeConnection(c)
+		c.Close()
+	}
+	p.availableConnections = nil
+	p.cond.Broadcast()
+}
+
+// Stat returns the current connection counts of the pool.
+func (p *ConnPool) Stat() (s ConnPoolStat) {
+	p.cond.L.Lock()
+	defer p.cond.L.Unlock()
+
+	s.MaxConnections = p.maxConnections
+	s.CurrentConnections = len(p.allConnections)
+	s.AvailableConnections = len(p.availableConnections)
+	return
+}
+
+// Prepare registers a prepared statement with name and sql. It is prepared
+// on one connection immediately, so an invalid statement is reported here,
+// and on the other connections before they are next acquired. Preparing the
+// same name and sql again returns the statement without registering it twice.
+func (p *ConnPool) Prepare(name, sql string) (*PreparedStatement, error) {
+	p.cond.L.Lock()
+	for _, stmt := range p.stmts {
+		if stmt.name == name {
+			p.cond.L.Unlock()
+			if stmt.sql != sql {
+				return nil, fmt.Errorf("Prepared statement \"%v\" is already registered with different sql", name)
+			}
+			// Acquire prepares it if it is missing.
+			c, err := p.Acquire()
+			if err != nil {
+				return nil, err
+			}
+			defer p.Release(c)
+			return c.preparedStatements[name], nil
+		}
+	}
+	p.cond.L.Unlock()
+
+	c, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(c)
+	ps, err := c.Prepare(name, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cond.L.Lock()
+	p.stmts = append(p.stmts, poolStatement{name: name, sql: sql})
+	p.cond.L.Unlock()
+	return ps, nil
+}
+
+// SelectFunc acquires a connection, calls its SelectFunc and releases it.
+func (p *ConnPool) SelectFunc(sql string, onDataRow func(*DataRowReader) error, arguments ...interface{}) error {
+	c, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	defer p.Release(c)
+	return c.SelectFunc(sql, onDataRow, arguments...)
+}
+
+// SelectValue acquires a connection, calls its SelectValue and releases it.
+func (p *ConnPool) SelectValue(sql string, arguments ...interface{}) (interface{}, error) {
+	c, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(c)
+	return c.SelectValue(sql, arguments...)
+}
+
+// SelectValueTo acquires a connection, calls its SelectValueTo and releases
+// it.
+func (p *ConnPool) SelectValueTo(w io.Writer, sql string, arguments ...interface{}) error {
+	c, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	defer p.Release(c)
+	return c.SelectValueTo(w, sql, arguments...)
+}
+
+// SelectStruct acquires a connection, calls its SelectStruct and releases
+// it.
+func (p *ConnPool) SelectStruct(dest interface{}, sql string, arguments ...interface{}) error {
+	c, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	defer p.Release(c)
+	return c.SelectStruct(dest, sql, arguments...)
+}
+
+// SelectStructs acquires a connection, calls its SelectStructs and releases
+// it.
+func (p *ConnPool) SelectStructs(dest interface{}, sql string, arguments ...interface{}) error {
+	c, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	defer p.Release(c)
+	return c.SelectStructs(dest, sql, arguments...)
+}
+
+// Execute acquires a connection, calls its Execute and releases it.
+func (p *ConnPool) Execute(sql string, arguments ...interface{}) (CommandTag, error) {
+	c, err := p.Acquire()
+	if err != nil {
+		return "", err
+	}
+	defer p.Release(c)
+	return c.Execute(sql, arguments...)
+}
@@ -0,0 +1,106 @@
+package raw_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestConnPool(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	var connects int32
+	pool, err := raw.NewConnPool(raw.ConnPoolConfig{
+		ConnConfig:     config,
+		MaxConnections: 2,
+		AcquireTimeout: 20 * time.Millisecond,
+		AfterConnect: func(*raw.Conn) error {
+			atomic.AddInt32(&connects, 1)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Prepare("sleep", "select pg_sleep($1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	c1, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Acquire(); err != raw.ErrAcquireTimeout {
+		t.Errorf("expected ErrAcquireTimeout, got %v", err)
+	}
+	stat := pool.Stat()
+	if stat.CurrentConnections != 2 || stat.CheckedOutConnections() != 2 {
+		t.Errorf("unexpected stat with every connection acquired: %+v", stat)
+	}
+	// The registered statement is prepared on the second connection too.
+	for _, c := range []*raw.Conn{c1, c2} {
+		if _, err := c.Execute("sleep", float64(0)); err != nil {
+			t.Errorf("registered statement on connection %d: %v", c.Pid, err)
+		}
+	}
+
+	// A connection that dies is discarded and replaced when needed.
+	c2.Conn().Close()
+	if _, err := c2.Execute("select 1"); err == nil || c2.IsAlive() {
+		t.Fatalf("expected the closed connection to be dead, got %v", err)
+	}
+	pool.Release(c2)
+	pool.Release(c1)
+	if stat := pool.Stat(); stat.CurrentConnections != 1 || stat.AvailableConnections != 1 {
+		t.Errorf("unexpected stat after releasing a dead connection: %+v", stat)
+	}
+	c1, err = pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err = pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&connects); n != 3 {
+		t.Errorf("expected 3 connections to have been established, got %d", n)
+	}
+	if _, err := c2.Execute("sleep", float64(0)); err != nil {
+		t.Errorf("registered statement on the replacement connection: %v", err)
+	}
+	pool.Release(c1)
+	pool.Release(c2)
+
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				if _, err := pool.Execute("sleep", float64(0)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent Execute: %v", err)
+		}
+	}
+	if stat := pool.Stat(); stat.CurrentConnections > 2 || stat.CheckedOutConnections() != 0 {
+		t.Errorf("unexpected stat after concurrent use: %+v", stat)
+	}
+
+	pool.Close()
+	if _, err := pool.Acquire(); err != raw.ErrClosedPool {
+		t.Errorf("expected ErrClosedPool, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package raw_test
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+// startServer starts a fake server that authenticates with md5 and returns
+// it with the config of a connection to it.
+func startServer(t *testing.T) (*fakepg.Server, raw.ConnConfig) {
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{Auth: fakepg.AuthMD5, Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := server.Addr().(*net.TCPAddr)
+	config := raw.ConnConfig{
+		Host:     addr.IP.String(),
+		Port:     uint16(addr.Port),
+		User:     "postgres",
+		Password: "secret",
+		Database: "postgres",
+	}
+	return server, config
+}
+
+func TestErrorFieldsAndNotices(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	var notices []raw.Notice
+	config.OnNotice = func(c *raw.Conn, n *raw.Notice) { notices = append(notices, *n) }
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Execute("drop table if exists missing"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 || notices[0].Severity != "NOTICE" || notices[0].Message != `table "missing" does not exist, skipping` {
+		t.Errorf("got notices %+v", notices)
+	}
+
+	if _, err := conn.Execute("create table person(id serial primary key, name varchar)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("insertPerson", "insert into person(id, name) values ($1, $2)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Execute("insertPerson", int32(1), "Adam"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Execute("insertPerson", int32(1), "Eve")
+	pgErr, ok := err.(raw.PgError)
+	if !ok {
+		t.Fatalf("expected PgError, got %v", err)
+	}
+	want := raw.PgError{
+		Severity:       "ERROR",
+		Code:           raw.UniqueViolation,
+		Message:        `duplicate key value violates unique constraint "person_pkey"`,
+		Detail:         "Key (id)=(1) already exists.",
+		SchemaName:     "public",
+		TableName:      "person",
+		ConstraintName: "person_pkey",
+	}
+	if pgErr != want {
+		t.Errorf("got %+v, want %+v", pgErr, want)
+	}
+	if pgErr.Class() != "23" || !raw.IsUniqueViolation(err) || !raw.IsIntegrityConstraintViolation(err) || raw.IsRetryable(err) {
+		t.Errorf("SQLSTATE helpers disagree with %s", pgErr.Code)
+	}
+	if raw.IsRetryable(nil) || raw.ErrorCode(nil) != "" {
+		t.Error("nil error has a SQLSTATE")
+	}
+}
+
+// TestConnectClosesOnError checks that a failed handshake closes the
+// connection it dialed.
+func TestConnectClosesOnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+
+		// Read the startup message and refuse it.
+		var length [4]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			closed <- err
+			return
+		}
+		if _, err := io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint32(length[:]))-4); err != nil {
+			closed <- err
+			return
+		}
+		body := "SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"
+		binary.BigEndian.PutUint32(length[:], uint32(4+len(body)))
+		conn.Write(append(append([]byte{'E'}, length[:]...), body...))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(length[:])
+		closed <- err
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	conn, err := raw.Connect(raw.ConnConfig{Host: addr.IP.String(), Port: uint16(addr.Port), User: "postgres"})
+	if raw.ErrorCode(err) != "28P01" || conn != nil {
+		t.Errorf("expected invalid_password, got %v, %v", conn, err)
+	}
+	if err := <-closed; err != io.EOF {
+		t.Errorf("expected the client to close the connection, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package raw
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// cancelTimeout bounds how long a cancelled call waits for the server to
+// accept the CancelRequest and end the query. If it does not, the connection
+// is closed.
+const cancelTimeout = 10 * time.Second
+
+// SelectFuncContext is SelectFunc with a context. If ctx is done before the
+// query completes, the query is cancelled with a CancelRequest, the rest of
+// the response is read so c can still be used, and ctx.Err() is returned.
+func (c *Conn) SelectFuncContext(ctx context.Context, sql string, onDataRow func(*DataRowReader) error, arguments ...interface{}) error {
+	finish, err := c.watchContext(ctx)
+	if err != nil {
+		return err
+	}
+	return finish(c.SelectFunc(sql, onDataRow, arguments...))
+}
+
+// SelectValueToContext is SelectValueTo with a context. It is cancelled as
+// SelectFuncContext is.
+func (c *Conn) SelectValueToContext(ctx context.Context, w io.Writer, sql string, arguments ...interface{}) error {
+	finish, err := c.watchContext(ctx)
+	if err != nil {
+		return err
+	}
+	return finish(c.SelectValueTo(w, sql, arguments...))
+}
+
+// ExecuteContext is Execute with a context. It is cancelled as
+// SelectFuncContext is.
+func (c *Conn) ExecuteContext(ctx context.Context, sql string, arguments ...interface{}) (CommandTag, error) {
+	finish, err := c.watchContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	commandTag, err := c.Execute(sql, arguments...)
+	return commandTag, finish(err)
+}
+
+// PrepareContext is Prepare with a context. It is cancelled as
+// SelectFuncContext is.
+func (c *Conn) PrepareContext(ctx context.Context, name, sql string) (*PreparedStatement, error) {
+	finish, err := c.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := c.Prepare(name, sql)
+	if err = finish(err); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// watchContext cancels the running query of c when ctx is done. The call
+// being watched must pass its error to the returned finish function, which
+// stops watching. If a CancelRequest was sent, finish waits for the server to
+// accept it, so it cannot cancel a later query, and returns ctx.Err() in
+// place of the error the cancellation caused.
+func (c *Conn) watchContext(ctx context.Context) (finish func(error) error, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return func(err error) error { return err }, nil
+	}
+
+	stop := make(chan struct{})
+	sentCancel := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// The deadline breaks a read that the CancelRequest does not
+			// end, such as one from an unreachable server.
+			c.conn.SetDeadline(time.Now().Add(cancelTimeout))
+			if err := c.sendCancelRequest(); err != nil {
+				c.logger.Error("CancelRequest failed", "error", err)
+			}
+			sentCancel <- true
+		case <-stop:
+			sentCancel <- false
+		}
+	}()
+
+	return func(err error) error {
+		close(stop)
+		if !<-sentCancel {
+			return err
+		}
+		c.conn.SetDeadline(time.Time{})
+		return contextError(ctx, err)
+	}, nil
+}
+
+// contextError returns ctx.Err() if err, the error of a call watched for
+// ctx, was caused by the call being cancelled.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if ErrorCode(err) == QueryCanceled {
+		return ctx.Err()
+	}
+	if _, ok := err.(net.Error); ok || err == DeadConnError {
+		return ctx.Err()
+	}
+	return err
+}
+
+// sendCancelRequest asks the server, on a new connection, to cancel the query
+// running on c. It returns once the server has closed that connection, which
+// it does after signalling the backend running the query.
+func (c *Conn) sendCancelRequest() error {
+	conn, err := net.DialTimeout(c.network, c.address, cancelTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cancelTimeout))
+
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint32(buf[0:4], 16)
+	binary.BigEndian.PutUint32(buf[4:8], 80877102)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(c.Pid))
+	binary.BigEndian.PutUint32(buf[12:16], uint32(c.SecretKey))
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, conn)
+	return err
+}
@@ -0,0 +1,80 @@
+package raw_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestCancel(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Prepare("sleep", "select pg_sleep($1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUsable := func(name string) {
+		if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+			t.Errorf("%s: connection not usable after cancel: %v, %v", name, v, err)
+		}
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = conn.SelectFuncContext(ctx, "select pg_sleep(10)", func(*raw.DataRowReader) error { return nil })
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("simple query: expected context.DeadlineExceeded, got %v", err)
+	}
+	expectUsable("simple query")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err = conn.ExecuteContext(ctx, "sleep", float64(10))
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("prepared statement: expected context.DeadlineExceeded, got %v", err)
+	}
+	expectUsable("prepared statement")
+
+	// The server flushes the results of a batch at the Sync, so the timeout
+	// cancels the second query before the first result is read.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	batch := conn.BeginBatch()
+	batch.Queue("sleep", float64(0))
+	batch.Queue("sleep", float64(10))
+	if err := batch.SendContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := batch.ResultsFunc(func(*raw.DataRowReader) error { return nil }); err != nil {
+		t.Errorf("batch: first result: %v", err)
+	}
+	if _, err := batch.ResultsFunc(func(*raw.DataRowReader) error { return nil }); err != context.DeadlineExceeded {
+		t.Errorf("batch: expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := batch.Close(); err != context.DeadlineExceeded {
+		t.Errorf("batch: Close: expected context.DeadlineExceeded, got %v", err)
+	}
+	cancel()
+	expectUsable("batch")
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled queries took %v", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := conn.ExecuteContext(ctx, "select 1"); err != context.Canceled {
+		t.Errorf("done context: expected context.Canceled, got %v", err)
+	}
+	if _, err := conn.ExecuteContext(context.Background(), "sleep", float64(0)); err != nil {
+		t.Errorf("background context: %v", err)
+	}
+}
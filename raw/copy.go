@@ -0,0 +1,368 @@
+package raw
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const copyBufSize = 65536 // size at which buffered CopyData is sent
+
+// copyBinarySignature starts the data of a binary COPY.
+var copyBinarySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// CopyFromSource is the source of the rows for CopyFrom and CopyFromText.
+type CopyFromSource interface {
+	// Next returns true if there is another row and makes the next row data
+	// available to Values(). When there are no more rows available or an error
+	// has occurred it returns false.
+	Next() bool
+
+	// Values returns the values for the current row.
+	Values() ([]interface{}, error)
+
+	// Err returns any error that has been encountered by the CopyFromSource. If
+	// this is not nil the copy is aborted.
+	Err() error
+}
+
+// CopyFromRows returns a CopyFromSource over rows.
+func CopyFromRows(rows [][]interface{}) CopyFromSource {
+	return &copyFromRows{rows: rows, idx: -1}
+}
+
+type copyFromRows struct {
+	rows [][]interface{}
+	idx  int
+}
+
+func (ctr *copyFromRows) Next() bool {
+	ctr.idx++
+	return ctr.idx < len(ctr.rows)
+}
+
+func (ctr *copyFromRows) Values() ([]interface{}, error) {
+	return ctr.rows[ctr.idx], nil
+}
+
+func (ctr *copyFromRows) Err() error {
+	return nil
+}
+
+// CopyFrom uses the binary COPY format to insert the rows of rowSrc into the
+// columnNames of tableName. It returns the number of rows copied.
+//
+// The binary format needs the type of each column, so CopyFrom first prepares
+// a select of the columns. Values are encoded by the ValueTranscoder of the
+// column type; dates and timestamps are converted to their binary form.
+func (c *Conn) CopyFrom(tableName string, columnNames []string, rowSrc CopyFromSource) (int, error) {
+	return c.copyFrom(tableName, columnNames, rowSrc, true)
+}
+
+// CopyFromText is CopyFrom using the text COPY format. It needs no column
+// types, so it takes one round trip less than CopyFrom.
+func (c *Conn) CopyFromText(tableName string, columnNames []string, rowSrc CopyFromSource) (int, error) {
+	return c.copyFrom(tableName, columnNames, rowSrc, false)
+}
+
+func (c *Conn) copyFrom(tableName string, columnNames []string, rowSrc CopyFromSource, binaryFormat bool) (n int, err error) {
+	startTime := time.Now()
+
+	defer func() {
+		if err == nil {
+			endTime := time.Now()
+			c.logger.Info("CopyFrom", "table", tableName, "binary", binaryFormat, "rows", n, "time", endTime.Sub(startTime))
+		} else {
+			c.logger.Error("CopyFrom", "table", tableName, "binary", binaryFormat, "error", err)
+		}
+	}()
+
+	quotedColumnNames := make([]string, len(columnNames))
+	for i, cn := range columnNames {
+		quotedColumnNames[i] = c.QuoteIdentifier(cn)
+	}
+	columnList := strings.Join(quotedColumnNames, ", ")
+	quotedTableName := c.QuoteIdentifier(tableName)
+
+	var encoders []func(*WriteBuf, interface{}) error
+	sql := fmt.Sprintf("copy %s ( %s ) from stdin", quotedTableName, columnList)
+	if binaryFormat {
+		ps, err := c.Prepare("", fmt.Sprintf("select %s from %s", columnList, quotedTableName))
+		if err != nil {
+			return 0, err
+		}
+		encoders = make([]func(*WriteBuf, interface{}) error, len(ps.FieldDescriptions))
+		for i, fd := range ps.FieldDescriptions {
+			if encoders[i], err = copyBinaryEncoder(fd.DataType); err != nil {
+				return 0, err
+			}
+		}
+		sql += " binary"
+	}
+
+	if err = c.sendSimpleQuery(sql); err != nil {
+		return 0, err
+	}
+	if err = c.rxUntilCopyResponse(copyInResponse); err != nil {
+		return 0, err
+	}
+
+	wbuf := newWriteBuf(c.wbuf[0:0], copyData)
+	if binaryFormat {
+		wbuf.WriteBytes(copyBinarySignature)
+		wbuf.WriteInt32(0) // flags
+		wbuf.WriteInt32(0) // header extension length
+	}
+
+	for rowSrc.Next() {
+		values, err := rowSrc.Values()
+		if err != nil {
+			return 0, c.abortCopyIn(err)
+		}
+		if len(values) != len(columnNames) {
+			return 0, c.abortCopyIn(fmt.Errorf("expected %d values, got %d values", len(columnNames), len(values)))
+		}
+
+		if binaryFormat {
+			wbuf.WriteInt16(int16(len(values)))
+			for i, v := range values {
+				if v == nil {
+					wbuf.WriteInt32(-1)
+					continue
+				}
+				if err := encoders[i](wbuf, v); err != nil {
+					return 0, c.abortCopyIn(err)
+				}
+			}
+		} else {
+			for i, v := range values {
+				if i > 0 {
+					wbuf.WriteByte('\t')
+				}
+				if wbuf.buf, err = appendCopyText(wbuf.buf, v); err != nil {
+					return 0, c.abortCopyIn(err)
+				}
+			}
+			wbuf.WriteByte('\n')
+		}
+
+		if len(wbuf.buf) > copyBufSize {
+			wbuf.closeMsg()
+			if _, err := c.conn.Write(wbuf.buf); err != nil {
+				c.die(err)
+				return 0, err
+			}
+			wbuf = newWriteBuf(wbuf.buf[0:0], copyData)
+		}
+	}
+	if err := rowSrc.Err(); err != nil {
+		return 0, c.abortCopyIn(err)
+	}
+
+	if binaryFormat {
+		wbuf.WriteInt16(-1) // trailer
+	}
+	wbuf.startMsg(copyDone)
+	wbuf.closeMsg()
+	if _, err := c.conn.Write(wbuf.buf); err != nil {
+		c.die(err)
+		return 0, err
+	}
+
+	// An error in the copy data is only reported once the server has
+	// received CopyDone.
+	commandTag, err := c.rxUntilReadyForQuery()
+	if err != nil {
+		return 0, err
+	}
+	return int(commandTag.RowsAffected()), nil
+}
+
+// abortCopyIn sends CopyFail for cause and waits for the server to end the
+// copy. It returns cause rather than the error the server reports for it.
+func (c *Conn) abortCopyIn(cause error) error {
+	buf := c.getBuf()
+	buf.WriteString("client error: " + cause.Error())
+	buf.WriteByte(0)
+	if err := c.txMsg(copyFail, buf); err != nil {
+		return err
+	}
+	if _, err := c.rxUntilReadyForQuery(); err != nil {
+		if _, ok := err.(PgError); !ok {
+			return err
+		}
+	}
+	return cause
+}
+
+// CopyTo runs sql, a COPY ... TO STDOUT statement, and writes the copy data to
+// w as it arrives without interpreting it. The format is whatever sql asks
+// for. If w returns an error the rest of the copy data is read and discarded,
+// so the connection can still be used, and the error is returned.
+func (c *Conn) CopyTo(w io.Writer, sql string) (commandTag CommandTag, err error) {
+	startTime := time.Now()
+
+	defer func() {
+		if err == nil {
+			endTime := time.Now()
+			c.logger.Info("CopyTo", "sql", sql, "time", endTime.Sub(startTime))
+		} else {
+			c.logger.Error("CopyTo", "sql", sql, "error", err)
+		}
+	}()
+
+	if err = c.sendSimpleQuery(sql); err != nil {
+		return "", err
+	}
+	if err = c.rxUntilCopyResponse(copyOutResponse); err != nil {
+		return "", err
+	}
+
+	var writeErr error // the first error returned by w
+	for {
+		var t byte
+		var bodySize int32
+		t, bodySize, err = c.rxMsgHeader()
+		if err != nil {
+			return "", err
+		}
+
+		if t == copyData {
+			data := &io.LimitedReader{R: c.reader, N: int64(bodySize)}
+			if writeErr == nil {
+				// An error reading from the connection is returned here
+				// too, but then draining data fails as well.
+				_, writeErr = io.Copy(w, data)
+			}
+			if _, err = io.Copy(ioutil.Discard, data); err == nil && data.N > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				c.die(err)
+				return "", err
+			}
+			continue
+		}
+
+		var body *bytes.Buffer
+		if body, err = c.rxMsgBody(bodySize); err != nil {
+			return "", err
+		}
+		if t == copyDone {
+			if commandTag, err = c.rxUntilReadyForQuery(); err == nil && writeErr != nil {
+				return "", writeErr
+			}
+			return commandTag, err
+		}
+		if err = c.processContextFreeMsg(t, (*MessageReader)(body)); err != nil {
+			c.rxUntilReadyForQuery()
+			return "", err
+		}
+	}
+}
+
+// rxUntilCopyResponse reads until the CopyInResponse or CopyOutResponse t
+// that starts a copy. If the statement fails instead it reads until
+// ReadyForQuery and returns the error.
+func (c *Conn) rxUntilCopyResponse(t byte) error {
+	for {
+		msgType, r, err := c.rxMsg()
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case t:
+			return nil
+		case readyForQuery:
+			c.rxReadyForQuery(r)
+			return ProtocolError("Statement did not start a copy")
+		default:
+			if err := c.processContextFreeMsg(msgType, r); err != nil {
+				c.rxUntilReadyForQuery()
+				return err
+			}
+		}
+	}
+}
+
+// rxUntilReadyForQuery reads the end of a response and returns its command
+// tag and the first error received.
+func (c *Conn) rxUntilReadyForQuery() (commandTag CommandTag, err error) {
+	var softErr error
+	for {
+		t, r, err := c.rxMsg()
+		if err != nil {
+			return "", err
+		}
+
+		switch t {
+		case readyForQuery:
+			c.rxReadyForQuery(r)
+			return commandTag, softErr
+		case commandComplete:
+			commandTag = CommandTag(c.rxCommandComplete(r))
+		default:
+			if e := c.processContextFreeMsg(t, r); e != nil && softErr == nil {
+				softErr = e
+			}
+		}
+	}
+}
+
+// copyBinaryEncoder returns the function that encodes values of type oid for
+// a binary COPY, which needs every value in binary format.
+func copyBinaryEncoder(oid Oid) (func(*WriteBuf, interface{}) error, error) {
+	switch oid {
+	case Oid(25), Oid(1043): // text and varchar are the same in both formats
+		return encodeText, nil
+	}
+	if vt := ValueTranscoders[oid]; vt != nil && vt.EncodeFormat == 1 {
+		return vt.EncodeTo, nil
+	}
+	return nil, fmt.Errorf("binary COPY of type oid %d is not supported", oid)
+}
+
+var copyTextEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// appendCopyText appends value in the text COPY format.
+func appendCopyText(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, `\N`...), nil
+	case string:
+		if strings.ContainsAny(v, "\\\t\n\r") {
+			v = copyTextEscaper.Replace(v)
+		}
+		return append(buf, v...), nil
+	case []byte:
+		buf = append(buf, `\\x`...)
+		return append(buf, hex.EncodeToString(v)...), nil
+	case bool:
+		if v {
+			return append(buf, 't'), nil
+		}
+		return append(buf, 'f'), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case float32:
+		return strconv.AppendFloat(buf, float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), nil
+	case time.Time:
+		return v.AppendFormat(buf, "2006-01-02 15:04:05.999999Z07:00"), nil
+	default:
+		return nil, fmt.Errorf("Cannot encode %T for text COPY", value)
+	}
+}
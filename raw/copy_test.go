@@ -0,0 +1,112 @@
+package raw_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestCopy(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Execute("create table person(id serial primary key, first_name varchar, weight int4 not null)"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]interface{}{{"Adam", int32(70)}, {nil, int32(60)}}
+	if n, err := conn.CopyFrom("person", []string{"first_name", "weight"}, raw.CopyFromRows(rows)); err != nil || n != len(rows) {
+		t.Fatalf("CopyFrom copied %d rows, %v", n, err)
+	}
+	rows = [][]interface{}{{"tab\there", 80}}
+	if n, err := conn.CopyFromText("person", []string{"first_name", "weight"}, raw.CopyFromRows(rows)); err != nil || n != len(rows) {
+		t.Fatalf("CopyFromText copied %d rows, %v", n, err)
+	}
+
+	var text strings.Builder
+	if _, err := conn.CopyTo(&text, "copy person to stdout"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := text.String(), "1\tAdam\t70\n2\t\\N\t60\n3\ttab\\there\t80\n"; got != want {
+		t.Errorf("text CopyTo got %q, want %q", got, want)
+	}
+
+	var binary strings.Builder
+	if _, err := conn.CopyTo(&binary, "copy (select weight from person where id = 1) to stdout (format binary)"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := binary.String(), "PGCOPY\n\377\r\n\000"+"\000\000\000\000\000\000\000\000"+"\000\001\000\000\000\004\000\000\000F"+"\377\377"; got != want {
+		t.Errorf("binary CopyTo got %q, want %q", got, want)
+	}
+
+	// A row of the wrong length aborts the copy with CopyFail, and nothing
+	// it sent is inserted.
+	rows = [][]interface{}{{"Seth", int32(90)}, {"Cain"}}
+	if _, err := conn.CopyFrom("person", []string{"first_name", "weight"}, raw.CopyFromRows(rows)); err == nil || !strings.Contains(err.Error(), "expected 2 values") {
+		t.Errorf("expected an error for a short row, got %v", err)
+	}
+	if v, err := conn.SelectValue("select count(*) from person"); err != nil || v != int64(3) {
+		t.Errorf("count after an aborted copy: %v, %v", v, err)
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("destination failed")
+}
+
+func TestCopyToWriterError(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	pool, err := raw.NewConnPool(raw.ConnPoolConfig{ConnConfig: config, MaxConnections: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Execute("create table person(id serial primary key, first_name varchar not null)"); err != nil {
+		t.Fatal(err)
+	}
+	rows := make([][]interface{}, 1000)
+	for i := range rows {
+		rows[i] = []interface{}{strings.Repeat("x", 100)}
+	}
+
+	conn, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.CopyFromText("person", []string{"first_name"}, raw.CopyFromRows(rows)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The copy data after the failed write is drained, so the connection
+	// stays alive and goes back to the pool.
+	w := &failingWriter{}
+	if _, err := conn.CopyTo(w, "copy person to stdout"); err == nil || err.Error() != "destination failed" {
+		t.Errorf("expected the writer's error, got %v", err)
+	}
+	if w.n != 1 {
+		t.Errorf("Write was called %d times, want 1", w.n)
+	}
+	if !conn.IsAlive() {
+		t.Fatal("connection died after a failed write")
+	}
+	pool.Release(conn)
+	if v, err := pool.SelectValue("select count(*) from person"); err != nil || v != int64(len(rows)) {
+		t.Errorf("pooled connection not usable after a failed write: %v, %v", v, err)
+	}
+	if stat := pool.Stat(); stat.CurrentConnections != 1 {
+		t.Errorf("expected the connection to be reused, got %+v", stat)
+	}
+}
@@ -1,40 +1,184 @@
 package raw
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 // DataRowReader is used by SelectFunc to process incoming rows.
+//
+// Besides ReadValue, it has accessors that read the next value as a given
+// type without boxing it in an interface{}, so reading a row in the binary
+// format allocates nothing. An accessor that cannot read the value as its
+// type returns the zero value and sets Err, which SelectFunc returns after
+// the row. Check IsNull before reading a column that can be NULL.
 type DataRowReader struct {
 	mr                *MessageReader
 	FieldDescriptions []FieldDescription
 	currentFieldIdx   int
+	err               error
 }
 
 func (r *DataRowReader) MessageReader() *MessageReader {
 	return r.mr
 }
 
+// reset prepares r to read the row of mr.
+func (r *DataRowReader) reset(mr *MessageReader, fields []FieldDescription) {
+	r.mr = mr
+	r.FieldDescriptions = fields
+	r.currentFieldIdx = 0
+	r.err = nil
+}
+
 // ReadValue returns the next value from the current row.
 func (r *DataRowReader) ReadValue() interface{} {
-	fieldDescription := r.FieldDescriptions[r.currentFieldIdx]
+	fieldDescription := &r.FieldDescriptions[r.currentFieldIdx]
 	r.currentFieldIdx++
 
 	size := r.mr.ReadInt32()
 	if size > -1 {
-		if vt, present := ValueTranscoders[fieldDescription.DataType]; present {
-			switch fieldDescription.FormatCode {
-			case 0:
-				return vt.DecodeText(r.mr, size)
-			case 1:
-				return vt.DecodeBinary(r.mr, size)
-			default:
-				return ProtocolError(fmt.Sprintf("Unknown field description format code: %v", fieldDescription.FormatCode))
-			}
-		} else {
-			return r.mr.ReadString(size)
-		}
+		return decodeValue(r.mr, fieldDescription, size)
 	} else {
 		return nil
 	}
 }
+
+// decodeValue decodes a non-NULL value of size bytes with ValueTranscoders.
+// Values of types without a transcoder are returned as strings.
+func decodeValue(mr *MessageReader, fieldDescription *FieldDescription, size int32) interface{} {
+	if vt, present := ValueTranscoders[fieldDescription.DataType]; present {
+		switch fieldDescription.FormatCode {
+		case 0:
+			return vt.DecodeText(mr, size)
+		case 1:
+			return vt.DecodeBinary(mr, size)
+		default:
+			return ProtocolError(fmt.Sprintf("Unknown field description format code: %v", fieldDescription.FormatCode))
+		}
+	}
+	return mr.ReadString(size)
+}
+
+// Err returns the first error of the accessors in the current row.
+func (r *DataRowReader) Err() error {
+	return r.err
+}
+
+// IsNull reports whether the next value is NULL without reading it.
+func (r *DataRowReader) IsNull() bool {
+	b := (*bytes.Buffer)(r.mr).Bytes()
+	return len(b) >= 4 && int32(binary.BigEndian.Uint32(b)) == -1
+}
+
+// Skip skips the next value.
+func (r *DataRowReader) Skip() {
+	r.next()
+}
+
+// ReadBytesUnsafe returns the next value as it was received, or nil for
+// NULL. The slice points into the receive buffer, so it is only valid until
+// the next row is read.
+func (r *DataRowReader) ReadBytesUnsafe() []byte {
+	_, src, _ := r.next()
+	return src
+}
+
+// ReadInt32 returns the next value, which must be an int2 or int4 that fits.
+func (r *DataRowReader) ReadInt32() int32 {
+	fd, src, ok := r.nextNotNull("int32")
+	if !ok {
+		return 0
+	}
+	n, err := scanInt(fd, src, 32)
+	r.setErr(fd, err)
+	return int32(n)
+}
+
+// ReadInt64 returns the next value, which must be an int2, int4 or int8.
+func (r *DataRowReader) ReadInt64() int64 {
+	fd, src, ok := r.nextNotNull("int64")
+	if !ok {
+		return 0
+	}
+	n, err := scanInt(fd, src, 64)
+	r.setErr(fd, err)
+	return n
+}
+
+// ReadFloat64 returns the next value, which must be a float4 or float8.
+func (r *DataRowReader) ReadFloat64() float64 {
+	fd, src, ok := r.nextNotNull("float64")
+	if !ok {
+		return 0
+	}
+	f, err := scanFloat(fd, src)
+	r.setErr(fd, err)
+	return f
+}
+
+// ReadBool returns the next value, which must be a bool.
+func (r *DataRowReader) ReadBool() bool {
+	fd, src, ok := r.nextNotNull("bool")
+	if !ok {
+		return false
+	}
+	b, err := scanBool(fd, src)
+	r.setErr(fd, err)
+	return b
+}
+
+// ReadTime returns the next value, which must be a date, timestamp or
+// timestamptz.
+func (r *DataRowReader) ReadTime() time.Time {
+	fd, src, ok := r.nextNotNull("time.Time")
+	if !ok {
+		return time.Time{}
+	}
+
+	var t time.Time
+	if fd.FormatCode == 1 {
+		r.setErr(fd, scanTime(fd, src, &t))
+		return t
+	}
+	switch v := decodeValue((*MessageReader)(bytes.NewBuffer(src)), fd, int32(len(src))).(type) {
+	case time.Time:
+		t = v
+	case ProtocolError:
+		r.setErr(fd, v)
+	default:
+		r.setErr(fd, fmt.Errorf("cannot read %T as time.Time", v))
+	}
+	return t
+}
+
+// next reads the next value, returning its field description and its bytes,
+// which are nil for NULL.
+func (r *DataRowReader) next() (fd *FieldDescription, src []byte, isNull bool) {
+	fd = &r.FieldDescriptions[r.currentFieldIdx]
+	r.currentFieldIdx++
+
+	size := r.mr.ReadInt32()
+	if size == -1 {
+		return fd, nil, true
+	}
+	return fd, (*bytes.Buffer)(r.mr).Next(int(size)), false
+}
+
+// nextNotNull reads the next value, setting Err if it is NULL.
+func (r *DataRowReader) nextNotNull(typeName string) (fd *FieldDescription, src []byte, ok bool) {
+	fd, src, isNull := r.next()
+	if isNull {
+		r.setErr(fd, fmt.Errorf("cannot read NULL as %s", typeName))
+		return fd, nil, false
+	}
+	return fd, src, true
+}
+
+func (r *DataRowReader) setErr(fd *FieldDescription, err error) {
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("Read column %d (%s): %v", r.currentFieldIdx-1, fd.Name, err)
+	}
+}
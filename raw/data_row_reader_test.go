@@ -0,0 +1,62 @@
+package raw_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestDataRowReaderAccessors(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const sql = "select 7::int2, 8::int4, 9::int8, 1.5::float8, true, '2001-02-03'::date, '2001-02-03 04:05:06'::timestamp, 'abc'::text, null::int4, 'skipped'::text"
+	if _, err := conn.Prepare("values", sql); err != nil {
+		t.Fatal(err)
+	}
+
+	// The prepared statement is read in binary and the simple query in text.
+	for _, query := range []string{"values", sql} {
+		var got []interface{}
+		err := conn.SelectFunc(query, func(r *raw.DataRowReader) error {
+			got = append(got, r.ReadInt32(), r.ReadInt32(), r.ReadInt64(), r.ReadFloat64(), r.ReadBool(), r.ReadTime().Format("2006-01-02"), r.ReadTime().UTC().Format("2006-01-02 15:04:05"), string(r.ReadBytesUnsafe()), r.IsNull())
+			r.Skip()
+			r.Skip()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		want := []interface{}{int32(7), int32(8), int64(9), 1.5, true, "2001-02-03", "2001-02-03 04:05:06", "abc", true}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	// Reading NULL or the wrong type is returned by SelectFunc.
+	err = conn.SelectFunc("select null::int4", func(r *raw.DataRowReader) error {
+		r.ReadInt32()
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "NULL") {
+		t.Errorf("expected an error reading NULL, got %v", err)
+	}
+	err = conn.SelectFunc("values", func(r *raw.DataRowReader) error {
+		r.ReadBool()
+		return nil
+	})
+	if err == nil {
+		t.Error("expected an error reading an int2 as bool")
+	}
+	if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+		t.Errorf("connection not usable after a read error: %v, %v", v, err)
+	}
+}
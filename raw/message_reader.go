@@ -8,9 +8,11 @@ import (
 // MessageReader is a helper that reads values from a PostgreSQL message.
 type MessageReader bytes.Buffer
 
-func (r *MessageReader) ReadByte() (b byte) {
-	b, _ = (*bytes.Buffer)(r).ReadByte()
-	return
+// ReadByte reads one byte. It has the signature of io.ByteReader, which go vet
+// requires of a method with this name; the error is io.EOF at the end of the
+// message.
+func (r *MessageReader) ReadByte() (byte, error) {
+	return (*bytes.Buffer)(r).ReadByte()
 }
 
 func (r *MessageReader) ReadInt16() (n int16) {
@@ -22,6 +22,12 @@ const (
 	bindComplete         = '2'
 	notificationResponse = 'A'
 	noData               = 'n'
+	copyInResponse       = 'G'
+	copyOutResponse      = 'H'
+	copyData             = 'd'
+	copyDone             = 'c'
+	copyFail             = 'f'
+	emptyQueryResponse   = 'I'
 )
 
 type startupMessage struct {
@@ -58,11 +64,32 @@ type FieldDescription struct {
 	FormatCode      int16
 }
 
+// PgError is an ErrorResponse from the server. See
+// https://www.postgresql.org/docs/current/protocol-error-fields.html for
+// the meaning of the fields; those the server did not send are empty.
 type PgError struct {
-	Severity string
-	Code     string
-	Message  string
-}
+	Severity         string
+	Code             string
+	Message          string
+	Detail           string
+	Hint             string
+	Position         int32
+	InternalPosition int32
+	InternalQuery    string
+	Where            string
+	SchemaName       string
+	TableName        string
+	ColumnName       string
+	DataTypeName     string
+	ConstraintName   string
+	File             string
+	Line             int32
+	Routine          string
+}
+
+// Notice is a NoticeResponse from the server, a message that is not an
+// error such as a warning. It has the same fields as PgError.
+type Notice PgError
 
 func (self PgError) Error() string {
 	return self.Severity + ": " + self.Message + " (SQLSTATE " + self.Code + ")"
@@ -88,8 +115,11 @@ func (wb *WriteBuf) closeMsg() {
 	binary.BigEndian.PutUint32(wb.buf[wb.sizeIdx:wb.sizeIdx+4], uint32(len(wb.buf)-wb.sizeIdx))
 }
 
-func (wb *WriteBuf) WriteByte(b byte) {
+// WriteByte appends b. It has the signature of io.ByteWriter, which go vet
+// requires of a method with this name, and always returns nil.
+func (wb *WriteBuf) WriteByte(b byte) error {
 	wb.buf = append(wb.buf, b)
+	return nil
 }
 
 func (wb *WriteBuf) WriteCString(s string) {
@@ -0,0 +1,242 @@
+package raw
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Numeric is an arbitrary-precision PostgreSQL numeric, Int * 10^Exp. Values
+// received from the server have Exp set to minus their scale, so 1.50 is 150
+// with Exp -2. NaN is set for the numeric NaN, with Int nil.
+type Numeric struct {
+	Int *big.Int
+	Exp int32
+	NaN bool
+}
+
+// ParseNumeric parses a decimal number such as -12.50 or 1e-3, or NaN.
+func ParseNumeric(s string) (Numeric, error) {
+	if strings.EqualFold(s, "NaN") {
+		return Numeric{NaN: true}, nil
+	}
+
+	var exp int64
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		var err error
+		if exp, err = strconv.ParseInt(s[i+1:], 10, 32); err != nil {
+			return Numeric{}, fmt.Errorf("Invalid numeric: %v", s)
+		}
+		s = s[:i]
+	}
+	digits := s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits = s[:i] + s[i+1:]
+		exp -= int64(len(s) - i - 1)
+	}
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok || exp < math.MinInt32 || exp > math.MaxInt32 {
+		return Numeric{}, fmt.Errorf("Invalid numeric: %v", s)
+	}
+	return Numeric{Int: n, Exp: int32(exp)}, nil
+}
+
+// String returns n in decimal notation without an exponent.
+func (n Numeric) String() string {
+	if n.NaN {
+		return "NaN"
+	}
+	if n.Int == nil {
+		return "0"
+	}
+
+	s := new(big.Int).Abs(n.Int).String()
+	switch {
+	case n.Exp > 0:
+		s += strings.Repeat("0", int(n.Exp))
+	case n.Exp < 0:
+		scale := int(-n.Exp)
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if n.Int.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Sign bits of the numeric binary format.
+const (
+	numericPos = 0x0000
+	numericNeg = 0x4000
+	numericNaN = 0xC000
+)
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func decodeNumericFromText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	n, err := ParseNumeric(s)
+	if err != nil {
+		return ProtocolError(err.Error())
+	}
+	return n
+}
+
+func decodeNumericFromBinary(mr *MessageReader, size int32) interface{} {
+	if size < 8 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a numeric: %d", size))
+	}
+	ndigits := mr.ReadInt16()
+	weight := mr.ReadInt16()
+	sign := uint16(mr.ReadInt16())
+	dscale := mr.ReadInt16()
+	if ndigits < 0 || size != 8+2*int32(ndigits) {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a numeric: %d", size))
+	}
+
+	// The digits are base 10000, the first of which is multiplied by
+	// 10000^weight.
+	n := new(big.Int)
+	var group int64
+	groupSize := 0
+	for i := int16(0); i < ndigits; i++ {
+		group = group*10000 + int64(mr.ReadInt16())
+		groupSize++
+		if groupSize == 4 || i == ndigits-1 {
+			// Add the digits in groups that fit an int64.
+			n.Mul(n, pow10(int32(groupSize*4)))
+			n.Add(n, big.NewInt(group))
+			group, groupSize = 0, 0
+		}
+	}
+
+	switch sign {
+	case numericPos:
+	case numericNeg:
+		n.Neg(n)
+	case numericNaN:
+		return Numeric{NaN: true}
+	default:
+		return ProtocolError(fmt.Sprintf("Received an unsupported numeric sign: %#x", sign))
+	}
+
+	exp := (int32(weight) - int32(ndigits) + 1) * 4
+	switch scale := -int32(dscale); {
+	case exp > scale:
+		n.Mul(n, pow10(exp-scale))
+	case exp < scale:
+		// The digits beyond the scale are zeros.
+		n.Quo(n, pow10(scale-exp))
+	}
+	return Numeric{Int: n, Exp: -int32(dscale)}
+}
+
+func encodeNumeric(w *WriteBuf, value interface{}) error {
+	var n Numeric
+	switch v := value.(type) {
+	case Numeric:
+		n = v
+	case *big.Int:
+		n = Numeric{Int: v}
+	case string:
+		var err error
+		if n, err = ParseNumeric(v); err != nil {
+			return err
+		}
+	case int:
+		n = Numeric{Int: big.NewInt(int64(v))}
+	case int16:
+		n = Numeric{Int: big.NewInt(int64(v))}
+	case int32:
+		n = Numeric{Int: big.NewInt(int64(v))}
+	case int64:
+		n = Numeric{Int: big.NewInt(v)}
+	case float32:
+		return encodeNumeric(w, float64(v))
+	case float64:
+		if math.IsNaN(v) {
+			n = Numeric{NaN: true}
+			break
+		}
+		if math.IsInf(v, 0) {
+			return fmt.Errorf("Cannot encode %v into numeric", v)
+		}
+		return encodeNumeric(w, strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("Expected Numeric, received %T", value)
+	}
+
+	if n.NaN {
+		sign := uint16(numericNaN)
+		w.WriteInt32(8)
+		w.WriteInt16(0)
+		w.WriteInt16(0)
+		w.WriteInt16(int16(sign))
+		w.WriteInt16(0)
+		return nil
+	}
+
+	abs := new(big.Int)
+	if n.Int != nil {
+		abs.Abs(n.Int)
+	}
+	exp := n.Exp
+	if exp > 0 {
+		abs.Mul(abs, pow10(exp))
+		exp = 0
+	}
+	dscale := int(-exp)
+	if dscale > math.MaxInt16 {
+		return fmt.Errorf("Cannot encode numeric with scale %d", dscale)
+	}
+
+	// Pad the decimal digits so the decimal point is between groups of four.
+	s := abs.String()
+	intLen := len(s) - dscale
+	if intLen < 0 {
+		s = strings.Repeat("0", -intLen) + s
+		intLen = 0
+	}
+	leftPad := (4 - intLen%4) % 4
+	s = strings.Repeat("0", leftPad) + s + strings.Repeat("0", (4-dscale%4)%4)
+
+	digits := make([]int16, len(s)/4)
+	for i := range digits {
+		d, _ := strconv.Atoi(s[i*4 : i*4+4])
+		digits[i] = int16(d)
+	}
+	weight := (intLen+leftPad)/4 - 1
+	for len(digits) > 0 && digits[0] == 0 {
+		digits = digits[1:]
+		weight--
+	}
+	for len(digits) > 0 && digits[len(digits)-1] == 0 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) == 0 {
+		weight = 0
+	}
+
+	sign := uint16(numericPos)
+	if n.Int != nil && n.Int.Sign() < 0 {
+		sign = numericNeg
+	}
+	w.WriteInt32(int32(8 + 2*len(digits)))
+	w.WriteInt16(int16(len(digits)))
+	w.WriteInt16(int16(weight))
+	w.WriteInt16(int16(sign))
+	w.WriteInt16(int16(dscale))
+	for _, d := range digits {
+		w.WriteInt16(d)
+	}
+	return nil
+}
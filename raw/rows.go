@@ -0,0 +1,443 @@
+package raw
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Rows is the result of Query. Its rows are read from the connection as Next
+// is called, and Scan decodes the values of the current row directly into its
+// destinations. The connection cannot be used for anything else until Rows
+// is closed, which Next does after the last row.
+type Rows struct {
+	conn      *Conn
+	fields    []FieldDescription
+	ps        *PreparedStatement // the statement queried, if it is prepared
+	mr        *MessageReader     // the current row
+	closed    bool
+	err       error
+	finish    func(error) error // stops watching the context, see Conn.watchContext
+	sql       string
+	args      []interface{}
+	startTime time.Time
+}
+
+// Query executes sql and returns its rows. sql can be either a prepared
+// statement name or an SQL string. arguments will be sanitized before being
+// interpolated into sql strings. arguments should be referenced positionally
+// from the sql string as $1, $2, etc.
+//
+// Only an error sending the query is returned here. Errors from the server
+// are returned by Err once Next returns false.
+func (c *Conn) Query(sql string, arguments ...interface{}) (*Rows, error) {
+	rows := &Rows{conn: c, sql: sql, args: arguments, startTime: time.Now()}
+
+	var err error
+	if ps, present := c.preparedStatements[sql]; present {
+		rows.fields = ps.FieldDescriptions
+		rows.ps = ps
+		err = c.sendPreparedQuery(ps, arguments...)
+	} else {
+		err = c.sendSimpleQuery(sql, arguments...)
+	}
+	if err != nil {
+		rows.closed = true
+		rows.err = err
+		c.logger.Error("Query", "sql", sql, "args", arguments, "error", err)
+		return nil, err
+	}
+	return rows, nil
+}
+
+// QueryContext is Query with a context. If ctx is done before the rows are
+// closed, the query is cancelled as SelectFuncContext is, and Err returns
+// ctx.Err().
+func (c *Conn) QueryContext(ctx context.Context, sql string, arguments ...interface{}) (*Rows, error) {
+	finish, err := c.watchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.Query(sql, arguments...)
+	if err != nil {
+		return nil, finish(err)
+	}
+	rows.finish = finish
+	return rows, nil
+}
+
+// FieldDescriptions returns the columns of the rows. For a query that is not
+// a prepared statement they are known once Next has been called.
+func (rows *Rows) FieldDescriptions() []FieldDescription {
+	return rows.fields
+}
+
+// Next reads the next row, reporting whether there is one. When there are no
+// more rows, or an error occurred, the rows are closed.
+func (rows *Rows) Next() bool {
+	if rows.closed {
+		return false
+	}
+	if rows.err != nil {
+		rows.Close()
+		return false
+	}
+	return rows.next()
+}
+
+// next reads up to the next row or ReadyForQuery, after which the rows are
+// closed. Rows are skipped once an error occurred.
+func (rows *Rows) next() bool {
+	c := rows.conn
+	for !rows.closed {
+		t, r, err := c.rxMsg()
+		if err != nil {
+			rows.setErr(err)
+			rows.close()
+			return false
+		}
+
+		switch t {
+		case rowDescription:
+			rows.fields = c.rxRowDescription(r)
+		case dataRow:
+			if rows.err != nil {
+				continue
+			}
+			if fieldCount := int(r.ReadInt16()); fieldCount != len(rows.fields) {
+				rows.setErr(ProtocolError(fmt.Sprintf("Row description field count (%v) and data row field count (%v) do not match", len(rows.fields), fieldCount)))
+				continue
+			}
+			rows.mr = r
+			return true
+		case bindComplete, commandComplete:
+		case readyForQuery:
+			c.rxReadyForQuery(r)
+			rows.close()
+		default:
+			if e := c.processContextFreeMsg(t, r); e != nil {
+				rows.setErr(e)
+			}
+		}
+	}
+	return false
+}
+
+// Close reads the rest of the response so the connection can be used again.
+// It is safe to call Close more than once.
+func (rows *Rows) Close() {
+	for rows.next() {
+	}
+}
+
+// close is called once the response has been read or the connection died.
+func (rows *Rows) close() {
+	rows.closed = true
+	rows.mr = nil
+	if rows.finish != nil {
+		rows.err = rows.finish(rows.err)
+		rows.finish = nil
+	}
+
+	c := rows.conn
+	if rows.err != nil {
+		c.logger.Error("Query", "sql", rows.sql, "args", rows.args, "error", rows.err)
+	} else {
+		c.logger.Info("Query", "sql", rows.sql, "args", rows.args, "time", time.Since(rows.startTime))
+	}
+}
+
+// Err returns the error that ended the rows, if any. It should be checked
+// once Next returns false.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+func (rows *Rows) setErr(err error) {
+	if rows.err == nil {
+		rows.err = err
+	}
+}
+
+// Values returns the values of the current row as decoded by
+// DataRowReader.ReadValue.
+func (rows *Rows) Values() ([]interface{}, error) {
+	if rows.mr == nil {
+		return nil, fmt.Errorf("Values called without a current row")
+	}
+
+	values := make([]interface{}, len(rows.fields))
+	for i := range rows.fields {
+		size := rows.mr.ReadInt32()
+		if size == -1 {
+			continue
+		}
+		v := decodeValue(rows.mr, &rows.fields[i], size)
+		if err, ok := v.(ProtocolError); ok {
+			rows.setErr(err)
+			return nil, err
+		}
+		values[i] = v
+	}
+	rows.mr = nil
+	return values, nil
+}
+
+// Scan reads the values of the current row into dest, which must have an
+// element for each column. A nil element skips its column. The supported
+// destinations are:
+//
+//   - *int16, *int32, *int64 and *int for integer columns
+//   - *float32 and *float64 for float columns
+//   - *bool for bool columns
+//   - *string for any column received in text format and for text columns
+//   - *[]byte for the bytes of a bytea column, or the value of any other
+//     column as it was received
+//   - *time.Time for date, timestamp and timestamptz columns
+//   - sql.Scanner, which is given the value as database/sql would be
+//   - *interface{}, which is given the value as decoded by ReadValue
+//   - a pointer to a pointer to any of the above, which is set to nil for
+//     NULL
+//   - a pointer to any type the column is decoded to by ValueTranscoders
+//
+// Only a pointer to a pointer, *interface{}, *[]byte and sql.Scanner accept
+// NULL. Scan reads the current row, so it can be called once per row.
+func (rows *Rows) Scan(dest ...interface{}) error {
+	if rows.mr == nil {
+		return fmt.Errorf("Scan called without a current row")
+	}
+	if len(dest) != len(rows.fields) {
+		err := fmt.Errorf("Scan received wrong number of arguments, got %d but expected %d", len(dest), len(rows.fields))
+		rows.setErr(err)
+		return err
+	}
+
+	mr := rows.mr
+	rows.mr = nil
+	for i, d := range dest {
+		size := mr.ReadInt32()
+		if err := scanValue(mr, &rows.fields[i], size, d); err != nil {
+			err = fmt.Errorf("Scan column %d (%s): %v", i, rows.fields[i].Name, err)
+			rows.setErr(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// scanValue reads a value of size bytes, -1 for NULL, from mr into dest.
+func scanValue(mr *MessageReader, fd *FieldDescription, size int32, dest interface{}) error {
+	buf := (*bytes.Buffer)(mr)
+	if size == -1 {
+		switch d := dest.(type) {
+		case nil:
+		case *interface{}:
+			*d = nil
+		case *[]byte:
+			*d = nil
+		case sql.Scanner:
+			return d.Scan(nil)
+		default:
+			v := reflect.ValueOf(dest)
+			if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Ptr {
+				return fmt.Errorf("cannot scan NULL into %T", dest)
+			}
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		}
+		return nil
+	}
+
+	switch d := dest.(type) {
+	case nil:
+		buf.Next(int(size))
+	case *int16:
+		n, err := scanInt(fd, buf.Next(int(size)), 16)
+		*d = int16(n)
+		return err
+	case *int32:
+		n, err := scanInt(fd, buf.Next(int(size)), 32)
+		*d = int32(n)
+		return err
+	case *int64:
+		n, err := scanInt(fd, buf.Next(int(size)), 64)
+		*d = n
+		return err
+	case *int:
+		n, err := scanInt(fd, buf.Next(int(size)), strconv.IntSize)
+		*d = int(n)
+		return err
+	case *float32:
+		f, err := scanFloat(fd, buf.Next(int(size)))
+		*d = float32(f)
+		return err
+	case *float64:
+		f, err := scanFloat(fd, buf.Next(int(size)))
+		*d = f
+		return err
+	case *bool:
+		b, err := scanBool(fd, buf.Next(int(size)))
+		*d = b
+		return err
+	case *string:
+		if fd.FormatCode == 1 && !isTextOid(fd.DataType) {
+			return fmt.Errorf("cannot scan type oid %d into %T", fd.DataType, dest)
+		}
+		*d = string(buf.Next(int(size)))
+	case *[]byte:
+		src := buf.Next(int(size))
+		if fd.DataType == Oid(17) && fd.FormatCode == 0 {
+			if len(src) < 2 || src[0] != '\\' || src[1] != 'x' {
+				return fmt.Errorf("invalid bytea")
+			}
+			*d = make([]byte, hex.DecodedLen(len(src)-2))
+			_, err := hex.Decode(*d, src[2:])
+			return err
+		}
+		*d = append([]byte(nil), src...)
+	case *time.Time:
+		if fd.FormatCode == 1 {
+			return scanTime(fd, buf.Next(int(size)), d)
+		}
+		return assignValue(mr, fd, size, dest)
+	case sql.Scanner:
+		v := decodeValue(mr, fd, size)
+		if err, ok := v.(ProtocolError); ok {
+			return err
+		}
+		// Pass the types database/sql drivers return, as sqlRows does.
+		switch n := v.(type) {
+		case int16:
+			v = int64(n)
+		case int32:
+			v = int64(n)
+		case float32:
+			v = float64(n)
+		}
+		return d.Scan(v)
+	case *interface{}:
+		v := decodeValue(mr, fd, size)
+		if err, ok := v.(ProtocolError); ok {
+			return err
+		}
+		*d = v
+	default:
+		v := reflect.ValueOf(dest)
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Ptr {
+			// Allocate the value the pointer points to and scan into it.
+			p := reflect.New(v.Elem().Type().Elem())
+			if err := scanValue(mr, fd, size, p.Interface()); err != nil {
+				return err
+			}
+			v.Elem().Set(p)
+			return nil
+		}
+		return assignValue(mr, fd, size, dest)
+	}
+	return nil
+}
+
+// isTextOid reports whether the binary format of oid is its text.
+func isTextOid(oid Oid) bool {
+	switch oid {
+	case Oid(18), Oid(19), Oid(25), Oid(114), Oid(705), Oid(1042), Oid(1043):
+		return true
+	}
+	return false
+}
+
+func scanInt(fd *FieldDescription, src []byte, bitSize int) (int64, error) {
+	var n int64
+	if fd.FormatCode == 0 {
+		var err error
+		if n, err = strconv.ParseInt(string(src), 10, 64); err != nil {
+			return 0, err
+		}
+	} else {
+		switch {
+		case fd.DataType == Oid(21) && len(src) == 2:
+			n = int64(int16(binary.BigEndian.Uint16(src)))
+		case fd.DataType == Oid(23) && len(src) == 4:
+			n = int64(int32(binary.BigEndian.Uint32(src)))
+		case fd.DataType == Oid(20) && len(src) == 8:
+			n = int64(binary.BigEndian.Uint64(src))
+		default:
+			return 0, fmt.Errorf("cannot scan type oid %d into an integer", fd.DataType)
+		}
+	}
+	if bitSize < 64 && (n < -1<<uint(bitSize-1) || n >= 1<<uint(bitSize-1)) {
+		return 0, fmt.Errorf("%d is out of range for int%d", n, bitSize)
+	}
+	return n, nil
+}
+
+func scanBool(fd *FieldDescription, src []byte) (bool, error) {
+	switch {
+	case fd.DataType != Oid(16):
+		return false, fmt.Errorf("cannot scan type oid %d into a bool", fd.DataType)
+	case fd.FormatCode == 1 && len(src) == 1:
+		return src[0] != 0, nil
+	case fd.FormatCode == 0 && len(src) == 1:
+		return src[0] == 't', nil
+	}
+	return false, fmt.Errorf("invalid bool")
+}
+
+func scanFloat(fd *FieldDescription, src []byte) (float64, error) {
+	if fd.FormatCode == 0 {
+		return strconv.ParseFloat(string(src), 64)
+	}
+	switch {
+	case fd.DataType == Oid(700) && len(src) == 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(src))), nil
+	case fd.DataType == Oid(701) && len(src) == 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(src)), nil
+	}
+	return 0, fmt.Errorf("cannot scan type oid %d into a float", fd.DataType)
+}
+
+// scanTime decodes a binary date, timestamp or timestamptz as the
+// ValueTranscoders do.
+func scanTime(fd *FieldDescription, src []byte, dest *time.Time) error {
+	const microsecFromUnixEpochToY2K = 946684800 * 1000000
+	switch {
+	case fd.DataType == Oid(1082) && len(src) == 4:
+		days := int32(binary.BigEndian.Uint32(src))
+		*dest = time.Date(2000, 1, int(1+days), 0, 0, 0, 0, time.Local)
+	case (fd.DataType == Oid(1114) || fd.DataType == Oid(1184)) && len(src) == 8:
+		microsec := int64(binary.BigEndian.Uint64(src)) + microsecFromUnixEpochToY2K
+		*dest = time.Unix(microsec/1000000, (microsec%1000000)*1000)
+		if fd.DataType == Oid(1114) {
+			*dest = dest.UTC()
+		}
+	default:
+		return fmt.Errorf("cannot scan type oid %d into a time.Time", fd.DataType)
+	}
+	return nil
+}
+
+// assignValue decodes the value with ValueTranscoders and assigns it to
+// dest, which must point to its type.
+func assignValue(mr *MessageReader, fd *FieldDescription, size int32, dest interface{}) error {
+	v := decodeValue(mr, fd, size)
+	if err, ok := v.(ProtocolError); ok {
+		return err
+	}
+
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return fmt.Errorf("cannot scan into %T, which is not a non-nil pointer", dest)
+	}
+	src := reflect.ValueOf(v)
+	if !src.Type().AssignableTo(d.Elem().Type()) {
+		return fmt.Errorf("cannot scan %T into %T", v, dest)
+	}
+	d.Elem().Set(src)
+	return nil
+}
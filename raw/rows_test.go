@@ -0,0 +1,129 @@
+package raw_test
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestRows(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Execute(`create table person(
+  id serial primary key,
+  first_name varchar(30) not null,
+  nickname text,
+  birth_date date not null,
+  weight int2 not null,
+  height float8 not null,
+  photo bytea
+);
+insert into person(first_name, nickname, birth_date, weight, height, photo) values
+  ('Adam', 'Ad', '1980-01-02', 80, 1.8, '\x0102'),
+  ('Eve', null, '1990-03-04', 60, 1.65, null),
+  ('Abel', null, '2000-05-06', 70, 1.75, null);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const selectSQL = "select id, first_name, nickname, birth_date, weight, height, photo from person where id >= $1 order by id"
+	if _, err := conn.Prepare("selectPeople", selectSQL); err != nil {
+		t.Fatal(err)
+	}
+
+	// The prepared statement is read in binary and the simple query in text.
+	for _, query := range []string{"selectPeople", selectSQL} {
+		rows, err := conn.Query(query, int32(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var id int64
+			var name string
+			var nickname sql.NullString
+			var birthDate time.Time
+			var weight int32
+			var height *float64
+			var photo []byte
+			if err := rows.Scan(&id, &name, &nickname, &birthDate, &weight, &height, &photo); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			got = append(got, fmt.Sprintf("%d %s %v %s %d %v %x", id, name, nickname, birthDate.Format("2006-01-02"), weight, *height, photo))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		want := []string{
+			"1 Adam {Ad true} 1980-01-02 80 1.8 0102",
+			"2 Eve { false} 1990-03-04 60 1.65 ",
+			"3 Abel { false} 2000-05-06 70 1.75 ",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", query, got, want)
+		}
+	}
+
+	expectUsable := func(name string) {
+		if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+			t.Errorf("%s: connection not usable: %v, %v", name, v, err)
+		}
+	}
+
+	// Close reads the rows that were not.
+	rows, err := conn.Query("selectPeople", int32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	rows.Close()
+	if rows.Next() || rows.Err() != nil {
+		t.Errorf("closed rows: Next returned true or Err %v", rows.Err())
+	}
+	expectUsable("closed")
+
+	// Scan errors end the rows, which are still read to the end.
+	rows, _ = conn.Query("selectPeople", int32(1))
+	rows.Next()
+	var id int32
+	var nickname string
+	if err := rows.Scan(&id, nil, nil, nil, nil, nil, &nickname); err == nil {
+		t.Error("expected an error scanning NULL into *string")
+	}
+	if rows.Next() || rows.Err() == nil {
+		t.Error("expected the scan error to end the rows")
+	}
+	expectUsable("scan error")
+
+	rows, _ = conn.Query("select * from missing")
+	if rows.Next() {
+		t.Error("Next returned true for a failed query")
+	}
+	if pgErr, ok := rows.Err().(raw.PgError); !ok || pgErr.Code != "42P01" {
+		t.Errorf("expected undefined_table error, got %v", rows.Err())
+	}
+	expectUsable("query error")
+
+	var values []interface{}
+	rows, _ = conn.Query("select 'x'::text, null::int4, 2::int8")
+	for rows.Next() {
+		if values, err = rows.Values(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []interface{}{"x", nil, int64(2)}; rows.Err() != nil || !reflect.DeepEqual(values, want) {
+		t.Errorf("Values returned %v, %v, want %v", values, rows.Err(), want)
+	}
+}
@@ -65,17 +65,19 @@ func (c *Conn) SanitizeSql(sql string, args ...interface{}) (output string, err
 		case []byte:
 			return `E'\\x` + hex.EncodeToString(arg) + `'`
 		case []int16:
-			var s string
-			s, err = int16SliceToArrayString(arg)
-			return c.QuoteString(s)
+			return c.quoteArray(Oid(1005), arg, &err)
 		case []int32:
-			var s string
-			s, err = int32SliceToArrayString(arg)
-			return c.QuoteString(s)
+			return c.quoteArray(Oid(1007), arg, &err)
 		case []int64:
-			var s string
-			s, err = int64SliceToArrayString(arg)
-			return c.QuoteString(s)
+			return c.quoteArray(Oid(1016), arg, &err)
+		case []float32:
+			return c.quoteArray(Oid(1021), arg, &err)
+		case []float64:
+			return c.quoteArray(Oid(1022), arg, &err)
+		case []bool:
+			return c.quoteArray(Oid(1000), arg, &err)
+		case []string:
+			return c.quoteArray(Oid(1009), arg, &err)
 		case nil:
 			return "null"
 		default:
@@ -87,3 +89,14 @@ func (c *Conn) SanitizeSql(sql string, args ...interface{}) (output string, err
 	output = literalPattern.ReplaceAllStringFunc(sql, replacer)
 	return
 }
+
+// quoteArray returns the quoted text of value as an array of type arrayOid. An
+// error is stored in err.
+func (c *Conn) quoteArray(arrayOid Oid, value interface{}, err *error) string {
+	w := &WriteBuf{}
+	if e := arrayCodecs[arrayOid].encodeText(w, value); e != nil {
+		*err = e
+		return ""
+	}
+	return c.QuoteString(string(w.buf[4:]))
+}
@@ -0,0 +1,216 @@
+package raw
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+)
+
+// SASL mechanisms supported by Conn.
+const (
+	scramSHA256     = "SCRAM-SHA-256"
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+)
+
+// scramClient is the client side of a SCRAM-SHA-256 exchange (RFC 5802 and
+// RFC 7677). The password is used as is, without SASLprep, which only matters
+// for passwords that are not ASCII.
+type scramClient struct {
+	mechanism         string
+	password          string
+	gs2Header         string // channel binding flag of the client-first-message
+	channelBinding    []byte // tls-server-end-point data for SCRAM-SHA-256-PLUS
+	clientNonce       string
+	clientFirstBare   string
+	serverSignature   []byte
+	expectServerFinal bool
+}
+
+// newSCRAMClient picks a mechanism from those offered by the server. It uses
+// SCRAM-SHA-256-PLUS when conn is a TLS connection and the server offers it.
+func newSCRAMClient(mechanisms []string, conn net.Conn, password string) (*scramClient, error) {
+	sc := &scramClient{password: password}
+
+	var offersPlus, offersPlain bool
+	for _, m := range mechanisms {
+		switch m {
+		case scramSHA256Plus:
+			offersPlus = true
+		case scramSHA256:
+			offersPlain = true
+		}
+	}
+
+	tlsConn, isTLS := conn.(*tls.Conn)
+	switch {
+	case isTLS && offersPlus:
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			return nil, errors.New("SCRAM-SHA-256-PLUS requires a server certificate")
+		}
+		cb, err := tlsServerEndPoint(certs[0])
+		if err != nil {
+			return nil, err
+		}
+		sc.mechanism = scramSHA256Plus
+		sc.gs2Header = "p=tls-server-end-point,,"
+		sc.channelBinding = cb
+	case offersPlain:
+		sc.mechanism = scramSHA256
+		// "y" tells the server the client supports channel binding but
+		// thinks the server does not, so a stripped PLUS can be detected.
+		if isTLS {
+			sc.gs2Header = "y,,"
+		} else {
+			sc.gs2Header = "n,,"
+		}
+	default:
+		return nil, fmt.Errorf("Server offered no supported SASL mechanism: %v", mechanisms)
+	}
+
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sc.clientNonce = base64.StdEncoding.EncodeToString(nonce)
+	return sc, nil
+}
+
+// clientFirstMessage returns the data of the SASLInitialResponse.
+func (sc *scramClient) clientFirstMessage() []byte {
+	// The user name is taken from the startup message, so it is left empty.
+	sc.clientFirstBare = "n=,r=" + sc.clientNonce
+	return []byte(sc.gs2Header + sc.clientFirstBare)
+}
+
+// clientFinalMessage returns the response to serverFirst, the data of the
+// AuthenticationSASLContinue.
+func (sc *scramClient) clientFinalMessage(serverFirst []byte) ([]byte, error) {
+	attrs, err := parseSCRAMAttributes(serverFirst)
+	if err != nil {
+		return nil, err
+	}
+	nonce, salt64, iterStr := attrs['r'], attrs['s'], attrs['i']
+	if len(nonce) <= len(sc.clientNonce) || nonce[:len(sc.clientNonce)] != sc.clientNonce {
+		return nil, errors.New("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SCRAM salt: %v", err)
+	}
+	iterations, err := strconv.Atoi(iterStr)
+	if err != nil || iterations <= 0 {
+		return nil, fmt.Errorf("Invalid SCRAM iteration count %q", iterStr)
+	}
+
+	cbind := append([]byte(sc.gs2Header), sc.channelBinding...)
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString(cbind) + ",r=" + nonce
+	authMessage := []byte(sc.clientFirstBare + "," + string(serverFirst) + "," + clientFinalWithoutProof)
+
+	saltedPassword := scramHi([]byte(sc.password), salt, iterations)
+	clientKey := scramHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSignature := scramHMAC(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := scramHMAC(saltedPassword, []byte("Server Key"))
+	sc.serverSignature = scramHMAC(serverKey, authMessage)
+	sc.expectServerFinal = true
+
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verifyServerFinal checks the server signature in serverFinal, the data of
+// the AuthenticationSASLFinal.
+func (sc *scramClient) verifyServerFinal(serverFinal []byte) error {
+	if !sc.expectServerFinal {
+		return errors.New("Received AuthenticationSASLFinal before AuthenticationSASLContinue")
+	}
+	attrs, err := parseSCRAMAttributes(serverFinal)
+	if err != nil {
+		return err
+	}
+	if e, ok := attrs['e']; ok {
+		return fmt.Errorf("SCRAM authentication failed: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs['v'])
+	if err != nil {
+		return fmt.Errorf("Invalid SCRAM server signature: %v", err)
+	}
+	if subtle.ConstantTimeCompare(signature, sc.serverSignature) != 1 {
+		return errors.New("SCRAM server signature does not match")
+	}
+	return nil
+}
+
+// parseSCRAMAttributes parses the comma separated a=value attributes of a
+// SCRAM message.
+func parseSCRAMAttributes(msg []byte) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, attr := range bytes.Split(msg, []byte{','}) {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, fmt.Errorf("Invalid SCRAM message %q", msg)
+		}
+		attrs[attr[0]] = string(attr[2:])
+	}
+	return attrs, nil
+}
+
+// scramHi is the Hi function of RFC 5802, PBKDF2 with HMAC-SHA-256 producing
+// one block.
+func scramHi(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func scramHMAC(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// tlsServerEndPoint returns the tls-server-end-point channel binding data of
+// cert (RFC 5929): its hash with the hash function of its signature, or
+// SHA-256 if that is MD5 or SHA-1.
+func tlsServerEndPoint(cert *x509.Certificate) ([]byte, error) {
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = sha512.New()
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1,
+		x509.SHA256WithRSA, x509.ECDSAWithSHA256, x509.SHA256WithRSAPSS, x509.DSAWithSHA256:
+		h = sha256.New()
+	default:
+		return nil, fmt.Errorf("Cannot compute channel binding for signature algorithm %v", cert.SignatureAlgorithm)
+	}
+	h.Write(cert.Raw)
+	return h.Sum(nil), nil
+}
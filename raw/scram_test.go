@@ -0,0 +1,109 @@
+package raw_test
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestSCRAM(t *testing.T) {
+	cert, roots, err := fakepg.SelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{
+		Auth:      fakepg.AuthSCRAMSHA256,
+		Password:  "secret",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.Addr().(*net.TCPAddr)
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		tls      bool
+		ok       bool
+	}{
+		{"scram-sha-256", "secret", false, true},
+		{"scram-sha-256-plus", "secret", true, true},
+		{"wrong password", "wrong", false, false},
+		{"wrong password with tls", "wrong", true, false},
+	} {
+		config := raw.ConnConfig{
+			Host:     addr.IP.String(),
+			Port:     uint16(addr.Port),
+			User:     "postgres",
+			Password: tt.password,
+			Database: "postgres",
+		}
+		if tt.tls {
+			config.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		}
+		conn, err := raw.Connect(config)
+		if !tt.ok {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: expected authentication to fail", tt.name)
+			} else if pgErr, ok := err.(raw.PgError); !ok || pgErr.Code != "28P01" {
+				t.Errorf("%s: expected invalid_password error, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if v, err := conn.SelectValue("select 1"); err != nil || v != int32(1) {
+			t.Errorf("%s: select returned %v, %v", tt.name, v, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestSCRAMRequiresServerFinal(t *testing.T) {
+	cert, roots, err := fakepg.SelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{
+		Auth:          fakepg.AuthSCRAMSHA256,
+		Password:      "secret",
+		TLSConfig:     &tls.Config{Certificates: []tls.Certificate{cert}},
+		SkipSASLFinal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.Addr().(*net.TCPAddr)
+
+	// The server sends AuthenticationOk straight after
+	// AuthenticationSASLContinue, so it never proves it knows the password.
+	for _, useTLS := range []bool{false, true} {
+		config := raw.ConnConfig{
+			Host:     addr.IP.String(),
+			Port:     uint16(addr.Port),
+			User:     "postgres",
+			Password: "secret",
+			Database: "postgres",
+		}
+		if useTLS {
+			config.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+		}
+		conn, err := raw.Connect(config)
+		if err == nil {
+			conn.Close()
+			t.Errorf("tls %v: expected connect to fail without AuthenticationSASLFinal", useTLS)
+		} else if !strings.Contains(err.Error(), "AuthenticationSASLFinal") {
+			t.Errorf("tls %v: unexpected error %v", useTLS, err)
+		}
+	}
+}
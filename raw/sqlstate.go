@@ -0,0 +1,90 @@
+package raw
+
+// SQLSTATE codes of errors that callers commonly handle. See
+// https://www.postgresql.org/docs/current/errcodes-appendix.html for the
+// full list.
+const (
+	SuccessfulCompletion = "00000"
+	Warning              = "01000"
+
+	ConnectionException = "08000"
+	ConnectionFailure   = "08006"
+
+	IntegrityConstraintViolation = "23000"
+	RestrictViolation            = "23001"
+	NotNullViolation             = "23502"
+	ForeignKeyViolation          = "23503"
+	UniqueViolation              = "23505"
+	CheckViolation               = "23514"
+	ExclusionViolation           = "23P01"
+
+	TransactionRollback  = "40000"
+	SerializationFailure = "40001"
+	DeadlockDetected     = "40P01"
+
+	SyntaxError       = "42601"
+	UndefinedColumn   = "42703"
+	UndefinedTable    = "42P01"
+	DuplicateTable    = "42P07"
+	InvalidPassword   = "28P01"
+	QueryCanceled     = "57014"
+	AdminShutdown     = "57P01"
+	LockNotAvailable  = "55P03"
+	DivisionByZero    = "22012"
+	InvalidTextFormat = "22P02"
+)
+
+// Class returns the class of the SQLSTATE of e, its first two characters,
+// e.g. "23" for integrity constraint violations.
+func (e PgError) Class() string {
+	if len(e.Code) < 2 {
+		return ""
+	}
+	return e.Code[:2]
+}
+
+// ErrorCode returns the SQLSTATE of err if it is a PgError, or "" if it is
+// not.
+func ErrorCode(err error) string {
+	switch e := err.(type) {
+	case PgError:
+		return e.Code
+	case *PgError:
+		return e.Code
+	}
+	return ""
+}
+
+// IsUniqueViolation reports whether err is a unique_violation.
+func IsUniqueViolation(err error) bool {
+	return ErrorCode(err) == UniqueViolation
+}
+
+// IsIntegrityConstraintViolation reports whether err is in the integrity
+// constraint violation class, such as a unique, foreign key or not null
+// violation.
+func IsIntegrityConstraintViolation(err error) bool {
+	code := ErrorCode(err)
+	return len(code) == 5 && code[:2] == "23"
+}
+
+// IsSerializationFailure reports whether err is a serialization_failure.
+func IsSerializationFailure(err error) bool {
+	return ErrorCode(err) == SerializationFailure
+}
+
+// IsDeadlockDetected reports whether err is a deadlock_detected.
+func IsDeadlockDetected(err error) bool {
+	return ErrorCode(err) == DeadlockDetected
+}
+
+// IsRetryable reports whether the transaction that failed with err can be
+// run again from the start and may succeed: serialization failures and
+// deadlocks, which PostgreSQL resolves by aborting one of the transactions.
+func IsRetryable(err error) bool {
+	switch ErrorCode(err) {
+	case SerializationFailure, DeadlockDetected:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package raw_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestStdlib(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	db, err := sql.Open("raw", fmt.Sprintf("postgres://%s:%s@%s:%d/%s", config.User, config.Password, config.Host, config.Port, config.Database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("create table person(id serial primary key, first_name varchar(30) not null, weight int4 not null)"); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare("insert into person(first_name, weight) values($1, $2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Adam", "Eve", "Seth"} {
+		if result, err := insert.Exec(name, 60+i); err != nil {
+			t.Fatal(err)
+		} else if n, _ := result.RowsAffected(); n != 1 {
+			t.Errorf("expected 1 row affected, got %d", n)
+		}
+	}
+	insert.Close()
+
+	stmt, err := db.Prepare("select id, first_name, weight from person where id >= $1 order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var id, weight int64
+		var name []byte
+		if err := rows.Scan(&id, &name, &weight); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s:%d", id, name, weight))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2:Eve:61,3:Seth:62"; strings.Join(got, ",") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ","), want)
+	}
+
+	// A simple query with several statements has a result set for each one
+	// that returns rows.
+	rows, err = db.Query("select first_name from person where id = 1; select 1; select weight from person where id = 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sets []string
+	for {
+		var values []string
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+		sets = append(sets, strings.Join(values, ","))
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(sets, ";"), "Adam;1;62"; got != want {
+		t.Errorf("result sets: got %q, want %q", got, want)
+	}
+
+	if _, err := db.Query("select from where"); raw.ErrorCode(err) != raw.SyntaxError {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("update person set weight = $1 where id = $2", 70, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var weight int32
+	if err := db.QueryRow("select weight from person where id = $1", 1).Scan(&weight); err != nil || weight != 70 {
+		t.Errorf("expected weight 70 after commit, got %d, %v", weight, err)
+	}
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := db.ExecContext(ctx, "select pg_sleep(10)"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled query took %v", elapsed)
+	}
+	var name string
+	if err := db.QueryRow("select first_name from person where id = $1", 2).Scan(&name); err != nil || name != "Eve" {
+		t.Errorf("expected Eve after a cancelled query, got %q, %v", name, err)
+	}
+}
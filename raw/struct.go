@@ -0,0 +1,289 @@
+package raw
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SelectStruct executes sql and scans its single row into the struct dest
+// points to. sql can be either a prepared statement name or an SQL string.
+// arguments will be sanitized before being interpolated into sql strings.
+// arguments should be referenced positionally from the sql string as $1, $2,
+// etc.
+//
+// Each column is scanned with Rows.Scan into the field it is mapped to. A
+// field is mapped to the column named by its sql tag, as go-pg's are, or else
+// to the column named by its name in snake case or by its name ignoring case,
+// so FirstName is mapped to first_name and Id to id. A tag of "-" and
+// zero-size fields, such as go-pg's TableName, are not mapped. The fields of
+// embedded structs are mapped as if they were fields of dest. It is an error
+// for a column not to be mapped to a field, or to be mapped to a field whose
+// type Rows.Scan cannot scan the column's type into. The mapping of a prepared
+// statement into a struct type is cached with the statement.
+//
+// Returns a NotSingleRowError if exactly one row is not found
+func (c *Conn) SelectStruct(dest interface{}, sql string, arguments ...interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("SelectStruct expects a pointer to a struct, received %T", dest)
+	}
+	v = v.Elem()
+
+	rows, err := c.Query(sql, arguments...)
+	if err != nil {
+		return err
+	}
+
+	var numRowsFound int64
+	var scanDest []interface{}
+	for rows.Next() {
+		numRowsFound++
+		if numRowsFound > 1 {
+			continue
+		}
+		plan, err := rows.structPlan(v.Type())
+		if err != nil {
+			rows.setErr(err)
+			break
+		}
+		scanDest = plan.dest(v, scanDest)
+		if err := rows.Scan(scanDest...); err != nil {
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if numRowsFound != 1 {
+		return NotSingleRowError{RowCount: numRowsFound}
+	}
+	return nil
+}
+
+// SelectStructs executes sql and scans each of its rows into a struct
+// appended to the slice dest points to, which is first truncated. The
+// elements of the slice can be structs or pointers to structs. Columns are
+// mapped to fields as by SelectStruct. sql can be either a prepared statement
+// name or an SQL string. arguments will be sanitized before being
+// interpolated into sql strings. arguments should be referenced positionally
+// from the sql string as $1, $2, etc.
+func (c *Conn) SelectStructs(dest interface{}, sql string, arguments ...interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("SelectStructs expects a pointer to a slice, received %T", dest)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	structType, isPtr := elemType, false
+	if elemType.Kind() == reflect.Ptr {
+		structType, isPtr = elemType.Elem(), true
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("SelectStructs expects a pointer to a slice of structs, received %T", dest)
+	}
+
+	rows, err := c.Query(sql, arguments...)
+	if err != nil {
+		return err
+	}
+
+	slice.SetLen(0)
+	var plan *structPlan
+	var scanDest []interface{}
+	for rows.Next() {
+		if plan == nil {
+			if plan, err = rows.structPlan(structType); err != nil {
+				rows.setErr(err)
+				break
+			}
+		}
+
+		var elem reflect.Value
+		if isPtr {
+			p := reflect.New(structType)
+			slice.Set(reflect.Append(slice, p))
+			elem = p.Elem()
+		} else {
+			// Append a zero struct and scan into it in place.
+			slice.Set(reflect.Append(slice, reflect.Zero(structType)))
+			elem = slice.Index(slice.Len() - 1)
+		}
+		scanDest = plan.dest(elem, scanDest)
+		if err := rows.Scan(scanDest...); err != nil {
+			break
+		}
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// structPlan maps the columns of a result to the fields of a struct type.
+type structPlan struct {
+	fields [][]int // the index of the field of each column, see reflect.Value.FieldByIndex
+}
+
+// dest returns the addresses of the fields of v to scan the columns into,
+// reusing buf.
+func (plan *structPlan) dest(v reflect.Value, buf []interface{}) []interface{} {
+	buf = buf[:0]
+	for _, index := range plan.fields {
+		var f reflect.Value
+		if len(index) == 1 {
+			f = v.Field(index[0])
+		} else {
+			f = v.FieldByIndex(index)
+		}
+		buf = append(buf, f.Addr().Interface())
+	}
+	return buf
+}
+
+// structPlanCache holds the plan of the columns of a prepared statement into
+// each struct type it has been selected into. The plan of a statement that is
+// not prepared is built for each query.
+type structPlanCache map[reflect.Type]*structPlan
+
+// structPlan returns the plan of the columns of rows into t.
+func (rows *Rows) structPlan(t reflect.Type) (*structPlan, error) {
+	if rows.ps == nil {
+		return newStructPlan(t, rows.fields)
+	}
+	if plan, ok := rows.ps.structPlans[t]; ok {
+		return plan, nil
+	}
+
+	plan, err := newStructPlan(t, rows.fields)
+	if err != nil {
+		return nil, err
+	}
+	if rows.ps.structPlans == nil {
+		rows.ps.structPlans = make(structPlanCache)
+	}
+	rows.ps.structPlans[t] = plan
+	return plan, nil
+}
+
+func newStructPlan(t reflect.Type, columns []FieldDescription) (*structPlan, error) {
+	tagged := make(map[string][]int)
+	var untagged []structField
+	mapStructFields(t, nil, tagged, &untagged)
+
+	plan := &structPlan{fields: make([][]int, len(columns))}
+	for i := range columns {
+		name := columns[i].Name
+		index, ok := tagged[name]
+		if !ok {
+			for _, f := range untagged {
+				if f.snakeName == name {
+					index, ok = f.index, true
+					break
+				}
+			}
+		}
+		if !ok {
+			for _, f := range untagged {
+				if strings.EqualFold(f.name, name) {
+					index, ok = f.index, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Column %s has no field in %v", name, t)
+		}
+		if f := t.FieldByIndex(index); !canScanInto(&columns[i], f.Type) {
+			return nil, fmt.Errorf("Column %s of type oid %d cannot be scanned into field %s %v of %v", name, columns[i].DataType, f.Name, f.Type, t)
+		}
+		plan.fields[i] = index
+	}
+	return plan, nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// canScanInto reports whether Rows.Scan can scan values of the column fd
+// into a value of type t, as far as it can be known from the type of the
+// column. It follows the destinations of scanValue that depend on the type
+// oid; the others are only checked by Scan.
+func canScanInto(fd *FieldDescription, t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return true
+	}
+	text := fd.FormatCode == 0
+	switch t {
+	case reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0)), reflect.TypeOf(int(0)):
+		return text || fd.DataType == Oid(20) || fd.DataType == Oid(21) || fd.DataType == Oid(23)
+	case reflect.TypeOf(float32(0)), reflect.TypeOf(float64(0)):
+		return text || fd.DataType == Oid(700) || fd.DataType == Oid(701)
+	case reflect.TypeOf(false):
+		return fd.DataType == Oid(16)
+	case reflect.TypeOf(""):
+		return text || isTextOid(fd.DataType)
+	case timeType:
+		return text || fd.DataType == Oid(1082) || fd.DataType == Oid(1114) || fd.DataType == Oid(1184)
+	}
+	if t.Kind() == reflect.Ptr {
+		return canScanInto(fd, t.Elem())
+	}
+	return true
+}
+
+// structField is a field without an sql tag.
+type structField struct {
+	name, snakeName string
+	index           []int
+}
+
+// mapStructFields adds the fields of t, whose index in the outermost struct
+// starts with index, to tagged by the name of their tag and to untagged.
+// Fields of embedded structs are added instead of the struct unless it is
+// tagged.
+func mapStructFields(t reflect.Type, index []int, tagged map[string][]int, untagged *[]structField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		tag := strings.Split(f.Tag.Get("sql"), ",")[0]
+		if tag == "-" || f.Type.Size() == 0 {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag == "" {
+			mapStructFields(f.Type, fieldIndex, tagged, untagged)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if tag != "" {
+			tagged[tag] = fieldIndex
+		} else {
+			*untagged = append(*untagged, structField{name: f.Name, snakeName: snakeCase(f.Name), index: fieldIndex})
+		}
+	}
+}
+
+// snakeCase converts a Go name to snake case, such as FirstName to
+// first_name and UserID to user_id.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a word at an upper case letter that follows a lower case
+			// one or that starts a word after an acronym.
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package raw_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestStructs(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	pool, err := raw.NewConnPool(raw.ConnPoolConfig{ConnConfig: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	_, err = pool.Execute(`create table person(
+  id serial primary key,
+  first_name varchar(30) not null,
+  nickname text,
+  birth_date date not null
+);
+insert into person(first_name, nickname, birth_date) values ('Adam', 'Ad', '1980-01-02'), ('Eve', null, '1990-03-04');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Prepare("selectPeople", "select id, first_name, nickname, birth_date from person order by id"); err != nil {
+		t.Fatal(err)
+	}
+
+	type named struct {
+		Name string `sql:"first_name"`
+	}
+	type person struct {
+		TableName struct{} `sql:"person"`
+		ID        int32
+		named
+		Nickname  *string
+		BirthDate time.Time
+		ignored   int
+	}
+	format := func(p *person) string {
+		nickname := "<nil>"
+		if p.Nickname != nil {
+			nickname = *p.Nickname
+		}
+		return fmt.Sprintf("%d %s %s %s", p.ID, p.Name, nickname, p.BirthDate.Format("2006-01-02"))
+	}
+	want := []string{"1 Adam Ad 1980-01-02", "2 Eve <nil> 1990-03-04"}
+
+	// The second query of each uses the cached plan.
+	for _, sql := range []string{"selectPeople", "selectPeople", "select id, first_name, nickname, birth_date from person order by id"} {
+		var people []person
+		if err := pool.SelectStructs(&people, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		var got []string
+		for i := range people {
+			got = append(got, format(&people[i]))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", sql, got, want)
+		}
+
+		pointers := []*person{{ID: -1}}
+		if err := pool.SelectStructs(&pointers, sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		got = got[:0]
+		for _, p := range pointers {
+			got = append(got, format(p))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: pointers: got %q, want %q", sql, got, want)
+		}
+	}
+
+	var p person
+	if err := pool.SelectStruct(&p, "select id, first_name, nickname, birth_date from person where id = $1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := format(&p); got != want[1] {
+		t.Errorf("SelectStruct: got %q, want %q", got, want[1])
+	}
+	if err := pool.SelectStruct(&p, "selectPeople"); err != (raw.NotSingleRowError{RowCount: 2}) {
+		t.Errorf("SelectStruct: expected NotSingleRowError, got %v", err)
+	}
+
+	var unmapped []named
+	if err := pool.SelectStructs(&unmapped, "selectPeople"); err == nil || !strings.Contains(err.Error(), "Column id has no field") {
+		t.Errorf("expected an unmapped column error, got %v", err)
+	}
+	if v, err := pool.SelectValue("select 1"); err != nil || v != int32(1) {
+		t.Errorf("connection not usable after an unmapped column: %v, %v", v, err)
+	}
+
+	// Column types are checked against field types when the plan is built.
+	var wrongType []struct {
+		ID string
+		named
+		Nickname  *string
+		BirthDate time.Time
+	}
+	if err := pool.SelectStructs(&wrongType, "selectPeople"); err == nil || !strings.Contains(err.Error(), "cannot be scanned into field ID string") {
+		t.Errorf("expected a column type error, got %v", err)
+	}
+
+	// Plans are cached per prepared statement, so a statement prepared again
+	// with the same column names and other types is planned again.
+	conn, err := pool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release(conn)
+	type id struct {
+		ID int32
+	}
+	var ids []id
+	if _, err := conn.Prepare("selectIDs", "select id from person order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SelectStructs(&ids, "selectIDs"); err != nil || !reflect.DeepEqual(ids, []id{{1}, {2}}) {
+		t.Errorf("selectIDs: got %v, %v", ids, err)
+	}
+	if err := conn.Deallocate("selectIDs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Prepare("selectIDs", "select first_name as id from person order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SelectStructs(&ids, "selectIDs"); err == nil || !strings.Contains(err.Error(), "cannot be scanned into field ID int32") {
+		t.Errorf("selectIDs prepared again: expected a column type error, got %v", err)
+	}
+}
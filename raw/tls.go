@@ -0,0 +1,66 @@
+package raw
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewTLSConfig returns the TLS config for a libpq sslmode: nil for disable,
+// no verification for prefer and require, verification of the certificate
+// chain for verify-ca and of the chain and host for verify-full. Certificates
+// are verified against the PEM roots in rootCertFile, or the system roots if
+// it is empty. prefer also needs ConnConfig.UseFallbackTLS.
+func NewTLSConfig(sslMode, host, rootCertFile string) (*tls.Config, error) {
+	var roots *x509.CertPool
+	switch sslMode {
+	case "disable":
+		return nil, nil
+	case "prefer", "require":
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	case "verify-ca", "verify-full":
+		if rootCertFile != "" {
+			pem, err := ioutil.ReadFile(rootCertFile)
+			if err != nil {
+				return nil, err
+			}
+			roots = x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("No certificates in %s", rootCertFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid sslmode: %s", sslMode)
+	}
+
+	if sslMode == "verify-full" {
+		return &tls.Config{ServerName: host, RootCAs: roots}, nil
+	}
+
+	// verify-ca checks the chain but not the host name, which crypto/tls only
+	// allows by skipping its own verification.
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("Server sent no certificate")
+			}
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, b := range rawCerts {
+				cert, err := x509.ParseCertificate(b)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		},
+	}, nil
+}
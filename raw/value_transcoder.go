@@ -1,12 +1,16 @@
 package raw
 
 import (
-	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math"
+	"net"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -46,6 +50,7 @@ func init() {
 	// bytea
 	ValueTranscoders[Oid(17)] = &ValueTranscoder{
 		DecodeText:   decodeByteaFromText,
+		DecodeBinary: decodeByteaFromBinary,
 		EncodeTo:     encodeBytea,
 		EncodeFormat: 1}
 
@@ -72,8 +77,23 @@ func init() {
 
 	// text
 	ValueTranscoders[Oid(25)] = &ValueTranscoder{
-		DecodeText: decodeTextFromText,
-		EncodeTo:   encodeText}
+		DecodeText:   decodeTextFromText,
+		DecodeBinary: decodeTextFromBinary,
+		EncodeTo:     encodeText}
+
+	// json -- the binary format is the text
+	ValueTranscoders[Oid(114)] = &ValueTranscoder{
+		DecodeText:   decodeTextFromText,
+		DecodeBinary: decodeTextFromBinary,
+		EncodeTo:     encodeJSON,
+		EncodeFormat: 1}
+
+	// cidr
+	ValueTranscoders[Oid(650)] = &ValueTranscoder{
+		DecodeText:   decodeInetFromText,
+		DecodeBinary: decodeInetFromBinary,
+		EncodeTo:     encodeCidr,
+		EncodeFormat: 1}
 
 	// float4
 	ValueTranscoders[Oid(700)] = &ValueTranscoder{
@@ -89,20 +109,19 @@ func init() {
 		EncodeTo:     encodeFloat8,
 		EncodeFormat: 1}
 
-	// int2[]
-	ValueTranscoders[Oid(1005)] = &ValueTranscoder{
-		DecodeText: decodeInt2ArrayFromText,
-		EncodeTo:   encodeInt2Array}
-
-	// int4[]
-	ValueTranscoders[Oid(1007)] = &ValueTranscoder{
-		DecodeText: decodeInt4ArrayFromText,
-		EncodeTo:   encodeInt4Array}
+	// macaddr
+	ValueTranscoders[Oid(829)] = &ValueTranscoder{
+		DecodeText:   decodeMacaddrFromText,
+		DecodeBinary: decodeMacaddrFromBinary,
+		EncodeTo:     encodeMacaddr,
+		EncodeFormat: 1}
 
-	// int8[]
-	ValueTranscoders[Oid(1016)] = &ValueTranscoder{
-		DecodeText: decodeInt8ArrayFromText,
-		EncodeTo:   encodeInt8Array}
+	// inet
+	ValueTranscoders[Oid(869)] = &ValueTranscoder{
+		DecodeText:   decodeInetFromText,
+		DecodeBinary: decodeInetFromBinary,
+		EncodeTo:     encodeInet,
+		EncodeFormat: 1}
 
 	// varchar -- same as text
 	ValueTranscoders[Oid(1043)] = ValueTranscoders[Oid(25)]
@@ -111,21 +130,91 @@ func init() {
 	ValueTranscoders[Oid(1082)] = &ValueTranscoder{
 		DecodeText:   decodeDateFromText,
 		DecodeBinary: decodeDateFromBinary,
-		EncodeTo:     encodeDate}
+		EncodeTo:     encodeDate,
+		EncodeFormat: 1}
+
+	// time
+	ValueTranscoders[Oid(1083)] = &ValueTranscoder{
+		DecodeText:   decodeTimeFromText,
+		DecodeBinary: decodeTimeFromBinary,
+		EncodeTo:     encodeTime,
+		EncodeFormat: 1}
+
+	// timestamp
+	ValueTranscoders[Oid(1114)] = &ValueTranscoder{
+		DecodeText:   decodeTimestampFromText,
+		DecodeBinary: decodeTimestampFromBinary,
+		EncodeTo:     encodeTimestamp,
+		EncodeFormat: 1}
 
 	// timestamptz
 	ValueTranscoders[Oid(1184)] = &ValueTranscoder{
 		DecodeText:   decodeTimestampTzFromText,
 		DecodeBinary: decodeTimestampTzFromBinary,
-		EncodeTo:     encodeTimestampTz}
+		EncodeTo:     encodeTimestampTz,
+		EncodeFormat: 1}
 
-	// use text transcoder for anything we don't understand
-	defaultTranscoder = ValueTranscoders[Oid(25)]
+	// interval
+	ValueTranscoders[Oid(1186)] = &ValueTranscoder{
+		DecodeText:   decodeIntervalFromText,
+		DecodeBinary: decodeIntervalFromBinary,
+		EncodeTo:     encodeInterval,
+		EncodeFormat: 1}
+
+	// numeric
+	ValueTranscoders[Oid(1700)] = &ValueTranscoder{
+		DecodeText:   decodeNumericFromText,
+		DecodeBinary: decodeNumericFromBinary,
+		EncodeTo:     encodeNumeric,
+		EncodeFormat: 1}
+
+	// uuid
+	ValueTranscoders[Oid(2950)] = &ValueTranscoder{
+		DecodeText:   decodeUUIDFromText,
+		DecodeBinary: decodeUUIDFromBinary,
+		EncodeTo:     encodeUUID,
+		EncodeFormat: 1}
+
+	// jsonb
+	ValueTranscoders[Oid(3802)] = &ValueTranscoder{
+		DecodeText:   decodeTextFromText,
+		DecodeBinary: decodeJSONBFromBinary,
+		EncodeTo:     encodeJSONB,
+		EncodeFormat: 1}
+
+	// use text for anything we don't understand; its binary format is
+	// unknown
+	defaultTranscoder = &ValueTranscoder{
+		DecodeText: decodeTextFromText,
+		EncodeTo:   encodeText}
+
+	// arrays of the types above
+	RegisterArrayType(Oid(1000), Oid(16), reflect.TypeOf(false))
+	RegisterArrayType(Oid(1001), Oid(17), reflect.TypeOf([]byte(nil)))
+	RegisterArrayType(Oid(1005), Oid(21), reflect.TypeOf(int16(0)))
+	RegisterArrayType(Oid(1007), Oid(23), reflect.TypeOf(int32(0)))
+	RegisterArrayType(Oid(1009), Oid(25), reflect.TypeOf(""))
+	RegisterArrayType(Oid(1015), Oid(1043), reflect.TypeOf(""))
+	RegisterArrayType(Oid(1016), Oid(20), reflect.TypeOf(int64(0)))
+	RegisterArrayType(Oid(1021), Oid(700), reflect.TypeOf(float32(0)))
+	RegisterArrayType(Oid(1022), Oid(701), reflect.TypeOf(float64(0)))
+	RegisterArrayType(Oid(1182), Oid(1082), reflect.TypeOf(time.Time{}))
+	RegisterArrayType(Oid(1185), Oid(1184), reflect.TypeOf(time.Time{}))
+	RegisterArrayType(Oid(199), Oid(114), reflect.TypeOf(""))
+	RegisterArrayType(Oid(651), Oid(650), reflect.TypeOf((*net.IPNet)(nil)))
+	RegisterArrayType(Oid(1040), Oid(829), reflect.TypeOf(net.HardwareAddr(nil)))
+	RegisterArrayType(Oid(1041), Oid(869), reflect.TypeOf((*net.IPNet)(nil)))
+	RegisterArrayType(Oid(1115), Oid(1114), reflect.TypeOf(time.Time{}))
+	RegisterArrayType(Oid(1183), Oid(1083), reflect.TypeOf(time.Duration(0)))
+	RegisterArrayType(Oid(1187), Oid(1186), reflect.TypeOf(Interval{}))
+	RegisterArrayType(Oid(1231), Oid(1700), reflect.TypeOf(Numeric{}))
+	RegisterArrayType(Oid(2951), Oid(2950), reflect.TypeOf([16]byte{}))
+	RegisterArrayType(Oid(3807), Oid(3802), reflect.TypeOf(""))
 }
 
 var arrayEl *regexp.Regexp = regexp.MustCompile(`[{,](?:"((?:[^"\\]|\\.)*)"|(NULL)|([^,}]+))`)
 
-// SplitArrayText is used by array transcoders to split array text into elements
+// SplitArrayText splits the text of a one-dimensional array into elements
 func SplitArrayText(text string) (elements []string) {
 	matches := arrayEl.FindAllStringSubmatch(text, -1)
 	elements = make([]string, 0, len(matches))
@@ -157,7 +246,7 @@ func decodeBoolFromBinary(mr *MessageReader, size int32) interface{} {
 	if size != 1 {
 		return ProtocolError(fmt.Sprintf("Received an invalid size for an bool: %d", size))
 	}
-	b := mr.ReadByte()
+	b, _ := mr.ReadByte()
 	return b != 0
 }
 
@@ -379,7 +468,7 @@ func encodeFloat4(w *WriteBuf, value interface{}) error {
 		v = float32(value)
 	case float64:
 		if value > math.MaxFloat32 {
-			return fmt.Errorf("%T %f is larger than max float32 %f", value, math.MaxFloat32)
+			return fmt.Errorf("%T %f is larger than max float32 %f", value, value, math.MaxFloat32)
 		}
 		v = float32(value)
 	default:
@@ -436,6 +525,10 @@ func decodeTextFromText(mr *MessageReader, size int32) interface{} {
 	return mr.ReadString(size)
 }
 
+func decodeTextFromBinary(mr *MessageReader, size int32) interface{} {
+	return mr.ReadString(size)
+}
+
 func encodeText(w *WriteBuf, value interface{}) error {
 	s, ok := value.(string)
 	if !ok {
@@ -457,6 +550,10 @@ func decodeByteaFromText(mr *MessageReader, size int32) interface{} {
 	return b
 }
 
+func decodeByteaFromBinary(mr *MessageReader, size int32) interface{} {
+	return []byte(mr.ReadString(size))
+}
+
 func encodeBytea(w *WriteBuf, value interface{}) error {
 	b, ok := value.([]byte)
 	if !ok {
@@ -489,8 +586,12 @@ func encodeDate(w *WriteBuf, value interface{}) error {
 		return fmt.Errorf("Expected time.Time, received %T", value)
 	}
 
-	s := t.Format("2006-01-02")
-	return encodeText(w, s)
+	// The date is the one t is in its own location.
+	days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix()/86400 - 10957
+	w.WriteInt32(4)
+	w.WriteInt32(int32(days))
+
+	return nil
 }
 
 func decodeTimestampTzFromText(mr *MessageReader, size int32) interface{} {
@@ -522,191 +623,424 @@ func encodeTimestampTz(w *WriteBuf, value interface{}) error {
 		return fmt.Errorf("Expected time.Time, received %T", value)
 	}
 
-	s := t.Format("2006-01-02 15:04:05.999999 -0700")
-	return encodeText(w, s)
+	microsecFromUnixEpochToY2K := int64(946684800 * 1000000)
+	microsecSinceUnixEpoch := t.Unix()*1000000 + int64(t.Nanosecond())/1000
+	w.WriteInt32(8)
+	w.WriteInt64(microsecSinceUnixEpoch - microsecFromUnixEpochToY2K)
+
+	return nil
 }
 
-func decodeInt2ArrayFromText(mr *MessageReader, size int32) interface{} {
+func decodeTimestampFromText(mr *MessageReader, size int32) interface{} {
 	s := mr.ReadString(size)
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", s, time.UTC)
+	if err != nil {
+		return ProtocolError(fmt.Sprintf("Can't decode timestamp: %v - %v", err, s))
+	}
+	return t
+}
 
-	elements := SplitArrayText(s)
-
-	numbers := make([]int16, 0, len(elements))
+// decodeTimestampFromBinary returns the timestamp, which has no time zone, in
+// UTC.
+func decodeTimestampFromBinary(mr *MessageReader, size int32) interface{} {
+	t := decodeTimestampTzFromBinary(mr, size)
+	if t, ok := t.(time.Time); ok {
+		return t.UTC()
+	}
+	return t
+}
 
-	for _, e := range elements {
-		n, err := strconv.ParseInt(e, 10, 16)
-		if err != nil {
-			return ProtocolError(fmt.Sprintf("Received invalid int2[]: %v", s))
-		}
-		numbers = append(numbers, int16(n))
+// encodeTimestamp encodes the date and time of day of a time.Time in its own
+// location.
+func encodeTimestamp(w *WriteBuf, value interface{}) error {
+	t, ok := value.(time.Time)
+	if !ok {
+		return fmt.Errorf("Expected time.Time, received %T", value)
 	}
 
-	return numbers
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return encodeTimestampTz(w, t)
 }
 
-func int16SliceToArrayString(nums []int16) (string, error) {
-	w := &bytes.Buffer{}
-	_, err := w.WriteString("{")
+// decodeTimeFromText decodes a time of day as the time.Duration since
+// midnight.
+func decodeTimeFromText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	d, err := parseClock(s)
 	if err != nil {
-		return "", err
+		return ProtocolError(fmt.Sprintf("Can't decode time: %v", s))
 	}
+	return d
+}
 
-	for i, n := range nums {
-		if i > 0 {
-			_, err = w.WriteString(",")
-			if err != nil {
-				return "", err
-			}
-		}
-
-		_, err = w.WriteString(strconv.FormatInt(int64(n), 10))
-		if err != nil {
-			return "", err
-		}
+// parseClock parses a time of day such as 15:04:05.999999.
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Invalid time: %v", s)
 	}
-
-	_, err = w.WriteString("}")
-	if err != nil {
-		return "", err
+	h, err1 := strconv.ParseUint(parts[0], 10, 32)
+	m, err2 := strconv.ParseUint(parts[1], 10, 32)
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil || strings.ContainsAny(parts[2], "eE+-") {
+		return 0, fmt.Errorf("Invalid time: %v", s)
 	}
-
-	return w.String(), nil
+	microsec := int64(math.Round(sec * 1000000))
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(microsec)*time.Microsecond, nil
 }
 
-func encodeInt2Array(w *WriteBuf, value interface{}) error {
-	v, ok := value.([]int16)
-	if !ok {
-		return fmt.Errorf("Expected []int16, received %T", value)
+func decodeTimeFromBinary(mr *MessageReader, size int32) interface{} {
+	if size != 8 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a time: %d", size))
 	}
+	return time.Duration(mr.ReadInt64()) * time.Microsecond
+}
 
-	s, err := int16SliceToArrayString(v)
-	if err != nil {
-		return fmt.Errorf("Failed to encode []int16: %v", err)
+// encodeTime encodes a time.Duration since midnight, or the time of day of a
+// time.Time in its own location.
+func encodeTime(w *WriteBuf, value interface{}) error {
+	var d time.Duration
+	switch v := value.(type) {
+	case time.Duration:
+		d = v
+	case time.Time:
+		d = time.Duration(v.Hour())*time.Hour + time.Duration(v.Minute())*time.Minute +
+			time.Duration(v.Second())*time.Second + time.Duration(v.Nanosecond())
+	default:
+		return fmt.Errorf("Expected time.Duration or time.Time, received %T", value)
 	}
 
-	return encodeText(w, s)
+	w.WriteInt32(8)
+	w.WriteInt64(int64(d / time.Microsecond))
+	return nil
 }
 
-func decodeInt4ArrayFromText(mr *MessageReader, size int32) interface{} {
-	s := mr.ReadString(size)
-
-	elements := SplitArrayText(s)
+// Interval is a PostgreSQL interval. Its months, days and microseconds are
+// kept apart because the length of a month or day depends on the date it is
+// added to.
+type Interval struct {
+	Microseconds int64
+	Days         int32
+	Months       int32
+}
 
-	numbers := make([]int32, 0, len(elements))
+// decodeIntervalFromText decodes the postgres IntervalStyle, such as
+// 1 year 2 mons -3 days +04:05:06.5.
+func decodeIntervalFromText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	var iv Interval
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			neg := strings.HasPrefix(f, "-")
+			d, err := parseClock(strings.TrimLeft(f, "+-"))
+			if err != nil {
+				return ProtocolError(fmt.Sprintf("Can't decode interval: %v", s))
+			}
+			if neg {
+				d = -d
+			}
+			iv.Microseconds += int64(d / time.Microsecond)
+			continue
+		}
 
-	for _, e := range elements {
-		n, err := strconv.ParseInt(e, 10, 16)
-		if err != nil {
-			return ProtocolError(fmt.Sprintf("Received invalid int4[]: %v", s))
+		n, err := strconv.ParseInt(f, 10, 32)
+		if err != nil || i+1 == len(fields) {
+			return ProtocolError(fmt.Sprintf("Can't decode interval: %v", s))
+		}
+		i++
+		switch strings.TrimSuffix(fields[i], "s") {
+		case "year":
+			iv.Months += int32(n) * 12
+		case "mon":
+			iv.Months += int32(n)
+		case "day":
+			iv.Days += int32(n)
+		default:
+			return ProtocolError(fmt.Sprintf("Can't decode interval: %v", s))
 		}
-		numbers = append(numbers, int32(n))
 	}
+	return iv
+}
 
-	return numbers
+func decodeIntervalFromBinary(mr *MessageReader, size int32) interface{} {
+	if size != 16 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for an interval: %d", size))
+	}
+	var iv Interval
+	iv.Microseconds = mr.ReadInt64()
+	iv.Days = mr.ReadInt32()
+	iv.Months = mr.ReadInt32()
+	return iv
 }
 
-func int32SliceToArrayString(nums []int32) (string, error) {
-	w := &bytes.Buffer{}
+// encodeInterval encodes an Interval, or a time.Duration as microseconds.
+func encodeInterval(w *WriteBuf, value interface{}) error {
+	var iv Interval
+	switch v := value.(type) {
+	case Interval:
+		iv = v
+	case time.Duration:
+		iv.Microseconds = int64(v / time.Microsecond)
+	default:
+		return fmt.Errorf("Expected Interval or time.Duration, received %T", value)
+	}
 
-	_, err := w.WriteString("{")
+	w.WriteInt32(16)
+	w.WriteInt64(iv.Microseconds)
+	w.WriteInt32(iv.Days)
+	w.WriteInt32(iv.Months)
+	return nil
+}
+
+// decodeUUIDFromText decodes a uuid such as
+// a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11.
+func decodeUUIDFromText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	u, err := parseUUID(s)
 	if err != nil {
-		return "", err
+		return ProtocolError(err.Error())
 	}
+	return u
+}
 
-	for i, n := range nums {
-		if i > 0 {
-			_, err = w.WriteString(",")
-			if err != nil {
-				return "", err
-			}
-		}
+func parseUUID(s string) (u [16]byte, err error) {
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != 16 {
+		return u, fmt.Errorf("Invalid uuid: %v", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
 
-		_, err = w.WriteString(strconv.FormatInt(int64(n), 10))
-		if err != nil {
-			return "", err
-		}
+func decodeUUIDFromBinary(mr *MessageReader, size int32) interface{} {
+	if size != 16 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a uuid: %d", size))
 	}
+	var u [16]byte
+	copy(u[:], mr.ReadString(size))
+	return u
+}
 
-	_, err = w.WriteString("}")
-	if err != nil {
-		return "", err
+// encodeUUID encodes a [16]byte, a []byte of length 16 or a string in the
+// uuid text format.
+func encodeUUID(w *WriteBuf, value interface{}) error {
+	var u [16]byte
+	switch v := value.(type) {
+	case [16]byte:
+		u = v
+	case []byte:
+		if len(v) != 16 {
+			return fmt.Errorf("Expected 16 bytes for uuid, received %d", len(v))
+		}
+		copy(u[:], v)
+	case string:
+		var err error
+		if u, err = parseUUID(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Expected [16]byte, received %T", value)
 	}
 
-	return w.String(), nil
+	w.WriteInt32(16)
+	w.WriteBytes(u[:])
+	return nil
 }
 
-func encodeInt4Array(w *WriteBuf, value interface{}) error {
-	v, ok := value.([]int32)
-	if !ok {
-		return fmt.Errorf("Expected []int32, received %T", value)
+// encodeJSON encodes a string or []byte as it is and anything else with
+// json.Marshal.
+func encodeJSON(w *WriteBuf, value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("Failed to encode json: %v", err)
+		}
 	}
 
-	s, err := int32SliceToArrayString(v)
-	if err != nil {
-		return fmt.Errorf("Failed to encode []int32: %v", err)
+	w.WriteInt32(int32(len(b)))
+	w.WriteBytes(b)
+	return nil
+}
+
+// The binary format of jsonb is a version byte followed by the json text.
+const jsonbVersion = 1
+
+func decodeJSONBFromBinary(mr *MessageReader, size int32) interface{} {
+	if size < 1 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a jsonb: %d", size))
+	}
+	if version, _ := mr.ReadByte(); version != jsonbVersion {
+		mr.ReadString(size - 1)
+		return ProtocolError(fmt.Sprintf("Received an unsupported jsonb version: %d", version))
 	}
+	return mr.ReadString(size - 1)
+}
 
-	return encodeText(w, s)
+func encodeJSONB(w *WriteBuf, value interface{}) error {
+	sizeIdx := len(w.buf)
+	if err := encodeJSON(w, value); err != nil {
+		return err
+	}
+	// Insert the version after the size.
+	w.buf = append(w.buf, 0)
+	copy(w.buf[sizeIdx+5:], w.buf[sizeIdx+4:])
+	w.buf[sizeIdx+4] = jsonbVersion
+	binary.BigEndian.PutUint32(w.buf[sizeIdx:], uint32(len(w.buf)-sizeIdx-4))
+	return nil
 }
 
-func decodeInt8ArrayFromText(mr *MessageReader, size int32) interface{} {
+// decodeInetFromText decodes an inet or cidr, such as 192.168.0.1/24, into a
+// *net.IPNet. The IP keeps its host bits, as in inet.
+func decodeInetFromText(mr *MessageReader, size int32) interface{} {
 	s := mr.ReadString(size)
+	ipNet, err := parseInet(s)
+	if err != nil {
+		return ProtocolError(err.Error())
+	}
+	return ipNet
+}
 
-	elements := SplitArrayText(s)
-
-	numbers := make([]int64, 0, len(elements))
-
-	for _, e := range elements {
-		n, err := strconv.ParseInt(e, 10, 16)
+func parseInet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		ip, ipNet, err := net.ParseCIDR(s)
 		if err != nil {
-			return ProtocolError(fmt.Sprintf("Received invalid int8[]: %v", s))
+			return nil, fmt.Errorf("Invalid inet: %v", s)
 		}
-		numbers = append(numbers, int64(n))
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.IPNet{IP: ip, Mask: ipNet.Mask}, nil
 	}
 
-	return numbers
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid inet: %v", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
 }
 
-func int64SliceToArrayString(nums []int64) (string, error) {
-	w := &bytes.Buffer{}
+// Address families of the inet binary format.
+const (
+	pgAFInet  = 2
+	pgAFInet6 = 3
+)
 
-	_, err := w.WriteString("{")
-	if err != nil {
-		return "", err
+func decodeInetFromBinary(mr *MessageReader, size int32) interface{} {
+	if size != 8 && size != 20 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for an inet: %d", size))
 	}
+	mr.ReadByte() // family, which the address length tells
+	bits, _ := mr.ReadByte()
+	mr.ReadByte() // is cidr
+	n, _ := mr.ReadByte()
+	if int32(n) != size-4 || int(bits) > int(n)*8 {
+		mr.ReadString(size - 4)
+		return ProtocolError("Received an invalid inet")
+	}
+	ip := net.IP(mr.ReadString(int32(n)))
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(int(bits), int(n)*8)}
+}
 
-	for i, n := range nums {
-		if i > 0 {
-			_, err = w.WriteString(",")
-			if err != nil {
-				return "", err
-			}
-		}
+func encodeInet(w *WriteBuf, value interface{}) error {
+	return encodeInetOrCidr(w, value, 0)
+}
 
-		_, err = w.WriteString(strconv.FormatInt(int64(n), 10))
+func encodeCidr(w *WriteBuf, value interface{}) error {
+	return encodeInetOrCidr(w, value, 1)
+}
+
+// encodeInetOrCidr encodes a *net.IPNet, net.IPNet, net.IP or a string in
+// the inet text format.
+func encodeInetOrCidr(w *WriteBuf, value interface{}, isCidr byte) error {
+	var ipNet net.IPNet
+	switch v := value.(type) {
+	case *net.IPNet:
+		ipNet = *v
+	case net.IPNet:
+		ipNet = v
+	case net.IP:
+		ipNet = net.IPNet{IP: v, Mask: net.CIDRMask(len(v)*8, len(v)*8)}
+	case string:
+		p, err := parseInet(v)
 		if err != nil {
-			return "", err
+			return err
 		}
+		ipNet = *p
+	default:
+		return fmt.Errorf("Expected *net.IPNet, received %T", value)
 	}
 
-	_, err = w.WriteString("}")
-	if err != nil {
-		return "", err
+	ip := ipNet.IP
+	family := byte(pgAFInet6)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		family = pgAFInet
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits == 0 {
+		// The mask is missing or not canonical.
+		return fmt.Errorf("Invalid mask for inet: %v", ipNet.Mask)
+	}
+	if bits == 128 && len(ip) == 4 {
+		ones -= 96
 	}
 
-	return w.String(), nil
+	w.WriteInt32(int32(4 + len(ip)))
+	w.WriteByte(family)
+	w.WriteByte(byte(ones))
+	w.WriteByte(isCidr)
+	w.WriteByte(byte(len(ip)))
+	w.WriteBytes(ip)
+	return nil
 }
 
-func encodeInt8Array(w *WriteBuf, value interface{}) error {
-	v, ok := value.([]int64)
-	if !ok {
-		return fmt.Errorf("Expected []int64, received %T", value)
+func decodeMacaddrFromText(mr *MessageReader, size int32) interface{} {
+	s := mr.ReadString(size)
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		return ProtocolError(fmt.Sprintf("Can't decode macaddr: %v", s))
 	}
+	return mac
+}
 
-	s, err := int64SliceToArrayString(v)
-	if err != nil {
-		return fmt.Errorf("Failed to encode []int64: %v", err)
+func decodeMacaddrFromBinary(mr *MessageReader, size int32) interface{} {
+	if size != 6 {
+		return ProtocolError(fmt.Sprintf("Received an invalid size for a macaddr: %d", size))
 	}
+	return net.HardwareAddr(mr.ReadString(size))
+}
 
-	return encodeText(w, s)
+// encodeMacaddr encodes a net.HardwareAddr of 6 bytes or a string in the
+// macaddr text format.
+func encodeMacaddr(w *WriteBuf, value interface{}) error {
+	var mac net.HardwareAddr
+	switch v := value.(type) {
+	case net.HardwareAddr:
+		mac = v
+	case string:
+		var err error
+		if mac, err = net.ParseMAC(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Expected net.HardwareAddr, received %T", value)
+	}
+	if len(mac) != 6 {
+		return fmt.Errorf("Expected 6 bytes for macaddr, received %d", len(mac))
+	}
+
+	w.WriteInt32(6)
+	w.WriteBytes(mac)
+	return nil
 }
@@ -0,0 +1,76 @@
+package raw_test
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestTypes(t *testing.T) {
+	server, config := startServer(t)
+	defer server.Close()
+
+	conn, err := raw.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mustParseNumeric := func(s string) raw.Numeric {
+		n, err := raw.ParseNumeric(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	_, ipNet, _ := net.ParseCIDR("10.1.0.0/16")
+	mac, _ := net.ParseMAC("08:00:2b:01:02:03")
+	uuid := [16]byte{0xa0, 0xee, 0xbc, 0x99, 0x9c, 0x0b, 0x4e, 0xf8, 0xbb, 0x6d, 0x6b, 0xb9, 0xbd, 0x38, 0x0a, 0x11}
+
+	tests := []struct {
+		typ     string
+		literal string
+		arg     interface{}
+		want    interface{}
+	}{
+		{"uuid", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11", uuid},
+		{"numeric", "-12345678901234567890.0500", mustParseNumeric("-12345678901234567890.0500"), mustParseNumeric("-12345678901234567890.0500")},
+		{"numeric", "0.00001", "1e-5", mustParseNumeric("0.00001")},
+		{"numeric", "120000", int64(120000), mustParseNumeric("120000")},
+		{"numeric", "NaN", raw.Numeric{NaN: true}, raw.Numeric{NaN: true}},
+		{"json", `{"a": [1, 2]}`, map[string]interface{}{"a": []int{1, 2}}, `{"a":[1,2]}`},
+		{"jsonb", `{"a":1}`, `{"a":1}`, `{"a":1}`},
+		{"timestamp", "2020-02-29 12:30:00.5", time.Date(2020, 2, 29, 12, 30, 0, 500000000, time.FixedZone("", 3600)),
+			time.Date(2020, 2, 29, 12, 30, 0, 500000000, time.UTC)},
+		{"time", "23:59:58.25", 23*time.Hour + 59*time.Minute + 58250*time.Millisecond, 23*time.Hour + 59*time.Minute + 58250*time.Millisecond},
+		{"interval", "1 year 2 mons -3 days -04:05:06.5", raw.Interval{Months: 14, Days: -3, Microseconds: -14706500000},
+			raw.Interval{Months: 14, Days: -3, Microseconds: -14706500000}},
+		{"inet", "192.168.0.1", "192.168.0.1", &net.IPNet{IP: net.IP{192, 168, 0, 1}, Mask: net.CIDRMask(32, 32)}},
+		{"cidr", "10.1.0.0/16", ipNet, ipNet},
+		{"macaddr", "08:00:2b:01:02:03", mac, mac},
+		{"bytea", `\x0001ff`, []byte{0, 1, 255}, []byte{0, 1, 255}},
+		{"text", "tab\there", "tab\there", "tab\there"},
+		{"uuid[]", "{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11,NULL}", []interface{}{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", nil},
+			raw.Array{Dimensions: []raw.ArrayDimension{{Length: 2, LowerBound: 1}}, Elements: []interface{}{uuid, nil}}},
+		{"numeric[]", "{1.5,-2}", []string{"1.5", "-2"}, []raw.Numeric{mustParseNumeric("1.5"), mustParseNumeric("-2")}},
+	}
+	for i, tt := range tests {
+		name := fmt.Sprintf("echo%d", i)
+		if _, err := conn.Prepare(name, "select $1::"+tt.typ); err != nil {
+			t.Fatalf("%s: %v", tt.typ, err)
+		}
+		if v, err := conn.SelectValue(name, tt.arg); err != nil || !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("%s binary: got %#v, %v, want %#v", tt.typ, v, err, tt.want)
+		}
+		if tt.typ == "json" {
+			continue
+		}
+		if v, err := conn.SelectValue("select " + conn.QuoteString(tt.literal) + "::" + tt.typ); err != nil || !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("%s text: got %#v, %v, want %#v", tt.typ, v, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hixichen/go_db_bench/histogram"
+)
+
+// resultSchemaVersion is written to JSON reports so readers can reject files
+// written by an incompatible version.
+const resultSchemaVersion = 1
+
+// runReport is the machine-readable output of db_bench run and import.
+type runReport struct {
+	Version   int           `json:"version"`
+	Start     string        `json:"start,omitempty"` // RFC 3339
+	GoVersion string        `json:"go_version,omitempty"`
+	GOOS      string        `json:"goos,omitempty"`
+	GOARCH    string        `json:"goarch,omitempty"`
+	NumCPU    int           `json:"num_cpu,omitempty"`
+	Duration  string        `json:"duration,omitempty"` // time spent on each sample of a driver and scenario pair
+	Results   []benchResult `json:"results"`
+}
+
+// benchResult is the measurement of one scenario against one driver,
+// aggregated over one or more samples. A sample is one timed run such as one
+// line of go test -count=N output.
+type benchResult struct {
+	Driver        string  `json:"driver"`
+	Scenario      string  `json:"scenario"`
+	Transport     string  `json:"transport,omitempty"` // how the driver reached the server; empty for the configured server
+	PayloadSize   int64   `json:"payload_size"`        // bytes; 0 when the scenario has no variable payload
+	Concurrency   int     `json:"concurrency"`         // goroutines running the scenario at once; 0 in older files means 1
+	Samples       int     `json:"samples"`
+	Iterations    int64   `json:"iterations"`       // total over all samples
+	NsPerOp       float64 `json:"ns_per_op"`        // mean over all samples
+	NsPerOpStddev float64 `json:"ns_per_op_stddev"` // sample standard deviation; 0 with one sample
+	BytesPerOp    int64   `json:"bytes_per_op"`
+	AllocsPerOp   int64   `json:"allocs_per_op"`
+	latencyPercentiles
+
+	// Latency is the distribution of the latency of single operations in
+	// nanoseconds over all samples. It is nil for results imported from go
+	// test -bench output, which only carries the percentiles.
+	Latency *histogram.Histogram `json:"latency,omitempty"`
+}
+
+// latencyPercentiles summarizes a latency distribution. All values are
+// nanoseconds and are 0 when the distribution is unknown.
+type latencyPercentiles struct {
+	P50Ns  int64 `json:"p50_ns"`
+	P90Ns  int64 `json:"p90_ns"`
+	P99Ns  int64 `json:"p99_ns"`
+	P999Ns int64 `json:"p999_ns"`
+	MaxNs  int64 `json:"max_ns"`
+}
+
+func percentilesOf(h *histogram.Histogram) latencyPercentiles {
+	return latencyPercentiles{
+		P50Ns:  h.ValueAtPercentile(50),
+		P90Ns:  h.ValueAtPercentile(90),
+		P99Ns:  h.ValueAtPercentile(99),
+		P999Ns: h.ValueAtPercentile(99.9),
+		MaxNs:  h.Max(),
+	}
+}
+
+// latencySignificantFigures is the precision of recorded latencies.
+const latencySignificantFigures = 3
+
+func newLatencyHistogram() *histogram.Histogram {
+	h := histogram.New(latencySignificantFigures)
+	h.Preallocate(int64(10 * time.Second))
+	return h
+}
+
+// benchSample is one timed run of a scenario. Latency is nil and the
+// percentiles are set directly for samples read from go test -bench output.
+type benchSample struct {
+	Iterations  int64
+	NsPerOp     float64
+	BytesPerOp  int64
+	AllocsPerOp int64
+	Latency     *histogram.Histogram
+	latencyPercentiles
+}
+
+var csvHeader = []string{
+	"driver",
+	"scenario",
+	"payload_size",
+	"concurrency",
+	"samples",
+	"iterations",
+	"ns_per_op",
+	"ns_per_op_stddev",
+	"bytes_per_op",
+	"allocs_per_op",
+	"p50_ns",
+	"p90_ns",
+	"p99_ns",
+	"p999_ns",
+	"max_ns",
+	"transport",
+}
+
+// optionalCSVColumns may be missing from CSV files written by older versions.
+var optionalCSVColumns = map[string]bool{
+	"concurrency": true,
+	"p50_ns":      true,
+	"p90_ns":      true,
+	"p99_ns":      true,
+	"p999_ns":     true,
+	"max_ns":      true,
+	"transport":   true,
+}
+
+func newRunReport(duration time.Duration) *runReport {
+	return &runReport{
+		Version:   resultSchemaVersion,
+		Start:     time.Now().UTC().Format(time.RFC3339),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Duration:  duration.String(),
+	}
+}
+
+// aggregateSamples combines the samples of the driver and scenario described
+// by r. The latency histograms of the samples are merged. Samples without a
+// histogram contribute the mean of their percentiles and the largest max.
+func aggregateSamples(r benchResult, samples []benchSample) benchResult {
+	r.Samples = len(samples)
+	if len(samples) == 0 {
+		return r
+	}
+
+	var nsSum float64
+	var bytesSum, allocsSum int64
+	var percentileSums latencyPercentiles
+	for _, s := range samples {
+		r.Iterations += s.Iterations
+		nsSum += s.NsPerOp
+		bytesSum += s.BytesPerOp
+		allocsSum += s.AllocsPerOp
+
+		p := s.latencyPercentiles
+		if s.Latency != nil {
+			if r.Latency == nil {
+				r.Latency = histogram.New(latencySignificantFigures)
+			}
+			r.Latency.Merge(s.Latency)
+			p = percentilesOf(s.Latency)
+		}
+		percentileSums.P50Ns += p.P50Ns
+		percentileSums.P90Ns += p.P90Ns
+		percentileSums.P99Ns += p.P99Ns
+		percentileSums.P999Ns += p.P999Ns
+		if p.MaxNs > percentileSums.MaxNs {
+			percentileSums.MaxNs = p.MaxNs
+		}
+	}
+	n := float64(len(samples))
+	r.NsPerOp = nsSum / n
+	r.BytesPerOp = int64(math.Round(float64(bytesSum) / n))
+	r.AllocsPerOp = int64(math.Round(float64(allocsSum) / n))
+
+	if r.Latency != nil && r.Latency.Count() > 0 {
+		r.latencyPercentiles = percentilesOf(r.Latency)
+	} else {
+		r.Latency = nil
+		r.latencyPercentiles = latencyPercentiles{
+			P50Ns:  int64(math.Round(float64(percentileSums.P50Ns) / n)),
+			P90Ns:  int64(math.Round(float64(percentileSums.P90Ns) / n)),
+			P99Ns:  int64(math.Round(float64(percentileSums.P99Ns) / n)),
+			P999Ns: int64(math.Round(float64(percentileSums.P999Ns) / n)),
+			MaxNs:  percentileSums.MaxNs,
+		}
+	}
+
+	if len(samples) > 1 {
+		var sq float64
+		for _, s := range samples {
+			d := s.NsPerOp - r.NsPerOp
+			sq += d * d
+		}
+		r.NsPerOpStddev = math.Sqrt(sq / (n - 1))
+	}
+
+	return r
+}
+
+// writeResults writes report to w in format, which is json or csv.
+func writeResults(w io.Writer, report *runReport, format string) error {
+	switch format {
+	case "json":
+		return report.writeJSON(w)
+	case "csv":
+		return report.writeCSV(w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// writeResultsFile writes report to path, or to stdout when path is -.
+func writeResultsFile(path string, report *runReport, format string) error {
+	if path == "-" {
+		return writeResults(os.Stdout, report, format)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeResults(f, report, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *runReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *runReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, res := range r.Results {
+		record := []string{
+			res.Driver,
+			res.Scenario,
+			strconv.FormatInt(res.PayloadSize, 10),
+			strconv.Itoa(res.Concurrency),
+			strconv.Itoa(res.Samples),
+			strconv.FormatInt(res.Iterations, 10),
+			strconv.FormatFloat(res.NsPerOp, 'f', -1, 64),
+			strconv.FormatFloat(res.NsPerOpStddev, 'f', -1, 64),
+			strconv.FormatInt(res.BytesPerOp, 10),
+			strconv.FormatInt(res.AllocsPerOp, 10),
+			strconv.FormatInt(res.P50Ns, 10),
+			strconv.FormatInt(res.P90Ns, 10),
+			strconv.FormatInt(res.P99Ns, 10),
+			strconv.FormatInt(res.P999Ns, 10),
+			strconv.FormatInt(res.MaxNs, 10),
+			res.Transport,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readResultsFile reads a JSON or CSV result file or go test -bench output.
+// The format is detected from the content.
+func readResultsFile(path string) (*runReport, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := parseResults(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return report, nil
+}
+
+func parseResults(buf []byte) (*runReport, error) {
+	trimmed := bytes.TrimSpace(buf)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return parseJSONResults(trimmed)
+	case bytes.HasPrefix(trimmed, []byte(strings.Join(csvHeader[:2], ","))):
+		return parseCSVResults(trimmed)
+	default:
+		return parseBenchOutput(bytes.NewReader(buf))
+	}
+}
+
+func parseJSONResults(buf []byte) (*runReport, error) {
+	var report runReport
+	if err := json.Unmarshal(buf, &report); err != nil {
+		return nil, err
+	}
+	if report.Version != resultSchemaVersion {
+		return nil, fmt.Errorf("unsupported result schema version %d", report.Version)
+	}
+	return &report, nil
+}
+
+func parseCSVResults(buf []byte) (*runReport, error) {
+	records, err := csv.NewReader(bytes.NewReader(buf)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok && !optionalCSVColumns[name] {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	report := &runReport{Version: resultSchemaVersion}
+	for i, record := range records[1:] {
+		line := i + 2
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return "0"
+		}
+		r := benchResult{Driver: field("driver"), Scenario: field("scenario")}
+		if _, ok := columns["transport"]; ok {
+			r.Transport = field("transport")
+		}
+
+		var err error
+		parseInt := func(name string) int64 {
+			if err != nil {
+				return 0
+			}
+			var n int64
+			n, err = strconv.ParseInt(field(name), 10, 64)
+			return n
+		}
+		parseFloat := func(name string) float64 {
+			if err != nil {
+				return 0
+			}
+			var f float64
+			f, err = strconv.ParseFloat(field(name), 64)
+			return f
+		}
+
+		r.PayloadSize = parseInt("payload_size")
+		r.Concurrency = int(parseInt("concurrency"))
+		r.Samples = int(parseInt("samples"))
+		r.Iterations = parseInt("iterations")
+		r.NsPerOp = parseFloat("ns_per_op")
+		r.NsPerOpStddev = parseFloat("ns_per_op_stddev")
+		r.BytesPerOp = parseInt("bytes_per_op")
+		r.AllocsPerOp = parseInt("allocs_per_op")
+		r.P50Ns = parseInt("p50_ns")
+		r.P90Ns = parseInt("p90_ns")
+		r.P99Ns = parseInt("p99_ns")
+		r.P999Ns = parseInt("p999_ns")
+		r.MaxNs = parseInt("max_ns")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		report.Results = append(report.Results, r)
+	}
+
+	return report, nil
+}
+
+// parseBenchOutput reads go test -bench output. Lines that are not benchmark
+// results are ignored. Repeated results for the same benchmark, as written by
+// -count=N, become samples of one result.
+func parseBenchOutput(r io.Reader) (*runReport, error) {
+	var order []resultKey
+	results := make(map[resultKey]benchResult)
+	samples := make(map[resultKey][]benchSample)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	report := &runReport{Version: resultSchemaVersion}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "goos: ") {
+			report.GOOS = strings.TrimSpace(line[len("goos: "):])
+			continue
+		}
+		if strings.HasPrefix(line, "goarch: ") {
+			report.GOARCH = strings.TrimSpace(line[len("goarch: "):])
+			continue
+		}
+
+		name, sample, ok := parseBenchLine(line)
+		if !ok {
+			continue
+		}
+		r := benchNameToResult(name)
+		k := r.key()
+		if _, seen := samples[k]; !seen {
+			order = append(order, k)
+			results[k] = r
+		}
+		samples[k] = append(samples[k], sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no benchmark results found")
+	}
+
+	for _, k := range order {
+		report.Results = append(report.Results, aggregateSamples(results[k], samples[k]))
+	}
+	return report, nil
+}
+
+// parseBenchLine parses one line of go test -bench output such as
+//
+//	BenchmarkPgxNativeSelectSingleRow-8   3000   446766 ns/op   291 B/op   6 allocs/op
+//
+// The GOMAXPROCS suffix is removed from the returned name.
+func parseBenchLine(line string) (name string, sample benchSample, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return "", sample, false
+	}
+
+	iterations, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", sample, false
+	}
+	sample.Iterations = iterations
+
+	foundNsPerOp := false
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return "", sample, false
+		}
+		switch fields[i+1] {
+		case "ns/op":
+			sample.NsPerOp = value
+			foundNsPerOp = true
+		case "B/op":
+			sample.BytesPerOp = int64(value)
+		case "allocs/op":
+			sample.AllocsPerOp = int64(value)
+		case "p50-ns":
+			sample.P50Ns = int64(value)
+		case "p90-ns":
+			sample.P90Ns = int64(value)
+		case "p99-ns":
+			sample.P99Ns = int64(value)
+		case "p99.9-ns":
+			sample.P999Ns = int64(value)
+		case "max-ns":
+			sample.MaxNs = int64(value)
+		}
+	}
+	if !foundNsPerOp {
+		return "", sample, false
+	}
+
+	name = fields[0]
+	// Scenario names such as batch-3 and connect methods such as
+	// scram-sha-256 look like a GOMAXPROCS suffix, which is not written when
+	// GOMAXPROCS is 1.
+	last := name[strings.LastIndexByte(name, '/')+1:]
+	_, isScenario := lookupScenario(last)
+	isMethod := strings.HasPrefix(name, "BenchmarkConnect/") && connectMethods[last]
+	if !isScenario && !isMethod {
+		if i := strings.LastIndexByte(name, '-'); i >= 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+	}
+	return name, sample, true
+}
+
+// benchDriverPrefixes maps the driver prefix of benchmark function names to
+// db_bench run driver names. Longer prefixes must come first.
+var benchDriverPrefixes = []struct {
+	prefix, driver string
+}{
+	{"PgxNative", "pgx-native"},
+	{"PgxStdlib", "pgx-stdlib"},
+	{"PgModels", "pg-models"},
+	{"GoPg", "pg"},
+	{"Pg", "pg"},
+	{"Pq", "pq"},
+	{"RawDecoded", "raw-decoded"},
+	{"Raw", "raw"},
+}
+
+// benchScenarios maps the rest of benchmark function names to db_bench run
+// scenario names. Names not listed are converted to kebab case.
+var benchScenarios = map[string]string{
+	"SelectSingleShortString":             "single-value",
+	"SelectSingleShortValue":              "single-value",
+	"SelectSingleShortBytes":              "single-value-bytes",
+	"SelectSingleRow":                     "single-row",
+	"SelectMultipleRows":                  "multi-row",
+	"SelectMultipleRowsBytes":             "multi-row-bytes",
+	"SelectMultipleRowsCollect":           "multi-row-collect",
+	"SelectMultipleRowsAndDiscard":        "multi-row-discard",
+	"SelectMultipleRowsIntoGenericBinary": "multi-row-generic-binary",
+	"SelectBatch3Query":                   "batch-3",
+	"SelectNoBatch3Query":                 "no-batch-3",
+	"SelectLargeTextString":               "large-text",
+	"SelectLargeTextBytes":                "large-text-bytes",
+}
+
+// connectMethods are the authentication methods BenchmarkConnect names its
+// raw/method sub-benchmarks by.
+var connectMethods = map[string]bool{
+	"trust":              true,
+	"cleartext":          true,
+	"md5":                true,
+	"scram-sha-256":      true,
+	"trust-tls":          true,
+	"md5-tls":            true,
+	"scram-sha-256-plus": true,
+}
+
+// benchNameToResult splits a benchmark name into the driver, scenario and
+// payload size used by db_bench run. Sub-benchmarks of BenchmarkScenarios,
+// BenchmarkParallel and BenchmarkTransports, such as
+// BenchmarkScenarios/pq/large-text-bytes-8kb,
+// BenchmarkParallel/pq/single-row/goroutines=16 and
+// BenchmarkTransports/tls/pq/connect, name the driver, scenario, concurrency
+// and transport directly. BenchmarkConnect/raw/md5 becomes raw and
+// connect-md5. The per-driver benchmark functions of older
+// versions are also understood: for example BenchmarkPqSelectLargeTextBytes8KB
+// becomes pq, large-text-bytes-8kb and 8192, and
+// BenchmarkPqParallel/single-row/goroutines=16 becomes pq, single-row and 16.
+func benchNameToResult(name string) benchResult {
+	parts := strings.Split(name, "/")
+	r := benchResult{Concurrency: 1}
+
+	switch {
+	case parts[0] == "BenchmarkScenarios" && len(parts) == 3:
+		r.Driver, r.Scenario = parts[1], parts[2]
+		r.PayloadSize = scenarioPayloadSize(r.Scenario)
+		return r
+	case parts[0] == "BenchmarkTransports" && len(parts) == 4:
+		r.Transport, r.Driver, r.Scenario = parts[1], parts[2], parts[3]
+		r.PayloadSize = scenarioPayloadSize(r.Scenario)
+		return r
+	case parts[0] == "BenchmarkConnect" && len(parts) == 3:
+		r.Driver, r.Scenario = parts[1], "connect-"+parts[2]
+		return r
+	case parts[0] == "BenchmarkParallel" && len(parts) == 4:
+		r.Driver, r.Scenario = parts[1], parts[2]
+		r.PayloadSize = scenarioPayloadSize(r.Scenario)
+		if n, err := strconv.Atoi(strings.TrimPrefix(parts[3], "goroutines=")); err == nil {
+			r.Concurrency = n
+		}
+		return r
+	}
+
+	rest := strings.TrimPrefix(parts[0], "Benchmark")
+	for _, p := range benchDriverPrefixes {
+		if strings.HasPrefix(rest, p.prefix) {
+			r.Driver = p.driver
+			rest = rest[len(p.prefix):]
+			break
+		}
+	}
+
+	if rest == "Parallel" && len(parts) == 3 {
+		r.Scenario = parts[1]
+		r.PayloadSize = scenarioPayloadSize(r.Scenario)
+		if n, err := strconv.Atoi(strings.TrimPrefix(parts[2], "goroutines=")); err == nil {
+			r.Concurrency = n
+		}
+		return r
+	}
+
+	var sizeSuffix string
+	if strings.HasSuffix(rest, "KB") {
+		i := len(rest) - 2
+		for i > 0 && rest[i-1] >= '0' && rest[i-1] <= '9' {
+			i--
+		}
+		if kb, err := strconv.ParseInt(rest[i:len(rest)-2], 10, 64); err == nil {
+			r.PayloadSize = kb * 1024
+			sizeSuffix = strings.ToLower(rest[i:])
+			rest = rest[:i]
+		}
+	}
+
+	scenario, ok := benchScenarios[rest]
+	if !ok {
+		scenario = kebabCase(strings.TrimPrefix(rest, "Select"))
+	}
+	if sizeSuffix != "" {
+		scenario += "-" + sizeSuffix
+	}
+	r.Scenario = strings.Join(append([]string{scenario}, parts[1:]...), "/")
+	return r
+}
+
+func kebabCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
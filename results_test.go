@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBenchOutput(t *testing.T) {
+	output := `goos: linux
+goarch: amd64
+BenchmarkPgxNativeSelectSingleRow-8   	    3000	    446766 ns/op	     291 B/op	       6 allocs/op
+BenchmarkPgxNativeSelectSingleRow-8   	    3000	    446770 ns/op	     291 B/op	       6 allocs/op
+BenchmarkPqSelectLargeTextBytes8KB    	     200	     38804 ns/op
+BenchmarkPqParallel/large-text-8kb/goroutines=16-8         	     100	     44740 ns/op
+BenchmarkScenarios/pgx-native/batch-3                     	    5000	    301234 ns/op
+BenchmarkScenarios/pgx-native/large-text-bytes-1kb-8      	    5000	     30123 ns/op
+BenchmarkParallel/pg/multi-row-collect/goroutines=4-8     	    5000	     60123 ns/op
+BenchmarkTransports/tls-resume/pq/connect-8               	    1000	   1023564 ns/op
+PASS
+`
+	report, err := parseBenchOutput(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.GOOS != "linux" || report.GOARCH != "amd64" {
+		t.Errorf("got goos %q goarch %q", report.GOOS, report.GOARCH)
+	}
+	if len(report.Results) != 7 {
+		t.Fatalf("expected 7 results, got %d", len(report.Results))
+	}
+
+	r := report.Results[0]
+	if r.Driver != "pgx-native" || r.Scenario != "single-row" || r.Samples != 2 || r.Iterations != 6000 || r.NsPerOp != 446768 || r.AllocsPerOp != 6 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[1]
+	if r.Driver != "pq" || r.Scenario != "large-text-bytes-8kb" || r.PayloadSize != 8192 || r.Concurrency != 1 || r.Samples != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[2]
+	if r.Driver != "pq" || r.Scenario != "large-text-8kb" || r.PayloadSize != 8192 || r.Concurrency != 16 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[3]
+	if r.Driver != "pgx-native" || r.Scenario != "batch-3" || r.PayloadSize != 0 || r.Concurrency != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[4]
+	if r.Driver != "pgx-native" || r.Scenario != "large-text-bytes-1kb" || r.PayloadSize != 1024 || r.Concurrency != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[5]
+	if r.Driver != "pg" || r.Scenario != "multi-row-collect" || r.Concurrency != 4 {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	r = report.Results[6]
+	if r.Driver != "pq" || r.Scenario != "connect" || r.Transport != "tls-resume" || r.Concurrency != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestParseConnectBenchLines(t *testing.T) {
+	for _, tt := range []struct {
+		line, scenario string
+	}{
+		{"BenchmarkConnect/raw/md5-8      1000   523456 ns/op", "connect-md5"},
+		{"BenchmarkConnect/raw/scram-sha-256      1000   923456 ns/op", "connect-scram-sha-256"},
+		{"BenchmarkConnect/raw/scram-sha-256-8      1000   923456 ns/op", "connect-scram-sha-256"},
+		{"BenchmarkConnect/raw/scram-sha-256-plus-8      1000   1523456 ns/op", "connect-scram-sha-256-plus"},
+	} {
+		name, _, ok := parseBenchLine(tt.line)
+		if !ok {
+			t.Errorf("%q was not parsed", tt.line)
+			continue
+		}
+		r := benchNameToResult(name)
+		if r.Driver != "raw" || r.Scenario != tt.scenario || r.Concurrency != 1 {
+			t.Errorf("%q: got %+v, want raw and %s", tt.line, r, tt.scenario)
+		}
+	}
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+	report := &runReport{
+		Version: resultSchemaVersion,
+		Results: []benchResult{
+			{Driver: "pq", Scenario: "large-text-1kb", PayloadSize: 1024, Concurrency: 4, Samples: 3, Iterations: 300, NsPerOp: 1234.5, NsPerOpStddev: 10.25, BytesPerOp: 42, AllocsPerOp: 3},
+			{Driver: "raw", Scenario: "connect", Transport: "tls", Concurrency: 1, Samples: 1, Iterations: 100, NsPerOp: 960576.5, BytesPerOp: 110643, AllocsPerOp: 1056},
+		},
+	}
+
+	for _, format := range []string{"json", "csv"} {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, report, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		parsed, err := parseResults(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(parsed.Results, report.Results) {
+			t.Errorf("%s: got %+v, want %+v", format, parsed.Results, report.Results)
+		}
+	}
+}
+
+func TestDiffConfidenceInterval(t *testing.T) {
+	base := &benchResult{Samples: 5, NsPerOp: 1000, NsPerOpStddev: 10}
+	faster := &benchResult{Samples: 5, NsPerOp: 900, NsPerOpStddev: 10}
+	same := &benchResult{Samples: 5, NsPerOp: 1005, NsPerOpStddev: 10}
+
+	low, high, ok := diffConfidenceInterval(base, faster)
+	if !ok || low > high || high >= 0 || low > -100 || high < -100 {
+		t.Errorf("expected interval below zero around -100, got [%v, %v]", low, high)
+	}
+
+	low, high, ok = diffConfidenceInterval(base, same)
+	if !ok || low > 0 || high < 0 {
+		t.Errorf("expected interval including zero, got [%v, %v]", low, high)
+	}
+
+	if _, _, ok := diffConfidenceInterval(base, &benchResult{Samples: 1, NsPerOp: 900}); ok {
+		t.Error("expected no interval with one sample")
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hixichen/go_db_bench/histogram"
+	"github.com/jackc/pgx"
+)
+
+const runUsage = `Usage: db_bench run [flags]
+
+Runs each selected scenario against each selected driver for the given
+duration and writes the results as JSON or CSV.
+
+Flags:
+`
+
+// runCommand implements db_bench run.
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	drivers := fs.String("drivers", "all", "comma separated list of drivers to run")
+	scenarios := fs.String("scenarios", "all", "comma separated list of scenarios to run")
+	duration := fs.Duration("duration", time.Second, "time to run each sample of a scenario for each driver")
+	count := fs.Int("count", 1, "number of samples to take of each scenario for each driver")
+	goroutines := fs.String("goroutines", "1", "comma separated list of goroutine counts to run each scenario with")
+	maxConns := fs.Int("max-conns", 0, "connection pool size of each driver, overrides GO_DB_BENCH_MAX_CONNS (default 10)")
+	output := fs.String("output", "-", "file to write results to, - for stdout")
+	format := fs.String("format", "json", "output format: json or csv")
+	list := fs.Bool("list", false, "list drivers and scenarios, then exit")
+	conn := fs.String("conn", "", connFlagUsage)
+	transports := fs.String("transports", "", "comma separated list of transports, or all, to reach the server with, or in-process fake servers with GO_DB_BENCH_FAKE_PG set: "+strings.Join(transportNames(), ", "))
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, runUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *list {
+		return listScenarios(os.Stdout)
+	}
+
+	if *duration <= 0 {
+		return fmt.Errorf("duration must be positive, got %v", *duration)
+	}
+	if *count < 1 {
+		return fmt.Errorf("count must be at least 1, got %d", *count)
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	goroutineCounts, err := parseGoroutineCounts(*goroutines)
+	if err != nil {
+		return err
+	}
+
+	selectedDrivers, err := selectDrivers(*drivers)
+	if err != nil {
+		return err
+	}
+	selectedScenarios, err := selectScenarios(*scenarios)
+	if err != nil {
+		return err
+	}
+
+	config, err := extractConfig(*conn)
+	if err != nil {
+		return err
+	}
+	if *maxConns > 0 {
+		config.MaxConnections = *maxConns
+	}
+
+	plan := runPlan{
+		drivers:         selectedDrivers,
+		scenarios:       selectedScenarios,
+		goroutineCounts: goroutineCounts,
+		duration:        *duration,
+		count:           *count,
+	}
+	report := newRunReport(*duration)
+
+	if *transports != "" {
+		selectedTransports, err := selectTransports(*transports)
+		if err != nil {
+			return err
+		}
+		for _, t := range selectedTransports {
+			env, server, err := startTransport(t, config)
+			if _, ok := err.(transportUnavailableError); ok {
+				fmt.Fprintf(os.Stderr, "skipped: %v\n", err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", t.Name, err)
+			}
+			fmt.Fprintf(os.Stderr, "transport %s: %v\n", t.Name, &env.config)
+			err = plan.run(report, env, t.Name)
+			server.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return writeResultsFile(*output, report, *format)
+	}
+
+	if useFakeServer() {
+		fakeServer, err := startFakeServer(&config)
+		if err != nil {
+			return err
+		}
+		defer fakeServer.Close()
+	}
+
+	if err := config.resolveSSLMode(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "database config: %v\n", &config)
+
+	env, err := newRunEnv(config)
+	if err != nil {
+		return err
+	}
+	if err := plan.run(report, env, ""); err != nil {
+		return err
+	}
+
+	return writeResultsFile(*output, report, *format)
+}
+
+// newRunEnv loads the test data into the database of config and selects the
+// person IDs and rows the scenarios read.
+func newRunEnv(config dbConfig) (*runEnv, error) {
+	if err := loadTestData(config); err != nil {
+		return nil, fmt.Errorf("loadTestData failed: %v", err)
+	}
+
+	env := &runEnv{config: config}
+	var err error
+	env.randPersonIDs, err = selectRandPersonIDs(config)
+	if err != nil {
+		return nil, err
+	}
+	env.people, err = selectPeople(config)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// runPlan is the drivers, scenarios and goroutine counts selected for db_bench
+// run and how long to measure each of them.
+type runPlan struct {
+	drivers         []Driver
+	scenarios       []Scenario
+	goroutineCounts []int
+	duration        time.Duration
+	count           int
+}
+
+// run measures the scenarios of each driver against env and appends the
+// results to report, labeled with transport. With a transport, drivers that do
+// not use it are skipped.
+func (plan *runPlan) run(report *runReport, env *runEnv, transport string) error {
+	defer env.Close()
+
+	for _, d := range plan.drivers {
+		if transport != "" && !usesTransport(d) {
+			fmt.Fprintf(os.Stderr, "%-12s skipped: driver does not use transport %s\n", d.Name(), transport)
+			continue
+		}
+		session, err := d.Open(env)
+		if err != nil {
+			return fmt.Errorf("%s: open failed: %v", d.Name(), err)
+		}
+
+		for _, s := range plan.scenarios {
+			fn := session.Scenario(s)
+			if fn == nil {
+				continue
+			}
+
+			for _, n := range plan.goroutineCounts {
+				if n > 1 && !d.Concurrent() {
+					fmt.Fprintf(os.Stderr, "%-12s %-24s %4d skipped: driver does not support concurrent use\n", d.Name(), s.Name, n)
+					continue
+				}
+
+				samples := make([]benchSample, plan.count)
+				for i := range samples {
+					samples[i], err = measure(fn, plan.duration, n)
+					if err != nil {
+						return fmt.Errorf("%s %s: %v", d.Name(), s.Name, err)
+					}
+					p := percentilesOf(samples[i].Latency)
+					fmt.Fprintf(os.Stderr, "%-12s %-24s %4d %10d %12.0f ns/op %10d B/op %6d allocs/op %10d p50-ns %10d p99-ns %10d max-ns\n",
+						d.Name(), s.Name, n, samples[i].Iterations, samples[i].NsPerOp, samples[i].BytesPerOp, samples[i].AllocsPerOp,
+						p.P50Ns, p.P99Ns, p.MaxNs)
+				}
+
+				result := benchResult{Driver: d.Name(), Scenario: s.Name, Transport: transport, PayloadSize: s.PayloadSize(), Concurrency: n}
+				report.Results = append(report.Results, aggregateSamples(result, samples))
+			}
+		}
+
+		if err := env.Close(); err != nil {
+			return fmt.Errorf("%s: close failed: %v", d.Name(), err)
+		}
+	}
+	return nil
+}
+
+// measure calls fn from goroutines goroutines at once until duration has
+// elapsed and reports the wall time and memory allocated per call and the
+// distribution of the latency of each call. With more than one goroutine ns/op
+// is the inverse of throughput, as with testing.B.RunParallel. fn is called
+// once before measuring starts so one-time work such as a driver preparing a
+// statement on first use is not counted.
+func measure(fn scenarioFunc, duration time.Duration, goroutines int) (benchSample, error) {
+	if err := fn(0); err != nil {
+		return benchSample{}, err
+	}
+
+	latencies := make([]*histogram.Histogram, goroutines)
+	for g := range latencies {
+		latencies[g] = newLatencyHistogram()
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var next int64
+	var failed int32
+	errs := make(chan error, goroutines)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(latency *histogram.Histogram) {
+			defer wg.Done()
+			opStart := time.Now()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if err := fn(i); err != nil {
+					atomic.StoreInt32(&failed, 1)
+					errs <- err
+					return
+				}
+				opEnd := time.Now()
+				latency.Record(int64(opEnd.Sub(opStart)))
+				if !opEnd.Before(deadline) {
+					return
+				}
+				opStart = opEnd
+			}
+		}(latencies[g])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	select {
+	case err := <-errs:
+		return benchSample{}, err
+	default:
+	}
+
+	for _, h := range latencies[1:] {
+		latencies[0].Merge(h)
+	}
+
+	n := atomic.LoadInt64(&next)
+	return benchSample{
+		Iterations:  n,
+		NsPerOp:     float64(elapsed.Nanoseconds()) / float64(n),
+		BytesPerOp:  int64(after.TotalAlloc-before.TotalAlloc) / n,
+		AllocsPerOp: int64(after.Mallocs-before.Mallocs) / n,
+		Latency:     latencies[0],
+	}, nil
+}
+
+// parseGoroutineCounts parses a comma separated list of goroutine counts such
+// as 1,4,16.
+func parseGoroutineCounts(list string) ([]int, error) {
+	return parsePositiveInts("goroutine count", list)
+}
+
+// parsePositiveInts parses a comma separated list of positive integers. kind
+// names the values in errors.
+func parsePositiveInts(kind, list string) ([]int, error) {
+	var counts []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q", kind, s)
+		}
+		counts = append(counts, n)
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no %ss given", kind)
+	}
+	return counts, nil
+}
+
+func selectRandPersonIDs(config dbConfig) ([]int32, error) {
+	conn, err := pgx.Connect(config.pgxConnConfig())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var ids []int32
+	rows, _ := conn.Query("select id from person order by random()")
+	for rows.Next() {
+		var id int32
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("person table is empty")
+	}
+	return ids, nil
+}
+
+// selectPeople returns every row of person in the column order of
+// personCopyColumns, for the copy-from scenarios to load.
+func selectPeople(config dbConfig) ([][]interface{}, error) {
+	conn, err := pgx.Connect(config.pgxConnConfig())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var people [][]interface{}
+	rows, _ := conn.Query("select id, first_name, last_name, sex, birth_date, weight, height, update_time from person order by id")
+	for rows.Next() {
+		var p person
+		if err := rows.Scan(&p.Id, &p.FirstName, &p.LastName, &p.Sex, &p.BirthDate, &p.Weight, &p.Height, &p.UpdateTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		people = append(people, []interface{}{p.Id, p.FirstName, p.LastName, p.Sex, p.BirthDate, p.Weight, p.Height, p.UpdateTime})
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return people, nil
+}
+
+func selectDrivers(list string) ([]Driver, error) {
+	selected, err := selectNames("driver", list, driverNames())
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]Driver, len(selected))
+	for i, name := range selected {
+		drivers[i], _ = lookupDriver(name)
+	}
+	return drivers, nil
+}
+
+func selectScenarios(list string) ([]Scenario, error) {
+	selected, err := selectNames("scenario", list, scenarioNames())
+	if err != nil {
+		return nil, err
+	}
+
+	scenarios := make([]Scenario, len(selected))
+	for i, name := range selected {
+		scenarios[i], _ = lookupScenario(name)
+	}
+	return scenarios, nil
+}
+
+// selectNames parses a comma separated list of names, or "all", and returns
+// the selected names in the order of known.
+func selectNames(kind, list string, known []string) ([]string, error) {
+	if list == "all" {
+		return known, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, k := range known {
+			if k == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown %s %q (known: %s)", kind, name, strings.Join(known, ", "))
+		}
+		wanted[name] = true
+	}
+
+	var selected []string
+	for _, k := range known {
+		if wanted[k] {
+			selected = append(selected, k)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no %ss selected", kind)
+	}
+	return selected, nil
+}
+
+// listScenarios writes the known drivers and scenarios. Drivers skip
+// scenarios they do not implement.
+func listScenarios(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "drivers: %s\n", strings.Join(driverNames(), ", ")); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "scenarios: %s\n", strings.Join(scenarioNames(), ", "))
+	return err
+}
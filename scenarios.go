@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// scenarioFunc performs one iteration of a scenario. i is the iteration number
+// and is used to pick the person to select so every driver reads the same
+// sequence of rows.
+type scenarioFunc func(i int) error
+
+// ScenarioKind is the kind of work a scenario does. Drivers implement kinds
+// rather than individual scenarios, so scenarios that only differ in Size
+// need no driver changes.
+type ScenarioKind int
+
+const (
+	SelectValue             ScenarioKind = iota // first name of one person into a string
+	SelectValueBytes                            // first name of one person into a []byte
+	SelectRow                                   // one person
+	SelectRows                                  // 25 people, scanned one row at a time
+	SelectRowsBytes                             // 25 people with text columns scanned into []byte
+	SelectRowsCollect                           // 25 people collected into a slice
+	SelectRowsDiscard                           // 25 people read and discarded
+	SelectRowsGenericBinary                     // 25 people scanned into undecoded binary values
+	SelectBatch                                 // Size large text queries sent as one batch
+	SelectNoBatch                               // Size large text queries sent one at a time
+	SelectLargeText                             // text of Size bytes into a string
+	SelectLargeTextBytes                        // text of Size bytes into a []byte
+	InsertRow                                   // one person_scratch row
+	InsertReturning                             // one person_scratch row, returning its id
+	UpdateRow                                   // two columns of one person_scratch row by id
+	DeleteRow                                   // one person_scratch row by id
+	CopyFromBinary                              // the person dataset into person_copy with binary COPY
+	CopyFromText                                // the person dataset into person_copy with text COPY
+	CopyToBinary                                // person to the client with binary COPY
+	CopyToText                                  // person to the client with text COPY
+	Connect                                     // a new connection that selects 1 and is closed
+)
+
+// Scenario is a registered benchmark scenario.
+type Scenario struct {
+	Name string
+	Kind ScenarioKind
+
+	// Size parameterizes Kind: the length of the selected text for large text
+	// scenarios and the number of queries for batch scenarios.
+	Size int
+
+	// Parallel scenarios are also run from several goroutines at once by
+	// BenchmarkParallel.
+	Parallel bool
+}
+
+// PayloadSize returns the size in bytes of the value selected by s, or 0 if s
+// has no variable payload.
+func (s Scenario) PayloadSize() int64 {
+	switch s.Kind {
+	case SelectLargeText, SelectLargeTextBytes:
+		return int64(s.Size)
+	}
+	return 0
+}
+
+var largeTextSizes = []struct {
+	name string
+	size int
+}{
+	{"1kb", 1024},
+	{"8kb", 8 * 1024},
+	{"64kb", 64 * 1024},
+	{"512kb", 512 * 1024},
+	{"4096kb", 4096 * 1024},
+}
+
+// registeredScenarios lists every scenario in the order they are run. Not
+// every driver implements every scenario. Adding a scenario of an existing
+// kind only takes an entry here.
+var registeredScenarios = append([]Scenario{
+	{Name: "single-value", Kind: SelectValue, Parallel: true},
+	{Name: "single-value-bytes", Kind: SelectValueBytes},
+	{Name: "single-row", Kind: SelectRow, Parallel: true},
+	{Name: "multi-row", Kind: SelectRows, Parallel: true},
+	{Name: "multi-row-bytes", Kind: SelectRowsBytes},
+	{Name: "multi-row-collect", Kind: SelectRowsCollect, Parallel: true},
+	{Name: "multi-row-discard", Kind: SelectRowsDiscard},
+	{Name: "multi-row-generic-binary", Kind: SelectRowsGenericBinary},
+	{Name: "insert", Kind: InsertRow},
+	{Name: "insert-returning", Kind: InsertReturning},
+	{Name: "update-by-id", Kind: UpdateRow},
+	{Name: "delete-by-id", Kind: DeleteRow},
+	{Name: "copy-from-binary", Kind: CopyFromBinary},
+	{Name: "copy-from-text", Kind: CopyFromText},
+	{Name: "copy-to-binary", Kind: CopyToBinary},
+	{Name: "copy-to-text", Kind: CopyToText},
+	{Name: "connect", Kind: Connect},
+}, append(batchScenarios(), largeTextScenarios()...)...)
+
+// batchDepths are the numbers of queries of the batch scenarios.
+var batchDepths = []int{1, 3, 10, 100}
+
+// batchScenarios returns a batch and a no-batch scenario for each of
+// batchDepths.
+func batchScenarios() []Scenario {
+	var scenarios []Scenario
+	for _, depth := range batchDepths {
+		scenarios = append(scenarios,
+			Scenario{Name: fmt.Sprintf("batch-%d", depth), Kind: SelectBatch, Size: depth},
+			Scenario{Name: fmt.Sprintf("no-batch-%d", depth), Kind: SelectNoBatch, Size: depth},
+		)
+	}
+	return scenarios
+}
+
+// largeTextScenarios returns the large text scenarios for each of
+// largeTextSizes.
+func largeTextScenarios() []Scenario {
+	var scenarios []Scenario
+	for _, lt := range largeTextSizes {
+		scenarios = append(scenarios, Scenario{Name: "large-text-" + lt.name, Kind: SelectLargeText, Size: lt.size, Parallel: true})
+	}
+	for _, lt := range largeTextSizes {
+		scenarios = append(scenarios, Scenario{Name: "large-text-bytes-" + lt.name, Kind: SelectLargeTextBytes, Size: lt.size})
+	}
+	return scenarios
+}
+
+// scenarioNames returns the names of registeredScenarios.
+func scenarioNames() []string {
+	names := make([]string, len(registeredScenarios))
+	for i, s := range registeredScenarios {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// lookupScenario returns the registered scenario called name.
+func lookupScenario(name string) (Scenario, bool) {
+	for _, s := range registeredScenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// scenarioPayloadSize returns the payload size of the registered scenario
+// called name, or 0 if there is none.
+func scenarioPayloadSize(name string) int64 {
+	s, _ := lookupScenario(name)
+	return s.PayloadSize()
+}
+
+// runEnv is the state shared by the drivers of one db_bench run or go test
+// -bench invocation.
+type runEnv struct {
+	config        dbConfig
+	randPersonIDs []int32
+	people        [][]interface{} // rows of person for the copy-from scenarios
+	closers       []io.Closer
+	deletable     deletablePeople // of the database at config
+}
+
+func (env *runEnv) personID(i int) int32 {
+	return env.randPersonIDs[i%len(env.randPersonIDs)]
+}
+
+// deletableBatch is the number of rows deletablePeople inserts at once.
+const deletableBatch = 1000
+
+// deletablePeople hands out the ids of the person_scratch rows of one
+// database for DeleteRow to delete.
+type deletablePeople struct {
+	mu     sync.Mutex
+	nextID int32 // next id returned by next, counting down from -1
+	count  int   // rows left to delete before next inserts more
+}
+
+// next returns the id of a person_scratch row for DeleteRow to delete. When
+// all rows have been handed out it inserts deletableBatch more with exec, so
+// delete-by-id includes the cost of inserting its rows spread over that many
+// iterations. The rows have negative ids so they never collide with the
+// serial ids given to inserted rows.
+func (d *deletablePeople) next(exec func(sql string) error) (int32, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.nextID == 0 {
+		d.nextID = -1
+	}
+	if d.count == 0 {
+		if err := exec(deletablePeopleSQL(d.nextID, deletableBatch)); err != nil {
+			return 0, err
+		}
+		d.count = deletableBatch
+	}
+	id := d.nextID
+	d.nextID--
+	d.count--
+	return id, nil
+}
+
+// deleteID returns the id of a person_scratch row of the database at
+// env.config for DeleteRow to delete.
+func (env *runEnv) deleteID(exec func(sql string) error) (int32, error) {
+	return env.deletable.next(exec)
+}
+
+// Close closes everything opened by drivers since the last Close, in reverse
+// order.
+func (env *runEnv) Close() error {
+	var err error
+	for i := len(env.closers) - 1; i >= 0; i-- {
+		if closeErr := env.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	env.closers = nil
+	return err
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// checkSelectOne returns the error of select 1 or, if there was none, checks
+// that it returned 1.
+func checkSelectOne(n int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("select 1 returned %d", n)
+	}
+	return nil
+}
+
+// checkRowsAffected returns an error unless a write affected exactly one row.
+func checkRowsAffected(n int64) error {
+	if n != 1 {
+		return fmt.Errorf("expected 1 row affected, got %v", n)
+	}
+	return nil
+}
+
+// checkCopyFrom returns an error unless a copy-from scenario copied every
+// person.
+func (env *runEnv) checkCopyFrom(n int64) error {
+	if n != int64(len(env.people)) {
+		return fmt.Errorf("expected %v rows copied, got %v", len(env.people), n)
+	}
+	return nil
+}
+
+// countingWriter discards what is written to it and counts the bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// checkCopyTo returns an error if a copy-to scenario received nothing.
+func checkCopyTo(w countingWriter) error {
+	if w == 0 {
+		return errors.New("copy received no data")
+	}
+	return nil
+}
+
+func checkLargeText(actual, expected int) error {
+	if actual != expected {
+		return fmt.Errorf("expected length %v, got %v", expected, actual)
+	}
+	return nil
+}
+
+// newResultsPool returns a pool of *[]string of length size for the batch
+// scenarios to scan into, so the results are allocated once per scenario
+// rather than per iteration, and concurrent iterations share none.
+func newResultsPool(size int) *sync.Pool {
+	return &sync.Pool{New: func() interface{} {
+		results := make([]string, size)
+		return &results
+	}}
+}
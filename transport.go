@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hixichen/go_db_bench/fakepg"
+)
+
+// transport is a way for the drivers to reach the server.
+type transport struct {
+	Name string
+
+	unix       bool // a unix domain socket instead of TCP on the loopback interface
+	tls        bool // TLS with a certificate the drivers verify
+	resumption bool // TLS sessions are resumed by new connections
+}
+
+// registeredTransports lists every transport in the order they are run.
+var registeredTransports = []transport{
+	{Name: "tcp"},
+	{Name: "unix", unix: true},
+	{Name: "tls", tls: true},
+	{Name: "tls-resume", tls: true, resumption: true},
+}
+
+// transportNames returns the names of registeredTransports.
+func transportNames() []string {
+	names := make([]string, len(registeredTransports))
+	for i, t := range registeredTransports {
+		names[i] = t.Name
+	}
+	return names
+}
+
+func selectTransports(list string) ([]transport, error) {
+	selected, err := selectNames("transport", list, transportNames())
+	if err != nil {
+		return nil, err
+	}
+
+	transports := make([]transport, len(selected))
+	for i, name := range selected {
+		for _, t := range registeredTransports {
+			if t.Name == name {
+				transports[i] = t
+			}
+		}
+	}
+	return transports, nil
+}
+
+// startTransport returns the environment of the drivers reaching the server
+// with t. With GO_DB_BENCH_FAKE_PG set it starts a fakepg server for t, as
+// startFakeTransport does. Otherwise it points config at the configured server
+// over t, as configureTransport does, so the server's side of the transport is
+// not measured with the drivers. The returned closer stops anything started.
+func startTransport(t transport, config dbConfig) (*runEnv, io.Closer, error) {
+	if useFakeServer() {
+		return startFakeTransport(t, config)
+	}
+	if err := configureTransport(t, &config); err != nil {
+		return nil, nil, err
+	}
+	env, err := newRunEnv(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env, &transportCleanup{}, nil
+}
+
+// transportUnavailableError is returned by startTransport when the configured
+// server cannot be reached with a transport, such as a unix domain socket of
+// a remote server. Callers skip the transport.
+type transportUnavailableError struct {
+	transport, reason string
+}
+
+func (e transportUnavailableError) Error() string {
+	return fmt.Sprintf("transport %s is not available: %s", e.transport, e.reason)
+}
+
+// socketDirs are the directories PostgreSQL creates its unix domain socket in
+// by default, searched for the unix transport when the configured host is a
+// loopback host name.
+var socketDirs = []string{"/var/run/postgresql", "/tmp"}
+
+// configureTransport points config at the configured server over t:
+//
+//   - tcp connects to the configured host, or localhost if it is a socket
+//     directory, without TLS
+//   - unix connects to the socket in the configured host directory, or in a
+//     default socket directory if the host is localhost
+//   - tls and tls-resume connect to the configured host with the configured
+//     sslmode if it requires TLS, verify-full if sslrootcert is set and
+//     require otherwise
+func configureTransport(t transport, config *dbConfig) error {
+	config.tlsResumption = false
+	switch {
+	case t.unix:
+		if !config.isSocket() {
+			if config.Host != "localhost" && config.Host != "127.0.0.1" && config.Host != "::1" {
+				return transportUnavailableError{t.Name, "host " + config.Host + " is not local"}
+			}
+			socket := fmt.Sprintf(".s.PGSQL.%d", config.Port)
+			dir := ""
+			for _, d := range socketDirs {
+				if fileExists(filepath.Join(d, socket)) {
+					dir = d
+					break
+				}
+			}
+			if dir == "" {
+				return transportUnavailableError{t.Name, "no " + socket + " in " + strings.Join(socketDirs, " or ") + "; set host to the socket directory"}
+			}
+			config.Host = dir
+		}
+		config.SSLMode = "disable"
+
+	case t.tls:
+		if config.isSocket() {
+			return transportUnavailableError{t.Name, "host " + config.Host + " is a socket directory"}
+		}
+		switch config.SSLMode {
+		case "require", "verify-ca", "verify-full":
+		default:
+			config.SSLMode = "require"
+			if config.SSLRootCert != "" {
+				config.SSLMode = "verify-full"
+			}
+		}
+		ok, err := config.serverAcceptsTLS()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return transportUnavailableError{t.Name, "the server refused TLS"}
+		}
+		config.tlsResumption = t.resumption
+
+	default:
+		if config.isSocket() {
+			config.Host = "localhost"
+		}
+		config.SSLMode = "disable"
+	}
+	return config.resolveSSLMode()
+}
+
+// startFakeTransport starts a fakepg server that the drivers reach with t and
+// returns the environment of the drivers connecting to it with config's
+// credentials and pool size. The TLS transports use a self-signed certificate,
+// which the drivers verify as with sslmode=verify-full. The returned closer
+// stops the server.
+func startFakeTransport(t transport, config dbConfig) (*runEnv, io.Closer, error) {
+	dir, err := ioutil.TempDir("", "go_db_bench")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := &transportCleanup{dir: dir}
+
+	serverConfig := fakepg.Config{Auth: fakepg.AuthMD5, Password: config.Password}
+	config.SSLMode, config.SSLRootCert, config.tlsResumption = "disable", "", false
+	if t.tls {
+		cert, _, err := fakepg.SelfSignedCert("127.0.0.1")
+		if err != nil {
+			cleanup.Close()
+			return nil, nil, err
+		}
+		serverConfig.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		// pq only reads root certificates from a file.
+		config.SSLRootCert = filepath.Join(dir, "root.crt")
+		rootCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		if err := ioutil.WriteFile(config.SSLRootCert, rootCert, 0600); err != nil {
+			cleanup.Close()
+			return nil, nil, err
+		}
+		config.SSLMode = "verify-full"
+		config.tlsResumption = t.resumption
+	}
+
+	if t.unix {
+		// The drivers find the socket of port 5432 in the directory Host.
+		config.Host, config.Port = dir, 5432
+		cleanup.server, err = fakepg.Listen("unix", filepath.Join(dir, ".s.PGSQL.5432"), serverConfig)
+	} else {
+		cleanup.server, err = fakepg.Listen("tcp", "127.0.0.1:0", serverConfig)
+		if err == nil {
+			addr := cleanup.server.Addr().(*net.TCPAddr)
+			config.Host, config.Port = addr.IP.String(), uint16(addr.Port)
+		}
+	}
+	if err != nil {
+		cleanup.Close()
+		return nil, nil, err
+	}
+
+	if err := config.resolveSSLMode(); err != nil {
+		cleanup.Close()
+		return nil, nil, err
+	}
+	env, err := newRunEnv(config)
+	if err != nil {
+		cleanup.Close()
+		return nil, nil, err
+	}
+	return env, cleanup, nil
+}
+
+// transportCleanup stops the fake server of a transport, if any, and removes
+// its socket and certificate.
+type transportCleanup struct {
+	dir    string
+	server *fakepg.Server
+}
+
+func (c *transportCleanup) Close() error {
+	var err error
+	if c.server != nil {
+		err = c.server.Close()
+	}
+	if c.dir == "" {
+		return err
+	}
+	if removeErr := os.RemoveAll(c.dir); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
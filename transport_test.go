@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hixichen/go_db_bench/fakepg"
+	"github.com/hixichen/go_db_bench/raw"
+)
+
+func TestTransports(t *testing.T) {
+	config, err := extractConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tr := range registeredTransports {
+		env, server, err := startFakeTransport(tr, config)
+		if err != nil {
+			t.Fatalf("%s: %v", tr.Name, err)
+		}
+
+		if tr.unix != env.config.isSocket() || tr.tls != (env.config.tlsConfig != nil) {
+			t.Errorf("%s: config %v", tr.Name, &env.config)
+		}
+
+		// raw reports whether the TLS session of the last connection was
+		// resumed; newRunEnv has already connected once.
+		conn, err := raw.Connect(env.config.rawConnConfig())
+		if err != nil {
+			t.Fatalf("%s: %v", tr.Name, err)
+		}
+		if _, err := conn.SelectValue("select 1"); err != nil {
+			t.Errorf("%s: %v", tr.Name, err)
+		}
+		if tlsConn, ok := conn.Conn().(*tls.Conn); ok != tr.tls {
+			t.Errorf("%s: connection is %T", tr.Name, conn.Conn())
+		} else if ok && tlsConn.ConnectionState().DidResume != tr.resumption {
+			t.Errorf("%s: DidResume is %v", tr.Name, tlsConn.ConnectionState().DidResume)
+		}
+		conn.Close()
+
+		for _, d := range registeredDrivers {
+			if !usesTransport(d) {
+				continue
+			}
+			session, err := d.Open(env)
+			if err != nil {
+				t.Errorf("%s/%s: open failed: %v", tr.Name, d.Name(), err)
+				continue
+			}
+			for _, s := range registeredScenarios {
+				if s.Kind != Connect && s.Kind != SelectRow {
+					continue
+				}
+				if fn := session.Scenario(s); fn != nil {
+					if err := fn(0); err != nil {
+						t.Errorf("%s/%s/%s: %v", tr.Name, d.Name(), s.Name, err)
+					}
+				}
+			}
+			env.Close()
+		}
+
+		server.Close()
+	}
+}
+
+func TestConfigureTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go_db_bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dirs []string) { socketDirs = dirs }(socketDirs)
+	socketDirs = []string{dir}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".s.PGSQL.5432"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A server that accepts TLS with a certificate verified by root.crt.
+	cert, _, err := fakepg.SelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCert := filepath.Join(dir, "root.crt")
+	if err := ioutil.WriteFile(rootCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tlsServer, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsServer.Close()
+	tlsPort := uint16(tlsServer.Addr().(*net.TCPAddr).Port)
+	plainServer, err := fakepg.Listen("tcp", "127.0.0.1:0", fakepg.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plainServer.Close()
+	plainPort := uint16(plainServer.Addr().(*net.TCPAddr).Port)
+
+	transports := make(map[string]transport)
+	for _, tr := range registeredTransports {
+		transports[tr.Name] = tr
+	}
+	tests := []struct {
+		transport   string
+		config      dbConfig
+		host        string
+		sslMode     string
+		unavailable bool
+	}{
+		{"tcp", dbConfig{Host: "db.example.com", Port: 5432, SSLMode: "require"}, "db.example.com", "disable", false},
+		{"tcp", dbConfig{Host: dir, Port: 5432}, "localhost", "disable", false},
+		{"unix", dbConfig{Host: "localhost", Port: 5432, SSLMode: "prefer"}, dir, "disable", false},
+		{"unix", dbConfig{Host: "/private/tmp", Port: 5432}, "/private/tmp", "disable", false},
+		{"unix", dbConfig{Host: "localhost", Port: 6543}, "", "", true},
+		{"unix", dbConfig{Host: "db.example.com", Port: 5432}, "", "", true},
+		{"tls", dbConfig{Host: "127.0.0.1", Port: tlsPort, SSLMode: "disable"}, "127.0.0.1", "require", false},
+		{"tls-resume", dbConfig{Host: "127.0.0.1", Port: tlsPort, SSLRootCert: rootCert}, "127.0.0.1", "verify-full", false},
+		{"tls", dbConfig{Host: "127.0.0.1", Port: tlsPort, SSLMode: "verify-ca", SSLRootCert: rootCert}, "127.0.0.1", "verify-ca", false},
+		{"tls", dbConfig{Host: "127.0.0.1", Port: plainPort}, "", "", true},
+		{"tls", dbConfig{Host: dir, Port: 5432}, "", "", true},
+	}
+	for i, tt := range tests {
+		config := tt.config
+		err := configureTransport(transports[tt.transport], &config)
+		if _, ok := err.(transportUnavailableError); ok != tt.unavailable {
+			t.Errorf("%d: %s: got error %v", i, tt.transport, err)
+			continue
+		}
+		if tt.unavailable {
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %s: %v", i, tt.transport, err)
+			continue
+		}
+		if config.Host != tt.host || config.SSLMode != tt.sslMode || (config.tlsConfig != nil) != (tt.sslMode != "disable") {
+			t.Errorf("%d: %s: got %v", i, tt.transport, &config)
+		}
+		if config.tlsResumption != transports[tt.transport].resumption {
+			t.Errorf("%d: %s: tlsResumption is %v", i, tt.transport, config.tlsResumption)
+		}
+	}
+}